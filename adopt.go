@@ -0,0 +1,163 @@
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AdoptResult counts the completed jobs found in another flowdir by what
+// became of them.
+type AdoptResult struct {
+	// Adopted were registered as done, Existing were already done here
+	// and Missing have no output at their new path.
+	Adopted  int
+	Existing int
+	Missing  []string
+}
+
+// AdoptFlowdir registers the jobs completed in the flowdir from as completed
+// in that of c. rebase maps the directories the outputs were in to those
+// they are in now, e.g., {"/old/project": "/new/project"}; the longest
+// matching directory is used. Only jobs whose first output exists at its new
+// path are adopted, and the usage recorded in from is added to this
+// flowdir's once. It takes the lock on the flowdir, so it fails if a run is
+// in progress.
+func (c *Config) AdoptFlowdir(from string, rebase map[string]string) (AdoptResult, error) {
+	result := AdoptResult{}
+	from, err := filepath.Abs(from)
+	if err != nil {
+		return result, err
+	}
+	doneDir := filepath.Join(from, "done")
+	if _, err := os.Stat(doneDir); err != nil {
+		return result, fmt.Errorf("not a flowdir: %s: %v", from, err)
+	}
+	if from == c.GetString("flowdir") {
+		return result, fmt.Errorf("cannot adopt the flowdir in use: %s", from)
+	}
+	if err := lockFlowdir(c); err != nil {
+		return result, err
+	}
+	defer unlockFlowdir(c)
+	err = filepath.Walk(doneDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".done") {
+			return nil
+		}
+		rel, err := filepath.Rel(doneDir, strings.TrimSuffix(path, ".done"))
+		if err != nil {
+			return err
+		}
+		output := rebasePath(string(filepath.Separator)+rel, rebase)
+		ok, err := fileExists(output)
+		if err != nil {
+			return fmt.Errorf("unable to determine if file exists: %s: %v", output, err)
+		}
+		if !ok {
+			result.Missing = append(result.Missing, output)
+			return nil
+		}
+		done := filepath.Join(c.GetString("flowdir"), "done", output+".done")
+		if ok, _ := fileExists(done); ok {
+			result.Existing++
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(done), 0755); err != nil {
+			return fmt.Errorf("unable to create done file directory: %v", err)
+		}
+		if err := ioutil.WriteFile(done, nil, 0644); err != nil {
+			return fmt.Errorf("unable to create done file: %v", err)
+		}
+		result.Adopted++
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if err := adoptUsage(c, from); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// rebasePath replaces the longest directory in rebase that p is in with
+// the directory it maps to.
+func rebasePath(p string, rebase map[string]string) string {
+	from, to := "", ""
+	for dir, newDir := range rebase {
+		dir = filepath.Clean(dir)
+		if len(dir) > len(from) && (p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))) {
+			from, to = dir, newDir
+		}
+	}
+	if from == "" {
+		return p
+	}
+	return filepath.Join(to, strings.TrimPrefix(p, from))
+}
+
+// adoptUsage appends the usage recorded in the flowdir from to that of this
+// one, unless it has been adopted before, as recorded in stats/adopted.
+func adoptUsage(conf *Config, from string) error {
+	src := filepath.Join(from, "stats", "usage.tsv")
+	content, err := ioutil.ReadFile(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read usage file: %v", err)
+	}
+	adopted := filepath.Join(conf.GetString("flowdir"), "stats", "adopted")
+	if b, err := ioutil.ReadFile(adopted); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if line == from {
+				return nil
+			}
+		}
+	}
+	if err := appendUsage(conf, content); err != nil {
+		return err
+	}
+	a, err := os.OpenFile(adopted, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to record adopted flowdir: %v", err)
+	}
+	defer a.Close()
+	_, err = fmt.Fprintln(a, from)
+	return err
+}
+
+// appendUsage appends the content of another usage file to that of this
+// flowdir.
+func appendUsage(conf *Config, content []byte) error {
+	dst := usageFile(conf)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("unable to create stats directory: %v", err)
+	}
+	_, err := os.Stat(dst)
+	exists := err == nil
+	f, err := os.OpenFile(dst, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open usage file: %v", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for i, line := range strings.SplitAfter(string(content), "\n") {
+		// The columns are the same, so the header is only written if
+		// this flowdir has no usage yet.
+		if i == 0 && exists {
+			continue
+		}
+		w.WriteString(line)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("unable to write usage file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_AdoptFlowdir(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old", ".flow")
+	defaultConfig.Set("flowdir", filepath.Join(dir, "new", ".flow"))
+	if err := os.MkdirAll(defaultConfig.GetString("flowdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(fn, content string) {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldProject, newProject := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	write(filepath.Join(old, "done", oldProject, "out", "a.bam.done"), "")
+	write(filepath.Join(old, "done", oldProject, "out", "b.bam.done"), "")
+	write(filepath.Join(old, "done", oldProject, "out", "c.bam.jobid"), "123")
+	write(filepath.Join(old, "stats", "usage.tsv"), "time\tanalysis_name\nx\talign\n")
+	// Only a.bam was moved with the workflow.
+	write(filepath.Join(newProject, "out", "a.bam"), "")
+	rebase := map[string]string{oldProject: newProject}
+	result, err := defaultConfig.AdoptFlowdir(old, rebase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Adopted != 1 || result.Existing != 0 || len(result.Missing) != 1 {
+		t.Errorf("AdoptFlowdir() = %+v, want 1 adopted and b.bam missing", result)
+	}
+	if ok, _ := fileExists(filepath.Join(dir, "new", ".flow", "done", newProject, "out", "a.bam.done")); !ok {
+		t.Errorf("AdoptFlowdir() did not create the done file of a.bam")
+	}
+	// Adopting again finds it done and does not record the usage twice.
+	result, err = defaultConfig.AdoptFlowdir(old, rebase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Adopted != 0 || result.Existing != 1 {
+		t.Errorf("AdoptFlowdir() again = %+v, want 1 existing", result)
+	}
+	history, err := usageHistory(defaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history["align"]) != 1 {
+		t.Errorf("usage history = %v, want one align sample", history)
+	}
+}
+
+func Test_rebasePath(t *testing.T) {
+	rebase := map[string]string{"/old": "/new", "/old/data/": "/data"}
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"/old/out/a.bam", "/new/out/a.bam"},
+		{"/old/data/x.fa", "/data/x.fa"},
+		{"/older/a.bam", "/older/a.bam"},
+		{"/other/a.bam", "/other/a.bam"},
+	}
+	for _, tt := range tests {
+		if got := rebasePath(tt.p, rebase); got != tt.want {
+			t.Errorf("rebasePath(%s) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+}
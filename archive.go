@@ -0,0 +1,125 @@
+package flow
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveDir returns the directory run archives are written to.
+func archiveDir(conf *Config) string {
+	if dir := conf.GetString("archive_dir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(conf.GetString("flowdir"), "archive")
+}
+
+// archiveLogs moves the work directories and logs of the jobs run into
+// <run-id>.tar.gz in the archive directory, under work/ and logs/ by their
+// full path, and returns its path, or "" if no jobs were run.
+func (g *graph) archiveLogs() (string, error) {
+	jobs := []*job{}
+	for _, j := range append(append([]*job{}, g.completed...), g.allowedFailed...) {
+		if j.workDir != "" {
+			jobs = append(jobs, j)
+		}
+	}
+	if len(jobs) == 0 {
+		return "", nil
+	}
+	runDir, err := g.config.runDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(archiveDir(g.config), 0755); err != nil {
+		return "", fmt.Errorf("unable to create archive directory: %v", err)
+	}
+	fn := filepath.Join(archiveDir(g.config), filepath.Base(runDir)+".tar.gz")
+	f, err := os.Create(fn)
+	if err != nil {
+		return "", fmt.Errorf("unable to create archive: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	work := filepath.Join(g.config.GetString("flowdir"), "work")
+	for _, j := range jobs {
+		rel, err := filepath.Rel(work, j.workDir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = strings.TrimPrefix(j.workDir, string(filepath.Separator))
+		}
+		if err := archiveTree(tw, j.workDir, filepath.Join("work", rel)); err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(j.Stdout); err == nil {
+			if err := archiveFile(tw, j.Stdout, filepath.Join("logs", strings.TrimPrefix(j.Stdout, string(filepath.Separator)))); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("unable to write archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("unable to write archive: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("unable to write archive: %v", err)
+	}
+	// Nothing is removed until the archive is complete.
+	for _, j := range jobs {
+		os.RemoveAll(j.workDir)
+		os.Remove(j.Stdout)
+	}
+	return fn, nil
+}
+
+// archiveTree adds the files in dir to tw under name.
+func archiveTree(tw *tar.Writer, dir, name string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Named pipes and the like are not worth keeping.
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return archiveFile(tw, path, filepath.Join(name, rel))
+	})
+}
+
+// archiveFile adds the file fn to tw as name.
+func archiveFile(tw *tar.Writer, fn, name string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return fmt.Errorf("unable to archive %s: %v", fn, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to archive %s: %v", fn, err)
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("unable to archive %s: %v", fn, err)
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("unable to write archive: %v", err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("unable to write archive: %v", err)
+	}
+	return nil
+}
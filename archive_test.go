@@ -0,0 +1,83 @@
+package flow
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_archiveLogs(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defer func(id string) { defaultConfig.runID = id }(defaultConfig.runID)
+	dir := t.TempDir()
+	defaultConfig.Set("flowdir", filepath.Join(dir, ".flow"))
+	defaultConfig.runID = "2026-01-01_000000_abcd1234"
+	workDir := filepath.Join(dir, ".flow", "work", "ab", "cdef")
+	stdout := filepath.Join(dir, "out", "a.bam.out")
+	for fn, content := range map[string]string{
+		filepath.Join(workDir, "job.sh"):    "#!/bin/bash\n",
+		filepath.Join(workDir, ".exitcode"): "0\n",
+		stdout:                              "aligned\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := graph{config: defaultConfig, completed: []*job{
+		{workDir: workDir, Stdout: stdout},
+		// Completed in a previous run.
+		{Stdout: filepath.Join(dir, "out", "b.bam.out")},
+	}}
+	fn, err := g.archiveLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, ".flow", "archive", defaultConfig.runID+".tar.gz"); fn != want {
+		t.Errorf("archiveLogs() = %s, want %s", fn, want)
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"logs/" + strings.TrimPrefix(stdout, "/"),
+		"work/ab/cdef/.exitcode",
+		"work/ab/cdef/job.sh",
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("archive contains %v, want %v", names, want)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("archiveLogs() did not remove the work directory")
+	}
+	if _, err := os.Stat(stdout); !os.IsNotExist(err) {
+		t.Errorf("archiveLogs() did not remove the log")
+	}
+}
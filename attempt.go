@@ -0,0 +1,36 @@
+package flow
+
+// Attempter is implemented by tasks that are told which attempt at running
+// them this is, counting from 1, before their command is rendered, so that
+// a resubmitted task can, e.g., add --continue. Tasks that embed Task have
+// it in Attempt; commands can read it from FLOW_ATTEMPT.
+type Attempter interface {
+	SetAttempt(n int)
+}
+
+// SetAttempt sets Attempt.
+func (t *Task) SetAttempt(n int) {
+	t.Attempt = n
+}
+
+// setAttempt tells the task of j, if it is an Attempter, which attempt at
+// running it this is.
+func setAttempt(j *job) {
+	if a, ok := j.Cmd.(Attempter); ok {
+		a.SetAttempt(j.attempt())
+	}
+}
+
+// firstAttempt calls f with the task of j, if it is an Attempter, told it is
+// the first attempt, so that f sees the same command however many times j
+// has been run.
+func firstAttempt(j *job, f func()) {
+	a, ok := j.Cmd.(Attempter)
+	if !ok {
+		f()
+		return
+	}
+	a.SetAttempt(1)
+	defer a.SetAttempt(j.attempt())
+	f()
+}
@@ -0,0 +1,47 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+)
+
+type retryTask struct {
+	Task
+	Output string `type:"output"`
+}
+
+func (t *retryTask) Command() string {
+	return fmt.Sprintf("assemble --attempt %d", t.Attempt)
+}
+
+func Test_setAttempt(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defaultConfig.Set("flowdir", t.TempDir())
+	task := &retryTask{Task: Task{Name: "assemble"}, Output: "out.fa"}
+	j := &job{Cmd: task, Outputs: []string{"out.fa"}}
+	setAttempt(j)
+	if task.Attempt != 1 {
+		t.Errorf("Attempt = %d, want 1", task.Attempt)
+	}
+	first, err := workDir(defaultConfig, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.preemptions = 1
+	j.timeouts = 1
+	setAttempt(j)
+	if got := task.Command(); got != "assemble --attempt 3" {
+		t.Errorf("Command() = %s, want assemble --attempt 3", got)
+	}
+	// The work directory does not change with the attempt.
+	third, err := workDir(defaultConfig, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != third {
+		t.Errorf("workDir() = %s on the third attempt, %s on the first", third, first)
+	}
+	if task.Attempt != 3 {
+		t.Errorf("Attempt = %d after workDir(), want 3", task.Attempt)
+	}
+}
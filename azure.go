@@ -0,0 +1,88 @@
+package flow
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AzureStorage stages Azure blobs using azcopy (see azcopy_bin). Blobs may be
+// given as az://account/container/blob or
+// https://account.blob.core.windows.net/container/blob. If azure_sas_token is
+// set it is used to authenticate, otherwise the managed identity of the host
+// is used.
+type AzureStorage struct {
+	boundConfig
+}
+
+func (s AzureStorage) Stat(uri string) (bool, error) {
+	blob, err := azureBlobURL(s.conf(), uri)
+	if err != nil {
+		return false, err
+	}
+	return statCommand(azcopyCommand(s.conf(), "list", blob))
+}
+
+func (s AzureStorage) Fetch(uri, dst string) error {
+	blob, err := azureBlobURL(s.conf(), uri)
+	if err != nil {
+		return err
+	}
+	return runCommand(azcopyCommand(s.conf(), "copy", blob, dst))
+}
+
+func (s AzureStorage) Store(src, uri string) error {
+	blob, err := azureBlobURL(s.conf(), uri)
+	if err != nil {
+		return err
+	}
+	return runCommand(azcopyCommand(s.conf(), "copy", src, blob))
+}
+
+// Checksum is not supported, as blobs only have an MD5 if the client that
+// uploaded them chose to set one.
+func (s AzureStorage) Checksum(uri string) (string, error) {
+	return "", nil
+}
+
+// isAzureBlob reports whether u refers to Azure Blob Storage.
+func isAzureBlob(u *url.URL) bool {
+	return u.Scheme == "az" || strings.HasSuffix(u.Host, ".blob.core.windows.net")
+}
+
+// azureBlobURL returns the https URL of an Azure blob, with the SAS token
+// from azure_sas_token appended if one is configured.
+func azureBlobURL(conf *Config, uri string) (string, error) {
+	blob, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI: %s: %v", uri, err)
+	}
+	if blob.Scheme == "az" {
+		blob.Scheme = "https"
+		blob.Host = blob.Host + ".blob.core.windows.net"
+	}
+	if token := strings.TrimPrefix(conf.GetString("azure_sas_token"), "?"); token != "" {
+		blob.RawQuery = token
+	}
+	return blob.String(), nil
+}
+
+// azcopyCommand returns an azcopy command. Without a SAS token azcopy is told
+// to authenticate with the managed identity of the host, unless another login
+// type has already been chosen.
+func azcopyCommand(conf *Config, args ...string) *exec.Cmd {
+	cmd := exec.Command(conf.GetString("azcopy_bin"), args...)
+	if conf.GetString("azure_sas_token") == "" && os.Getenv("AZCOPY_AUTO_LOGIN_TYPE") == "" {
+		cmd.Env = append(os.Environ(), "AZCOPY_AUTO_LOGIN_TYPE=MSI")
+	}
+	return cmd
+}
+
+func (s AzureStorage) withConfig(conf *Config) Storage {
+	s.config = conf
+	return s
+}
+
+var _ Storage = AzureStorage{}
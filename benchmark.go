@@ -0,0 +1,181 @@
+package flow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchmark runs each job whose name matches pattern repeats times, one run
+// after another and each in a fresh work directory, instead of running the
+// workflow. Their inputs must already exist. The time and resources used by
+// every run are written to benchmark.csv in the run directory and summarised
+// in the log, to compare tools or calibrate the resources a task asks for.
+func (g *graph) benchmark(pattern string, repeats int) error {
+	if repeats < 1 {
+		return fmt.Errorf("benchmark_repeats must be at least 1, not %d", repeats)
+	}
+	selected := []*job{}
+	for _, j := range g.jobs {
+		if ok, err := filepath.Match(pattern, j.Cmd.AnalysisName()); err != nil {
+			return fmt.Errorf("invalid benchmark pattern: %s: %v", pattern, err)
+		} else if ok {
+			selected = append(selected, j)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no task to benchmark is named %s", pattern)
+	}
+	for _, j := range selected {
+		for _, in := range j.Inputs {
+			if ok, err := fileExists(in); !j.optional[in] && !isGlob(in) && (err != nil || !ok) {
+				return fmt.Errorf("input of %s does not exist, run the workflow to create it first: %s", j.Cmd.AnalysisName(), in)
+			}
+		}
+	}
+	if err := createCondaEnvs(g.config, selected); err != nil {
+		return err
+	}
+	r, err := newRunner(g.config)
+	if err != nil {
+		return err
+	}
+	runDir, err := g.config.runDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(runDir, "benchmark.csv"))
+	if err != nil {
+		return fmt.Errorf("unable to create benchmark report: %v", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"analysis_name", "output", "repeat", "exit_status", "wall_seconds", "walltime_used", "memory_used", "cpupercent"})
+	for _, j := range selected {
+		runs := []benchmarkRun{}
+		for i := 1; i <= repeats; i++ {
+			log.Printf("Benchmarking %s, run %d of %d", j.Cmd.AnalysisName(), i, repeats)
+			run, err := g.benchmarkRun(r, j)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			w.Write([]string{
+				j.Cmd.AnalysisName(),
+				j.Outputs[0],
+				strconv.Itoa(i),
+				strconv.Itoa(run.used.ExitStatus),
+				strconv.FormatFloat(run.wall.Seconds(), 'f', 1, 64),
+				strconv.Itoa(run.used.TimeUsed),
+				strconv.Itoa(run.used.MemoryUsed),
+				strconv.Itoa(run.used.CPUPercent),
+			})
+		}
+		log.Printf("Benchmark of %s (%s), %d runs:\n%s", j.Cmd.AnalysisName(), j.Outputs[0], repeats, benchmarkSummary(runs))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// benchmarkRun is one run of a benchmarked job.
+type benchmarkRun struct {
+	ok   bool
+	wall time.Duration
+	used resourcesUsed
+}
+
+// benchmarkRun submits j and waits for it to finish.
+func (g *graph) benchmarkRun(r Runner, j *job) (benchmarkRun, error) {
+	j.hasCompleted = false
+	j.completedSuccessfully = false
+	start := time.Now()
+	if err := g.submit(r, j); err != nil {
+		return benchmarkRun{}, err
+	}
+	for {
+		time.Sleep(time.Until(j.nextPoll))
+		if br, ok := r.(batchRunner); ok {
+			if err := br.Refresh([]*job{j}); err != nil {
+				return benchmarkRun{}, err
+			}
+		}
+		done, err := r.Completed(j)
+		if err != nil {
+			r.Kill(j)
+			return benchmarkRun{}, fmt.Errorf("unable to determine job state: %s: %v", j.ID, err)
+		}
+		if done {
+			break
+		}
+		j.backoff(g.config)
+	}
+	run := benchmarkRun{wall: time.Since(start)}
+	os.Remove(j.idFile)
+	var err error
+	if run.ok, err = r.CompletedSuccessfully(j); err != nil {
+		return run, fmt.Errorf("unable to determine job state: %s: %v", j.ID, err)
+	}
+	if !run.ok {
+		log.Printf("WARNING: benchmark run of %s failed, stdout written to %s", j.Cmd.AnalysisName(), j.Stdout)
+	}
+	if run.used, err = r.ResourcesUsed(j); err != nil {
+		log.Printf("Failed to get resources for job: %v: %v", j.UUID, err)
+	}
+	return run, nil
+}
+
+// benchmarkSummary returns the minimum, median, mean and maximum of the time
+// and resources used by the runs, one line for each.
+func benchmarkSummary(runs []benchmarkRun) string {
+	metrics := []struct {
+		name  string
+		value func(benchmarkRun) float64
+	}{
+		{"wall seconds", func(r benchmarkRun) float64 { return r.wall.Seconds() }},
+		{"walltime used", func(r benchmarkRun) float64 { return float64(r.used.TimeUsed) }},
+		{"memory used", func(r benchmarkRun) float64 { return float64(r.used.MemoryUsed) }},
+		{"cpu percent", func(r benchmarkRun) float64 { return float64(r.used.CPUPercent) }},
+	}
+	failed := 0
+	for _, r := range runs {
+		if !r.ok {
+			failed++
+		}
+	}
+	lines := []string{fmt.Sprintf("  %-14s %10s %10s %10s %10s", "", "min", "median", "mean", "max")}
+	for _, m := range metrics {
+		xs := []float64{}
+		for _, r := range runs {
+			xs = append(xs, m.value(r))
+		}
+		min, median, mean, max := stats(xs)
+		lines = append(lines, fmt.Sprintf("  %-14s %10.1f %10.1f %10.1f %10.1f", m.name, min, median, mean, max))
+	}
+	if failed > 0 {
+		lines = append(lines, fmt.Sprintf("  %d of %d runs failed", failed, len(runs)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stats returns the minimum, median, mean and maximum of xs, which must not
+// be empty.
+func stats(xs []float64) (float64, float64, float64, float64) {
+	sorted := append([]float64{}, xs...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, x := range sorted {
+		sum += x
+	}
+	n := len(sorted)
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[0], median, sum / float64(n), sorted[n-1]
+}
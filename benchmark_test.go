@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func Test_stats(t *testing.T) {
+	tests := []struct {
+		name string
+		xs   []float64
+		want [4]float64
+	}{
+		{"one", []float64{3}, [4]float64{3, 3, 3, 3}},
+		{"odd", []float64{5, 1, 3}, [4]float64{1, 3, 3, 5}},
+		{"even", []float64{4, 1, 2, 9}, [4]float64{1, 3, 4, 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min, median, mean, max := stats(tt.xs)
+			if got := [4]float64{min, median, mean, max}; got != tt.want {
+				t.Errorf("stats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_benchmark(t *testing.T) {
+	dir := t.TempDir()
+	for key, value := range map[string]interface{}{
+		"flowdir":           filepath.Join(dir, ".flow"),
+		"job_runner":        "dummy",
+		"poll_min_interval": "1ms",
+		"poll_interval":     "1ms",
+	} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+		defaultConfig.Set(key, value)
+	}
+	in := filepath.Join(dir, "in.txt")
+	if err := ioutil.WriteFile(in, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.txt")
+	j := &job{
+		Cmd:     &mockTask{Task: Task{Name: "sort"}, In: []string{in}, Out: out},
+		UUID:    uuid.New(),
+		Inputs:  []string{in},
+		Outputs: []string{out},
+		Stdout:  out + ".out",
+		idFile:  filepath.Join(dir, "out.jobid"),
+	}
+	g := graph{config: defaultConfig, jobs: []*job{j}}
+	if err := g.benchmark("other", 2); err == nil {
+		t.Errorf("benchmark() of no task should fail")
+	}
+	if err := g.benchmark("so*", 2); err != nil {
+		t.Fatal(err)
+	}
+	runDir, err := RunDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := ioutil.ReadFile(filepath.Join(runDir, "benchmark.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(report)), "\n"); len(lines) != 3 {
+		t.Errorf("benchmark.csv has %d lines, want 3:\n%s", len(lines), report)
+	}
+	os.Remove(in)
+	if err := g.benchmark("sort", 1); err == nil {
+		t.Errorf("benchmark() with missing inputs should fail")
+	}
+}
@@ -0,0 +1,108 @@
+package flow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// buildScheme is the scheme of containers built by flow from a Singularity
+// definition or a Dockerfile, and cached by the hash of that file.
+const buildScheme = "build://"
+
+// buildDefinition returns the definition file of a container to be built,
+// and false if the container is not built.
+func buildDefinition(container string) (string, bool) {
+	if !strings.HasPrefix(container, buildScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(container, buildScheme), true
+}
+
+func isDockerfile(fn string) bool {
+	base := strings.ToLower(filepath.Base(fn))
+	return base == "dockerfile" || strings.HasPrefix(base, "dockerfile.") || strings.HasSuffix(base, ".dockerfile")
+}
+
+// builtImagePath returns the SIF file in the image cache for a definition
+// file.
+func builtImagePath(conf *Config, def string) (string, error) {
+	b, err := ioutil.ReadFile(def)
+	if err != nil {
+		return "", fmt.Errorf("unable to read container definition: %v", err)
+	}
+	dir, err := singularityCacheDir(conf)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return filepath.Join(dir, "images", "build-"+hex.EncodeToString(sum[:])[:16]+".sif"), nil
+}
+
+// buildImage builds the image for a definition file, unless it has already
+// been built, and returns its path.
+func buildImage(conf *Config, def string) (string, error) {
+	fn, err := builtImagePath(conf, def)
+	if err != nil {
+		return "", err
+	}
+	if ok, _ := fileExists(fn); ok {
+		return fn, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return "", err
+	}
+	env, err := singularityEnv(conf)
+	if err != nil {
+		return "", err
+	}
+	args, err := splitArgs(conf.GetString("singularity_build_args"))
+	if err != nil {
+		return "", fmt.Errorf("invalid singularity_build_args: %v", err)
+	}
+	log.Printf("Building container %s", def)
+	tmp := fn + ".part"
+	os.Remove(tmp)
+	source := def
+	if isDockerfile(def) {
+		tag := "flow-" + strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fn), "build-"), ".sif")
+		if err := runCommand(exec.Command(conf.GetString("docker_bin"), "build", "-t", tag, "-f", def, filepath.Dir(def))); err != nil {
+			return "", err
+		}
+		source = "docker-daemon://" + tag + ":latest"
+	}
+	cmd := exec.Command(conf.GetString("singularity_bin"), append(append([]string{"build"}, args...), tmp, source)...)
+	cmd.Env = append(os.Environ(), env...)
+	if err := runCommand(cmd); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return fn, os.Rename(tmp, fn)
+}
+
+// buildImages builds the containers of the pending jobs that are given as
+// definition files and has the jobs run the images.
+func (g *graph) buildImages() error {
+	images := map[string]string{}
+	for _, j := range g.pending {
+		def, ok := buildDefinition(j.resources.Container)
+		if !ok {
+			continue
+		}
+		if _, ok := images[def]; !ok {
+			fn, err := buildImage(g.config, def)
+			if err != nil {
+				return fmt.Errorf("unable to build container for %s: %s: %v", j.Cmd.AnalysisName(), def, err)
+			}
+			images[def] = fn
+		}
+		j.resources.Container = images[def]
+	}
+	return nil
+}
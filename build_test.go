@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_buildImages(t *testing.T) {
+	dir := t.TempDir()
+	// Stand ins for singularity and docker that record what they are asked
+	// to do, singularity creating the image.
+	calls := filepath.Join(dir, "calls")
+	for name, script := range map[string]string{
+		"singularity": "#!/bin/sh\necho singularity \"$@\" >>" + calls + "\nfor a; do out=$prev; prev=$a; done\ntouch \"$out\"\n",
+		"docker":      "#!/bin/sh\necho docker \"$1\" >>" + calls + "\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, value := range map[string]interface{}{
+		"flowdir":                filepath.Join(dir, ".flow"),
+		"tmpdir":                 filepath.Join(dir, "tmp"),
+		"singularity_bin":        filepath.Join(dir, "singularity"),
+		"docker_bin":             filepath.Join(dir, "docker"),
+		"singularity_build_args": "--fakeroot",
+		"singularity_cachedir":   "",
+	} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+		defaultConfig.Set(key, value)
+	}
+	def := filepath.Join(dir, "tool.def")
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	for _, fn := range []string{def, dockerfile} {
+		if err := ioutil.WriteFile(fn, []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newJob := func(container string) *job {
+		return &job{Cmd: &fileTask{Task: Task{Name: "a"}}, resources: Resources{Container: container}}
+	}
+	g := graph{config: defaultConfig, pending: []*job{newJob(buildScheme + def), newJob(buildScheme + def), newJob(buildScheme + dockerfile), newJob("docker://ubuntu")}}
+	if err := g.buildImages(); err != nil {
+		t.Fatal(err)
+	}
+	defImage, _ := builtImagePath(defaultConfig, def)
+	dockerImage, _ := builtImagePath(defaultConfig, dockerfile)
+	for i, want := range []string{defImage, defImage, dockerImage, "docker://ubuntu"} {
+		if got := g.pending[i].resources.Container; got != want {
+			t.Errorf("container of job %d = %s, want %s", i, got, want)
+		}
+	}
+	b, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "singularity build --fakeroot ") || lines[1] != "docker build" || !strings.Contains(lines[2], "docker-daemon://flow-") {
+		t.Errorf("unexpected commands:\n%s", b)
+	}
+}
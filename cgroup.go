@@ -0,0 +1,98 @@
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroup is the cgroup (v2) of a local job, limited to the CPUs and memory
+// the task asks for so that a task using more memory than it declared is
+// killed rather than bringing down the host.
+type cgroup struct {
+	dir string
+}
+
+// newCgroup creates a cgroup in local_cgroup limited to the resources of j,
+// or returns nil if local_cgroup is not set.
+func newCgroup(conf *Config, j *job) (*cgroup, error) {
+	parent := conf.GetString("local_cgroup")
+	if parent == "" {
+		return nil, nil
+	}
+	// Make the controllers available to the job's cgroup, which fails if
+	// they already are or cannot be.
+	ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+	c := &cgroup{dir: filepath.Join(parent, "flow-"+j.UUID.String())}
+	if err := os.Mkdir(c.dir, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("unable to create cgroup: %v", err)
+	}
+	limits := []struct {
+		file, value string
+		required    bool
+	}{
+		{"memory.max", strconv.Itoa(j.resources.Memory << 30), true},
+		// Without this the job would swap rather than be killed.
+		{"memory.swap.max", "0", false},
+		{"cpu.max", fmt.Sprintf("%d 100000", j.resources.CPUs*100000), false},
+	}
+	for _, l := range limits {
+		err := ioutil.WriteFile(filepath.Join(c.dir, l.file), []byte(l.value), 0644)
+		if err != nil && l.required {
+			c.remove()
+			return nil, fmt.Errorf("unable to limit cgroup, is the memory controller available in %s? %v", parent, err)
+		}
+	}
+	return c, nil
+}
+
+// command returns the command that runs script in the cgroup. The shell
+// moves itself into the cgroup before it becomes the job, so that nothing
+// the job starts escapes it.
+func (c *cgroup) command(script string) []string {
+	return []string{"sh", "-c", `echo $$ >"$1/cgroup.procs" && exec bash "$2"`, "sh", c.dir, script}
+}
+
+// oomKilled reports whether a process in the cgroup was killed for using
+// more than its memory.
+func (c *cgroup) oomKilled() bool {
+	return c.event("memory.events", "oom_kill") > 0
+}
+
+// memoryUsed returns the most memory the cgroup used, in GB rounded up, or 0
+// if the kernel does not record it.
+func (c *cgroup) memoryUsed() int {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, "memory.peak"))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return (n + 1<<30 - 1) >> 30
+}
+
+// event returns the count of an event in a cgroup file of "name count" lines.
+func (c *cgroup) event(file, name string) int {
+	f, err := os.Open(filepath.Join(c.dir, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == name {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// remove removes the cgroup, which must have no processes left.
+func (c *cgroup) remove() error {
+	return os.Remove(c.dir)
+}
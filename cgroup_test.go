@@ -0,0 +1,59 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func Test_cgroup(t *testing.T) {
+	defer defaultConfig.Set("local_cgroup", defaultConfig.Get("local_cgroup"))
+	j := &job{UUID: uuid.New(), resources: Resources{CPUs: 2, Memory: 4}}
+	defaultConfig.Set("local_cgroup", "")
+	if c, err := newCgroup(defaultConfig, j); c != nil || err != nil {
+		t.Fatalf("newCgroup() = %v, %v without local_cgroup", c, err)
+	}
+	// A plain directory stands in for the cgroup filesystem.
+	parent := t.TempDir()
+	defaultConfig.Set("local_cgroup", parent)
+	c, err := newCgroup(defaultConfig, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{"memory.max": "4294967296", "memory.swap.max": "0", "cpu.max": "200000 100000"} {
+		b, err := ioutil.ReadFile(filepath.Join(c.dir, file))
+		if err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", file, b, err, want)
+		}
+	}
+	script := filepath.Join(parent, "job.sh")
+	if err := ioutil.WriteFile(script, []byte("echo $$\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := c.command(script)
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs, _ := ioutil.ReadFile(filepath.Join(c.dir, "cgroup.procs"))
+	if strings.TrimSpace(string(procs)) != strings.TrimSpace(string(out)) {
+		t.Errorf("cgroup.procs = %q, want the pid of the job %q", procs, out)
+	}
+	if c.oomKilled() {
+		t.Errorf("oomKilled() = true before any event")
+	}
+	events := "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"
+	if err := ioutil.WriteFile(filepath.Join(c.dir, "memory.events"), []byte(events), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.dir, "memory.peak"), []byte("1073741825\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !c.oomKilled() || c.memoryUsed() != 2 {
+		t.Errorf("oomKilled() = %v, memoryUsed() = %d, want true, 2", c.oomKilled(), c.memoryUsed())
+	}
+}
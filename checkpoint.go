@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// checkpointDir returns the checkpoint directory of the job run in the work
+// directory dir, under flowdir/checkpoints with the same name.
+func checkpointDir(conf *Config, dir string) (string, error) {
+	return filepath.Abs(filepath.Join(conf.GetString("flowdir"), "checkpoints", filepath.Base(filepath.Dir(dir)), filepath.Base(dir)))
+}
+
+// checkpointPrologue returns the shell commands that create the checkpoint
+// directory of the job run in dir and export it.
+func checkpointPrologue(conf *Config, dir string) (string, error) {
+	cp, err := checkpointDir(conf, dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("export FLOW_CHECKPOINT_DIR=%s\n", shellQuote(cp)))
+	b.WriteString("export SINGULARITYENV_FLOW_CHECKPOINT_DIR=\"$FLOW_CHECKPOINT_DIR\" APPTAINERENV_FLOW_CHECKPOINT_DIR=\"$FLOW_CHECKPOINT_DIR\"\n")
+	b.WriteString("mkdir -p \"$FLOW_CHECKPOINT_DIR\" || exit 1\n")
+	return b.String(), nil
+}
+
+// checkpointCommand returns what to put before the interpreter and script
+// of a task to run it checkpointed, which for dmtcp restarts it from its
+// checkpoint if there is one and otherwise launches it under DMTCP. Each
+// job has a coordinator of its own, on a free port.
+func checkpointCommand(conf *Config, r Resources) string {
+	if r.Checkpoint != "dmtcp" {
+		return ""
+	}
+	opts := fmt.Sprintf(`--new-coordinator --coord-port 0 --ckptdir "$d" --interval %d`, int(conf.GetDuration("checkpoint_interval").Seconds()))
+	script := fmt.Sprintf(`d="$FLOW_CHECKPOINT_DIR"; `+
+		`if ls "$d"/ckpt_*.dmtcp >/dev/null 2>&1; then echo "Restarting from the checkpoint in $d" >&2; exec dmtcp_restart %s "$d"/ckpt_*.dmtcp; fi; `+
+		`exec dmtcp_launch %s "$@"`, opts, opts)
+	return fmt.Sprintf("sh -c %s sh ", shellQuote(script))
+}
+
+// checkpointEpilogue returns the shell commands that remove the checkpoint
+// directory once the task has succeeded.
+func checkpointEpilogue() string {
+	return "[ $status -eq 0 ] && rm -rf \"$FLOW_CHECKPOINT_DIR\"\n"
+}
@@ -0,0 +1,59 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_checkpointCommand(t *testing.T) {
+	defer defaultConfig.Set("checkpoint_interval", defaultConfig.Get("checkpoint_interval"))
+	defaultConfig.Set("checkpoint_interval", "30m")
+	if got := checkpointCommand(defaultConfig, Resources{Checkpoint: "native"}); got != "" {
+		t.Errorf("checkpointCommand() = %q for native", got)
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0755)
+	for name, script := range map[string]string{
+		"dmtcp_launch":  "#!/bin/sh\necho launch \"$@\"\n",
+		"dmtcp_restart": "#!/bin/sh\necho restart \"$@\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cp := filepath.Join(dir, "checkpoints")
+	os.Mkdir(cp, 0755)
+	run := func() string {
+		cmd := exec.Command("sh", "-c", checkpointCommand(defaultConfig, Resources{Checkpoint: "dmtcp"})+"bash script.sh")
+		cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"), "FLOW_CHECKPOINT_DIR="+cp)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if got := run(); !strings.HasPrefix(got, "launch ") || !strings.Contains(got, "--interval 1800") || !strings.HasSuffix(got, " bash script.sh") {
+		t.Errorf("without a checkpoint: %s", got)
+	}
+	ckpt := filepath.Join(cp, "ckpt_bash_1.dmtcp")
+	if err := ioutil.WriteFile(ckpt, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !strings.HasPrefix(got, "restart ") || !strings.HasSuffix(got, " "+ckpt) {
+		t.Errorf("with a checkpoint: %s", got)
+	}
+}
+
+func Test_checkpointDir(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defaultConfig.Set("flowdir", "/data/.flow")
+	got, err := checkpointDir(defaultConfig, "/data/.flow/work/ab/cdef")
+	if err != nil || got != "/data/.flow/checkpoints/ab/cdef" {
+		t.Errorf("checkpointDir() = %s, %v", got, err)
+	}
+}
@@ -0,0 +1,179 @@
+package flow
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+)
+
+// RunFlags are the command line flags of a run of a workflow, which Main and
+// the flow command share.
+type RunFlags struct {
+	ConfigFile       string
+	JobRunner        string
+	Profile          string
+	Flowdir          string
+	ParamsFile       string
+	StartFromScratch bool
+	KeepTemp         bool
+	Unprotect        bool
+	ForceUnlock      bool
+	CleanEnv         bool
+	KeepGoing        bool
+	StableOrder      bool
+	PrepareOnly      bool
+	Benchmark        string
+	Repeats          int
+	DryRun           bool
+	Force            bool
+}
+
+// AddConfigFlags adds the flags that choose the config to fs: --config,
+// --job-runner, --profile and --flowdir.
+func (f *RunFlags) AddConfigFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&f.ConfigFile, "config", "c", "", "Config file")
+	fs.StringVarP(&f.JobRunner, "job-runner", "j", "", "Job runner")
+	fs.StringVar(&f.Profile, "profile", "", "Config profile to use")
+	fs.StringVar(&f.Flowdir, "flowdir", "", "Directory for flow's state (default .flow next to the workflow)")
+}
+
+// AddRunFlags adds the flags that change how the workflow is run to fs.
+func (f *RunFlags) AddRunFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&f.StartFromScratch, "start-from-scratch", "s", false, "Start from scratch")
+	fs.BoolVar(&f.KeepTemp, "keep-temp", false, "Keep temp outputs instead of removing them once consumed")
+	fs.BoolVar(&f.Unprotect, "unprotect", false, "Allow jobs to overwrite protected outputs")
+	fs.BoolVar(&f.ForceUnlock, "force-unlock", false, "Remove a stale lock on the flowdir")
+	fs.BoolVarP(&f.KeepGoing, "keep-going", "k", false, "Keep running jobs that do not depend on a failed job")
+	fs.BoolVar(&f.PrepareOnly, "prepare-only", false, "Only pull containers and stage remote inputs")
+	fs.StringVar(&f.Benchmark, "benchmark", "", "Run the tasks with this name repeatedly and report the resources used")
+	fs.IntVar(&f.Repeats, "repeat", 0, "How many times to run each benchmarked task (default 3)")
+	fs.BoolVar(&f.StableOrder, "stable-order", false, "Submit jobs that are ready together in a stable order")
+	fs.BoolVar(&f.CleanEnv, "clean-env", false, "Run jobs with only the allowed host environment variables")
+	fs.BoolVarP(&f.DryRun, "dry-run", "n", false, "Show the jobs that would be run without running them")
+	fs.BoolVarP(&f.Force, "force", "f", false, "Rerun the targets (or every job) even if they are done")
+	fs.StringVarP(&f.ParamsFile, "params", "p", "", "Parameter file (default params.yaml)")
+}
+
+// ConfigOverrides returns the config overrides of the flags added by
+// AddConfigFlags, other than --config. The flowdir is made absolute as,
+// unlike in the config, it is relative to the current directory.
+func (f *RunFlags) ConfigOverrides() (map[string]interface{}, error) {
+	overrides := make(map[string]interface{})
+	if f.JobRunner != "" {
+		overrides["job_runner"] = f.JobRunner
+	}
+	if f.Profile != "" {
+		overrides["profile"] = f.Profile
+	}
+	if f.Flowdir != "" {
+		dir, err := filepath.Abs(f.Flowdir)
+		if err != nil {
+			return nil, err
+		}
+		overrides["flowdir"] = dir
+	}
+	return overrides, nil
+}
+
+// Overrides returns the config overrides of all the flags, other than
+// --config.
+func (f *RunFlags) Overrides() (map[string]interface{}, error) {
+	overrides, err := f.ConfigOverrides()
+	if err != nil {
+		return nil, err
+	}
+	for key, set := range map[string]bool{
+		"start_from_scratch": f.StartFromScratch,
+		"keep_temp":          f.KeepTemp,
+		"unprotect":          f.Unprotect,
+		"force_unlock":       f.ForceUnlock,
+		"clean_env":          f.CleanEnv,
+		"keep_going":         f.KeepGoing,
+		"stable_order":       f.StableOrder,
+		"prepare_only":       f.PrepareOnly,
+		"dry_run":            f.DryRun,
+		"force":              f.Force,
+	} {
+		if set {
+			overrides[key] = true
+		}
+	}
+	if f.Benchmark != "" {
+		overrides["benchmark"] = f.Benchmark
+	}
+	if f.Repeats > 0 {
+		overrides["benchmark_repeats"] = f.Repeats
+	}
+	if f.ParamsFile != "" {
+		overrides["params_file"] = f.ParamsFile
+	}
+	return overrides, nil
+}
+
+// Main runs a workflow written as a main package, without the flow command:
+//
+//	func main() {
+//		flow.Main(func(q *flow.Queue) {
+//			q.Add(&MyTask{Output: "out.txt"})
+//		})
+//	}
+//
+// It parses the command line, initialises the config, logs to the run
+// directory, calls build to populate the queue and runs it. Any arguments
+// after the flags are targets: only the jobs needed to produce them are run.
+// Main does not return; it exits with a non-zero status if the workflow
+// fails.
+func Main(build func(*Queue)) {
+	if err := runMain(os.Args[0], os.Args[1:], build); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+func runMain(name string, args []string, build func(*Queue)) error {
+	var flags RunFlags
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [target...]\n", name)
+		fs.PrintDefaults()
+	}
+	flags.AddConfigFlags(fs)
+	flags.AddRunFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	overrides, err := flags.Overrides()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		overrides["targets"] = fs.Args()
+	}
+	if err := InitConfig(flags.ConfigFile, overrides); err != nil {
+		return err
+	}
+	runDir, err := RunDir()
+	if err != nil {
+		return err
+	}
+	logFile := filepath.Join(runDir, "flow.log")
+	logw, err := os.Create(logFile)
+	if err != nil {
+		return fmt.Errorf("unable to create log file: %s: %v", logFile, err)
+	}
+	defer logw.Close()
+	log.SetOutput(io.MultiWriter(os.Stderr, logw))
+	log.Printf("Run directory: %s", runDir)
+	SafeWriteConfigAs(filepath.Join(runDir, "config.yaml"))
+
+	queue := &Queue{}
+	build(queue)
+	return queue.Run()
+}
@@ -0,0 +1,45 @@
+package flow
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func Test_RunFlags_Overrides(t *testing.T) {
+	cwd, _ := os.Getwd()
+	var flags RunFlags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.AddConfigFlags(fs)
+	flags.AddRunFlags(fs)
+	args := []string{"-c", "flow.yaml", "-j", "slurm", "--flowdir", "state", "--prepare-only", "-n", "--repeat", "2"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	got, err := flags.Overrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"job_runner":        "slurm",
+		"flowdir":           filepath.Join(cwd, "state"),
+		"prepare_only":      true,
+		"dry_run":           true,
+		"benchmark_repeats": 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Overrides() = %v, want %v", got, want)
+	}
+	if flags.ConfigFile != "flow.yaml" {
+		t.Errorf("ConfigFile = %s, want flow.yaml", flags.ConfigFile)
+	}
+}
+
+func Test_runMain_help(t *testing.T) {
+	if err := runMain("workflow", []string{"--help"}, func(*Queue) { t.Errorf("runMain() --help ran the workflow") }); err != nil {
+		t.Errorf("runMain() --help = %v, want nil", err)
+	}
+}
@@ -0,0 +1,174 @@
+package flow
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Pipeline builds a shell command from arguments that are quoted as
+// needed, so paths containing spaces or other special characters are passed
+// through intact. Create one with Cmd:
+//
+//	flow.Cmd("bwa", "mem", "-t", r.CPUs, t.Ref, t.Reads).
+//		Pipe("samtools", "sort", "-o", t.Output, "-").
+//		String()
+//
+// Arguments may be strings, Files, numbers, anything implementing
+// fmt.Stringer, or slices of these, which add one argument per element. Raw
+// arguments are added without quoting.
+type Pipeline struct {
+	// commands are joined by their operator: "|", "&&" or "".
+	commands  []string
+	operators []string
+	stdin     string
+	stdout    string
+	stderr    string
+	pipes     int
+}
+
+// Raw is an argument that is added to a command as is, for shell syntax
+// such as redirections or variables.
+type Raw string
+
+// Cmd starts a pipeline with the command name and its arguments.
+func Cmd(name string, args ...interface{}) *Pipeline {
+	return &Pipeline{commands: []string{commandLine(name, args)}, operators: []string{""}}
+}
+
+// Pipe adds a command that reads the output of the previous one. The
+// pipeline fails if any of its commands do (pipefail).
+func (p *Pipeline) Pipe(name string, args ...interface{}) *Pipeline {
+	p.commands = append(p.commands, commandLine(name, args))
+	p.operators = append(p.operators, "|")
+	p.pipes++
+	return p
+}
+
+// Then adds a command that is run if the previous one succeeds.
+func (p *Pipeline) Then(name string, args ...interface{}) *Pipeline {
+	p.commands = append(p.commands, commandLine(name, args))
+	p.operators = append(p.operators, "&&")
+	return p
+}
+
+// From redirects the input of the pipeline from a file.
+func (p *Pipeline) From(path interface{}) *Pipeline {
+	p.stdin = argString(path)
+	return p
+}
+
+// To redirects the output of the pipeline to a file.
+func (p *Pipeline) To(path interface{}) *Pipeline {
+	p.stdout = argString(path)
+	return p
+}
+
+// Stderr redirects the standard error of the pipeline to a file.
+func (p *Pipeline) Stderr(path interface{}) *Pipeline {
+	p.stderr = argString(path)
+	return p
+}
+
+// String returns the command line.
+func (p *Pipeline) String() string {
+	var b strings.Builder
+	if p.pipes > 0 {
+		b.WriteString("set -o pipefail; ")
+	}
+	for i, c := range p.commands {
+		if p.operators[i] != "" {
+			fmt.Fprintf(&b, " %s ", p.operators[i])
+		}
+		b.WriteString(c)
+	}
+	cmd := b.String()
+	redirects := ""
+	if p.stdin != "" {
+		redirects += " <" + shellQuote(p.stdin)
+	}
+	if p.stdout != "" {
+		redirects += " >" + shellQuote(p.stdout)
+	}
+	if p.stderr != "" {
+		redirects += " 2>" + shellQuote(p.stderr)
+	}
+	if redirects == "" {
+		return cmd
+	}
+	if len(p.commands) > 1 {
+		// Apply the redirections to the whole pipeline, not just its
+		// last command.
+		if p.pipes > 0 {
+			return "set -o pipefail; { " + strings.TrimPrefix(cmd, "set -o pipefail; ") + "; }" + redirects
+		}
+		return "{ " + cmd + "; }" + redirects
+	}
+	return cmd + redirects
+}
+
+func commandLine(name string, args []interface{}) string {
+	words := []string{shellQuote(name)}
+	for _, arg := range args {
+		words = append(words, argWords(arg)...)
+	}
+	return strings.Join(words, " ")
+}
+
+// argWords returns the quoted words for an argument.
+func argWords(arg interface{}) []string {
+	if raw, ok := arg.(Raw); ok {
+		return []string{string(raw)}
+	}
+	val := reflect.ValueOf(arg)
+	if val.Kind() == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8 {
+		words := []string{}
+		for i := 0; i < val.Len(); i++ {
+			words = append(words, argWords(val.Index(i).Interface())...)
+		}
+		return words
+	}
+	return []string{shellQuote(argString(arg))}
+}
+
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case fmt.Stringer:
+		return arg.String()
+	}
+	return fmt.Sprint(arg)
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for use as a single word in a shell command.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellPattern quotes the glob pattern p as shellQuote does, except for the
+// wildcard characters, so that the shell still expands it.
+func shellPattern(p string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexAny(p, "*?[]")
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			b.WriteString(shellQuote(p[:i]))
+		}
+		b.WriteByte(p[i])
+		p = p[i+1:]
+	}
+	if p != "" {
+		b.WriteString(shellQuote(p))
+	}
+	return b.String()
+}
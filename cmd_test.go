@@ -0,0 +1,25 @@
+package flow
+
+import "testing"
+
+func Test_Pipeline(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *Pipeline
+		want string
+	}{
+		{"simple", Cmd("gzip", "-c", "my file.txt").To("out (1).gz"), "gzip -c 'my file.txt' >'out (1).gz'"},
+		{"values", Cmd("bwa", "mem", "-t", 4, File{Path: "/ref.fa"}, []string{"/a.fq", "/b c.fq"}), "bwa mem -t 4 /ref.fa /a.fq '/b c.fq'"},
+		{"raw", Cmd("tool", Raw("2>/dev/null"), "$HOME"), "tool 2>/dev/null '$HOME'"},
+		{"pipe", Cmd("cat", "/a").Pipe("sort", "-u"), "set -o pipefail; cat /a | sort -u"},
+		{"pipe_redirect", Cmd("cat", "/a").Pipe("sort").To("/b"), "set -o pipefail; { cat /a | sort; } >/b"},
+		{"then", Cmd("mkdir", "-p", "/d").Then("touch", "/d/x"), "mkdir -p /d && touch /d/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package flow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func isCondaFile(spec string) bool {
+	ext := filepath.Ext(spec)
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// condaPrefix returns the directory of the environment created for an
+// environment file, in conda_dir and named by a hash of the file so that it
+// is reused until the file changes.
+func condaPrefix(conf *Config, fn string) (string, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return "", fmt.Errorf("unable to read conda environment: %v", err)
+	}
+	dir := conf.GetString("conda_dir")
+	if dir == "" {
+		dir = filepath.Join(conf.GetString("flowdir"), "conda")
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return filepath.Join(dir, hex.EncodeToString(sum[:])[:16]), nil
+}
+
+// createCondaEnvs creates the environments for the environment files used
+// by jobs that do not exist yet.
+func createCondaEnvs(conf *Config, jobs []*job) error {
+	done := make(map[string]bool)
+	for _, j := range jobs {
+		fn := j.resources.Conda
+		if !isCondaFile(fn) || done[fn] {
+			continue
+		}
+		done[fn] = true
+		prefix, err := condaPrefix(conf, fn)
+		if err != nil {
+			return err
+		}
+		if ok, _ := fileExists(condaMarker(prefix)); ok {
+			continue
+		}
+		unlock, err := lockCondaPrefix(prefix)
+		if err != nil {
+			return err
+		}
+		err = createCondaEnv(conf, fn, prefix)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// condaMarker is written once the environment at prefix is complete, so one
+// left half built by an interrupted run is recreated.
+func condaMarker(prefix string) string {
+	return filepath.Join(prefix, ".flow-created")
+}
+
+// createCondaEnv creates the environment for fn at prefix, unless it was
+// completed by another run while this one waited for the lock on it.
+func createCondaEnv(conf *Config, fn, prefix string) error {
+	marker := condaMarker(prefix)
+	if ok, _ := fileExists(marker); ok {
+		return nil
+	}
+	if err := os.RemoveAll(prefix); err != nil {
+		return fmt.Errorf("unable to remove incomplete conda environment: %v", err)
+	}
+	log.Printf("Creating conda environment for %s in %s", fn, prefix)
+	cmd := exec.Command(conf.GetString("conda_bin"), "env", "create", "--quiet", "--prefix", prefix, "--file", fn)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to create conda environment for %s: %v: %s", fn, err, out)
+	}
+	if err := ioutil.WriteFile(marker, []byte(fn+"\n"), 0644); err != nil {
+		return fmt.Errorf("unable to mark conda environment as created: %v", err)
+	}
+	return nil
+}
+
+// condaLockPoll is how often a run waiting for the lock on an environment
+// checks whether it has been released.
+var condaLockPoll = 10 * time.Second
+
+// lockCondaPrefix takes the lock on creating the environment at prefix, which
+// runs sharing conda_dir may want at the same time, waiting for any run that
+// holds it. It returns the function that releases the lock.
+func lockCondaPrefix(prefix string) (func(), error) {
+	fn := prefix + ".lock"
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create conda_dir: %v", err)
+	}
+	for waited := false; ; waited = true {
+		if stale, holder := staleLock(fn); stale {
+			log.Printf("Removing stale lock held by %s", holder)
+			os.Remove(fn)
+		}
+		err := createLock(fn)
+		if err == nil {
+			return func() { os.Remove(fn) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("unable to create lock: %s: %v", fn, err)
+		}
+		if !waited {
+			log.Printf("Waiting for another run to create the conda environment in %s", prefix)
+		}
+		time.Sleep(condaLockPoll)
+	}
+}
+
+// condaActivate returns the shell commands that activate a conda
+// environment.
+func condaActivate(conf *Config, spec string) (string, error) {
+	if spec == "" {
+		return "", nil
+	}
+	env := spec
+	if isCondaFile(spec) {
+		var err error
+		env, err = condaPrefix(conf, spec)
+		if err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "eval \"$(%s shell.bash hook)\"\n", shellQuote(conf.GetString("conda_bin")))
+	fmt.Fprintf(&b, "conda activate %s || exit 1\n", shellQuote(env))
+	return b.String(), nil
+}
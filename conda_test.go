@@ -0,0 +1,99 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_condaActivate(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "environment.yml")
+	if err := ioutil.WriteFile(fn, []byte("dependencies:\n  - samtools=1.19\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer defaultConfig.Set("conda_dir", nil)
+	defaultConfig.Set("conda_dir", filepath.Join(dir, "envs"))
+	defaultConfig.Set("conda_bin", "conda")
+	tests := []struct {
+		name string
+		spec string
+		want string
+	}{
+		{"none", "", ""},
+		{"name", "samtools", "conda activate samtools || exit 1\n"},
+		{"file", fn, "conda activate " + filepath.Join(dir, "envs") + "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := condaActivate(defaultConfig, tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("condaActivate() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createCondaEnvs(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "environment.yml")
+	if err := ioutil.WriteFile(fn, []byte("dependencies:\n  - samtools=1.19\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(poll time.Duration) { condaLockPoll = poll }(condaLockPoll)
+	condaLockPoll = 10 * time.Millisecond
+	conf := newConfig()
+	conf.Set("conda_dir", filepath.Join(dir, "envs"))
+	conf.Set("conda_bin", "conda")
+	// conda env create --quiet --prefix <prefix> --file <fn>
+	defer fakeScript(t, "conda", `mkdir -p "$5" && echo "$5" >>`+filepath.Join(dir, "created")+"\n")()
+	prefix, err := condaPrefix(conf, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := []*job{{resources: Resources{Conda: fn}}, {resources: Resources{Conda: fn}}}
+
+	// Another run holds the lock and completes the environment.
+	if err := os.MkdirAll(filepath.Dir(prefix), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := createLock(prefix + ".lock"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.MkdirAll(prefix, 0755)
+		ioutil.WriteFile(condaMarker(prefix), nil, 0644)
+		os.Remove(prefix + ".lock")
+	}()
+	if err := createCondaEnvs(conf, jobs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "created")); err == nil {
+		t.Errorf("environment created by another run was created again")
+	}
+
+	// An environment left incomplete is recreated, once.
+	if err := os.Remove(condaMarker(prefix)); err != nil {
+		t.Fatal(err)
+	}
+	if err := createCondaEnvs(conf, jobs); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "created"))
+	if err != nil || string(b) != prefix+"\n" {
+		t.Errorf("created environments = %q, %v, want %s", b, err, prefix)
+	}
+	if ok, _ := fileExists(condaMarker(prefix)); !ok {
+		t.Errorf("environment was not marked as created")
+	}
+	if ok, _ := fileExists(prefix + ".lock"); ok {
+		t.Errorf("lock on the environment was not released")
+	}
+}
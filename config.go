@@ -0,0 +1,936 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The type of each key that may appear in a config file, so that mistakes
+// are reported when the config is read rather than silently taking a zero
+// value.
+type configKind int
+
+const (
+	kindString configKind = iota
+	kindBool
+	kindInt
+	kindNumber
+	kindDuration
+	kindMode
+	kindStrings
+	kindEnv
+	kindSelectors
+	kindSecrets
+	kindProfiles
+	kindObject
+)
+
+// configKey is a key that may appear in a config file. The keys of a
+// kindObject are given by fields.
+type configKey struct {
+	kind   configKind
+	help   string
+	fields map[string]configKind
+}
+
+var configKeys = map[string]configKey{
+	"flowdir":                {kindString, "Directory for flow's state, logs and job scripts, relative to the directory of the workflow.", nil},
+	"workflow":               {kindString, "The workflow being run, set by flow; a relative flowdir is relative to its directory.", nil},
+	"tmpdir":                 {kindString, "Directory for temporary files, relative to the flowdir.", nil},
+	"start_from_scratch":     {kindBool, "Rerun every job, even those that have completed.", nil},
+	"dry_run":                {kindBool, "Show the jobs that would be run without running them.", nil},
+	"force":                  {kindBool, "Rerun the targets, or every job, even if they are done.", nil},
+	"targets":                {kindStrings, "Only run the jobs needed to produce these outputs.", nil},
+	"keep_temp":              {kindBool, "Keep temp outputs instead of removing them once consumed.", nil},
+	"unprotect":              {kindBool, "Allow jobs to overwrite protected outputs.", nil},
+	"force_unlock":           {kindBool, "Remove a stale lock on the flowdir.", nil},
+	"keep_going":             {kindBool, "After a job fails, keep running the jobs that do not depend on it.", nil},
+	"stable_order":           {kindBool, "Submit jobs that are ready at the same time in topological order, then by name.", nil},
+	"longest_first":          {kindBool, "Submit the jobs expected to take longest, from previous runs, first.", nil},
+	"keep_build":             {kindBool, "Keep the directory the workflow was compiled in after it has run successfully.", nil},
+	"archive_logs":           {kindBool, "Move the work directories and logs of the jobs of a successful run into a single archive.", nil},
+	"archive_dir":            {kindString, "Directory for the archives of archive_logs (default flowdir/archive).", nil},
+	"gc_keep_runs":           {kindInt, "How many of the newest run directories flow clean --auto keeps, or 0 to keep them by age alone.", nil},
+	"gc_keep_days":           {kindInt, "flow clean --auto keeps the run directories from this many days, or 0 to keep them by number alone.", nil},
+	"adaptive_resources":     {kindBool, "Ask for the memory and time jobs of each analysis used in previous runs, plus a margin, instead of what the task and config give.", nil},
+	"adaptive_min_samples":   {kindInt, "How many previous jobs of an analysis must have been recorded before adaptive_resources applies to it.", nil},
+	"adaptive_margin":        {kindNumber, "The fraction added to the 95th percentile of what an analysis used when it is suggested, or adapted.", nil},
+	"benchmark":              {kindString, "Instead of running the workflow, run the tasks with this name (a pattern) repeatedly and report the resources used.", nil},
+	"benchmark_repeats":      {kindInt, "How many times benchmark runs each task.", nil},
+	"clean_env":              {kindBool, "Run jobs with only the allowed host environment variables.", nil},
+	"env_passthrough":        {kindStrings, "Host variables passed to jobs when clean_env is set.", nil},
+	"poll_interval":          {kindDuration, "How often to check the status of running jobs.", nil},
+	"poll_min_interval":      {kindDuration, "The shortest time between checks of a job.", nil},
+	"heartbeat_interval":     {kindDuration, "How often running jobs touch their heartbeat file.", nil},
+	"heartbeat_timeout":      {kindDuration, "How long without a heartbeat before a job is considered dead.", nil},
+	"heartbeat_resubmits":    {kindInt, "How many times a dead job is resubmitted.", nil},
+	"node_fail_resubmits":    {kindInt, "How many times a job whose node failed is resubmitted.", nil},
+	"timeout_resubmits":      {kindInt, "How many times a job killed for running longer than its time is resubmitted with more time.", nil},
+	"timeout_time_factor":    {kindNumber, "What the time of a job that timed out is multiplied by when it is resubmitted.", nil},
+	"timeout_max_time":       {kindInt, "The most hours a job that timed out is resubmitted with, or 0 for no limit.", nil},
+	"vanished_timeout":       {kindDuration, "How long after submission a job the scheduler has no record of is considered lost with its node.", nil},
+	"preempt_resubmits":      {kindInt, "How many times a preempted job is resubmitted.", nil},
+	"preempt_fallback":       {kindObject, "Resources, as in a selector, for jobs preempted preempt_fallback_after times, e.g., an on-demand partition.", selectorKinds},
+	"preempt_fallback_after": {kindInt, "How many preemptions before preempt_fallback applies to a job.", nil},
+	"submit_retries":         {kindInt, "How many times to retry submitting a job after an error that matches submit_retry_patterns.", nil},
+	"submit_retry_delay":     {kindDuration, "How long to wait before retrying a submission, doubled after each attempt.", nil},
+	"submit_retry_patterns":  {kindStrings, "Submission errors containing any of these (ignoring case) are retried, others fail the job.", nil},
+	"prepare":                {kindBool, "Pull containers and stage remote inputs before submitting any job.", nil},
+	"prepare_only":           {kindBool, "Only pull containers and stage remote inputs, without running the workflow.", nil},
+	"check_disk_space":       {kindBool, "Before a run, check that there is room for the outputs of tasks that set an output size.", nil},
+	"umask":                  {kindMode, "The umask of jobs, in octal, e.g., 0002 so that the group can write their outputs.", nil},
+	"output_mode":            {kindMode, "Permissions, in octal, added to the outputs of jobs that succeed, e.g., 0440 to make them readable by the group.", nil},
+	"publish_mode":           {kindString, "The default publish mode: copy, move, symlink, hardlink or link.", nil},
+	"publish_group":          {kindString, "Group, a name or id, given to published outputs and their directories, which are also made setgid.", nil},
+	"workflow_loader":        {kindString, "How Go workflows are loaded: plugin, interpreter or executable.", nil},
+	"params_file":            {kindString, "Parameter file, params.yaml by default.", nil},
+	"aws_bin":                {kindString, "The aws command.", nil},
+	"gcloud_bin":             {kindString, "The gcloud command.", nil},
+	"azcopy_bin":             {kindString, "The azcopy command.", nil},
+	"azure_sas_token":        {kindString, "SAS token for Azure storage.", nil},
+	"curl_bin":               {kindString, "The curl command.", nil},
+	"vault_bin":              {kindString, "The vault command, for secrets.", nil},
+	"conda_bin":              {kindString, "The conda command.", nil},
+	"conda_dir":              {kindString, "Directory for conda environments created by flow, flowdir/conda by default.", nil},
+	"modules_init":           {kindStrings, "Scripts that define the module command, the first that exists is used.", nil},
+	"ils_bin":                {kindString, "The iRODS ils command.", nil},
+	"iget_bin":               {kindString, "The iRODS iget command.", nil},
+	"iput_bin":               {kindString, "The iRODS iput command.", nil},
+	"imkdir_bin":             {kindString, "The iRODS imkdir command.", nil},
+	"imeta_bin":              {kindString, "The iRODS imeta command.", nil},
+	"ichksum_bin":            {kindString, "The iRODS ichksum command.", nil},
+	"job_runner":             {kindString, "How jobs are run: local, slurm or pbs.", nil},
+	"singularity_bin":        {kindString, "The singularity command.", nil},
+	"local_cgroup":           {kindString, "A cgroup v2 directory in which the local runner limits each job to its CPUs and memory.", nil},
+	"local_nice":             {kindInt, "How much to lower the CPU priority of local jobs, from 0 to 19 as for nice.", nil},
+	"local_ionice":           {kindString, "The I/O priority of local jobs: idle, best-effort or best-effort:N (0 to 7).", nil},
+	"pack_size":              {kindInt, "The most tasks with the same pack run in a single job.", nil},
+	"checkpoint_interval":    {kindDuration, "How often tasks checkpointed with dmtcp are checkpointed.", nil},
+	"pipes":                  {kindBool, "Stream outputs declared with the pipe modifier to the task that reads them through named pipes.", nil},
+	"tmpfs_dir":              {kindString, "A directory in memory for the temporary directories of tasks that set tmpfs.", nil},
+	"singularity_cachedir":   {kindString, "Directory singularity caches pulled images in, flowdir/cache/singularity by default.", nil},
+	"singularity_tmpdir":     {kindString, "Directory singularity builds images in, tmpdir by default.", nil},
+	"singularity_build_args": {kindString, "Options for singularity build, for containers built from definition files.", nil},
+	"docker_bin":             {kindString, "The docker command, or podman, for containers built from Dockerfiles.", nil},
+	"profile":                {kindString, "The profile to use, from profiles.", nil},
+	"env":                    {kindEnv, "Environment variables for every task.", nil},
+	"selectors":              {kindSelectors, "Resource overrides for tasks matched by withName or withLabel.", nil},
+	"secrets":                {kindSecrets, "Secrets tasks may use, read from env, file or vault.", nil},
+	"slurm":                  {kindObject, "Defaults for SLURM jobs: account, partition and qos.", map[string]configKind{"account": kindString, "partition": kindString, "qos": kindString}},
+	"pbs":                    {kindObject, "Defaults for PBS jobs: account and queue.", map[string]configKind{"account": kindString, "queue": kindString}},
+	"pricing":                {kindObject, "Prices to estimate the cost of jobs: cpu_hour, memory_hour (per GB) and currency.", map[string]configKind{"cpu_hour": kindNumber, "memory_hour": kindNumber, "currency": kindString}},
+	"budget":                 {kindNumber, "With pricing, no job is submitted that could make the run cost more than this.", nil},
+	"scheduler_args":         {kindString, "Options added to the command that submits every job to the scheduler.", nil},
+	"vars":                   {kindEnv, "Variables for ${name} references in other settings.", nil},
+	"profiles":               {kindProfiles, "Named sets of settings, chosen with --profile or FLOW_PROFILE.", nil},
+}
+
+// selectorKinds are the keys of a selector, other than withName or
+// withLabel, which are the resources it sets.
+var selectorKinds = map[string]configKind{
+	"cpus":                   kindInt,
+	"memory":                 kindInt,
+	"time":                   kindInt,
+	"container":              kindString,
+	"singularity_extra_args": kindString,
+	"scratch":                kindBool,
+	"isolated":               kindBool,
+	"tmpfs":                  kindInt,
+	"env":                    kindEnv,
+	"ulimits":                kindEnv,
+	"secrets":                kindStrings,
+	"modules":                kindStrings,
+	"conda":                  kindString,
+	"account":                kindString,
+	"queue":                  kindString,
+	"partition":              kindString,
+	"qos":                    kindString,
+	"scheduler_args":         kindString,
+	"allow_failure":          kindBool,
+	"output_size":            kindString,
+	"pack":                   kindString,
+	"batch":                  kindInt,
+	"checkpoint":             kindString,
+}
+
+// configProblem is a mistake in a config file. path is the keys leading to
+// it, with list items written as their index, for finding its line.
+type configProblem struct {
+	path []string
+	msg  string
+}
+
+// configLayers returns the config files read, if they exist, after the
+// defaults and before the one given with --config, each taking precedence
+// over those before. FLOW_<KEY> variables, the profile and command line
+// options follow, in that order.
+func configLayers() []string {
+	layers := []string{"/etc/flow/flow.yaml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		layers = append(layers, filepath.Join(home, ".config", "flow", "flow.yaml"))
+	}
+	return append(layers, "flow.yaml")
+}
+
+// readLayer reads and validates the config file fn and sets the
+// config from it.
+func (c *Config) readLayer(fn string) error {
+	layer := viper.New()
+	layer.SetConfigFile(fn)
+	if err := layer.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := validateConfig(fn, layer.AllSettings()); err != nil {
+		return err
+	}
+	for _, key := range layer.AllKeys() {
+		c.v.Set(key, layer.Get(key))
+		c.origins[key] = fn
+	}
+	return nil
+}
+
+// applyEnv sets the config from FLOW_<KEY> environment variables, as
+// otherwise the config files would take precedence over them. Values are
+// parsed as the type of their key. The fields of objects are given as
+// FLOW_<KEY>_<FIELD>, such as FLOW_SLURM_ACCOUNT.
+func (c *Config) applyEnv() {
+	kinds := map[string]configKind{}
+	for key, k := range configKeys {
+		kinds[key] = k.kind
+		for field, kind := range k.fields {
+			kinds[key+"."+field] = kind
+		}
+	}
+	for key, kind := range kinds {
+		name := "FLOW_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		var val interface{} = s
+		switch kind {
+		case kindBool:
+			if b, err := strconv.ParseBool(s); err == nil {
+				val = b
+			}
+		case kindInt:
+			if x, err := strconv.Atoi(s); err == nil {
+				val = x
+			}
+		case kindNumber:
+			if x, err := strconv.ParseFloat(s, 64); err == nil {
+				val = x
+			}
+		case kindStrings:
+			val = strings.Fields(strings.ReplaceAll(s, ",", " "))
+		case kindString, kindDuration, kindMode:
+		default:
+			// Maps and lists cannot be given in the environment.
+			continue
+		}
+		c.v.Set(key, val)
+		c.origins[key] = "env " + name
+	}
+}
+
+// origin returns where the setting key came from.
+func (c *Config) origin(key string) string {
+	for k := key; ; {
+		if origin, ok := c.origins[k]; ok {
+			return origin
+		}
+		i := strings.LastIndex(k, ".")
+		if i < 0 {
+			return "default"
+		}
+		k = k[:i]
+	}
+}
+
+// ShowConfig writes the settings in effect to w, as YAML, with where each
+// came from if origins is set.
+func ShowConfig(w io.Writer, origins bool) error {
+	c := currentConfig()
+	keys := c.v.AllKeys()
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		val := templateValue(c.Get(key))
+		if key == "azure_sas_token" {
+			val = "<hidden>"
+		}
+		fmt.Fprintf(&b, "%s: %s", key, val)
+		if origins {
+			fmt.Fprintf(&b, "  # %s", c.origin(key))
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// validateConfig checks the settings read from the config file fn and
+// returns an error listing every problem found.
+func validateConfig(fn string, settings map[string]interface{}) error {
+	problems := checkConfig(nil, settings, false)
+	if len(problems) == 0 {
+		return nil
+	}
+	lines := []string{}
+	if b, err := ioutil.ReadFile(fn); err == nil {
+		lines = strings.Split(string(b), "\n")
+	}
+	msgs := []string{}
+	for _, p := range problems {
+		where := fn
+		if n := configLine(lines, p.path); n > 0 {
+			where = fmt.Sprintf("%s:%d", fn, n)
+		}
+		msgs = append(msgs, fmt.Sprintf("  %s: %s: %s", where, strings.Join(p.path, "."), p.msg))
+	}
+	return fmt.Errorf("invalid config:\n%s", strings.Join(msgs, "\n"))
+}
+
+func checkConfig(path []string, settings map[string]interface{}, inProfile bool) []configProblem {
+	problems := []configProblem{}
+	for _, key := range sortedKeys(settings) {
+		p := childPath(path, key)
+		k, ok := configKeys[key]
+		if !ok || (inProfile && k.kind == kindProfiles) {
+			problems = append(problems, configProblem{p, "unknown key"})
+			continue
+		}
+		if k.kind == kindObject {
+			problems = append(problems, checkObject(p, k.fields, settings[key])...)
+			continue
+		}
+		problems = append(problems, checkValue(p, k.kind, settings[key])...)
+	}
+	return problems
+}
+
+func checkValue(path []string, kind configKind, val interface{}) []configProblem {
+	problem := func(format string, a ...interface{}) []configProblem {
+		return []configProblem{{path, fmt.Sprintf(format, a...)}}
+	}
+	if s, ok := val.(string); ok && strings.Contains(s, "${") {
+		// A reference, which is expanded after the config is read.
+		return nil
+	}
+	switch kind {
+	case kindString:
+		if !isScalar(val) {
+			return problem("must be a string")
+		}
+	case kindBool:
+		if _, ok := val.(bool); !ok {
+			return problem("must be true or false, not %v", val)
+		}
+	case kindInt:
+		if _, err := strconv.Atoi(fmt.Sprint(val)); err != nil || !isScalar(val) {
+			return problem("must be a whole number, not %v", val)
+		}
+	case kindNumber:
+		if _, err := strconv.ParseFloat(fmt.Sprint(val), 64); err != nil || !isScalar(val) {
+			return problem("must be a number, not %v", val)
+		}
+	case kindDuration:
+		if _, err := time.ParseDuration(fmt.Sprint(val)); err != nil {
+			return problem("must be a duration such as 30s or 5m, not %v", val)
+		}
+	case kindMode:
+		// An empty string is the default, leaving the mode unset, and is
+		// what a config written back by viper holds.
+		if val == "" {
+			break
+		}
+		if _, err := parseMode(val); err != nil {
+			return problem("%v", err)
+		}
+	case kindStrings:
+		xs, ok := val.([]interface{})
+		if !ok {
+			return problem("must be a list")
+		}
+		for _, x := range xs {
+			if !isScalar(x) {
+				return problem("must be a list of strings")
+			}
+		}
+	case kindEnv:
+		m, ok := configMap(val)
+		if !ok {
+			return problem("must be a map of variable names to values")
+		}
+		for k, x := range m {
+			if !isScalar(x) {
+				return []configProblem{{childPath(path, k), "must be a string"}}
+			}
+		}
+	case kindSelectors:
+		return checkSelectors(path, val)
+	case kindSecrets:
+		return checkSecrets(path, val)
+	case kindProfiles:
+		m, ok := configMap(val)
+		if !ok {
+			return problem("must be a map of profile names to settings")
+		}
+		problems := []configProblem{}
+		for _, name := range sortedKeys(m) {
+			p := childPath(path, name)
+			settings, ok := configMap(m[name])
+			if !ok {
+				problems = append(problems, configProblem{p, "must be a map of settings"})
+				continue
+			}
+			problems = append(problems, checkConfig(p, settings, true)...)
+		}
+		return problems
+	}
+	return nil
+}
+
+func checkObject(path []string, fields map[string]configKind, val interface{}) []configProblem {
+	m, ok := configMap(val)
+	if !ok {
+		return []configProblem{{path, "must be a map"}}
+	}
+	problems := []configProblem{}
+	for _, key := range sortedKeys(m) {
+		kind, ok := fields[key]
+		if !ok {
+			problems = append(problems, configProblem{childPath(path, key), "unknown key"})
+			continue
+		}
+		problems = append(problems, checkValue(childPath(path, key), kind, m[key])...)
+	}
+	return problems
+}
+
+func checkSelectors(path []string, val interface{}) []configProblem {
+	xs, ok := val.([]interface{})
+	if !ok {
+		return []configProblem{{path, "must be a list of selectors"}}
+	}
+	problems := []configProblem{}
+	for i, x := range xs {
+		p := childPath(path, strconv.Itoa(i))
+		sel, ok := configMap(x)
+		if !ok {
+			problems = append(problems, configProblem{p, "must be a map"})
+			continue
+		}
+		_, byName := sel["withname"]
+		_, byLabel := sel["withlabel"]
+		if byName == byLabel {
+			problems = append(problems, configProblem{p, "must have one of withName or withLabel"})
+		}
+		if len(sel) == 1 && byName != byLabel {
+			problems = append(problems, configProblem{p, "sets no resources"})
+		}
+		for _, key := range sortedKeys(sel) {
+			kp := childPath(p, key)
+			switch key {
+			case "withname", "withlabel":
+				if !isScalar(sel[key]) {
+					problems = append(problems, configProblem{kp, "must be a string"})
+				}
+				continue
+			case "memory", "time":
+				if _, err := resourceInt(key, sel[key]); err != nil {
+					problems = append(problems, configProblem{kp, err.Error()})
+				}
+				continue
+			case "ulimits":
+				if err := checkUlimits(stringMap(sel[key])); err != nil {
+					problems = append(problems, configProblem{kp, err.Error()})
+					continue
+				}
+			}
+			kind, ok := selectorKinds[key]
+			if !ok {
+				problems = append(problems, configProblem{kp, "unknown resource"})
+				continue
+			}
+			problems = append(problems, checkValue(kp, kind, sel[key])...)
+		}
+	}
+	return problems
+}
+
+func checkSecrets(path []string, val interface{}) []configProblem {
+	m, ok := configMap(val)
+	if !ok {
+		return []configProblem{{path, "must be a map of secret names to sources"}}
+	}
+	problems := []configProblem{}
+	for _, name := range sortedKeys(m) {
+		p := childPath(path, name)
+		src, ok := configMap(m[name])
+		if !ok {
+			problems = append(problems, configProblem{p, "must be a map with one of env, file or vault"})
+			continue
+		}
+		n := 0
+		for _, key := range sortedKeys(src) {
+			switch key {
+			case "env", "file", "vault":
+				n++
+			case "field":
+			default:
+				problems = append(problems, configProblem{childPath(p, key), "unknown key"})
+			}
+		}
+		if n != 1 {
+			problems = append(problems, configProblem{p, "must have one of env, file or vault"})
+		}
+	}
+	return problems
+}
+
+// resourceInt converts the value of a cpus, memory or time resource. Memory
+// may be given with a unit, such as 16GB or 512MB, and time as HH:MM:SS,
+// which is rounded up to whole hours.
+func resourceInt(key string, val interface{}) (int, error) {
+	s := strings.TrimSpace(fmt.Sprint(val))
+	if x, err := strconv.Atoi(s); err == nil {
+		return x, nil
+	}
+	switch key {
+	case "memory":
+		s = strings.ToLower(s)
+		if strings.HasSuffix(s, "g") || strings.HasSuffix(s, "m") || strings.HasSuffix(s, "k") {
+			s += "b"
+		}
+		if x, err := convertMemory(s); err == nil {
+			return x, nil
+		}
+		return 0, fmt.Errorf("memory must be a number of GB or have a unit such as 16GB, not %s", s)
+	case "time":
+		if secs, err := convertWalltime(s); err == nil {
+			return (secs + 3599) / 3600, nil
+		}
+		return 0, fmt.Errorf("time must be a number of hours or HH:MM:SS, not %s", s)
+	}
+	return 0, fmt.Errorf("%s must be a whole number, not %s", key, s)
+}
+
+func isScalar(val interface{}) bool {
+	switch val.(type) {
+	case []interface{}, map[string]interface{}, map[interface{}]interface{}, nil:
+		return false
+	}
+	return true
+}
+
+// configMap returns val as a map with lower case keys, as viper does.
+func configMap(val interface{}) (map[string]interface{}, bool) {
+	m := make(map[string]interface{})
+	switch val := val.(type) {
+	case map[string]interface{}:
+		for k, x := range val {
+			m[strings.ToLower(k)] = x
+		}
+	case map[interface{}]interface{}:
+		for k, x := range val {
+			m[strings.ToLower(fmt.Sprint(k))] = x
+		}
+	default:
+		return nil, false
+	}
+	return m, true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var listItem = regexp.MustCompile(`^\s*- `)
+
+// configLine returns the line number of the key at path in a YAML config,
+// or 0 if it is not found. It relies only on indentation and so is a best
+// effort, which is enough to point the user in the right direction.
+func configLine(lines []string, path []string) int {
+	found, from, indent := -1, 0, -1
+	for _, key := range path {
+		found = -1
+		if i, err := strconv.Atoi(key); err == nil {
+			// The i-th list item after the parent key.
+			n := 0
+			for l := from; l < len(lines) && found < 0; l++ {
+				if listItem.MatchString(lines[l]) && leadingSpace(lines[l]) > indent {
+					if n == i {
+						found = l
+					}
+					n++
+				}
+			}
+			if found < 0 {
+				return 0
+			}
+			// The first key of the item is on the same line.
+			from, indent = found, leadingSpace(lines[found])
+			continue
+		}
+		re := regexp.MustCompile(`(?i)^(\s*(- )?)` + regexp.QuoteMeta(key) + `\s*:`)
+		for l := from; l < len(lines) && found < 0; l++ {
+			m := re.FindStringSubmatch(lines[l])
+			if m != nil && (len(m[1]) > indent || (indent < 0 && m[1] == "")) {
+				found = l
+			}
+		}
+		if found < 0 {
+			return 0
+		}
+		from, indent = found+1, len(re.FindStringSubmatch(lines[found])[1])
+	}
+	return found + 1
+}
+
+func leadingSpace(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+func childPath(path []string, key string) []string {
+	return append(append([]string{}, path...), key)
+}
+
+// WriteConfigTemplate writes a starter config to w, with every setting
+// commented out at its current value. If fn is not empty, it is a workflow
+// whose tasks are each given a selector with their resources, ready to be
+// adjusted.
+func WriteConfigTemplate(fn string, w io.Writer) error {
+	c := currentConfig()
+	var tasks []Commander
+	if fn != "" {
+		var err error
+		tasks, err = workflowTasks(c, fn)
+		if err != nil {
+			return err
+		}
+	}
+	var b strings.Builder
+	b.WriteString("# flow config. Settings are shown commented out with their current values.\n")
+	b.WriteString("# Run flow config schema for a JSON Schema of this file.\n")
+	keys := []string{}
+	for key := range configKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		k := configKeys[key]
+		switch k.kind {
+		case kindEnv, kindSelectors, kindSecrets, kindProfiles, kindObject:
+			continue
+		}
+		val := c.Get(key)
+		if key == "azure_sas_token" {
+			// Never copy a credential into a file that may be shared.
+			val = ""
+		}
+		fmt.Fprintf(&b, "\n# %s\n# %s: %s\n", k.help, key, templateValue(val))
+	}
+	b.WriteString(`
+# Variables for ${name} references in other settings, such as
+# ${refdir}/hg38.fa. Environment variables are referred to as ${env:NAME}.
+# vars:
+#   refdir: /data/refs
+
+# Defaults for the jobs submitted to SLURM or PBS. Selectors may set account,
+# partition (or queue) and qos for some tasks.
+# slurm:
+#   account: myproject
+#   partition: normal
+#   qos: normal
+# pbs:
+#   account: myproject
+#   queue: normal
+
+# Environment variables for every task.
+# env:
+#   TMPDIR: /scratch
+
+# Secrets tasks may use, read from env, file or vault.
+# secrets:
+#   API_TOKEN: {env: MY_API_TOKEN}
+
+# Named sets of settings, chosen with --profile or FLOW_PROFILE.
+# profiles:
+#   cluster:
+#     job_runner: slurm
+`)
+	b.WriteString("\n# Resource overrides for tasks matched by withName or withLabel.\n")
+	if len(tasks) == 0 {
+		b.WriteString("# selectors:\n#   - withName: bwa_*\n#     cpus: 16\n#     memory: 32\n")
+	} else {
+		b.WriteString("selectors:\n")
+		seen := make(map[string]bool)
+		for _, task := range tasks {
+			name := task.AnalysisName()
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			r := task.Resources()
+			fmt.Fprintf(&b, "  - withName: %s\n    cpus: %d\n    memory: %d\n    time: %d\n", templateValue(name), r.CPUs, r.Memory, r.Time)
+			if r.Container != "" {
+				fmt.Fprintf(&b, "    container: %s\n", templateValue(r.Container))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z0-9_./*-][A-Za-z0-9_./*: -]*$`)
+
+// templateValue formats a value of the config as YAML.
+func templateValue(val interface{}) string {
+	switch val := val.(type) {
+	case string:
+		if yamlPlain.MatchString(val) && !strings.Contains(val, ": ") {
+			return val
+		}
+		return strconv.Quote(val)
+	case []string:
+		xs := []string{}
+		for _, x := range val {
+			xs = append(xs, templateValue(x))
+		}
+		return "[" + strings.Join(xs, ", ") + "]"
+	case []interface{}:
+		xs := []string{}
+		for _, x := range val {
+			xs = append(xs, templateValue(x))
+		}
+		return "[" + strings.Join(xs, ", ") + "]"
+	}
+	return fmt.Sprint(val)
+}
+
+// WriteConfigSchema writes a JSON Schema for config files to w, for editors
+// to complete and check them.
+func WriteConfigSchema(w io.Writer) error {
+	schema := configSchema(false)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "flow config"
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func configSchema(inProfile bool) map[string]interface{} {
+	props := make(map[string]interface{})
+	for key, k := range configKeys {
+		if inProfile && k.kind == kindProfiles {
+			continue
+		}
+		s := kindSchema(k.kind)
+		if k.kind == kindObject {
+			fields := make(map[string]interface{})
+			for field, kind := range k.fields {
+				fields[field] = kindSchema(kind)
+			}
+			s = map[string]interface{}{"type": "object", "properties": fields, "additionalProperties": false}
+		}
+		s["description"] = k.help
+		props[key] = s
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func kindSchema(kind configKind) map[string]interface{} {
+	scalar := []string{"string", "number", "boolean"}
+	switch kind {
+	case kindBool:
+		return map[string]interface{}{"type": "boolean"}
+	case kindInt:
+		return map[string]interface{}{"type": "integer"}
+	case kindNumber:
+		return map[string]interface{}{"type": "number"}
+	case kindDuration:
+		return map[string]interface{}{"type": "string", "pattern": `^([0-9.]+(ns|us|µs|ms|s|m|h))+$`}
+	case kindMode:
+		return map[string]interface{}{"type": []string{"string", "integer"}, "pattern": "^[0-7]{1,4}$"}
+	case kindStrings:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	case kindEnv:
+		return map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": scalar}}
+	case kindSelectors:
+		props := map[string]interface{}{
+			"withName":  map[string]interface{}{"type": "string", "description": "Glob matched against the names of tasks."},
+			"withLabel": map[string]interface{}{"type": "string", "description": "Label of the tasks to match."},
+		}
+		for key, kind := range selectorKinds {
+			props[key] = kindSchema(kind)
+		}
+		props["memory"] = map[string]interface{}{"type": []string{"integer", "string"}, "description": "GB, or with a unit such as 512MB."}
+		props["time"] = map[string]interface{}{"type": []string{"integer", "string"}, "description": "Hours, or HH:MM:SS."}
+		return map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type":                 "object",
+				"properties":           props,
+				"additionalProperties": false,
+				"oneOf": []interface{}{
+					map[string]interface{}{"required": []string{"withName"}},
+					map[string]interface{}{"required": []string{"withLabel"}},
+				},
+			},
+		}
+	case kindSecrets:
+		str := map[string]interface{}{"type": "string"}
+		return map[string]interface{}{
+			"type": "object",
+			"additionalProperties": map[string]interface{}{
+				"type":                 "object",
+				"properties":           map[string]interface{}{"env": str, "file": str, "vault": str, "field": str},
+				"additionalProperties": false,
+				"oneOf": []interface{}{
+					map[string]interface{}{"required": []string{"env"}},
+					map[string]interface{}{"required": []string{"file"}},
+					map[string]interface{}{"required": []string{"vault", "field"}},
+				},
+			},
+		}
+	case kindProfiles:
+		return map[string]interface{}{"type": "object", "additionalProperties": configSchema(true)}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// Values in the config may refer to other settings as ${name}, which is
+// looked up in vars and then the config itself, or to environment variables
+// as ${env:NAME}. $${ is a literal ${.
+var configRef = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// expand expands the references in every value of the config.
+func (c *Config) expand() error {
+	for _, key := range c.v.AllKeys() {
+		val := c.Get(key)
+		expanded, err := c.expandValue(val, nil)
+		if err != nil {
+			return fmt.Errorf("invalid config: %s: %v", key, err)
+		}
+		if !reflect.DeepEqual(val, expanded) {
+			c.v.Set(key, expanded)
+		}
+	}
+	return nil
+}
+
+func (c *Config) expandValue(val interface{}, seen []string) (interface{}, error) {
+	switch val := val.(type) {
+	case string:
+		return c.expandString(val, seen)
+	case []string:
+		xs := []string{}
+		for _, x := range val {
+			s, err := c.expandString(x, seen)
+			if err != nil {
+				return nil, err
+			}
+			xs = append(xs, s)
+		}
+		return xs, nil
+	case []interface{}:
+		xs := []interface{}{}
+		for _, x := range val {
+			y, err := c.expandValue(x, seen)
+			if err != nil {
+				return nil, err
+			}
+			xs = append(xs, y)
+		}
+		return xs, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for k, x := range val {
+			y, err := c.expandValue(x, seen)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = y
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{})
+		for k, x := range val {
+			y, err := c.expandValue(x, seen)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = y
+		}
+		return m, nil
+	}
+	return val, nil
+}
+
+func (c *Config) expandString(s string, seen []string) (string, error) {
+	var err error
+	expanded := configRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		name := strings.TrimSpace(ref[2 : len(ref)-1])
+		if strings.HasPrefix(name, "env:") {
+			val, ok := os.LookupEnv(strings.TrimPrefix(name, "env:"))
+			if !ok && err == nil {
+				err = fmt.Errorf("environment variable %s is not set", strings.TrimPrefix(name, "env:"))
+			}
+			return val
+		}
+		for _, x := range seen {
+			if x == name && err == nil {
+				err = fmt.Errorf("%s refers to itself", strings.Join(append(seen, name), " -> "))
+				return ""
+			}
+		}
+		val := c.Get("vars." + name)
+		if val == nil {
+			val = c.Get(name)
+		}
+		if val == nil || !isScalar(val) {
+			if err == nil {
+				err = fmt.Errorf("${%s} is not a variable or setting", name)
+			}
+			return ""
+		}
+		x, e := c.expandString(fmt.Sprint(val), append(seen, name))
+		if e != nil && err == nil {
+			err = e
+		}
+		return x
+	})
+	return expanded, err
+}
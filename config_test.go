@@ -0,0 +1,201 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_validateConfig(t *testing.T) {
+	config := `job_runer: slurm
+poll_interval: 5 minutes
+selectors:
+  - withName: bwa_*
+    memory: 16GB
+  - withLabel: big
+    memory: lots
+  - withName: sort
+profiles:
+  cluster:
+    keep_temp: yes
+umask: ""
+`
+	fn := filepath.Join(t.TempDir(), "flow.yaml")
+	if err := ioutil.WriteFile(fn, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]interface{}{
+		"job_runer":     "slurm",
+		"poll_interval": "5 minutes",
+		"selectors": []interface{}{
+			map[interface{}]interface{}{"withName": "bwa_*", "memory": "16GB"},
+			map[interface{}]interface{}{"withLabel": "big", "memory": "lots"},
+			map[interface{}]interface{}{"withName": "sort"},
+		},
+		"profiles": map[string]interface{}{
+			"cluster": map[string]interface{}{"keep_temp": "yes"},
+		},
+		"umask": "",
+	}
+	err := validateConfig(fn, settings)
+	if err == nil {
+		t.Fatal("validateConfig() should fail")
+	}
+	for _, want := range []string{
+		fn + ":1: job_runer: unknown key",
+		fn + ":2: poll_interval: must be a duration",
+		fn + ":7: selectors.1.memory: memory must be",
+		fn + ":8: selectors.2: sets no resources",
+		fn + ":11: profiles.cluster.keep_temp: must be true or false",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validateConfig() = %v, want it to contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "selectors.0") {
+		t.Errorf("validateConfig() = %v, memory of 16GB is valid", err)
+	}
+	if strings.Contains(err.Error(), "umask") {
+		t.Errorf("validateConfig() = %v, an empty umask is unset", err)
+	}
+}
+
+func Test_resourceInt(t *testing.T) {
+	tests := []struct {
+		key     string
+		val     interface{}
+		want    int
+		wantErr bool
+	}{
+		{"cpus", 4, 4, false},
+		{"cpus", "four", 0, true},
+		{"memory", "16GB", 16, false},
+		{"memory", "2048m", 2, false},
+		{"memory", "16 gigs", 0, true},
+		{"time", "1:30:00", 2, false},
+		{"time", 12, 12, false},
+		{"time", "1h", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := resourceInt(tt.key, tt.val)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resourceInt(%v, %v) = %v, %v, want %v", tt.key, tt.val, got, err, tt.want)
+		}
+	}
+}
+
+func Test_WriteConfigTemplate(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "workflow.json")
+	workflow := `{"version": 1, "name": "test", "tasks": [
+  {"name": "bwa_mem", "command": "bwa mem", "outputs": {"bam": "a.bam"}, "resources": {"cpus": 4, "container": "docker://bwa"}}
+]}`
+	if err := ioutil.WriteFile(fn, []byte(workflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := WriteConfigTemplate(fn, &b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# job_runner: ",
+		"# poll_interval: 60s\n",
+		"  - withName: bwa_mem\n    cpus: 4\n    memory: 16\n    time: 24\n    container: docker://bwa\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteConfigTemplate() = %v, want it to contain %q", b.String(), want)
+		}
+	}
+}
+
+func Test_WriteConfigSchema(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteConfigSchema(&b); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	for key := range configKeys {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("schema has no property %s", key)
+		}
+	}
+}
+
+func Test_expandString(t *testing.T) {
+	defer defaultConfig.Set("vars", nil)
+	defaultConfig.Set("vars", map[string]interface{}{
+		"refdir": "/data/refs",
+		"genome": "${refdir}/hg38.fa",
+		"loop":   "${loop}",
+	})
+	os.Setenv("FLOW_TEST_SCRATCH", "/scratch/me")
+	defer os.Unsetenv("FLOW_TEST_SCRATCH")
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"${refdir}/hg38.fa", "/data/refs/hg38.fa", false},
+		{"-B ${genome}", "-B /data/refs/hg38.fa", false},
+		{"${env:FLOW_TEST_SCRATCH}/work", "/scratch/me/work", false},
+		{"$HOME/x $${refdir}", "$HOME/x ${refdir}", false},
+		{"${undefined}", "", true},
+		{"${env:FLOW_TEST_UNSET}", "", true},
+		{"${loop}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := defaultConfig.expandString(tt.s, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandString(%v) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("expandString(%v) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func Test_Config_applyEnv(t *testing.T) {
+	conf := newConfig()
+	conf.origins = map[string]string{"pricing": "/etc/flow/flow.yaml"}
+	for name, val := range map[string]string{
+		"FLOW_KEEP_GOING":      "true",
+		"FLOW_SUBMIT_RETRIES":  "7",
+		"FLOW_ENV_PASSTHROUGH": "HOME,USER",
+		"FLOW_SELECTORS":       "ignored",
+		"FLOW_SLURM_ACCOUNT":   "proj1",
+	} {
+		os.Setenv(name, val)
+		defer os.Unsetenv(name)
+	}
+	conf.applyEnv()
+	if !conf.GetBool("keep_going") || conf.GetInt("submit_retries") != 7 {
+		t.Errorf("keep_going = %v, submit_retries = %v", conf.Get("keep_going"), conf.Get("submit_retries"))
+	}
+	if got := conf.GetStringSlice("env_passthrough"); strings.Join(got, " ") != "HOME USER" {
+		t.Errorf("env_passthrough = %v", got)
+	}
+	if conf.Get("selectors") != nil {
+		t.Errorf("selectors = %v, want them not to be set from the environment", conf.Get("selectors"))
+	}
+	if got := conf.GetString("slurm.account"); got != "proj1" {
+		t.Errorf("slurm.account = %v", got)
+	}
+	for key, want := range map[string]string{
+		"keep_going":        "env FLOW_KEEP_GOING",
+		"pricing.cpu_hour":  "/etc/flow/flow.yaml",
+		"heartbeat_timeout": "default",
+	} {
+		if got := conf.origin(key); got != want {
+			t.Errorf("origin(%s) = %s, want %s", key, got, want)
+		}
+	}
+}
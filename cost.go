@@ -0,0 +1,101 @@
+package flow
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pricingSet reports whether prices are configured. Costs are estimated from
+// the prices in the pricing config, per CPU hour and per GB of memory per
+// hour, and the time jobs run for. There are no prices by default, and then
+// costs are neither estimated nor limited.
+func pricingSet(conf *Config) bool {
+	return conf.GetFloat64("pricing.cpu_hour") > 0 || conf.GetFloat64("pricing.memory_hour") > 0
+}
+
+// jobCost returns the estimated cost of running a job with resources r for d.
+func jobCost(conf *Config, r Resources, d time.Duration) float64 {
+	perHour := float64(r.CPUs)*conf.GetFloat64("pricing.cpu_hour") + float64(r.Memory)*conf.GetFloat64("pricing.memory_hour")
+	return perHour * d.Hours()
+}
+
+// maxJobCost returns the cost of j if it runs for all the time it asks for.
+func maxJobCost(conf *Config, j *job) float64 {
+	return jobCost(conf, j.resources, time.Duration(j.resources.Time)*time.Hour)
+}
+
+func formatCost(conf *Config, x float64) string {
+	if currency := conf.GetString("pricing.currency"); currency != "" {
+		return fmt.Sprintf("%.2f %s", x, currency)
+	}
+	return fmt.Sprintf("%.2f", x)
+}
+
+// charge records the cost of a job that has finished, from the time the
+// scheduler says it used or, if it does not say, the time since it was
+// submitted.
+func (g *graph) charge(j *job, used resourcesUsed) {
+	if !pricingSet(g.config) {
+		return
+	}
+	d := time.Since(j.submitted)
+	if used.TimeUsed > 0 {
+		d = time.Duration(used.TimeUsed) * time.Second
+	}
+	j.cost = jobCost(g.config, j.resources, d)
+	g.spent += j.cost
+}
+
+// withinBudget reports whether j can be submitted without the run costing
+// more than budget, assuming it and every running job use all the time they
+// ask for.
+func (g *graph) withinBudget(j *job) bool {
+	budget := g.config.GetFloat64("budget")
+	if budget <= 0 || !pricingSet(g.config) {
+		return true
+	}
+	committed := g.spent
+	for _, r := range g.running {
+		committed += maxJobCost(g.config, r)
+	}
+	if committed+maxJobCost(g.config, j) <= budget {
+		return true
+	}
+	if !g.overBudget {
+		g.overBudget = true
+		log.Printf("WARNING: budget of %s reached (%s spent, %s committed to running jobs), no more jobs will be submitted", formatCost(g.config, budget), formatCost(g.config, g.spent), formatCost(g.config, committed-g.spent))
+	}
+	return false
+}
+
+// costSummary returns the estimated cost of the jobs, for each task name,
+// most expensive first, and in total.
+func costSummary(conf *Config, jobs []*job) string {
+	byName := map[string]float64{}
+	counts := map[string]int{}
+	total := 0.0
+	for _, j := range jobs {
+		byName[j.Cmd.AnalysisName()] += j.cost
+		counts[j.Cmd.AnalysisName()]++
+		total += j.cost
+	}
+	names := []string{}
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		if byName[names[a]] != byName[names[b]] {
+			return byName[names[a]] > byName[names[b]]
+		}
+		return names[a] < names[b]
+	})
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  %-30s %6d jobs %12s", name, counts[name], formatCost(conf, byName[name])))
+	}
+	lines = append(lines, fmt.Sprintf("  %-30s %6d jobs %12s", "total", len(jobs), formatCost(conf, total)))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func Test_withinBudget(t *testing.T) {
+	defer defaultConfig.Set("pricing", nil)
+	defer defaultConfig.Set("budget", 0)
+	newJob := func(name string, cpus, hours int) *job {
+		return &job{
+			Cmd:       &fileTask{Task: Task{Name: name}},
+			UUID:      uuid.New(),
+			resources: Resources{CPUs: cpus, Memory: 4, Time: hours},
+		}
+	}
+	defaultConfig.Set("pricing", map[string]interface{}{"cpu_hour": 0.5, "memory_hour": 0.25})
+	if got := jobCost(defaultConfig, Resources{CPUs: 2, Memory: 4}, 90*time.Minute); got != 3 {
+		t.Errorf("jobCost() = %v, want 3", got)
+	}
+	running := newJob("a", 2, 1)
+	tests := []struct {
+		name   string
+		budget float64
+		spent  float64
+		job    *job
+		want   bool
+	}{
+		{"no_budget", 0, 100, newJob("b", 2, 1), true},
+		{"within", 10, 4, newJob("b", 2, 1), true},
+		{"over", 10, 4, newJob("b", 4, 2), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("budget", tt.budget)
+			// The running job could cost 2 more.
+			g := graph{config: defaultConfig, running: []*job{running}, spent: tt.spent}
+			if got := g.withinBudget(tt.job); got != tt.want {
+				t.Errorf("withinBudget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_costSummary(t *testing.T) {
+	defer defaultConfig.Set("pricing", nil)
+	defaultConfig.Set("pricing", map[string]interface{}{"cpu_hour": 1, "currency": "USD"})
+	jobs := []*job{
+		{Cmd: &fileTask{Task: Task{Name: "align"}}, cost: 2},
+		{Cmd: &fileTask{Task: Task{Name: "sort"}}, cost: 0.5},
+		{Cmd: &fileTask{Task: Task{Name: "align"}}, cost: 3},
+	}
+	want := "" +
+		"  align                               2 jobs     5.00 USD\n" +
+		"  sort                                1 jobs     0.50 USD\n" +
+		"  total                               3 jobs     5.50 USD"
+	if got := costSummary(defaultConfig, jobs); got != want {
+		t.Errorf("costSummary() =\n%s\nwant\n%s", got, want)
+	}
+}
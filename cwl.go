@@ -0,0 +1,881 @@
+package flow
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ImportCWL converts a CWL CommandLineTool or Workflow, with the input values
+// in the job order file jobFile, into a Submission. Only the common subset of
+// CWL v1.2 is supported: tools built from baseCommand, arguments and input
+// bindings, outputs found by glob or stdout, ResourceRequirement and
+// DockerRequirement, and workflows whose steps run such tools. Expressions
+// are limited to simple parameter references such as $(inputs.reads.path).
+//
+// Each tool runs in its own directory under outdir, as CWL expects, and its
+// outputs are found there.
+func ImportCWL(fn, jobFile, outdir string) (*Submission, error) {
+	doc, err := readCWL(fn)
+	if err != nil {
+		return nil, err
+	}
+	job := map[string]interface{}{}
+	if jobFile != "" {
+		data, err := ioutil.ReadFile(jobFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job order: %v", err)
+		}
+		raw := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse job order: %v", err)
+		}
+		for k, v := range raw {
+			job[fmt.Sprint(k)] = cwlValue(v, filepath.Dir(jobFile))
+		}
+	}
+	s := &Submission{Version: SubmissionVersion, Name: strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn))}
+	switch class := cwlString(doc["class"]); class {
+	case "CommandLineTool":
+		tool, err := parseCWLTool(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		inputs, err := tool.bind(job)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		spec, _, err := tool.task(s.Name, inputs, filepath.Join(outdir, s.Name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		s.Tasks = append(s.Tasks, spec)
+	case "Workflow":
+		if err := importCWLWorkflow(s, doc, filepath.Dir(fn), job, outdir); err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported CWL class: %s", fn, class)
+	}
+	return s, nil
+}
+
+func readCWL(fn string) (map[interface{}]interface{}, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CWL document: %v", err)
+	}
+	doc := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse CWL document: %s: %v", fn, err)
+	}
+	return doc, nil
+}
+
+// cwlParam is an input or output parameter of a CommandLineTool.
+type cwlParam struct {
+	id       string
+	typ      string
+	array    bool
+	optional bool
+	bound    bool
+	position int
+	prefix   string
+	separate bool
+	glob     string
+	def      interface{}
+}
+
+type cwlArgument struct {
+	position int
+	value    string
+}
+
+type cwlTool struct {
+	baseCommand []string
+	arguments   []cwlArgument
+	inputs      []cwlParam
+	outputs     []cwlParam
+	stdout      string
+	cpus        int
+	memory      int
+	container   string
+}
+
+func parseCWLTool(doc map[interface{}]interface{}) (*cwlTool, error) {
+	t := &cwlTool{stdout: cwlString(doc["stdout"])}
+	switch base := doc["baseCommand"].(type) {
+	case string:
+		t.baseCommand = []string{base}
+	case []interface{}:
+		for _, b := range base {
+			t.baseCommand = append(t.baseCommand, fmt.Sprint(b))
+		}
+	}
+	for _, a := range cwlList(doc["arguments"]) {
+		switch a := a.(type) {
+		case map[interface{}]interface{}:
+			pos, _ := strconv.Atoi(fmt.Sprint(a["position"]))
+			t.arguments = append(t.arguments, cwlArgument{pos, cwlString(a["valueFrom"])})
+		default:
+			t.arguments = append(t.arguments, cwlArgument{0, fmt.Sprint(a)})
+		}
+	}
+	var err error
+	if t.inputs, err = parseCWLParams(doc["inputs"]); err != nil {
+		return nil, err
+	}
+	if t.outputs, err = parseCWLParams(doc["outputs"]); err != nil {
+		return nil, err
+	}
+	for _, req := range cwlRequirements(doc) {
+		switch cwlString(req["class"]) {
+		case "ResourceRequirement":
+			t.cpus = cwlInt(req["coresMin"])
+			// ramMin is in mebibytes, memory in gigabytes.
+			if ram := cwlInt(req["ramMin"]); ram > 0 {
+				t.memory = (ram + 1023) / 1024
+			}
+		case "DockerRequirement":
+			if pull := cwlString(req["dockerPull"]); pull != "" {
+				t.container = "docker://" + pull
+			}
+		case "InlineJavascriptRequirement", "ShellCommandRequirement", "NetworkAccess":
+		default:
+			return nil, fmt.Errorf("unsupported requirement: %s", cwlString(req["class"]))
+		}
+	}
+	return t, nil
+}
+
+// parseCWLParams reads inputs or outputs, which may be a list of parameters
+// with ids or a map of id to type or parameter.
+func parseCWLParams(x interface{}) ([]cwlParam, error) {
+	params := []cwlParam{}
+	add := func(id string, p interface{}) error {
+		param := cwlParam{id: strings.TrimPrefix(id, "#"), separate: true}
+		m, ok := p.(map[interface{}]interface{})
+		if !ok {
+			m = map[interface{}]interface{}{"type": p}
+		}
+		var err error
+		if param.typ, param.array, param.optional, err = cwlType(m["type"]); err != nil {
+			return fmt.Errorf("parameter %s: %v", param.id, err)
+		}
+		param.def = m["default"]
+		if b, ok := m["inputBinding"].(map[interface{}]interface{}); ok {
+			param.bound = true
+			param.position = cwlInt(b["position"])
+			param.prefix = cwlString(b["prefix"])
+			if s, ok := b["separate"].(bool); ok {
+				param.separate = s
+			}
+		}
+		if b, ok := m["outputBinding"].(map[interface{}]interface{}); ok {
+			param.glob = cwlString(b["glob"])
+		}
+		params = append(params, param)
+		return nil
+	}
+	switch x := x.(type) {
+	case []interface{}:
+		for _, p := range x {
+			m, ok := p.(map[interface{}]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid parameter: %v", p)
+			}
+			if err := add(cwlString(m["id"]), m); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		ids := []string{}
+		for id := range x {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := add(id, x[id]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return params, nil
+}
+
+// cwlType returns the base type of a parameter and whether it is an array or
+// optional.
+func cwlType(x interface{}) (typ string, array, optional bool, err error) {
+	switch x := x.(type) {
+	case string:
+		if strings.HasSuffix(x, "?") {
+			optional = true
+			x = strings.TrimSuffix(x, "?")
+		}
+		if strings.HasSuffix(x, "[]") {
+			array = true
+			x = strings.TrimSuffix(x, "[]")
+		}
+		return x, array, optional, nil
+	case []interface{}:
+		// A union, which is only supported as "null" plus one type.
+		types := []interface{}{}
+		for _, t := range x {
+			if t == "null" {
+				optional = true
+			} else {
+				types = append(types, t)
+			}
+		}
+		if len(types) != 1 {
+			return "", false, false, fmt.Errorf("unsupported union type: %v", x)
+		}
+		typ, array, _, err = cwlType(types[0])
+		return typ, array, optional, err
+	case map[interface{}]interface{}:
+		if cwlString(x["type"]) != "array" {
+			return "", false, false, fmt.Errorf("unsupported type: %v", x["type"])
+		}
+		typ, _, _, err = cwlType(x["items"])
+		return typ, true, false, err
+	}
+	return "", false, false, fmt.Errorf("unsupported type: %v", x)
+}
+
+// cwlRequirements returns the requirements and hints of a document, which
+// may be lists of objects with a class or maps of class to object.
+func cwlRequirements(doc map[interface{}]interface{}) []map[interface{}]interface{} {
+	reqs := []map[interface{}]interface{}{}
+	for _, key := range []string{"requirements", "hints"} {
+		switch x := doc[key].(type) {
+		case []interface{}:
+			for _, r := range x {
+				if m, ok := r.(map[interface{}]interface{}); ok {
+					reqs = append(reqs, m)
+				}
+			}
+		case map[interface{}]interface{}:
+			for class, r := range x {
+				m, ok := r.(map[interface{}]interface{})
+				if !ok {
+					m = map[interface{}]interface{}{}
+				}
+				m["class"] = class
+				reqs = append(reqs, m)
+			}
+		}
+	}
+	return reqs
+}
+
+// bind matches input values to the tool's inputs, applying defaults. Values
+// are strings (paths, for files) or lists of them.
+func (t *cwlTool) bind(values map[string]interface{}) (map[string]interface{}, error) {
+	bound := map[string]interface{}{}
+	for _, p := range t.inputs {
+		value, ok := values[p.id]
+		if !ok && p.def != nil {
+			value, ok = cwlValue(p.def, ""), true
+		}
+		if !ok {
+			if !p.optional {
+				return nil, fmt.Errorf("no value for input: %s", p.id)
+			}
+			continue
+		}
+		bound[p.id] = value
+	}
+	return bound, nil
+}
+
+var cwlReference = regexp.MustCompile(`\$\(([^)]*)\)`)
+
+// substitute replaces parameter references, such as $(inputs.x) or
+// $(inputs.x.basename), in s.
+func (t *cwlTool) substitute(s string, inputs map[string]interface{}) (string, error) {
+	if strings.Contains(s, "${") {
+		return "", fmt.Errorf("JavaScript expressions are not supported: %s", s)
+	}
+	var err error
+	result := cwlReference.ReplaceAllStringFunc(s, func(ref string) string {
+		parts := strings.Split(strings.TrimSpace(ref[2:len(ref)-1]), ".")
+		switch {
+		case len(parts) == 2 && parts[0] == "runtime" && parts[1] == "cores":
+			return strconv.Itoa(t.cpus)
+		case len(parts) >= 2 && parts[0] == "inputs":
+			value, ok := inputs[parts[1]].(string)
+			if !ok {
+				err = fmt.Errorf("unsupported parameter reference: %s", ref)
+				return ref
+			}
+			if len(parts) == 2 {
+				return value
+			}
+			switch parts[2] {
+			case "path", "location":
+				return value
+			case "basename":
+				return filepath.Base(value)
+			case "nameroot":
+				return strings.TrimSuffix(filepath.Base(value), filepath.Ext(value))
+			case "nameext":
+				return filepath.Ext(value)
+			case "dirname":
+				return filepath.Dir(value)
+			}
+		}
+		err = fmt.Errorf("unsupported parameter reference: %s", ref)
+		return ref
+	})
+	return result, err
+}
+
+// task builds the TaskSpec running the tool in dir, returning it along with
+// the paths of the tool's outputs.
+func (t *cwlTool) task(name string, inputs map[string]interface{}, dir string) (TaskSpec, map[string]string, error) {
+	type word struct {
+		position int
+		words    []string
+	}
+	words := []word{}
+	for _, a := range t.arguments {
+		value, err := t.substitute(a.value, inputs)
+		if err != nil {
+			return TaskSpec{}, nil, err
+		}
+		words = append(words, word{a.position, []string{value}})
+	}
+	for _, p := range t.inputs {
+		value, ok := inputs[p.id]
+		if !p.bound || !ok {
+			continue
+		}
+		words = append(words, word{p.position, p.commandLine(value)})
+	}
+	sort.SliceStable(words, func(i, j int) bool { return words[i].position < words[j].position })
+	cmdline := []string{}
+	for _, w := range append([]word{{words: t.baseCommand}}, words...) {
+		for _, s := range w.words {
+			cmdline = append(cmdline, shellQuote(s))
+		}
+	}
+
+	spec := TaskSpec{
+		Name:    name,
+		Inputs:  map[string]string{},
+		Outputs: map[string]string{},
+		Resources: ResourceSpec{
+			CPUs:      t.cpus,
+			Memory:    t.memory,
+			Container: t.container,
+		},
+	}
+	for id, value := range inputs {
+		for i, path := range cwlFiles(t.inputType(id), value) {
+			spec.Inputs[fmt.Sprintf("%s_%d", id, i)] = path
+		}
+	}
+	stdout := t.stdout
+	outputs := map[string]string{}
+	for _, p := range t.outputs {
+		glob := p.glob
+		if p.typ == "stdout" {
+			if stdout == "" {
+				stdout = p.id + ".stdout"
+			}
+			glob = stdout
+		}
+		if glob == "" {
+			return TaskSpec{}, nil, fmt.Errorf("output %s has no glob", p.id)
+		}
+		glob, err := t.substitute(glob, inputs)
+		if err != nil {
+			return TaskSpec{}, nil, err
+		}
+		path := filepath.Join(dir, glob)
+		spec.Outputs[p.id] = path
+		outputs[p.id] = path
+	}
+	if len(outputs) == 0 {
+		return TaskSpec{}, nil, fmt.Errorf("tool has no outputs")
+	}
+	command := fmt.Sprintf("mkdir -p %s && cd %s && %s", shellQuote(dir), shellQuote(dir), strings.Join(cmdline, " "))
+	if stdout != "" {
+		out, err := t.substitute(stdout, inputs)
+		if err != nil {
+			return TaskSpec{}, nil, err
+		}
+		command += " >" + shellQuote(out)
+	}
+	// Commands are templates, so anything that looks like an action must be
+	// escaped.
+	spec.Command = strings.ReplaceAll(command, "{{", `{{"{{"}}`)
+	return spec, outputs, nil
+}
+
+func (t *cwlTool) inputType(id string) string {
+	for _, p := range t.inputs {
+		if p.id == id {
+			return p.typ
+		}
+	}
+	return ""
+}
+
+// commandLine returns the words added to the command line for an input.
+func (p cwlParam) commandLine(value interface{}) []string {
+	values := []string{}
+	switch value := value.(type) {
+	case []string:
+		values = value
+	case bool:
+		if value && p.prefix != "" {
+			return []string{p.prefix}
+		}
+		return nil
+	default:
+		values = []string{fmt.Sprint(value)}
+	}
+	if p.prefix == "" {
+		return values
+	}
+	if p.separate {
+		return append([]string{p.prefix}, values...)
+	}
+	words := []string{}
+	for _, v := range values {
+		words = append(words, p.prefix+v)
+	}
+	return words
+}
+
+// cwlFiles returns the paths in value if typ is a file type.
+func cwlFiles(typ string, value interface{}) []string {
+	if typ != "File" && typ != "Directory" {
+		return nil
+	}
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []string:
+		return value
+	}
+	return nil
+}
+
+func importCWLWorkflow(s *Submission, doc map[interface{}]interface{}, dir string, job map[string]interface{}, outdir string) error {
+	inputs, err := parseCWLParams(doc["inputs"])
+	if err != nil {
+		return err
+	}
+	// values holds the value of every workflow input and step output, keyed
+	// by its source, e.g. "reads" or "align/bam".
+	values := map[string]interface{}{}
+	for _, p := range inputs {
+		if value, ok := job[p.id]; ok {
+			values[p.id] = value
+		} else if p.def != nil {
+			values[p.id] = cwlValue(p.def, dir)
+		} else if !p.optional {
+			return fmt.Errorf("no value for workflow input: %s", p.id)
+		}
+	}
+
+	type step struct {
+		id      string
+		tool    *cwlTool
+		sources map[string][]string
+		defs    map[string]interface{}
+	}
+	steps := []*step{}
+	for _, x := range cwlSteps(doc["steps"]) {
+		st := &step{id: cwlString(x["id"]), sources: map[string][]string{}, defs: map[string]interface{}{}}
+		if _, ok := x["scatter"]; ok {
+			return fmt.Errorf("step %s: scatter is not supported", st.id)
+		}
+		var toolDoc map[interface{}]interface{}
+		switch run := x["run"].(type) {
+		case string:
+			if toolDoc, err = readCWL(filepath.Join(dir, run)); err != nil {
+				return err
+			}
+		case map[interface{}]interface{}:
+			toolDoc = run
+		default:
+			return fmt.Errorf("step %s: missing run", st.id)
+		}
+		if class := cwlString(toolDoc["class"]); class != "CommandLineTool" {
+			return fmt.Errorf("step %s: unsupported class: %s", st.id, class)
+		}
+		if st.tool, err = parseCWLTool(toolDoc); err != nil {
+			return fmt.Errorf("step %s: %v", st.id, err)
+		}
+		for id, in := range cwlStepInputs(x["in"]) {
+			for _, src := range cwlList(in["source"]) {
+				st.sources[id] = append(st.sources[id], strings.TrimPrefix(fmt.Sprint(src), "#"))
+			}
+			if def, ok := in["default"]; ok {
+				st.defs[id] = cwlValue(def, dir)
+			}
+		}
+		steps = append(steps, st)
+	}
+
+	// Steps may be listed in any order, so keep converting those whose
+	// sources are all known until none are left.
+	done := map[string]bool{}
+	for len(done) < len(steps) {
+		progress := false
+		for _, st := range steps {
+			if done[st.id] {
+				continue
+			}
+			ready := true
+			for _, srcs := range st.sources {
+				for _, src := range srcs {
+					if _, ok := values[src]; !ok && strings.Contains(src, "/") {
+						ready = false
+					}
+				}
+			}
+			if !ready {
+				continue
+			}
+			stepInputs := map[string]interface{}{}
+			for id, def := range st.defs {
+				stepInputs[id] = def
+			}
+			for id, srcs := range st.sources {
+				if len(srcs) == 1 {
+					if value, ok := values[srcs[0]]; ok {
+						stepInputs[id] = value
+					}
+					continue
+				}
+				merged := []string{}
+				for _, src := range srcs {
+					merged = append(merged, cwlFiles("File", values[src])...)
+				}
+				stepInputs[id] = merged
+			}
+			bound, err := st.tool.bind(stepInputs)
+			if err != nil {
+				return fmt.Errorf("step %s: %v", st.id, err)
+			}
+			spec, outputs, err := st.tool.task(st.id, bound, filepath.Join(outdir, st.id))
+			if err != nil {
+				return fmt.Errorf("step %s: %v", st.id, err)
+			}
+			spec.ID = st.id
+			for id, path := range outputs {
+				values[st.id+"/"+id] = path
+			}
+			s.Tasks = append(s.Tasks, spec)
+			done[st.id] = true
+			progress = true
+		}
+		if !progress {
+			return fmt.Errorf("unable to resolve the inputs of every step, is there a cycle?")
+		}
+	}
+	return nil
+}
+
+func cwlSteps(x interface{}) []map[interface{}]interface{} {
+	steps := []map[interface{}]interface{}{}
+	switch x := x.(type) {
+	case []interface{}:
+		for _, st := range x {
+			if m, ok := st.(map[interface{}]interface{}); ok {
+				m["id"] = strings.TrimPrefix(cwlString(m["id"]), "#")
+				steps = append(steps, m)
+			}
+		}
+	case map[interface{}]interface{}:
+		ids := []string{}
+		for id := range x {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if m, ok := x[id].(map[interface{}]interface{}); ok {
+				m["id"] = id
+				steps = append(steps, m)
+			}
+		}
+	}
+	return steps
+}
+
+// cwlStepInputs returns the inputs of a step keyed by id. Each has a source
+// and/or default.
+func cwlStepInputs(x interface{}) map[string]map[interface{}]interface{} {
+	inputs := map[string]map[interface{}]interface{}{}
+	switch x := x.(type) {
+	case []interface{}:
+		for _, in := range x {
+			if m, ok := in.(map[interface{}]interface{}); ok {
+				inputs[strings.TrimPrefix(cwlString(m["id"]), "#")] = m
+			}
+		}
+	case map[interface{}]interface{}:
+		for id, in := range x {
+			m, ok := in.(map[interface{}]interface{})
+			if !ok {
+				m = map[interface{}]interface{}{"source": in}
+			}
+			inputs[fmt.Sprint(id)] = m
+		}
+	}
+	return inputs
+}
+
+// cwlValue converts a value from a job order or default to a string, a list
+// of strings or a bool. Files become their paths, relative to dir.
+func cwlValue(x interface{}, dir string) interface{} {
+	switch x := x.(type) {
+	case map[interface{}]interface{}:
+		path := cwlString(x["path"])
+		if path == "" {
+			path = strings.TrimPrefix(cwlString(x["location"]), "file://")
+		}
+		if path != "" && !filepath.IsAbs(path) && !isURI(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		return path
+	case []interface{}:
+		values := []string{}
+		for _, y := range x {
+			values = append(values, fmt.Sprint(cwlValue(y, dir)))
+		}
+		return values
+	case bool:
+		return x
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(x)
+}
+
+func cwlList(x interface{}) []interface{} {
+	switch x := x.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return x
+	}
+	return []interface{}{x}
+}
+
+func cwlString(x interface{}) string {
+	if x == nil {
+		return ""
+	}
+	return fmt.Sprint(x)
+}
+
+func cwlInt(x interface{}) int {
+	switch x := x.(type) {
+	case int:
+		return x
+	case float64:
+		return int(x)
+	}
+	return 0
+}
+
+// ExportCWL writes the tasks in the queue to w as a single CWL v1.2
+// Workflow. Each task becomes a step running an inline CommandLineTool; its
+// inputs are wired to the steps producing them or to workflow inputs, which
+// default to the files used by the queue. Commands refer to their inputs by
+// parameter reference and write their outputs to the tool's output
+// directory, so the workflow can be run by any CWL runner.
+func (q *Queue) ExportCWL(w io.Writer) error {
+	conf := q.queueConfig()
+	for _, task := range q.tasks {
+		if err := freezeTask(conf, task); err != nil {
+			return err
+		}
+	}
+	doc, err := cwlWorkflow(q.tasks)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to write CWL: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+type cwlStep struct {
+	id      string
+	cmd     Commander
+	inputs  []string
+	outputs []string
+}
+
+func cwlWorkflow(cmds []Commander) (yaml.MapSlice, error) {
+	steps := []*cwlStep{}
+	for i, cmd := range cmds {
+		steps = append(steps, &cwlStep{
+			id:      fmt.Sprintf("%s_%d", cwlID(cmd.AnalysisName()), i+1),
+			cmd:     cmd,
+			inputs:  cmdInputs(cmd),
+			outputs: cmdOutputs(cmd),
+		})
+	}
+	// producer returns the source of a path produced by one of the steps.
+	producer := func(p string) string {
+		for _, st := range steps {
+			for j, out := range st.outputs {
+				if out != "" && pathsMatch(p, out) {
+					return fmt.Sprintf("%s/out_%d", st.id, j)
+				}
+			}
+		}
+		return ""
+	}
+	consumed := map[string]bool{}
+	wfInputs := yaml.MapSlice{}
+	wfInputIDs := map[string]string{}
+	wfSteps := yaml.MapSlice{}
+	for _, st := range steps {
+		in := yaml.MapSlice{}
+		toolInputs := yaml.MapSlice{}
+		refs := map[string]string{}
+		for j, p := range st.inputs {
+			if p == "" {
+				continue
+			}
+			id := fmt.Sprintf("in_%d", j)
+			source := producer(p)
+			if source != "" {
+				consumed[source] = true
+			} else {
+				source = wfInputIDs[p]
+				if source == "" {
+					source = fmt.Sprintf("input_%d", len(wfInputIDs)+1)
+					wfInputIDs[p] = source
+					wfInputs = append(wfInputs, yaml.MapItem{Key: source, Value: yaml.MapSlice{
+						{Key: "type", Value: "File"},
+						{Key: "default", Value: yaml.MapSlice{{Key: "class", Value: "File"}, {Key: "location", Value: p}}},
+					}})
+				}
+			}
+			in = append(in, yaml.MapItem{Key: id, Value: source})
+			toolInputs = append(toolInputs, yaml.MapItem{Key: id, Value: "File"})
+			refs[p] = fmt.Sprintf("$(inputs.%s.path)", id)
+		}
+		out := []string{}
+		toolOutputs := yaml.MapSlice{}
+		for j, p := range st.outputs {
+			if p == "" {
+				continue
+			}
+			id := fmt.Sprintf("out_%d", j)
+			typ := "File"
+			if isGlob(p) {
+				typ = "File[]"
+			}
+			out = append(out, id)
+			toolOutputs = append(toolOutputs, yaml.MapItem{Key: id, Value: yaml.MapSlice{
+				{Key: "type", Value: typ},
+				{Key: "outputBinding", Value: yaml.MapSlice{{Key: "glob", Value: filepath.Base(p)}}},
+			}})
+			refs[p] = filepath.Base(p)
+		}
+		tool := yaml.MapSlice{
+			{Key: "class", Value: "CommandLineTool"},
+			{Key: "baseCommand", Value: []string{"bash", "-c"}},
+			{Key: "arguments", Value: []string{cwlCommand(st.cmd.Command(), refs)}},
+			{Key: "inputs", Value: toolInputs},
+			{Key: "outputs", Value: toolOutputs},
+		}
+		if reqs := cwlExportRequirements(st.cmd.Resources()); len(reqs) > 0 {
+			tool = append(tool, yaml.MapItem{Key: "requirements", Value: reqs})
+		}
+		wfSteps = append(wfSteps, yaml.MapItem{Key: st.id, Value: yaml.MapSlice{
+			{Key: "run", Value: tool},
+			{Key: "in", Value: in},
+			{Key: "out", Value: out},
+		}})
+	}
+	// Outputs that no other step consumes are the results of the workflow.
+	wfOutputs := yaml.MapSlice{}
+	for _, st := range steps {
+		for j, p := range st.outputs {
+			source := fmt.Sprintf("%s/out_%d", st.id, j)
+			if p == "" || consumed[source] {
+				continue
+			}
+			typ := "File"
+			if isGlob(p) {
+				typ = "File[]"
+			}
+			wfOutputs = append(wfOutputs, yaml.MapItem{Key: fmt.Sprintf("%s_out_%d", st.id, j), Value: yaml.MapSlice{
+				{Key: "type", Value: typ},
+				{Key: "outputSource", Value: source},
+			}})
+		}
+	}
+	return yaml.MapSlice{
+		{Key: "cwlVersion", Value: "v1.2"},
+		{Key: "class", Value: "Workflow"},
+		{Key: "inputs", Value: wfInputs},
+		{Key: "outputs", Value: wfOutputs},
+		{Key: "steps", Value: wfSteps},
+	}, nil
+}
+
+// cwlCommand rewrites a command for CWL, replacing the paths in refs and
+// escaping anything CWL would take as a parameter reference. Longer paths
+// are replaced first so that a path is not mistaken for part of another.
+func cwlCommand(cmd string, refs map[string]string) string {
+	cmd = strings.ReplaceAll(cmd, "$(", `\$(`)
+	paths := []string{}
+	for p := range refs {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
+	pairs := []string{}
+	for _, p := range paths {
+		pairs = append(pairs, p, refs[p])
+	}
+	return strings.NewReplacer(pairs...).Replace(cmd)
+}
+
+func cwlExportRequirements(r Resources) []yaml.MapSlice {
+	reqs := []yaml.MapSlice{}
+	if r.CPUs > 0 || r.Memory > 0 {
+		req := yaml.MapSlice{{Key: "class", Value: "ResourceRequirement"}}
+		if r.CPUs > 0 {
+			req = append(req, yaml.MapItem{Key: "coresMin", Value: r.CPUs})
+		}
+		if r.Memory > 0 {
+			req = append(req, yaml.MapItem{Key: "ramMin", Value: r.Memory * 1024})
+		}
+		reqs = append(reqs, req)
+	}
+	if strings.HasPrefix(r.Container, "docker://") {
+		reqs = append(reqs, yaml.MapSlice{
+			{Key: "class", Value: "DockerRequirement"},
+			{Key: "dockerPull", Value: strings.TrimPrefix(r.Container, "docker://")},
+		})
+	}
+	return reqs
+}
+
+var cwlIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func cwlID(s string) string {
+	return cwlIDUnsafe.ReplaceAllString(s, "_")
+}
@@ -0,0 +1,121 @@
+package flow
+
+import "testing"
+
+func Test_cwlType(t *testing.T) {
+	tests := []struct {
+		name         string
+		x            interface{}
+		wantType     string
+		wantArray    bool
+		wantOptional bool
+		wantErr      bool
+	}{
+		{"file", "File", "File", false, false, false},
+		{"optional", "string?", "string", false, true, false},
+		{"array", "File[]", "File", true, false, false},
+		{"union", []interface{}{"null", "int"}, "int", false, true, false},
+		{"array_map", map[interface{}]interface{}{"type": "array", "items": "File"}, "File", true, false, false},
+		{"record", map[interface{}]interface{}{"type": "record"}, "", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, array, optional, err := cwlType(tt.x)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cwlType() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if typ != tt.wantType || array != tt.wantArray || optional != tt.wantOptional {
+				t.Errorf("cwlType() = %v, %v, %v, want %v, %v, %v", typ, array, optional, tt.wantType, tt.wantArray, tt.wantOptional)
+			}
+		})
+	}
+}
+
+func Test_shellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"/a/b.txt", "/a/b.txt"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := shellQuote(tt.s); got != tt.want {
+				t.Errorf("shellQuote() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shellPattern(t *testing.T) {
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"out/*.txt", "out/*.txt"},
+		{"my dir/o?[12].txt", "'my dir/o'?[12].txt"},
+		{"it's", `'it'\''s'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.p, func(t *testing.T) {
+			if got := shellPattern(tt.p); got != tt.want {
+				t.Errorf("shellPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cwlTool_task(t *testing.T) {
+	doc := map[interface{}]interface{}{
+		"class":       "CommandLineTool",
+		"baseCommand": []interface{}{"sort"},
+		"arguments":   []interface{}{"-u"},
+		"inputs": map[interface{}]interface{}{
+			"reverse": map[interface{}]interface{}{"type": "boolean", "inputBinding": map[interface{}]interface{}{"prefix": "-r", "position": 1}},
+			"words":   map[interface{}]interface{}{"type": "File", "inputBinding": map[interface{}]interface{}{"position": 2}},
+		},
+		"outputs": map[interface{}]interface{}{"sorted": "stdout"},
+		"stdout":  "$(inputs.words.nameroot).sorted",
+		"requirements": []interface{}{
+			map[interface{}]interface{}{"class": "ResourceRequirement", "coresMin": 2, "ramMin": 1500},
+		},
+	}
+	tool, err := parseCWLTool(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := tool.bind(map[string]interface{}{"reverse": true, "words": "/data/my words.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, outputs, err := tool.task("sort", inputs, "/out/sort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCmd := "mkdir -p /out/sort && cd /out/sort && sort -u -r '/data/my words.txt' >'my words.sorted'"
+	if spec.Command != wantCmd {
+		t.Errorf("Command = %v, want %v", spec.Command, wantCmd)
+	}
+	if outputs["sorted"] != "/out/sort/my words.sorted" {
+		t.Errorf("outputs = %v", outputs)
+	}
+	if spec.Resources.CPUs != 2 || spec.Resources.Memory != 2 {
+		t.Errorf("Resources = %+v", spec.Resources)
+	}
+}
+
+func Test_cwlCommand(t *testing.T) {
+	refs := map[string]string{
+		"/data/x.txt":        "$(inputs.in_0.path)",
+		"/data/x.txt.sorted": "x.txt.sorted",
+	}
+	got := cwlCommand("sort /data/x.txt >/data/x.txt.sorted; echo $(date)", refs)
+	want := `sort $(inputs.in_0.path) >x.txt.sorted; echo \$(date)`
+	if got != want {
+		t.Errorf("cwlCommand() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+package flow
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// outputSize is an estimated size of outputs: bytes, or factor times the
+// size of the inputs.
+type outputSize struct {
+	bytes  int64
+	factor float64
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	{"t", 1 << 40}, {"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+func parseOutputSize(s string) (outputSize, error) {
+	invalid := fmt.Errorf("output size must be a size such as 20GB or a multiple of the inputs such as 1.5x, not %s", s)
+	x := strings.ToLower(strings.TrimSpace(s))
+	if strings.HasSuffix(x, "x") {
+		factor, err := strconv.ParseFloat(strings.TrimSuffix(x, "x"), 64)
+		if err != nil || factor < 0 {
+			return outputSize{}, invalid
+		}
+		return outputSize{factor: factor}, nil
+	}
+	unit := float64(1 << 30)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(x, u.suffix) {
+			x, unit = strings.TrimSpace(strings.TrimSuffix(x, u.suffix)), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(x, 64)
+	if err != nil || n < 0 {
+		return outputSize{}, invalid
+	}
+	return outputSize{bytes: int64(n * unit)}, nil
+}
+
+func formatBytes(n int64) string {
+	for _, u := range sizeUnits[:4] {
+		if float64(n) >= u.bytes {
+			return fmt.Sprintf("%.1f%s", float64(n)/u.bytes, strings.ToUpper(u.suffix))
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// sizeEstimator estimates the size of the outputs of jobs, using the size of
+// inputs that exist and the estimates of the jobs that create those that do
+// not.
+type sizeEstimator struct {
+	estimates map[*job]int64
+}
+
+func (e *sizeEstimator) estimate(j *job) (int64, error) {
+	if n, ok := e.estimates[j]; ok {
+		return n, nil
+	}
+	e.estimates[j] = 0
+	if j.resources.OutputSize == "" {
+		return 0, nil
+	}
+	size, err := parseOutputSize(j.resources.OutputSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", j.Cmd.AnalysisName(), err)
+	}
+	n := size.bytes
+	if size.factor > 0 {
+		in, err := e.inputSize(j)
+		if err != nil {
+			return 0, err
+		}
+		n = int64(size.factor * float64(in))
+	}
+	e.estimates[j] = n
+	return n, nil
+}
+
+func (e *sizeEstimator) inputSize(j *job) (int64, error) {
+	total := int64(0)
+	for _, in := range j.Inputs {
+		if n, ok := pathSize(in); ok {
+			total += n
+			continue
+		}
+		for _, d := range j.Dependencies {
+			if !containsString(d.Outputs, in) {
+				continue
+			}
+			n, err := e.estimate(d)
+			if err != nil {
+				return 0, err
+			}
+			total += n / int64(len(d.Outputs))
+		}
+	}
+	return total, nil
+}
+
+func containsString(xs []string, s string) bool {
+	for _, x := range xs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pathSize returns the size of the files matching p, with everything in
+// directories, and whether there are any.
+func pathSize(p string) (int64, bool) {
+	paths := []string{p}
+	if isGlob(p) {
+		paths, _ = filepath.Glob(p)
+	}
+	total := int64(0)
+	found := false
+	for _, path := range paths {
+		filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				total += info.Size()
+				found = true
+			} else if err == nil && info.IsDir() {
+				found = true
+			}
+			return nil
+		})
+	}
+	return total, found
+}
+
+// filesystem is where outputs are written, the free space on it and the
+// estimated size of the outputs.
+type filesystem struct {
+	dir  string
+	free int64
+	need int64
+}
+
+// checkDiskSpace fails if the estimated size of the outputs of the pending
+// jobs is more than the free space on the filesystems they are written to.
+func (g *graph) checkDiskSpace() error {
+	if !g.config.GetBool("check_disk_space") {
+		return nil
+	}
+	e := sizeEstimator{estimates: map[*job]int64{}}
+	filesystems := map[uint64]*filesystem{}
+	for _, j := range g.pending {
+		n, err := e.estimate(j)
+		if err != nil {
+			return err
+		}
+		if n == 0 || len(j.Outputs) == 0 {
+			continue
+		}
+		for _, out := range j.Outputs {
+			dev, free, dir, err := freeSpace(filepath.Dir(out))
+			if err != nil {
+				log.Printf("Unable to determine free space for %s: %v", out, err)
+				continue
+			}
+			if filesystems[dev] == nil {
+				filesystems[dev] = &filesystem{dir: dir, free: free}
+			}
+			filesystems[dev].need += n / int64(len(j.Outputs))
+		}
+	}
+	problems := []string{}
+	for _, fs := range filesystems {
+		if fs.need > fs.free {
+			problems = append(problems, fmt.Sprintf("%s: outputs are estimated to need %s, only %s is free", fs.dir, formatBytes(fs.need), formatBytes(fs.free)))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("not enough disk space (set check_disk_space to false to run anyway):\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// freeSpace returns the device of the filesystem dir is on, or will be on
+// once created, the space free on it for unprivileged users and the
+// directory that was checked.
+func freeSpace(dir string) (uint64, int64, string, error) {
+	for {
+		var st syscall.Stat_t
+		if err := syscall.Stat(dir, &st); err == nil {
+			var fs syscall.Statfs_t
+			if err := syscall.Statfs(dir, &fs); err != nil {
+				return 0, 0, dir, err
+			}
+			return uint64(st.Dev), int64(fs.Bavail) * int64(fs.Bsize), dir, nil
+		} else if !os.IsNotExist(err) {
+			return 0, 0, dir, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, 0, dir, fmt.Errorf("no such directory")
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,64 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_parseOutputSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    outputSize
+		wantErr bool
+	}{
+		{"20GB", outputSize{bytes: 20 << 30}, false},
+		{"512mb", outputSize{bytes: 512 << 20}, false},
+		{"2", outputSize{bytes: 2 << 30}, false},
+		{"1.5x", outputSize{factor: 1.5}, false},
+		{"lots", outputSize{}, true},
+		{"-1GB", outputSize{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseOutputSize(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOutputSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseOutputSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkDiskSpace(t *testing.T) {
+	defer defaultConfig.Set("check_disk_space", defaultConfig.Get("check_disk_space"))
+	defaultConfig.Set("check_disk_space", true)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := ioutil.WriteFile(in, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mid := filepath.Join(dir, "mid.txt")
+	align := &job{Cmd: &fileTask{Task: Task{Name: "align"}}, Inputs: []string{in}, Outputs: []string{mid}, resources: Resources{OutputSize: "3x"}}
+	sort := &job{Cmd: &fileTask{Task: Task{Name: "sort"}}, Inputs: []string{mid}, Outputs: []string{filepath.Join(dir, "out", "sorted.txt")}, resources: Resources{OutputSize: "2x"}, Dependencies: []*job{align}}
+	e := sizeEstimator{estimates: map[*job]int64{}}
+	if got, err := e.estimate(sort); err != nil || got != 6000 {
+		t.Errorf("estimate() = %v, %v, want 6000", got, err)
+	}
+	g := graph{config: defaultConfig, pending: []*job{align, sort}}
+	if err := g.checkDiskSpace(); err != nil {
+		t.Errorf("checkDiskSpace() = %v", err)
+	}
+	huge := &job{Cmd: &fileTask{Task: Task{Name: "huge"}}, Outputs: []string{filepath.Join(dir, "huge.txt")}, resources: Resources{OutputSize: "1000000TB"}}
+	g.pending = append(g.pending, huge)
+	if err := g.checkDiskSpace(); err == nil || !strings.Contains(err.Error(), "not enough disk space") {
+		t.Errorf("checkDiskSpace() = %v, want not enough disk space", err)
+	}
+	defaultConfig.Set("check_disk_space", false)
+	if err := g.checkDiskSpace(); err != nil {
+		t.Errorf("checkDiskSpace() = %v when disabled", err)
+	}
+}
@@ -0,0 +1,268 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+)
+
+// workflowHandshake stops a workflow executable from being run other than by
+// flow. It only changes when the protocol between them does.
+var workflowHandshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "FLOW_WORKFLOW",
+	MagicCookieValue: "6d1c3f0e-workflow",
+}
+
+// workflowPluginName is the name the workflow is dispensed under.
+const workflowPluginName = "workflow"
+
+// workflowServer is the interface a workflow executable serves to flow.
+type workflowServer interface {
+	// Run runs the workflow with the config in the file config, as part
+	// of the run runID.
+	Run(config, runID string) error
+}
+
+// workflowPlugin carries a workflowServer over net/rpc. workflow is only set
+// in the workflow executable.
+type workflowPlugin struct {
+	workflow func(*Queue)
+}
+
+func (p *workflowPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &workflowRPCServer{workflow: p.workflow}, nil
+}
+
+func (p *workflowPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &workflowRPCClient{client: c}, nil
+}
+
+// WorkflowRunArgs are the arguments of a workflowServer's Run sent over RPC.
+type WorkflowRunArgs struct {
+	Config string
+	RunID  string
+}
+
+type workflowRPCClient struct {
+	client *rpc.Client
+}
+
+func (c *workflowRPCClient) Run(config, runID string) error {
+	return c.client.Call("Plugin.Run", WorkflowRunArgs{Config: config, RunID: runID}, new(struct{}))
+}
+
+type workflowRPCServer struct {
+	workflow func(*Queue)
+}
+
+func (s *workflowRPCServer) Run(args WorkflowRunArgs, resp *struct{}) error {
+	conf, err := NewConfig(args.Config, map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	conf.runID = args.RunID
+	// The executable runs only this workflow, so functions such as Params,
+	// which use the config read by InitConfig, read its config too.
+	setDefaultConfig(conf)
+	queue := &Queue{config: conf}
+	s.workflow(queue)
+	return queue.Run()
+}
+
+// serveMain is added to workflows that do not declare their own main
+// function when they are built as an executable.
+const serveMain = `package main
+
+import "github.com/jje42/flow"
+
+func main() {
+	flow.Serve(Workflow)
+}
+`
+
+// serveMainFile is the name given to serveMain in the workflow's package.
+const serveMainFile = "flow_serve_main.go"
+
+// Serve serves workflow to a flow process that built it as an executable
+// (the "executable" workflow loader), which runs it over RPC with
+// hashicorp/go-plugin. It returns once flow is done with the workflow.
+//
+// Workflows normally do not need to call Serve: a main function calling it
+// is generated for them. Workflows that declare their own main function must
+// call Serve from it.
+func Serve(workflow func(*Queue)) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: workflowHandshake,
+		Plugins: map[string]plugin.Plugin{
+			workflowPluginName: &workflowPlugin{workflow: workflow},
+		},
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Level:      hclog.Error,
+			Output:     os.Stderr,
+			JSONFormat: true,
+		}),
+	})
+}
+
+// runExecutable builds the workflow as a normal executable and runs it over
+// RPC. The executable links its own copy of flow, so unlike a plugin it does
+// not have to be built with exactly the same versions of flow and its
+// dependencies as this binary.
+func runExecutable(conf *Config, fn string) error {
+	log.Printf("Compiling workflow\n")
+	exe, err := compileExecutable(conf, fn)
+	if err != nil {
+		return fmt.Errorf("failed to compile workflow: %v", err)
+	}
+	runDir, err := conf.runDir()
+	if err != nil {
+		return err
+	}
+	config := filepath.Join(filepath.Dir(exe), "config.yaml")
+	if err := conf.v.WriteConfigAs(config); err != nil {
+		return fmt.Errorf("failed to write config for workflow: %v", err)
+	}
+	cmd := exec.Command(exe)
+	cmd.Stdin = os.Stdin
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig: workflowHandshake,
+		Plugins: map[string]plugin.Plugin{
+			workflowPluginName: &workflowPlugin{},
+		},
+		Cmd: cmd,
+		// Output of the workflow, including its log, is passed on
+		// as is; only errors from go-plugin itself are logged.
+		Stderr:     log.Writer(),
+		SyncStdout: log.Writer(),
+		SyncStderr: log.Writer(),
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "workflow",
+			Level:  hclog.Error,
+			Output: log.Writer(),
+		}),
+	})
+	defer client.Kill()
+	rpcClient, err := client.Client()
+	if err != nil {
+		return fmt.Errorf("failed to start workflow: %v", err)
+	}
+	raw, err := rpcClient.Dispense(workflowPluginName)
+	if err != nil {
+		return fmt.Errorf("failed to start workflow: %v", err)
+	}
+	if err := raw.(workflowServer).Run(config, filepath.Base(runDir)); err != nil {
+		return fmt.Errorf("workflow failed: %v", err)
+	}
+	return nil
+}
+
+// compileExecutable builds the workflow as an executable in the same way as
+// compileWorkflow builds a plugin, adding serveMain unless the workflow
+// declares its own main function.
+func compileExecutable(conf *Config, fn string) (string, error) {
+	runDir, err := conf.runDir()
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(runDir, "workflow")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	exe := filepath.Join(dir, "workflow")
+	buildDir, files, err := prepareBuild(fn, dir)
+	if err != nil {
+		return "", err
+	}
+	hasMain, err := declaresMain(buildDir, files)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"build", "-o", exe}
+	if !hasMain {
+		if buildDir == dir {
+			if err := ioutil.WriteFile(filepath.Join(dir, serveMainFile), []byte(serveMain), 0644); err != nil {
+				return "", fmt.Errorf("failed to write %s: %v", serveMainFile, err)
+			}
+		} else {
+			// Add serveMain to the package with an overlay rather than
+			// writing it into the user's source directory.
+			overlay, err := writeServeOverlay(dir, filepath.Join(buildDir, serveMainFile))
+			if err != nil {
+				return "", err
+			}
+			args = append(args, "-overlay", overlay)
+		}
+		// A whole package picks up the new file by itself. Named files
+		// must all be given in the same way.
+		switch {
+		case files[0] == ".":
+		case filepath.IsAbs(files[0]):
+			files = append(files, filepath.Join(buildDir, serveMainFile))
+		default:
+			files = append(files, serveMainFile)
+		}
+	}
+	cmdl := exec.Command("go", append(args, files...)...)
+	cmdl.Dir = buildDir
+	out, err := cmdl.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to compile workflow: %v\n%v", err, string(out))
+	}
+	return exe, nil
+}
+
+// writeServeOverlay writes serveMain into dir together with a go build
+// overlay that makes it appear at path.
+func writeServeOverlay(dir, path string) (string, error) {
+	mainFile := filepath.Join(dir, serveMainFile)
+	if err := ioutil.WriteFile(mainFile, []byte(serveMain), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", serveMainFile, err)
+	}
+	overlay := struct {
+		Replace map[string]string
+	}{map[string]string{path: mainFile}}
+	data, err := json.Marshal(overlay)
+	if err != nil {
+		return "", err
+	}
+	fn := filepath.Join(dir, "overlay.json")
+	if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write build overlay: %v", err)
+	}
+	return fn, nil
+}
+
+// declaresMain reports whether the workflow being built in dir declares a
+// main function. files are as returned by prepareBuild.
+func declaresMain(dir string, files []string) (bool, error) {
+	if files[0] == "." {
+		var err error
+		if files, err = packageFiles(dir); err != nil {
+			return false, err
+		}
+	}
+	for _, fn := range files {
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(dir, fn)
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), fn, nil, 0)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse workflow: %v", err)
+		}
+		if f.Scope.Lookup("main") != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
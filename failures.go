@@ -0,0 +1,95 @@
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// failureTailLines is the number of lines of a failed job's output shown in
+// the failure summary.
+const failureTailLines = 20
+
+// failureSummary describes each failed job with what is needed to look into
+// it: its exit code, the end of its output, its work directory and the
+// command that reruns it.
+func failureSummary(failed, allowed []*job) string {
+	var b strings.Builder
+	for _, list := range []struct {
+		jobs  []*job
+		label string
+	}{{failed, "FAILED"}, {allowed, "FAILED (allowed)"}} {
+		for _, j := range list.jobs {
+			fmt.Fprintf(&b, "%s: %s (%s)\n", list.label, j.Cmd.AnalysisName(), j.UUID)
+			if j.ID != "" {
+				fmt.Fprintf(&b, "  Job ID:    %s\n", j.ID)
+			}
+			exit := "unknown"
+			if code, ok := exitCode(j); ok {
+				exit = strconv.Itoa(code)
+			}
+			fmt.Fprintf(&b, "  Exit code: %s\n", exit)
+			fmt.Fprintf(&b, "  Reason:    %s\n", j.failure)
+			if j.workDir != "" {
+				fmt.Fprintf(&b, "  Work dir:  %s\n", j.workDir)
+				fmt.Fprintf(&b, "  Rerun:     %s\n", rerunCommand(j))
+			}
+			fmt.Fprintf(&b, "  Output:    %s\n", j.Stdout)
+			if lines := tail(j.Stdout, failureTailLines); len(lines) > 0 {
+				b.WriteString("  Last lines of output:\n")
+				for _, line := range lines {
+					fmt.Fprintf(&b, "    %s\n", line)
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// exitCode returns the exit code recorded by the job script, if it ran to
+// the end.
+func exitCode(j *job) (int, bool) {
+	if j.workDir == "" {
+		return 0, false
+	}
+	b, err := ioutil.ReadFile(exitCodeFile(j.workDir))
+	if err != nil {
+		return 0, false
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	return code, err == nil
+}
+
+// rerunCommand returns the command that runs the job again, as it was
+// submitted.
+func rerunCommand(j *job) string {
+	cmd := j.BatchCommand
+	if cmd == "" {
+		cmd = fmt.Sprintf("bash %s >%s 2>&1", shellQuote(filepath.Join(j.workDir, "job.sh")), shellQuote(j.Stdout))
+	}
+	return fmt.Sprintf("cd %s && %s", shellQuote(j.workDir), cmd)
+}
+
+// tail returns the last n lines of the file fn.
+func tail(fn string, n int) []string {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,51 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func Test_failureSummary(t *testing.T) {
+	dir := t.TempDir()
+	stdout := filepath.Join(dir, "out.txt.out")
+	output := ""
+	for i := 1; i <= 30; i++ {
+		output += fmt.Sprintf("line %d\n", i)
+	}
+	if err := ioutil.WriteFile(stdout, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(exitCodeFile(dir), []byte("137\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &job{
+		Cmd:          &fileTask{Task: Task{Name: "bwa"}},
+		UUID:         uuid.New(),
+		ID:           "1234",
+		Stdout:       stdout,
+		workDir:      dir,
+		BatchCommand: "sbatch job.sh",
+		failure:      "job 1234 failed",
+	}
+	got := failureSummary([]*job{j}, nil)
+	for _, want := range []string{
+		"FAILED: bwa (",
+		"  Exit code: 137\n",
+		"  Work dir:  " + dir + "\n",
+		"  Rerun:     cd " + dir + " && sbatch job.sh\n",
+		"    line 11\n",
+		"    line 30\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("failureSummary() = %v, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "line 10\n") {
+		t.Errorf("failureSummary() = %v, want only the last %d lines", got, failureTailLines)
+	}
+}
@@ -0,0 +1,78 @@
+package flow
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+)
+
+// Map adds a task to q for each element of items, a slice, made by fn, a
+// function that takes an element and returns a Commander, and returns the
+// tasks in the order of items:
+//
+//	aligned := flow.Map(q, samples, func(s Sample) flow.Commander {
+//		return &Align{Reads: s.Reads, Bam: s.Name + ".bam"}
+//	})
+//
+// fn may return nil to add nothing for an element. Map panics if items is
+// not a slice or fn does not take its elements, as sort.Slice does.
+func Map(q *Queue, items interface{}, fn interface{}) []Commander {
+	xs := reflect.ValueOf(items)
+	if xs.Kind() != reflect.Slice && xs.Kind() != reflect.Array {
+		panic(fmt.Sprintf("flow.Map: items must be a slice, not %T", items))
+	}
+	f := reflect.ValueOf(fn)
+	commander := reflect.TypeOf((*Commander)(nil)).Elem()
+	if f.Kind() != reflect.Func || f.Type().NumIn() != 1 || f.Type().NumOut() != 1 ||
+		!xs.Type().Elem().AssignableTo(f.Type().In(0)) || !f.Type().Out(0).Implements(commander) {
+		panic(fmt.Sprintf("flow.Map: fn must be a func(%s) Commander, not %T", xs.Type().Elem(), fn))
+	}
+	tasks := []Commander{}
+	for i := 0; i < xs.Len(); i++ {
+		out := f.Call([]reflect.Value{xs.Index(i)})[0]
+		if (out.Kind() == reflect.Interface || out.Kind() == reflect.Ptr) && out.IsNil() {
+			continue
+		}
+		task := out.Interface().(Commander)
+		q.Add(task)
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// Outputs returns the outputs of tasks whose file name matches pattern, as
+// for filepath.Match, or all of them if pattern is "", in the order of the
+// tasks.
+func Outputs(tasks []Commander, pattern string) []string {
+	paths := []string{}
+	for _, t := range tasks {
+		for _, p := range cmdOutputs(t) {
+			if ok, _ := filepath.Match(pattern, filepath.Base(p)); pattern == "" || ok {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// Gather adds a task to q that merges the outputs of tasks, made by fn from
+// those that match pattern (see Outputs), and returns it:
+//
+//	merged := flow.Gather(q, called, "*.vcf.gz", func(vcfs []string) flow.Commander {
+//		return &MergeVCFs{Inputs: vcfs, Output: "cohort.vcf.gz"}
+//	})
+//
+// The task must declare the paths it is given as inputs, which is what makes
+// it depend on tasks; Gather panics if it does not.
+func Gather(q *Queue, tasks []Commander, pattern string, fn func(inputs []string) Commander) Commander {
+	paths := Outputs(tasks, pattern)
+	task := fn(paths)
+	inputs := cmdInputs(task)
+	for _, p := range paths {
+		if !hasIntersection(inputs, []string{p}) {
+			panic(fmt.Sprintf("flow.Gather: %s does not declare %s as an input", task.AnalysisName(), p))
+		}
+	}
+	q.Add(task)
+	return task
+}
@@ -0,0 +1,78 @@
+package flow
+
+import (
+	"testing"
+)
+
+func Test_Map(t *testing.T) {
+	type sample struct{ name string }
+	samples := []sample{{"a"}, {"skip"}, {"b"}}
+	q := &Queue{}
+	tasks := Map(q, samples, func(s sample) Commander {
+		if s.name == "skip" {
+			return nil
+		}
+		return &ShellTask{Name: "align_" + s.name, Outputs: []string{s.name + ".bam"}}
+	})
+	if len(tasks) != 2 || len(q.Tasks()) != 2 || tasks[1].AnalysisName() != "align_b" {
+		t.Errorf("Map() = %d tasks, queue has %d, want align_a and align_b", len(tasks), len(q.Tasks()))
+	}
+	// A function returning a concrete task type is also accepted.
+	tasks = Map(q, []string{"c"}, func(s string) *ShellTask { return &ShellTask{Name: s} })
+	if len(tasks) != 1 || len(q.Tasks()) != 3 {
+		t.Errorf("Map() = %d tasks, want 1", len(tasks))
+	}
+	for name, fn := range map[string]interface{}{
+		"wrong_element": func(n int) Commander { return nil },
+		"not_a_task":    func(s sample) string { return "" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Map() did not panic")
+				}
+			}()
+			Map(q, samples, fn)
+		})
+	}
+}
+
+func Test_Gather(t *testing.T) {
+	q := &Queue{}
+	called := Map(q, []string{"a", "b"}, func(s string) Commander {
+		return &ShellTask{Name: "call_" + s, Outputs: []string{s + ".vcf.gz", s + ".vcf.gz.tbi"}}
+	})
+	merged := Gather(q, called, "*.vcf.gz", func(vcfs []string) Commander {
+		return &ShellTask{Name: "merge", Inputs: vcfs, Outputs: []string{"cohort.vcf.gz"}}
+	})
+	if got := cmdInputs(merged); len(got) != 2 || got[0] != "a.vcf.gz" || got[1] != "b.vcf.gz" {
+		t.Errorf("Gather() inputs = %v, want a.vcf.gz and b.vcf.gz", got)
+	}
+	if len(q.Tasks()) != 3 {
+		t.Errorf("queue has %d tasks, want 3", len(q.Tasks()))
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Gather() did not panic for a task without the inputs")
+		}
+	}()
+	Gather(q, called, "", func(paths []string) Commander {
+		return &ShellTask{Name: "merge", Inputs: paths[:1], Outputs: []string{"cohort.vcf.gz"}}
+	})
+}
+
+func Test_Queue_AddQueue(t *testing.T) {
+	sub := &Queue{}
+	sub.AddAll(&ShellTask{Name: "a"}, &ShellTask{Name: "b"})
+	q := &Queue{}
+	q.Add(&ShellTask{Name: "first"})
+	q.AddQueue(sub)
+	q.AddQueue(nil)
+	names := []string{}
+	for _, task := range q.Tasks() {
+		names = append(names, task.AnalysisName())
+	}
+	if len(names) != 3 || names[0] != "first" || names[2] != "b" || len(sub.Tasks()) != 2 {
+		t.Errorf("AddQueue() = %v, want first a b", names)
+	}
+}
@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// File can be used in place of a string for input and output fields of a
+// task. In addition to the path it carries metadata that the engine can act
+// upon.
+//
+//	type Align struct {
+//		flow.Task
+//		Reference flow.File `type:"input"`
+//		Output    flow.File `type:"output"`
+//	}
+type File struct {
+	// Path is the location of the file. As with string fields, it is made
+	// absolute when the task is added to the workflow.
+	Path string
+	// Checksum is the expected checksum of an input, either "md5:<hex>" or
+	// "sha256:<hex>" (a bare hex digest is taken to be sha256). If set, the
+	// input is verified before any job using it is run.
+	Checksum string
+	// Source is the URI the file originally came from, if any.
+	Source string
+	// Temp marks the file as an intermediate output, equivalent to the temp
+	// tag modifier.
+	Temp bool
+	// Protected marks the file as a final output that must not be
+	// overwritten, equivalent to the protected tag modifier.
+	Protected bool
+	// Name is the name of the file in the command of a TemplateTask. It is
+	// kept by each of the files an input pattern matches.
+	Name string
+}
+
+// String returns the path of the file so that a File can be used directly in
+// fmt verbs and templates when building a command.
+func (f File) String() string {
+	return f.Path
+}
+
+// modifiers returns the tag modifiers implied by the file's flags.
+func (f File) modifiers() map[string]bool {
+	return map[string]bool{
+		"temp":      f.Temp,
+		"protected": f.Protected,
+	}
+}
+
+var fileType = reflect.TypeOf(File{})
+
+// isPathType reports whether a field of type t can hold a path, i.e., it is a
+// string or a File.
+func isPathType(t reflect.Type) bool {
+	return t.Kind() == reflect.String || t == fileType
+}
+
+// pathValue returns the string value holding the path of v, which must be of
+// a type accepted by isPathType.
+func pathValue(v reflect.Value) reflect.Value {
+	if v.Type() == fileType {
+		return v.FieldByName("Path")
+	}
+	return v
+}
+
+// setSource records the URI of a File given as a URI in its Source field,
+// before the path is replaced by its staged location. v may be any value
+// accepted by isPathType.
+func setSource(v reflect.Value) {
+	if v.Type() != fileType {
+		return
+	}
+	src, path := v.FieldByName("Source"), v.FieldByName("Path")
+	if src.String() == "" && isURI(path.String()) {
+		src.SetString(path.String())
+	}
+}
+
+// cmdFiles returns all File values (including elements of []File) in fields
+// of kind t.
+func cmdFiles(c Commander, t string) []File {
+	files := []File{}
+	v := taskValue(c)
+	for i := 0; i < v.NumField(); i++ {
+		kind, _ := parseTypeTag(v.Type().Field(i).Tag.Get("type"))
+		if kind != t {
+			continue
+		}
+		val := v.Field(i)
+		if !val.CanInterface() {
+			continue
+		}
+		switch {
+		case val.Type() == fileType:
+			files = append(files, val.Interface().(File))
+		case val.Kind() == reflect.Slice && val.Type().Elem() == fileType:
+			for j := 0; j < val.Len(); j++ {
+				files = append(files, val.Index(j).Interface().(File))
+			}
+		}
+	}
+	return files
+}
+
+// verifyChecksum checks the file against its Checksum, if one is set.
+func (f File) verifyChecksum() error {
+	if f.Checksum == "" {
+		return nil
+	}
+	algorithm, want := splitChecksum(f.Checksum)
+	got, err := fileDigest(f.Path, algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got, want) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", f.Path, want, got)
+	}
+	return nil
+}
+
+// splitChecksum splits a checksum such as "md5:<hex>" into the algorithm and
+// the hex digest. A bare digest is taken to be sha256.
+func splitChecksum(checksum string) (string, string) {
+	if idx := strings.Index(checksum, ":"); idx != -1 {
+		return checksum[:idx], checksum[idx+1:]
+	}
+	return "sha256", checksum
+}
+
+// fileDigest returns the hex digest of the file using the named algorithm.
+func fileDigest(fn string, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+	r, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("unable to read %s: %v", fn, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -11,15 +11,14 @@ import (
 	"path/filepath"
 	"plugin"
 	"reflect"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 )
 
-var v *viper.Viper = viper.New()
-
 type Commander interface {
 	AnalysisName() string
 	Command() string
@@ -32,6 +31,66 @@ type Resources struct {
 	Time                 int
 	Container            string
 	SingularityExtraArgs string
+	// Scratch runs the task in node-local scratch space, copying inputs
+	// in and outputs back out.
+	Scratch bool
+	// Isolated writes the outputs of the task into its work directory and
+	// links them out once it succeeds.
+	Isolated bool
+	// Tmpfs gives the task a temporary directory of this many GB in memory
+	// (see tmpfs_dir), for tools that create huge numbers of small
+	// temporary files. Files in it count against Memory.
+	Tmpfs int
+	// Labels group tasks so that selectors in the config can override
+	// their resources.
+	Labels []string
+	// Env is exported in the environment of the task, inside its
+	// container if it has one. FLOW_TASK_NAME, FLOW_RUN_ID, FLOW_ATTEMPT,
+	// FLOW_CPUS and FLOW_MEMORY_MB are always exported as well.
+	Env map[string]string
+	// Ulimits sets the limits of the shell the task runs in, by name:
+	// nofile, nproc, stack, core or memlock, e.g., {"nofile": "65536"}.
+	Ulimits map[string]string
+	// Secrets are the names of secrets in the config that are exported in
+	// the environment of the task.
+	Secrets []string
+	// Modules are loaded with Environment Modules or Lmod before the task
+	// is run, instead of or as well as using a container.
+	Modules []string
+	// Conda is the name of a conda environment, or an environment.yml file
+	// from which flow creates one, that is activated before the task is
+	// run.
+	Conda string
+	// Account, Queue (the partition with SLURM) and QOS are passed to the
+	// scheduler, overriding those of the slurm or pbs config.
+	Account string
+	Queue   string
+	QOS     string
+	// SchedulerArgs are added to the command that submits the task to the
+	// scheduler, for options flow does not otherwise support. They are
+	// split into arguments as the shell would.
+	SchedulerArgs string
+	// AllowFailure marks a non-critical task, such as a QC report, whose
+	// failure is reported but does not fail the workflow. Tasks that
+	// depend on it are not run.
+	AllowFailure bool
+	// OutputSize is the estimated size of the outputs of the task, such as
+	// 20GB, or a multiple of the size of its inputs, such as 1.5x, which is
+	// checked against the free disk space before the run starts.
+	OutputSize string
+	// Pack names a group of short tasks that are run one after another in
+	// a single job, up to pack_size at a time, rather than submitted one
+	// job each.
+	Pack string
+	// Batch runs the tasks of the same analysis Batch at a time in a single
+	// job, as Pack does, and is usually given to an analysis by a selector
+	// in the config.
+	Batch int
+	// Checkpoint is how a long running task is checkpointed so that, when
+	// it is resubmitted, it restarts from its last checkpoint rather than
+	// from the start: dmtcp, or native for a tool that checkpoints itself
+	// to $FLOW_CHECKPOINT_DIR.
+	Checkpoint string
 }
 
 // Task provides some default implementations for
@@ -44,6 +103,30 @@ type Task struct {
 	Time                 int
 	Container            string
 	SingularityExtraArgs string
+	Scratch              bool
+	Isolated             bool
+	Tmpfs                int
+	Labels               []string
+	Env                  map[string]string
+	Ulimits              map[string]string
+	Secrets              []string
+	Modules              []string
+	Conda                string
+	Account              string
+	Queue                string
+	QOS                  string
+	SchedulerArgs        string
+	AllowFailure         bool
+	OutputSize           string
+	Pack                 string
+	Batch                int
+	Checkpoint           string
+	// Attempt is which attempt at running the task this is, counting
+	// from 1, set by flow before Command is called.
+	Attempt int
+	// boundConfig is the config of the run the task is part of, which
+	// Render resolves its resources with.
+	boundConfig
 }
 
 func (t Task) AnalysisName() string {
@@ -77,6 +160,24 @@ func (t Task) Resources() Resources {
 		Time:                 time,
 		Container:            t.Container,
 		SingularityExtraArgs: t.SingularityExtraArgs,
+		Scratch:              t.Scratch,
+		Isolated:             t.Isolated,
+		Tmpfs:                t.Tmpfs,
+		Labels:               t.Labels,
+		Env:                  t.Env,
+		Ulimits:              t.Ulimits,
+		Secrets:              t.Secrets,
+		Modules:              t.Modules,
+		Conda:                t.Conda,
+		Account:              t.Account,
+		Queue:                t.Queue,
+		QOS:                  t.QOS,
+		SchedulerArgs:        t.SchedulerArgs,
+		AllowFailure:         t.AllowFailure,
+		OutputSize:           t.OutputSize,
+		Pack:                 t.Pack,
+		Batch:                t.Batch,
+		Checkpoint:           t.Checkpoint,
 	}
 }
 
@@ -86,111 +187,483 @@ func (t *Task) SetResources(res Resources) {
 	t.Time = res.Time
 	t.Container = res.Container
 	t.SingularityExtraArgs = res.SingularityExtraArgs
+	t.Scratch = res.Scratch
+	t.Isolated = res.Isolated
+	t.Tmpfs = res.Tmpfs
+	t.Labels = res.Labels
+	t.Env = res.Env
+	t.Ulimits = res.Ulimits
+	t.Secrets = res.Secrets
+	t.Modules = res.Modules
+	t.Conda = res.Conda
+	t.Account = res.Account
+	t.Queue = res.Queue
+	t.QOS = res.QOS
+	t.SchedulerArgs = res.SchedulerArgs
+	t.AllowFailure = res.AllowFailure
+	t.OutputSize = res.OutputSize
+	t.Pack = res.Pack
+	t.Batch = res.Batch
+	t.Checkpoint = res.Checkpoint
+}
+
+// ShellTask is a Commander for one-off steps that do not warrant a type of
+// their own. Cmd is rendered with Render, so it can refer to the inputs and
+// outputs:
+//
+//	q.Add(&flow.ShellTask{
+//		Name:    "compress",
+//		Cmd:     "gzip -c {{index .Inputs 0}} >{{index .Outputs 0}}",
+//		Inputs:  []string{"out.txt"},
+//		Outputs: []string{"out.txt.gz"},
+//	})
+type ShellTask struct {
+	Name    string
+	Cmd     string
+	Inputs  []string `type:"input"`
+	Outputs []string `type:"output"`
+	// Res are the resources of the task. Unset values take the same
+	// defaults as Task.
+	Res Resources
+	boundConfig
+}
+
+func (t *ShellTask) AnalysisName() string {
+	return Task{Name: t.Name}.AnalysisName()
+}
+
+func (t *ShellTask) Command() string {
+	return Render(t, t.Cmd)
+}
+
+func (t *ShellTask) Resources() Resources {
+	task := Task{}
+	task.SetResources(t.Res)
+	return task.Resources()
+}
+
+// ScriptTask runs a multi-line script with an interpreter such as python3 or
+// Rscript, rather than a shell command. Script is rendered with Render and
+// has its common indentation removed, so it can be written indented in the
+// source:
+//
+//	q.Add(&flow.ScriptTask{
+//		Name:        "count",
+//		Interpreter: "python3",
+//		Script: `
+//			with open("{{index .Inputs 0}}") as f, open("{{index .Outputs 0}}", "w") as out:
+//			    out.write(str(len(f.readlines())))
+//		`,
+//		Inputs:  []string{"reads.txt"},
+//		Outputs: []string{"count.txt"},
+//	})
+//
+// Any task can do the same by starting its command with a shebang line; the
+// script is written to the work directory and run with the interpreter named
+// there, inside the container if there is one.
+type ScriptTask struct {
+	Name string
+	// Interpreter runs the script, found on the PATH if not absolute. It
+	// defaults to bash.
+	Interpreter string
+	Script      string
+	Inputs      []string `type:"input"`
+	Outputs     []string `type:"output"`
+	// Res are the resources of the task. Unset values take the same
+	// defaults as Task.
+	Res Resources
+	boundConfig
+}
+
+func (t *ScriptTask) AnalysisName() string {
+	return Task{Name: t.Name}.AnalysisName()
+}
+
+func (t *ScriptTask) Command() string {
+	script := dedent(Render(t, t.Script))
+	if strings.HasPrefix(script, "#!") {
+		return script
+	}
+	interpreter := t.Interpreter
+	if interpreter == "" {
+		interpreter = "bash"
+	}
+	if !filepath.IsAbs(interpreter) {
+		interpreter = "/usr/bin/env " + interpreter
+	}
+	return "#!" + interpreter + "\n" + script
+}
+
+func (t *ScriptTask) Resources() Resources {
+	task := Task{}
+	task.SetResources(t.Res)
+	return task.Resources()
 }
 
 type Queue struct {
-	tasks []Commander
+	tasks  []Commander
+	config *Config
+}
+
+// NewQueue returns an empty Queue that is run with config c.
+func NewQueue(c *Config) *Queue {
+	return &Queue{config: c}
 }
 
 func (q *Queue) Add(task ...Commander) {
 	q.tasks = append(q.tasks, task...)
 }
 
+// AddAll adds tasks to the queue, such as those built by a helper:
+//
+//	q.AddAll(alignTasks(samples)...)
+func (q *Queue) AddAll(tasks ...Commander) {
+	q.Add(tasks...)
+}
+
+// AddQueue adds the tasks of other to the queue, in order, so that a list
+// of tasks can be built separately, in its own Queue, and then merged.
+// other is left as it is.
+func (q *Queue) AddQueue(other *Queue) {
+	if other == nil {
+		return
+	}
+	q.Add(other.tasks...)
+}
+
 func (q *Queue) Tasks() []Commander {
 	return q.tasks
 }
 
+// Run runs the tasks in the queue with its config or, if it has none, the
+// one read by InitConfig.
 func (q *Queue) Run() error {
-	if !v.IsSet("flowdir") {
-		InitConfig("", map[string]interface{}{})
-	}
+	conf := q.queueConfig()
 	if len(q.tasks) > 0 {
 		log.Printf("Starting workflow with %d jobs", len(q.tasks))
 	} else {
 		log.Printf("No jobs where added to the queue, nothing to do!")
 	}
 	for _, task := range q.tasks {
-		freezeTask(task)
+		if err := freezeTask(conf, task); err != nil {
+			return err
+		}
 		//r := task.Resources()
 		//if r.Container == "" {
 		//        return fmt.Errorf("no container specified for task: %v", task.AnalysisName())
 		//}
 	}
-	g, err := newGraph(q.tasks)
+	dryRun := conf.GetBool("dry_run")
+	if !dryRun {
+		if err := lockFlowdir(conf); err != nil {
+			return err
+		}
+		defer unlockFlowdir(conf)
+	}
+	g, err := newGraph(conf, q.tasks)
 	if err != nil {
 		return fmt.Errorf("unable to create graph: %v", err)
 	}
+	if dryRun {
+		g.describe()
+		return nil
+	}
+	if pattern := conf.GetString("benchmark"); pattern != "" {
+		return g.benchmark(pattern, conf.GetInt("benchmark_repeats"))
+	}
+	if err := g.checkDiskSpace(); err != nil {
+		return err
+	}
+	prepareOnly := conf.GetBool("prepare_only")
+	if conf.GetString("job_runner") != "dummy" {
+		if err := g.buildImages(); err != nil {
+			return err
+		}
+		if conf.GetBool("prepare") || prepareOnly {
+			if err := g.prepare(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := createCondaEnvs(conf, g.pending); err != nil {
+		return err
+	}
+	if prepareOnly {
+		return nil
+	}
 	err = g.Process()
 	if err != nil {
+		unlockFlowdir(conf)
 		log.Fatalf("Failed to run workflow: %v", err)
 	}
 	return nil
 }
 
-func freezeTask(c Commander) {
-	v := reflect.ValueOf(c).Elem()
+// taskValue returns the struct behind a Commander. Commanders defined by
+// an interpreted workflow arrive wrapped in a struct whose IValue field holds
+// the interpreted value, so that is unwrapped first.
+func taskValue(c Commander) reflect.Value {
+	val := reflect.ValueOf(c)
+	if val.Kind() == reflect.Struct {
+		if w := val.FieldByName("IValue"); w.IsValid() && !w.IsNil() {
+			val = w.Elem()
+		}
+	}
+	return val.Elem()
+}
+
+// freezeTask makes the input and output paths of a task absolute, staging
+// URIs, and binds it to conf, before it is added to the graph.
+func freezeTask(conf *Config, c Commander) error {
+	if b, ok := c.(interface{ bind(*Config) }); ok {
+		b.bind(conf)
+	}
+	v := taskValue(c)
 	t := v.Type()
 	for i := 0; i < v.NumField(); i++ {
 		ft := t.Field(i)
-		tag := ft.Tag.Get("type")
+		tag, _ := parseTypeTag(ft.Tag.Get("type"))
 		if tag == "input" || tag == "output" {
 			val := v.Field(i)
 			if val.CanSet() {
-				switch kind := val.Kind(); kind {
-				case reflect.String:
-					var p string
-					if val.String() != "" {
-						p, _ = filepath.Abs(val.String())
+				switch {
+				case isPathType(val.Type()):
+					setSource(val)
+					if err := absPath(conf, pathValue(val)); err != nil {
+						return fmt.Errorf("%s: %v", c.AnalysisName(), err)
 					}
-					val.SetString(p)
-				case reflect.Slice:
-					if val.Type().Elem().Name() != "string" {
-						panic("tag type:input or type:output on something that is not []string")
+				case val.Kind() == reflect.Slice:
+					if !isPathType(val.Type().Elem()) {
+						panic("tag type:input or type:output on something that is not []string or []File")
 					}
 					for j := 0; j < val.Len(); j++ {
-						sliceValue := val.Index(j)
-						var p string
-						if sliceValue.String() != "" {
-							p, _ = filepath.Abs(sliceValue.String())
+						setSource(val.Index(j))
+						if err := absPath(conf, pathValue(val.Index(j))); err != nil {
+							return fmt.Errorf("%s: %v", c.AnalysisName(), err)
 						}
-						sliceValue.SetString(p)
 					}
 				default:
-					panic("tag type:input or tag:output on something that is not a string or slice")
+					panic("tag type:input or tag:output on something that is not a string, File or slice")
 				}
 			}
 		}
 	}
+	return nil
 }
 
-func resourcesFor(analysisName string) (Resources, error) {
-	// Should we provide default resource allocations or just fail?
-	// cpus=1;mem=1;time=1 is rarely going to be useful.
-	cpus := v.GetInt(fmt.Sprintf("resources.%s.cpus", analysisName))
-	if cpus == 0 {
-		return Resources{}, fmt.Errorf("no cpus resource for %s", analysisName)
+// absPath replaces the path held in the string value v with its absolute
+// form. Empty paths are left empty and URIs are replaced by their path in the
+// staging directory.
+func absPath(conf *Config, v reflect.Value) error {
+	var p string
+	switch {
+	case v.String() == "":
+	case isURI(v.String()):
+		var err error
+		p, err = stagedPath(conf, v.String())
+		if err != nil {
+			return err
+		}
+	default:
+		p, _ = filepath.Abs(v.String())
 	}
-	memory := v.GetInt(fmt.Sprintf("resources.%s.memory", analysisName))
-	if memory == 0 {
-		return Resources{}, fmt.Errorf("no memory resource for %s", analysisName)
+	v.SetString(p)
+	return nil
+}
+
+// resourcesFor returns the resources of a task with any overrides from the
+// selectors in the config applied. withLabel selectors match a label of the
+// task and withName selectors, which take precedence, match its analysis name
+// with a glob pattern. Within each kind selectors apply in the order listed:
+//
+//	selectors:
+//	  - withLabel: align
+//	    cpus: 16
+//	  - withName: "bwa_*"
+//	    memory: 32
+func resourcesFor(conf *Config, c Commander) (Resources, error) {
+	r := c.Resources()
+	// Variables from the env config key apply to every task, those of the
+	// task itself take precedence.
+	r.Env = mergeEnv(stringMap(conf.Get("env")), r.Env)
+	selectors, err := configSelectors(conf)
+	if err != nil {
+		return r, err
 	}
-	time := v.GetInt(fmt.Sprintf("resources.%s.time", analysisName))
-	if time == 0 {
-		return Resources{}, fmt.Errorf("no time resource for %s", analysisName)
+	for _, kind := range []string{"withlabel", "withname"} {
+		for _, sel := range selectors {
+			pattern, ok := sel[kind].(string)
+			if !ok {
+				continue
+			}
+			matched := false
+			if kind == "withname" {
+				matched, _ = filepath.Match(pattern, c.AnalysisName())
+			} else {
+				for _, label := range r.Labels {
+					matched = matched || label == pattern
+				}
+			}
+			if matched {
+				applyOverrides(&r, sel)
+			}
+		}
 	}
-	container := v.GetString(fmt.Sprintf("resources.%s.container", analysisName))
-	if container == "" {
-		return Resources{}, fmt.Errorf("no container resource for %s", analysisName)
+	r.Container = resolveContainer(r.Container)
+	return r, nil
+}
+
+// configSelectors returns the selectors in the config, with lower case keys.
+func configSelectors(conf *Config) ([]map[string]interface{}, error) {
+	raw, ok := conf.Get("selectors").([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	selectors := []map[string]interface{}{}
+	for _, x := range raw {
+		sel := make(map[string]interface{})
+		switch x := x.(type) {
+		case map[string]interface{}:
+			for k, val := range x {
+				sel[strings.ToLower(k)] = val
+			}
+		case map[interface{}]interface{}:
+			for k, val := range x {
+				sel[strings.ToLower(fmt.Sprint(k))] = val
+			}
+		default:
+			return nil, fmt.Errorf("invalid selector: %v", x)
+		}
+		_, byName := sel["withname"]
+		_, byLabel := sel["withlabel"]
+		if byName == byLabel {
+			return nil, fmt.Errorf("selector must have one of withName or withLabel: %v", x)
+		}
+		if pattern, ok := sel["withname"].(string); ok {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid withName pattern: %s: %v", pattern, err)
+			}
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+func applyOverrides(r *Resources, sel map[string]interface{}) {
+	for key, val := range sel {
+		switch key {
+		case "cpus", "memory", "time", "tmpfs", "batch":
+			// Values are checked when the config is read.
+			x, _ := resourceInt(key, val)
+			switch key {
+			case "cpus":
+				r.CPUs = x
+			case "tmpfs":
+				r.Tmpfs = x
+			case "batch":
+				r.Batch = x
+			case "memory":
+				r.Memory = x
+			case "time":
+				r.Time = x
+			}
+		case "container":
+			r.Container = fmt.Sprint(val)
+		case "singularity_extra_args":
+			r.SingularityExtraArgs = fmt.Sprint(val)
+		case "scratch":
+			r.Scratch, _ = val.(bool)
+		case "isolated":
+			r.Isolated, _ = val.(bool)
+		case "env":
+			r.Env = mergeEnv(r.Env, stringMap(val))
+		case "ulimits":
+			r.Ulimits = mergeEnv(r.Ulimits, stringMap(val))
+		case "secrets":
+			if xs, ok := val.([]interface{}); ok {
+				r.Secrets = stringSlice(xs)
+			}
+		case "modules":
+			if xs, ok := val.([]interface{}); ok {
+				r.Modules = stringSlice(xs)
+			}
+		case "conda":
+			r.Conda = fmt.Sprint(val)
+		case "account":
+			r.Account = fmt.Sprint(val)
+		case "queue", "partition":
+			r.Queue = fmt.Sprint(val)
+		case "qos":
+			r.QOS = fmt.Sprint(val)
+		case "scheduler_args":
+			r.SchedulerArgs = fmt.Sprint(val)
+		case "allow_failure":
+			r.AllowFailure, _ = val.(bool)
+		case "output_size":
+			r.OutputSize = fmt.Sprint(val)
+		case "pack":
+			r.Pack = fmt.Sprint(val)
+		case "checkpoint":
+			r.Checkpoint = fmt.Sprint(val)
+		}
 	}
-	return Resources{
-		CPUs:      cpus,
-		Memory:    memory,
-		Time:      time,
-		Container: container,
-	}, nil
 }
 
+// mergeEnv returns the variables of base overridden by those of env.
+func mergeEnv(base, env map[string]string) map[string]string {
+	if len(base) == 0 && len(env) == 0 {
+		return nil
+	}
+	merged := make(map[string]string)
+	for k, val := range base {
+		merged[k] = val
+	}
+	for k, val := range env {
+		merged[k] = val
+	}
+	return merged
+}
+
+func stringSlice(xs []interface{}) []string {
+	ys := []string{}
+	for _, x := range xs {
+		ys = append(ys, fmt.Sprint(x))
+	}
+	return ys
+}
+
+func stringMap(x interface{}) map[string]string {
+	m := make(map[string]string)
+	switch x := x.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			m[k] = fmt.Sprint(val)
+		}
+	case map[interface{}]interface{}:
+		for k, val := range x {
+			m[fmt.Sprint(k)] = fmt.Sprint(val)
+		}
+	case map[string]string:
+		for k, val := range x {
+			m[k] = val
+		}
+	}
+	return m
+}
+
+// InitConfig reads the config, as NewConfig does, and makes it the config
+// used by Queues without one of their own.
 func InitConfig(fn string, overrides map[string]interface{}) error {
+	c, err := NewConfig(fn, overrides)
+	if err != nil {
+		return err
+	}
+	setDefaultConfig(c)
+	return nil
+}
+
+// read reads the config into c.
+func (c *Config) read(fn string, overrides map[string]interface{}) error {
 	jobRunner := "local"
 	if _, err := exec.LookPath("qsub"); err == nil {
 		jobRunner = "pbs"
@@ -199,87 +672,279 @@ func InitConfig(fn string, overrides map[string]interface{}) error {
 		jobRunner = "slurm"
 	}
 	defaults := map[string]interface{}{
-		"flowdir":            ".flow",
-		"tmpdir":             ".flow/tmp",
-		"start_from_scratch": false,
-		"job_runner":         jobRunner,
-		"singularity_bin":    "singularity",
+		"flowdir":                ".flow",
+		"workflow":               "",
+		"tmpdir":                 "tmp",
+		"start_from_scratch":     false,
+		"dry_run":                false,
+		"force":                  false,
+		"targets":                []string{},
+		"keep_temp":              false,
+		"unprotect":              false,
+		"force_unlock":           false,
+		"clean_env":              false,
+		"keep_going":             false,
+		"stable_order":           false,
+		"longest_first":          false,
+		"keep_build":             false,
+		"archive_logs":           false,
+		"archive_dir":            "",
+		"gc_keep_runs":           0,
+		"gc_keep_days":           0,
+		"adaptive_resources":     false,
+		"adaptive_min_samples":   10,
+		"adaptive_margin":        0.2,
+		"benchmark":              "",
+		"benchmark_repeats":      3,
+		"budget":                 0,
+		"env_passthrough":        []string{"HOME", "USER", "LOGNAME", "LANG"},
+		"poll_interval":          "60s",
+		"poll_min_interval":      "5s",
+		"heartbeat_interval":     "1m",
+		"heartbeat_timeout":      "10m",
+		"heartbeat_resubmits":    2,
+		"preempt_resubmits":      5,
+		"node_fail_resubmits":    3,
+		"timeout_resubmits":      2,
+		"timeout_time_factor":    2,
+		"timeout_max_time":       0,
+		"vanished_timeout":       "10m",
+		"submit_retries":         5,
+		"submit_retry_delay":     "30s",
+		"submit_retry_patterns":  []string{"timed out", "timeout", "temporarily unavailable", "try again", "connection refused", "connection reset", "cannot connect to server", "too busy", "MaxSubmitJob"},
+		"preempt_fallback_after": 2,
+		"umask":                  "",
+		"output_mode":            "",
+		"check_disk_space":       true,
+		"prepare":                true,
+		"tmpfs_dir":              "/dev/shm",
+		"local_cgroup":           "",
+		"local_nice":             0,
+		"local_ionice":           "",
+		"pack_size":              50,
+		"checkpoint_interval":    "1h",
+		"pipes":                  false,
+		"prepare_only":           false,
+		"publish_mode":           "copy",
+		"publish_group":          "",
+		"workflow_loader":        "plugin",
+		"params_file":            "",
+		"aws_bin":                "aws",
+		"gcloud_bin":             "gcloud",
+		"azcopy_bin":             "azcopy",
+		"curl_bin":               "curl",
+		"vault_bin":              "vault",
+		"conda_bin":              "conda",
+		"conda_dir":              "",
+		"modules_init":           []string{"/etc/profile.d/lmod.sh", "/etc/profile.d/modules.sh"},
+		"ils_bin":                "ils",
+		"iget_bin":               "iget",
+		"iput_bin":               "iput",
+		"imkdir_bin":             "imkdir",
+		"imeta_bin":              "imeta",
+		"ichksum_bin":            "ichksum",
+		"job_runner":             jobRunner,
+		"singularity_bin":        "singularity",
+		"singularity_cachedir":   "",
+		"singularity_tmpdir":     "",
+		"singularity_build_args": "--fakeroot",
+		"docker_bin":             "docker",
+		"profile":                "",
 	}
-	v = viper.New()
 	for key, value := range defaults {
-		v.SetDefault(key, value)
+		c.v.SetDefault(key, value)
 	}
-	v.SetConfigName("flow")
-	v.SetConfigType("yaml")
-	v.AddConfigPath("$HOME/.config/flow")
-	v.SetEnvPrefix("flow")
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AutomaticEnv()
-	if err := v.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			// Config found but another error was produced
-			return fmt.Errorf("failed to read config file: %v", err)
+	for _, layer := range configLayers() {
+		if _, err := os.Stat(layer); os.IsNotExist(err) {
+			continue
+		}
+		if err := c.readLayer(layer); err != nil {
+			return err
 		}
 	}
 	if fn != "" {
-		localconfig := viper.New()
-		localconfig.SetConfigFile(fn)
-		localconfig.SetEnvPrefix("flow")
-		localconfig.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-		localconfig.AutomaticEnv()
-		if err := localconfig.ReadInConfig(); err != nil {
-			if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-				return fmt.Errorf("failed to read local config file: %v", err)
-			}
-		}
-		for _, key := range localconfig.AllKeys() {
-			v.Set(key, localconfig.Get(key))
+		if err := c.readLayer(fn); err != nil {
+			return err
 		}
 	}
+	c.applyEnv()
+	profile := c.GetString("profile")
+	if p, ok := overrides["profile"].(string); ok {
+		profile = p
+	}
+	if err := c.applyProfile(profile); err != nil {
+		return err
+	}
 	for key, value := range overrides {
-		v.Set(key, value)
+		c.v.Set(key, value)
+		c.origins[key] = "command line"
 	}
-	err := os.MkdirAll(v.GetString("flowdir"), 0755)
+	if err := c.expand(); err != nil {
+		return err
+	}
+	if err := c.resolveFlowdir(); err != nil {
+		return fmt.Errorf("unable to resolve flowdir: %v", err)
+	}
+	err := os.MkdirAll(c.GetString("flowdir"), 0755)
 	if err != nil {
-		return fmt.Errorf("failed to create flowdir: %s: %v", v.GetString("flowdir"), err)
+		return fmt.Errorf("failed to create flowdir: %s: %v", c.GetString("flowdir"), err)
 	}
-	err = os.MkdirAll(v.GetString("tmpdir"), 0755)
+	err = os.MkdirAll(c.GetString("tmpdir"), 0755)
 	if err != nil {
-		return fmt.Errorf("failed to create tmpdir: %s: %s", v.GetString("tmpdir"), err)
+		return fmt.Errorf("failed to create tmpdir: %s: %s", c.GetString("tmpdir"), err)
 	}
 	return nil
 }
 
+// applyProfile sets the config from the named profile in profiles, such as
+// profiles.cluster, so that the settings for each place a workflow is run
+// can be kept in one config file and chosen with --profile or FLOW_PROFILE.
+// A profile takes precedence over the rest of the config, but not over
+// command line options.
+func (c *Config) applyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	if !c.IsSet("profiles." + name) {
+		profiles := []string{}
+		for p := range c.GetStringMap("profiles") {
+			profiles = append(profiles, p)
+		}
+		sort.Strings(profiles)
+		return fmt.Errorf("profile %s is not defined in the config (profiles: %s)", name, strings.Join(profiles, ", "))
+	}
+	for key, value := range c.GetStringMap("profiles." + name) {
+		c.v.Set(key, value)
+		c.origins[key] = "profile " + name
+	}
+	return nil
+}
+
+// RunDir returns the directory for this invocation of flow,
+// flowdir/runs/<run-id>, creating it if necessary. It holds everything
+// specific to the run, such as logs, reports and the compiled workflow, so
+// that runs do not accumulate in the flowdir and can be cleaned up
+// individually. It is that of the config read by InitConfig.
+func RunDir() (string, error) {
+	return currentConfig().runDir()
+}
+
+// runDir returns the run directory of runs made with c.
+func (c *Config) runDir() (string, error) {
+	c.mu.Lock()
+	if c.runID == "" {
+		t := time.Now()
+		c.runID = fmt.Sprintf(
+			"%d-%02d-%02d_%02d%02d%02d_%s",
+			t.Year(), t.Month(), t.Day(),
+			t.Hour(), t.Minute(), t.Second(),
+			uuid.New().String()[:8],
+		)
+	}
+	runID := c.runID
+	c.mu.Unlock()
+	dir, err := filepath.Abs(filepath.Join(c.GetString("flowdir"), "runs", runID))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create run directory: %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+// currentRunID returns the ID of the run made with c, or "" if its run
+// directory has not been created.
+func (c *Config) currentRunID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.runID
+}
+
 // should this be in the flow package to make in easier for users to run workflows?
 func RunWorkflow(fn string) error {
-	if !v.IsSet("flowdir") {
-		// If flowdir is not set, config has not been initialised. Should we
-		// return an error and force the user to init the config?
-		InitConfig("", map[string]interface{}{})
+	conf := currentConfig()
+	if conf.GetString("workflow_loader") == "executable" && !isSubmission(fn) {
+		if err := runExecutable(conf, fn); err != nil {
+			return err
+		}
+		removeBuildDirs(conf)
+		return nil
 	}
-	workflowFunc, err := loadPlugin(fn)
+	workflowFunc, err := loadWorkflow(conf, fn)
 	if err != nil {
 		return fmt.Errorf("failed to load workflow: %v", err)
 	}
-	queue := &Queue{}
+	queue := NewQueue(conf)
 	workflowFunc(queue)
 	if err := queue.Run(); err != nil {
 		return err
 	}
+	removeBuildDirs(conf)
 	return nil
 }
 
 func nilWorkflowFunc(q *Queue) {}
 
-func loadPlugin(fn string) (func(*Queue), error) {
+// ExportWorkflow loads the workflow fn, as RunWorkflow would, and writes its
+// tasks to w as CWL instead of running them.
+func ExportWorkflow(fn string, w io.Writer) error {
+	conf := currentConfig()
+	tasks, err := workflowTasks(conf, fn)
+	if err != nil {
+		return err
+	}
+	queue := &Queue{tasks: tasks, config: conf}
+	return queue.ExportCWL(w)
+}
+
+// workflowTasks loads a workflow and returns its tasks without running them.
+func workflowTasks(conf *Config, fn string) ([]Commander, error) {
+	if conf.GetString("workflow_loader") == "executable" && !isSubmission(fn) {
+		return nil, fmt.Errorf("the tasks of a workflow cannot be read with the executable loader")
+	}
+	workflowFunc, err := loadWorkflow(conf, fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workflow: %v", err)
+	}
+	queue := NewQueue(conf)
+	workflowFunc(queue)
+	return queue.Tasks(), nil
+}
+
+// loadInterpreted evaluates a workflow with an interpreter instead of
+// building it as a plugin. It is only available when flow is built with the
+// yaegi build tag (see yaegi.go).
+var loadInterpreted func(fn string) (func(*Queue), error)
+
+func loadWorkflow(conf *Config, fn string) (func(*Queue), error) {
+	if isSubmission(fn) {
+		return loadSubmission(conf, fn)
+	}
+	switch loader := conf.GetString("workflow_loader"); loader {
+	case "plugin":
+		return loadPlugin(conf, fn)
+	case "interpreter":
+		if loadInterpreted == nil {
+			return nilWorkflowFunc, fmt.Errorf("this flow binary was built without interpreter support (rebuild it with -tags yaegi)")
+		}
+		return loadInterpreted(fn)
+	default:
+		return nilWorkflowFunc, fmt.Errorf("unknown workflow loader: %s", loader)
+	}
+}
+
+func loadPlugin(conf *Config, fn string) (func(*Queue), error) {
 	log.Printf("Compiling workflow\n")
-	pluginFile, err := compileWorkflow(fn)
+	pluginFile, err := compileWorkflow(conf, fn)
 	if err != nil {
 		return nilWorkflowFunc, fmt.Errorf("failed to compile workflow: %v", err)
 	}
 	p, err := plugin.Open(pluginFile)
 	if err != nil {
-		return nilWorkflowFunc, fmt.Errorf("failed to open plugin: %v", err)
+		// Most often because the workflow's module requires a different
+		// version of flow (or of a shared dependency) to this binary.
+		return nilWorkflowFunc, fmt.Errorf("failed to open plugin (it must be built with the same versions of flow and its dependencies as this binary): %v", err)
 	}
 	pWorkflow, err := p.Lookup("Workflow")
 	if err != nil {
@@ -292,32 +957,120 @@ func loadPlugin(fn string) (func(*Queue), error) {
 	return workflowFunc, nil
 }
 
-func compileWorkflow(fn string) (string, error) {
-	dir, err := ioutil.TempDir(v.GetString("flowdir"), "workflow")
+// compileWorkflow builds the workflow as a plugin.
+func compileWorkflow(conf *Config, fn string) (string, error) {
+	runDir, err := conf.runDir()
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(runDir, "workflow")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %v", err)
 	}
-	if err := copyFile(fn, fmt.Sprintf("%s/workflow.go", dir)); err != nil {
-		return "", fmt.Errorf("failed to copy workflow to temp directory: %v", err)
-	}
-	// c := exec.Command("go", "mod", "init", "github.com/jje42/workflow")
-	// c.Dir = dir
-	// if err := c.Run(); err != nil {
-	// 	return "", fmt.Errorf("failed to create go.mod: %v", err)
-	// }
-	// c = exec.Command("go", "mod", "tidy")
-	// c.Dir = dir
-	// if err := c.Run(); err != nil {
-	// 	return "", fmt.Errorf("failed to run go mod tidy: %v", err)
-	// }
-
-	cmdl := exec.Command("go", "build", "-buildmode=plugin", "workflow.go")
-	cmdl.Dir = dir
+	pluginFile := filepath.Join(dir, "workflow.so")
+	buildDir, files, err := prepareBuild(fn, dir)
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{"build", "-buildmode=plugin", "-o", pluginFile}, files...)
+	cmdl := exec.Command("go", args...)
+	cmdl.Dir = buildDir
 	out, err := cmdl.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("failed to compile workflow: %v\n%v", err, string(out))
 	}
-	return filepath.Join(dir, "workflow.so"), nil
+	return pluginFile, nil
+}
+
+// prepareBuild prepares to build the workflow fn, which may be a Go file, a
+// directory containing a package or an import path. It returns the directory
+// to run go build in and what to build there. A workflow that is part of a Go
+// module is built in place, so the module's go.mod (and vendor directory, if
+// any) determine its dependencies. Otherwise its sources are copied into dir
+// and built on their own, which only works if everything they import can be
+// resolved without a go.mod.
+func prepareBuild(fn, dir string) (string, []string, error) {
+	src, err := workflowSource(fn)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", nil, err
+	}
+	pkgDir := src
+	if !info.IsDir() {
+		pkgDir = filepath.Dir(src)
+	}
+	if root := findModuleRoot(pkgDir); root != "" {
+		log.Printf("Building workflow in module %s", root)
+		if info.IsDir() {
+			return pkgDir, []string{"."}, nil
+		}
+		return pkgDir, []string{src}, nil
+	}
+	srcs := []string{src}
+	if info.IsDir() {
+		srcs, err = packageFiles(src)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	files := []string{}
+	for _, s := range srcs {
+		name := filepath.Base(s)
+		if err := copyFile(s, filepath.Join(dir, name)); err != nil {
+			return "", nil, fmt.Errorf("failed to copy workflow to temp directory: %v", err)
+		}
+		files = append(files, name)
+	}
+	return dir, files, nil
+}
+
+// workflowSource returns the absolute path of the workflow fn, resolving an
+// import path to the directory of the package.
+func workflowSource(fn string) (string, error) {
+	if ok, _ := fileExists(fn); ok {
+		return filepath.Abs(fn)
+	}
+	out, err := exec.Command("go", "list", "-f", "{{.Dir}}", fn).Output()
+	if err != nil {
+		return "", fmt.Errorf("workflow is not a file, directory or package: %s", fn)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// packageFiles returns the Go source files, other than tests, in dir.
+func packageFiles(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, m := range matches {
+		if !strings.HasSuffix(m, "_test.go") {
+			files = append(files, m)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in workflow directory: %s", dir)
+	}
+	return files, nil
+}
+
+// findModuleRoot returns the directory containing the go.mod of the module
+// that dir belongs to, or "" if it is not in a module.
+func findModuleRoot(dir string) string {
+	for {
+		if ok, _ := fileExists(filepath.Join(dir, "go.mod")); ok {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
 }
 
 func copyFile(src, dst string) error {
@@ -336,7 +1089,7 @@ func copyFile(src, dst string) error {
 }
 
 func SafeWriteConfigAs(fn string) error {
-	return v.SafeWriteConfigAs(fn)
+	return currentConfig().v.SafeWriteConfigAs(fn)
 }
 
 // ReadFOFN reads a file of filenames and returns them as a string slice. It
@@ -372,6 +1125,105 @@ func RenderTemplate(tpl string, object interface{}) string {
 	return b.String()
 }
 
+// dedent removes the indentation common to every non-blank line of s, along
+// with leading and trailing blank lines.
+func dedent(s string) string {
+	lines := strings.Split(strings.Trim(s, "\n"), "\n")
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), " \t\n")
+}
+
+// templateFuncs are available to templates rendered by Render.
+var templateFuncs = template.FuncMap{
+	"join":       strings.Join,
+	"quote":      shellQuote,
+	"base":       filepath.Base,
+	"dir":        filepath.Dir,
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// Render renders tpl, the command of c, as a template. The exported fields
+// of c, including those of embedded structs such as Task, can be used by
+// name and its resources, including any overrides from the config, as
+// .Resources:
+//
+//	func (t Sort) Command() string {
+//		return flow.Render(t, "sort --parallel {{.Resources.CPUs}} {{.Input}} >{{.Output}}")
+//	}
+//
+// The functions join, quote (for the shell), base, dir and trimSuffix are
+// also available. Like RenderTemplate, Render panics if the template is
+// invalid. The overrides are those of the config of the run, for tasks that
+// embed Task, and otherwise those of the config read by InitConfig; use
+// Config.Render to give the config explicitly.
+func Render(c Commander, tpl string) string {
+	if b, ok := c.(interface{ conf() *Config }); ok {
+		return b.conf().Render(c, tpl)
+	}
+	return currentConfig().Render(c, tpl)
+}
+
+// Render renders tpl, the command of task, as Render does, with the
+// overrides of c.
+func (c *Config) Render(task Commander, tpl string) string {
+	data := map[string]interface{}{}
+	addFields(data, reflect.Indirect(reflect.ValueOf(task)))
+	r, err := resourcesFor(c, task)
+	if err != nil {
+		panic(err)
+	}
+	data["Resources"] = r
+	t := template.Must(template.New("command").Funcs(templateFuncs).Option("missingkey=error").Parse(tpl))
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		panic(err)
+	}
+	return b.String()
+}
+
+// addFields adds the exported fields of the struct val to data, flattening
+// embedded structs. Fields of the outer struct take precedence.
+func addFields(data map[string]interface{}, val reflect.Value) {
+	if val.Kind() != reflect.Struct {
+		return
+	}
+	embedded := []reflect.Value{}
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		if field.Anonymous {
+			embedded = append(embedded, reflect.Indirect(val.Field(i)))
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		data[field.Name] = val.Field(i).Interface()
+	}
+	for _, e := range embedded {
+		inner := map[string]interface{}{}
+		addFields(inner, e)
+		for k, x := range inner {
+			if _, ok := data[k]; !ok {
+				data[k] = x
+			}
+		}
+	}
+}
+
 type Tasks struct {
 	Commands []Commander
 	Outputs  map[string]string
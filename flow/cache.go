@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneOlderThan time.Duration
+	cacheCmd       = &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the caches in the flowdir",
+	}
+	pruneImagesCmd = &cobra.Command{
+		Use:   "prune-images",
+		Short: "Remove images from the singularity cache",
+		Args:  cobra.NoArgs,
+		Run:   pruneImages,
+	}
+)
+
+func pruneImages(cmd *cobra.Command, args []string) {
+	conf, err := flow.NewConfig(runFlags.ConfigFile, configOverrides())
+	if err != nil {
+		log.Fatal(err)
+	}
+	removed, freed, err := conf.PruneImageCache(pruneOlderThan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Removed %d files, %.1f GB\n", removed, float64(freed)/(1<<30))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanAuto     bool
+	cleanKeepRuns int
+	cleanKeepDays int
+	cleanDryRun   bool
+	cleanCmd      = &cobra.Command{
+		Use:   "clean --auto | --keep-runs N | --keep-days N",
+		Short: "Remove old run directories, and build directories, from the flowdir",
+		Args:  cobra.NoArgs,
+		Run:   clean,
+	}
+)
+
+func clean(cmd *cobra.Command, args []string) {
+	if !cleanAuto && cleanKeepRuns == 0 && cleanKeepDays == 0 {
+		log.Fatal("use --auto to apply gc_keep_runs and gc_keep_days from the config, or give --keep-runs or --keep-days")
+	}
+	overrides := configOverrides()
+	if cleanKeepRuns > 0 {
+		overrides["gc_keep_runs"] = cleanKeepRuns
+	}
+	if cleanKeepDays > 0 {
+		overrides["gc_keep_days"] = cleanKeepDays
+	}
+	conf, err := flow.NewConfig(runFlags.ConfigFile, overrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	removed, err := conf.CleanRuns(cleanDryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verb := "Removed"
+	if cleanDryRun {
+		verb = "Would remove"
+	}
+	for _, dir := range removed {
+		fmt.Printf("%s %s\n", verb, dir)
+	}
+	fmt.Printf("%s %d directories\n", verb, len(removed))
+}
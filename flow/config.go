@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	configOutput string
+	showOrigin   bool
+	configCmd    = &cobra.Command{
+		Use:   "config",
+		Short: "Create and show config files",
+	}
+	configInitCmd = &cobra.Command{
+		Use:   "init [workflow]",
+		Short: "Write a starter config, with a selector for each task of the workflow",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   configInit,
+	}
+	configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Show the settings in effect",
+		Args:  cobra.NoArgs,
+		Run:   configShow,
+	}
+	configSchemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Write a JSON Schema for config files",
+		Args:  cobra.NoArgs,
+		Run:   configSchema,
+	}
+)
+
+func configInit(cmd *cobra.Command, args []string) {
+	if err := flow.InitConfig(runFlags.ConfigFile, map[string]interface{}{}); err != nil {
+		log.Fatal(err)
+	}
+	workflow := ""
+	if len(args) > 0 {
+		workflow = args[0]
+	}
+	out := os.Stdout
+	if configOutput != "" {
+		f, err := os.OpenFile(configOutput, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			log.Fatalf("unable to create config: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := flow.WriteConfigTemplate(workflow, out); err != nil {
+		log.Fatal(err)
+	}
+	if configOutput != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", configOutput)
+	}
+}
+
+func configSchema(cmd *cobra.Command, args []string) {
+	if err := flow.WriteConfigSchema(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func configShow(cmd *cobra.Command, args []string) {
+	if err := flow.InitConfig(runFlags.ConfigFile, configOverrides()); err != nil {
+		log.Fatal(err)
+	}
+	if err := flow.ShowConfig(os.Stdout, showOrigin); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cancelOrphans bool
+	assumeYes     bool
+	doctorCmd     = &cobra.Command{
+		Use:   "doctor",
+		Short: "Find (and optionally cancel) jobs left running by a dead run",
+		Args:  cobra.NoArgs,
+		Run:   doctor,
+	}
+)
+
+func doctor(cmd *cobra.Command, args []string) {
+	conf, err := flow.NewConfig(runFlags.ConfigFile, configOverrides())
+	if err != nil {
+		log.Fatal(err)
+	}
+	orphans, err := conf.FindOrphans()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(orphans) == 0 {
+		fmt.Println("No orphaned jobs found")
+		return
+	}
+	fmt.Printf("Found %d orphaned jobs:\n", len(orphans))
+	for _, o := range orphans {
+		fmt.Printf("  %s  %s\n", o.ID, o.Output)
+	}
+	if !cancelOrphans {
+		fmt.Println("Run a workflow to reattach to them, or use --cancel to cancel them")
+		return
+	}
+	if !assumeYes {
+		fmt.Printf("Cancel these jobs? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return
+		}
+	}
+	for _, o := range orphans {
+		if err := conf.CancelOrphan(o); err != nil {
+			log.Printf("Unable to cancel job %s: %v", o.ID, err)
+			continue
+		}
+		fmt.Printf("Cancelled %s\n", o.ID)
+	}
+}
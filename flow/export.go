@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <workflow>",
+	Short: "Write a workflow as CWL",
+	Args:  cobra.ExactArgs(1),
+	Run:   exportWorkflow,
+}
+
+func exportWorkflow(cmd *cobra.Command, args []string) {
+	if err := flow.InitConfig(runFlags.ConfigFile, configOverrides()); err != nil {
+		log.Fatal(err)
+	}
+	if err := flow.ExportWorkflow(args[0], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	importOutdir string
+	importFrom   string
+	importRebase []string
+	importCmd    = &cobra.Command{
+		Use:   "import <workflow.cwl|workflow.wdl> [job.yaml|inputs.json] | --from <flowdir>",
+		Short: "Convert a workflow from another language into a flow YAML workflow, or adopt the results of another flowdir",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if importFrom != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.RangeArgs(1, 2)(cmd, args)
+		},
+		Run: importWorkflow,
+	}
+)
+
+func importWorkflow(cmd *cobra.Command, args []string) {
+	if importFrom != "" {
+		adoptFlowdir()
+		return
+	}
+	jobFile := ""
+	if len(args) > 1 {
+		jobFile = args[1]
+	}
+	var s *flow.Submission
+	var err error
+	switch ext := filepath.Ext(args[0]); ext {
+	case ".cwl":
+		s, err = flow.ImportCWL(args[0], jobFile, importOutdir)
+	case ".wdl":
+		s, err = flow.ImportWDL(args[0], jobFile, importOutdir)
+	default:
+		err = fmt.Errorf("unable to import workflows of type %s", ext)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(data)
+}
+
+// adoptFlowdir registers the jobs completed in the flowdir given with --from
+// in this one.
+func adoptFlowdir() {
+	rebase := parseRebase(importRebase)
+	conf, err := flow.NewConfig(runFlags.ConfigFile, configOverrides())
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := conf.AdoptFlowdir(importFrom, rebase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range result.Missing {
+		fmt.Printf("Missing: %s\n", m)
+	}
+	fmt.Printf("Adopted %d completed jobs, %d already done, %d missing their output\n", result.Adopted, result.Existing, len(result.Missing))
+}
+
+// parseRebase parses --rebase options, OLD=NEW, into a map of absolute
+// paths.
+func parseRebase(options []string) map[string]string {
+	rebase := make(map[string]string)
+	for _, r := range options {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Fatalf("invalid --rebase, want OLD=NEW: %s", r)
+		}
+		from, err := filepath.Abs(parts[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		to, err := filepath.Abs(parts[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		rebase[from] = to
+	}
+	return rebase
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint <workflow.go|dir>",
+	Short: "Check the tasks of a Go workflow for common mistakes",
+	Args:  cobra.ExactArgs(1),
+	Run:   lintWorkflow,
+}
+
+func lintWorkflow(cmd *cobra.Command, args []string) {
+	conf, err := flow.NewConfig(runFlags.ConfigFile, configOverrides())
+	if err != nil {
+		log.Fatal(err)
+	}
+	problems, err := conf.Lint(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
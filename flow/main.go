@@ -5,20 +5,20 @@ import (
 	"io"
 	"log"
 	"os"
-	"time"
+	"path/filepath"
 
 	"github.com/jje42/flow"
 	"github.com/spf13/cobra"
 )
 
 var (
-	version          = "undefined"
-	buildDate        = "undefined"
-	startFromScratch bool
-	jobRunner        string
-	configFile       string
-	rootCmd          = &cobra.Command{
-		Use:     "flow [flags] <workflow.go>",
+	version   = "undefined"
+	buildDate = "undefined"
+	runFlags  flow.RunFlags
+	loader    string
+	targets   []string
+	rootCmd   = &cobra.Command{
+		Use:     "flow [flags] <workflow.go|workflow.yaml|workflow.json|dir|package>",
 		Short:   fmt.Sprintf("flow (%s built on %s)", version, buildDate),
 		Long:    "",
 		Version: version,
@@ -29,46 +29,85 @@ var (
 
 func main() {
 	rootCmd.SetVersionTemplate(version + "\n")
-	rootCmd.Flags().BoolVarP(&startFromScratch, "start-from-scratch", "s", false, "Start from scratch")
-	rootCmd.Flags().StringVarP(&jobRunner, "job-runner", "j", "", "Job runner")
-	rootCmd.Flags().StringVarP(&configFile, "config", "c", "", "Config file")
+	runFlags.AddRunFlags(rootCmd.Flags())
+	rootCmd.Flags().StringSliceVarP(&targets, "target", "t", nil, "Only run the jobs needed to produce these outputs")
+	rootCmd.Flags().StringVar(&loader, "loader", "", "How to load the workflow (plugin, interpreter or executable)")
+	runFlags.AddConfigFlags(rootCmd.PersistentFlags())
+	doctorCmd.Flags().BoolVar(&cancelOrphans, "cancel", false, "Offer to cancel orphaned jobs")
+	doctorCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Cancel without asking for confirmation")
+	rootCmd.AddCommand(doctorCmd)
+	importCmd.Flags().StringVarP(&importOutdir, "outdir", "o", "imported", "Directory for the outputs of imported tasks")
+	importCmd.Flags().StringVar(&importFrom, "from", "", "Adopt the completed jobs of this flowdir")
+	importCmd.Flags().StringSliceVar(&importRebase, "rebase", nil, "Map the outputs of adopted jobs from one directory to another, as OLD=NEW")
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(prepareCmd)
+	rootCmd.AddCommand(statsCmd)
+	cleanCmd.Flags().BoolVar(&cleanAuto, "auto", false, "Keep the runs given by gc_keep_runs and gc_keep_days in the config")
+	cleanCmd.Flags().IntVar(&cleanKeepRuns, "keep-runs", 0, "Keep this many of the newest runs")
+	cleanCmd.Flags().IntVar(&cleanKeepDays, "keep-days", 0, "Keep the runs from this many days")
+	cleanCmd.Flags().BoolVarP(&cleanDryRun, "dry-run", "n", false, "Show what would be removed without removing it")
+	rootCmd.AddCommand(cleanCmd)
+	stateImportCmd.Flags().StringSliceVar(&stateRebase, "rebase", nil, "Map the outputs of imported jobs from one directory to another, as OLD=NEW")
+	stateCmd.AddCommand(stateExportCmd, stateImportCmd)
+	rootCmd.AddCommand(stateCmd)
+	configInitCmd.Flags().StringVarP(&configOutput, "output", "o", "", "Write the config to this file, which must not exist")
+	configShowCmd.Flags().BoolVar(&showOrigin, "origin", false, "Show where each setting came from")
+	configCmd.AddCommand(configInitCmd, configShowCmd, configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+	pruneImagesCmd.Flags().DurationVar(&pruneOlderThan, "older-than", 0, "Only remove images cached longer ago than this, e.g., 720h (default all)")
+	cacheCmd.AddCommand(pruneImagesCmd)
+	rootCmd.AddCommand(cacheCmd)
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
 func myMain(cmd *cobra.Command, args []string) {
-	overrides := make(map[string]interface{})
-	if startFromScratch {
-		overrides["start_from_scratch"] = true
+	overrides, err := runFlags.Overrides()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(targets) > 0 {
+		overrides["targets"] = targets
 	}
-	if jobRunner != "" {
-		overrides["job_runner"] = jobRunner
+	if loader != "" {
+		overrides["workflow_loader"] = loader
+	}
+	overrides["workflow"] = args[0]
+	if err := flow.InitConfig(runFlags.ConfigFile, overrides); err != nil {
+		log.Fatal(err)
+	}
+	runDir, err := flow.RunDir()
+	if err != nil {
+		log.Fatal(err)
 	}
-	flow.InitConfig(configFile, overrides)
-	timestamp := makeTimestamp()
 
 	// Log file ----------
-	logFile := fmt.Sprintf("flow_%s.log", timestamp)
+	logFile := filepath.Join(runDir, "flow.log")
 	logw, err := os.Create(logFile)
 	if err != nil {
 		log.Fatalf("Unable to create log file: %s: %v", logFile, err)
 	}
 	defer logw.Close()
 	log.SetOutput(io.MultiWriter(os.Stderr, logw))
+	log.Printf("Run directory: %s", runDir)
 
 	// Config file ----------
-	flow.SafeWriteConfigAs(fmt.Sprintf("flow_config_%s.yaml", timestamp))
+	flow.SafeWriteConfigAs(filepath.Join(runDir, "config.yaml"))
 
 	if err := flow.RunWorkflow(args[0]); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func makeTimestamp() string {
-	t := time.Now()
-	return fmt.Sprintf(
-		"%d-%02d-%02d_%02d%02d%02d",
-		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
-	)
+// configOverrides returns the overrides of the flags that choose the config,
+// for the commands that do not run a workflow.
+func configOverrides() map[string]interface{} {
+	overrides, err := runFlags.ConfigOverrides()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return overrides
 }
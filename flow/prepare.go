@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var prepareCmd = &cobra.Command{
+	Use:   "prepare <workflow>",
+	Short: "Pull the containers and stage the remote inputs of a workflow without running it",
+	Args:  cobra.ExactArgs(1),
+	Run:   prepareWorkflow,
+}
+
+func prepareWorkflow(cmd *cobra.Command, args []string) {
+	runFlags.PrepareOnly = true
+	myMain(cmd, args)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stateRebase []string
+	stateCmd    = &cobra.Command{
+		Use:   "state",
+		Short: "Move the state of the flowdir to another system",
+	}
+	stateExportCmd = &cobra.Command{
+		Use:   "export <run.tar|run.tar.gz>",
+		Short: "Write what has been done, but not the data, to a tar file",
+		Args:  cobra.ExactArgs(1),
+		Run:   stateExport,
+	}
+	stateImportCmd = &cobra.Command{
+		Use:   "import <run.tar|run.tar.gz>",
+		Short: "Read what has been done from a tar file written by flow state export",
+		Args:  cobra.ExactArgs(1),
+		Run:   stateImport,
+	}
+)
+
+func stateConfig() *flow.Config {
+	conf, err := flow.NewConfig(runFlags.ConfigFile, configOverrides())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conf
+}
+
+func stateExport(cmd *cobra.Command, args []string) {
+	if err := stateConfig().ExportState(args[0]); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %s\n", args[0])
+}
+
+func stateImport(cmd *cobra.Command, args []string) {
+	rebase := parseRebase(stateRebase)
+	result, err := stateConfig().ImportState(args[0], rebase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range result.Missing {
+		fmt.Printf("Not found yet: %s\n", m)
+	}
+	fmt.Printf("Imported %d completed jobs and %d runs, %d outputs not found yet\n", result.Done, result.Runs, len(result.Missing))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jje42/flow"
+	"github.com/spf13/cobra"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show how long each analysis took and the resources it used in previous runs",
+	Args:  cobra.NoArgs,
+	Run:   stats,
+}
+
+func stats(cmd *cobra.Command, args []string) {
+	conf, err := flow.NewConfig(runFlags.ConfigFile, configOverrides())
+	if err != nil {
+		log.Fatal(err)
+	}
+	analyses, err := conf.UsageStats()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(analyses) == 0 {
+		fmt.Println("No usage has been recorded")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ANALYSIS\tRUNS\tMEAN TIME\tMAX TIME\tMAX MEMORY\tSUGGESTED MEMORY\tSUGGESTED TIME")
+	for _, s := range analyses {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Runs,
+			s.MeanTime.Round(time.Second), s.MaxTime.Round(time.Second),
+			unknownIfZero(s.MaxMemory, "GB"), unknownIfZero(s.SuggestedMemory, "GB"),
+			unknownIfZero(s.SuggestedTime, "h"))
+	}
+	w.Flush()
+}
+
+func unknownIfZero(x int, unit string) string {
+	if x == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%s", x, unit)
+}
@@ -0,0 +1,165 @@
+package flow
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config is a complete set of settings, read from the config files, the
+// environment and overrides. Each Queue may be given its own, with NewQueue,
+// so that a program can run workflows with different settings, at the same
+// time if they use different flowdirs. Those without one use the config read
+// by InitConfig.
+type Config struct {
+	v       *viper.Viper
+	origins map[string]string
+
+	// mu guards the state of the runs made with the config: the ID of the
+	// run, for RunDir, the lock on the flowdir and the values of the secrets
+	// read from the sources it defines.
+	mu       sync.Mutex
+	runID    string
+	lockFile string
+	secrets  map[string]string
+}
+
+func newConfig() *Config {
+	return &Config{v: viper.New(), origins: map[string]string{}, secrets: map[string]string{}}
+}
+
+// defaultConfig is the config read by InitConfig. configMu guards it being
+// replaced.
+var (
+	configMu      sync.Mutex
+	defaultConfig = newConfig()
+)
+
+// NewConfig reads the config from its layers: the defaults,
+// /etc/flow/flow.yaml, ~/.config/flow/flow.yaml, flow.yaml, fn if it is not
+// empty, FLOW_<KEY> variables, the profile and overrides, each taking
+// precedence over those before. It does not change the config used by
+// Queues without one of their own.
+func NewConfig(fn string, overrides map[string]interface{}) (*Config, error) {
+	c := newConfig()
+	if err := c.read(fn, overrides); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setDefaultConfig makes c the config used by Queues without one of their
+// own.
+func setDefaultConfig(c *Config) {
+	configMu.Lock()
+	defaultConfig = c
+	configMu.Unlock()
+}
+
+// currentConfig returns the config read by InitConfig, or the defaults if it
+// has not been called.
+func currentConfig() *Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if !defaultConfig.IsSet("flowdir") {
+		if c, err := NewConfig("", map[string]interface{}{}); err == nil {
+			defaultConfig = c
+		}
+	}
+	return defaultConfig
+}
+
+// Get returns the setting key.
+func (c *Config) Get(key string) interface{} {
+	return c.v.Get(key)
+}
+
+// Set sets the setting key, taking precedence over everything else. It must
+// not be called while the config is in use by a run.
+func (c *Config) Set(key string, value interface{}) {
+	c.v.Set(key, value)
+	c.origins[key] = "set"
+}
+
+// IsSet reports whether the setting key has a value, including a default.
+func (c *Config) IsSet(key string) bool {
+	return c.v.IsSet(key)
+}
+
+// GetString returns the setting key as a string.
+func (c *Config) GetString(key string) string {
+	return c.v.GetString(key)
+}
+
+// GetBool returns the setting key as a bool.
+func (c *Config) GetBool(key string) bool {
+	return c.v.GetBool(key)
+}
+
+// GetInt returns the setting key as an int.
+func (c *Config) GetInt(key string) int {
+	return c.v.GetInt(key)
+}
+
+// GetFloat64 returns the setting key as a float64.
+func (c *Config) GetFloat64(key string) float64 {
+	return c.v.GetFloat64(key)
+}
+
+// GetDuration returns the setting key as a time.Duration.
+func (c *Config) GetDuration(key string) time.Duration {
+	return c.v.GetDuration(key)
+}
+
+// GetStringSlice returns the setting key as a slice of strings.
+func (c *Config) GetStringSlice(key string) []string {
+	return c.v.GetStringSlice(key)
+}
+
+// GetStringMap returns the setting key as a map.
+func (c *Config) GetStringMap(key string) map[string]interface{} {
+	return c.v.GetStringMap(key)
+}
+
+// ResourcesFor returns the resources task is run with: its own with the
+// overrides of any selectors in c that match it.
+func (c *Config) ResourcesFor(task Commander) (Resources, error) {
+	return resourcesFor(c, task)
+}
+
+// boundConfig is embedded in the built-in runners and storages, and in Task,
+// which take their settings from the config of the run using them, or the
+// config read by InitConfig if none is set.
+type boundConfig struct {
+	config *Config
+}
+
+func (b boundConfig) conf() *Config {
+	if b.config != nil {
+		return b.config
+	}
+	return currentConfig()
+}
+
+// bind sets the config of the run.
+func (b *boundConfig) bind(conf *Config) {
+	b.config = conf
+}
+
+// queueConfig returns the config of q or, if it has none, the one read by
+// InitConfig.
+func (q *Queue) queueConfig() *Config {
+	if q.config != nil {
+		return q.config
+	}
+	return currentConfig()
+}
+
+// ResourcesFor returns the resources task is run with, using the config
+// read by InitConfig, or the defaults if it has not been called.
+//
+// Deprecated: use Config.ResourcesFor.
+func ResourcesFor(task Commander) (Resources, error) {
+	return currentConfig().ResourcesFor(task)
+}
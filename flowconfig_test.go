@@ -0,0 +1,71 @@
+package flow
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_NewConfig(t *testing.T) {
+	dir := t.TempDir()
+	before := defaultConfig
+	c, err := NewConfig("", map[string]interface{}{
+		"flowdir":    filepath.Join(dir, "flow"),
+		"tmpdir":     filepath.Join(dir, "tmp"),
+		"job_runner": "dummy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultConfig != before {
+		t.Errorf("NewConfig() changed the default config")
+	}
+	if got := c.Get("job_runner"); got != "dummy" {
+		t.Errorf("job_runner = %v, want dummy", got)
+	}
+	c.Set("selectors", []interface{}{
+		map[string]interface{}{"withName": "align", "cpus": 8},
+	})
+	r, err := c.ResourcesFor(&fileTask{Task: Task{Name: "align", CPUs: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CPUs != 8 {
+		t.Errorf("ResourcesFor() CPUs = %d, want 8", r.CPUs)
+	}
+	if defaultConfig != before {
+		t.Errorf("ResourcesFor() changed the default config")
+	}
+}
+
+func Test_Queue_Run_concurrent(t *testing.T) {
+	dir := t.TempDir()
+	errs := make(chan error, 2)
+	for _, name := range []string{"a", "b"} {
+		c, err := NewConfig("", map[string]interface{}{
+			"flowdir":    filepath.Join(dir, name, ".flow"),
+			"tmpdir":     filepath.Join(dir, name, "tmp"),
+			"job_runner": "local",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := NewQueue(c)
+		q.Add(&packTask{Task: Task{Name: name, CPUs: 1, Memory: 1, Time: 1}, Cmd: "true", Out: filepath.Join(dir, name, "out.txt")})
+		go func() { errs <- q.Run() }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a", "b"} {
+		out := filepath.Join(dir, name, "out.txt")
+		if ok, _ := fileExists(filepath.Join(dir, name, ".flow", "done", out+".done")); !ok {
+			t.Errorf("%s was not recorded as done in its own flowdir", out)
+		}
+		runs, _ := filepath.Glob(filepath.Join(dir, name, ".flow", "runs", "*"))
+		if len(runs) != 1 {
+			t.Errorf("%d runs in the flowdir of %s, want 1", len(runs), name)
+		}
+	}
+}
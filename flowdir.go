@@ -0,0 +1,59 @@
+package flow
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// resolveFlowdir makes flowdir and tmpdir absolute. A relative flowdir is
+// relative to the directory of the workflow, so that running it from
+// elsewhere does not create another flowdir, or to the current directory
+// without one. A relative tmpdir is relative to the flowdir.
+func (c *Config) resolveFlowdir() error {
+	dir := c.GetString("flowdir")
+	if !filepath.IsAbs(dir) {
+		abs, err := c.flowdirFor(dir)
+		if err != nil {
+			return err
+		}
+		c.v.Set("flowdir", abs)
+	}
+	if tmp := c.GetString("tmpdir"); !filepath.IsAbs(tmp) {
+		c.v.Set("tmpdir", filepath.Join(c.GetString("flowdir"), tmp))
+	}
+	return nil
+}
+
+// flowdirFor returns the absolute path of the relative flowdir dir.
+func (c *Config) flowdirFor(dir string) (string, error) {
+	base := c.workflowDir()
+	abs, err := filepath.Abs(filepath.Join(base, dir))
+	if err != nil {
+		return "", err
+	}
+	if base != "" {
+		cwd, _ := filepath.Abs(dir)
+		if info, err := os.Stat(cwd); err == nil && info.IsDir() && cwd != abs {
+			log.Printf("WARNING: not using the flowdir %s in the current directory, the flowdir of %s is %s (use --flowdir to choose)", cwd, c.GetString("workflow"), abs)
+		}
+	}
+	return abs, nil
+}
+
+// workflowDir returns the directory of the workflow being run, or "" if
+// there is none or it is not a local file or directory, such as a package.
+func (c *Config) workflowDir() string {
+	fn := c.GetString("workflow")
+	if fn == "" {
+		return ""
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		return fn
+	}
+	return filepath.Dir(fn)
+}
@@ -0,0 +1,51 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_resolveFlowdir(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defer defaultConfig.Set("workflow", defaultConfig.Get("workflow"))
+	defer defaultConfig.Set("tmpdir", defaultConfig.Get("tmpdir"))
+	dir := t.TempDir()
+	workflow := filepath.Join(dir, "workflow.yaml")
+	if err := ioutil.WriteFile(workflow, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	tests := []struct {
+		name     string
+		workflow string
+		flowdir  string
+		tmpdir   string
+		want     string
+		wantTmp  string
+	}{
+		{"next to the workflow", workflow, ".flow", "tmp", filepath.Join(dir, ".flow"), filepath.Join(dir, ".flow", "tmp")},
+		{"in a workflow directory", dir, "state", "tmp", filepath.Join(dir, "state"), filepath.Join(dir, "state", "tmp")},
+		{"absolute", workflow, "/data/flow", "tmp", "/data/flow", "/data/flow/tmp"},
+		{"absolute tmpdir", workflow, ".flow", "/scratch/tmp", filepath.Join(dir, ".flow"), "/scratch/tmp"},
+		{"package", "github.com/x/y", ".flow", "tmp", filepath.Join(cwd, ".flow"), filepath.Join(cwd, ".flow", "tmp")},
+		{"no workflow", "", ".flow", "tmp", filepath.Join(cwd, ".flow"), filepath.Join(cwd, ".flow", "tmp")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("workflow", tt.workflow)
+			defaultConfig.Set("flowdir", tt.flowdir)
+			defaultConfig.Set("tmpdir", tt.tmpdir)
+			if err := defaultConfig.resolveFlowdir(); err != nil {
+				t.Fatal(err)
+			}
+			if got := defaultConfig.GetString("flowdir"); got != tt.want {
+				t.Errorf("flowdir = %s, want %s", got, tt.want)
+			}
+			if got := defaultConfig.GetString("tmpdir"); got != tt.wantTmp {
+				t.Errorf("tmpdir = %s, want %s", got, tt.wantTmp)
+			}
+		})
+	}
+}
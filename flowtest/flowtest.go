@@ -0,0 +1,166 @@
+// Package flowtest helps test flow workflows. Render writes a workflow in a
+// canonical text form, and Golden compares it with a file kept with the
+// tests, so that a change to a workflow shows up as a diff to review.
+package flowtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jje42/flow"
+)
+
+// UpdateEnv is the environment variable that makes Golden write the golden
+// files instead of comparing with them, e.g., FLOWTEST_UPDATE=1 go test.
+const UpdateEnv = "FLOWTEST_UPDATE"
+
+// Render returns the tasks of q in a canonical text form: sorted by name and
+// outputs, with the commands they would run, the tasks they depend on and
+// their resources. Paths under the working directory are written relative to
+// it, so the result does not depend on where it is made. Tasks must have a
+// name, those without one get a random name.
+func Render(q *flow.Queue) (string, error) {
+	run, err := q.MockRun()
+	if err != nil {
+		return "", err
+	}
+	jobs := append(run.Jobs, run.Skipped...)
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Name != jobs[j].Name {
+			return jobs[i].Name < jobs[j].Name
+		}
+		return strings.Join(jobs[i].Outputs, "\n") < strings.Join(jobs[j].Outputs, "\n")
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	relative := strings.NewReplacer(wd+string(filepath.Separator), "")
+	var b strings.Builder
+	for i, j := range jobs {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "task %s\n", j.Name)
+		writeList(&b, "inputs", relative, j.Inputs)
+		writeList(&b, "outputs", relative, j.Outputs)
+		writeList(&b, "depends on", relative, dependencies(j, jobs))
+		writeList(&b, "resources", relative, resources(j.Resources))
+		writeList(&b, "command", relative, strings.Split(strings.TrimRight(j.Command, "\n"), "\n"))
+	}
+	return b.String(), nil
+}
+
+// dependencies returns the jobs j depends on, with the outputs that j uses,
+// which tells apart jobs with the same name.
+func dependencies(j flow.MockJob, jobs []flow.MockJob) []string {
+	producers := map[string]string{}
+	for _, other := range jobs {
+		for _, o := range other.Outputs {
+			producers[o] = other.Name
+		}
+	}
+	deps := []string{}
+	for _, in := range j.Inputs {
+		if name, ok := producers[in]; ok {
+			deps = append(deps, fmt.Sprintf("%s (%s)", name, in))
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func writeList(b *strings.Builder, title string, r *strings.Replacer, xs []string) {
+	if len(xs) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "  %s:\n", title)
+	for _, x := range xs {
+		fmt.Fprintf(b, "    %s\n", r.Replace(x))
+	}
+}
+
+// resources returns the resources that are set, one "name: value" each.
+func resources(r flow.Resources) []string {
+	xs := []string{}
+	val := reflect.ValueOf(r)
+	for i := 0; i < val.NumField(); i++ {
+		f := val.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		value := fmt.Sprint(f.Interface())
+		if f.Kind() == reflect.Map {
+			keys := []string{}
+			for _, k := range f.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			pairs := []string{}
+			for _, k := range keys {
+				pairs = append(pairs, fmt.Sprintf("%s=%v", k, f.MapIndex(reflect.ValueOf(k))))
+			}
+			value = strings.Join(pairs, " ")
+		}
+		xs = append(xs, fmt.Sprintf("%s: %s", val.Type().Field(i).Name, value))
+	}
+	return xs
+}
+
+// Golden renders q and compares it with the golden file fn, failing the test
+// if they differ. When the UpdateEnv environment variable is set the golden
+// file is written instead.
+func Golden(t testing.TB, q *flow.Queue, fn string) {
+	t.Helper()
+	got, err := Render(q)
+	if err != nil {
+		t.Fatalf("unable to render workflow: %v", err)
+	}
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("unable to read golden file (set %s=1 to create it): %v", UpdateEnv, err)
+	}
+	if !bytes.Equal(want, []byte(got)) {
+		t.Errorf("workflow differs from %s (set %s=1 to update it):\n%s", fn, UpdateEnv, diff(string(want), got))
+	}
+}
+
+// diff returns the lines of want and got that differ, prefixed with - and +,
+// from the first line that differs.
+func diff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	var s strings.Builder
+	fmt.Fprintf(&s, "@@ line %d @@\n", start+1)
+	for _, line := range a[start:endA] {
+		fmt.Fprintf(&s, "-%s\n", line)
+	}
+	for _, line := range b[start:endB] {
+		fmt.Fprintf(&s, "+%s\n", line)
+	}
+	return s.String()
+}
@@ -0,0 +1,65 @@
+package flowtest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jje42/flow"
+)
+
+type count struct {
+	flow.Task
+	Input  string `type:"input"`
+	Output string `type:"output"`
+}
+
+func (c count) Command() string {
+	return fmt.Sprintf("wc -l %s >%s", c.Input, c.Output)
+}
+
+type merge struct {
+	flow.Task
+	Inputs []string `type:"input"`
+	Output string   `type:"output"`
+}
+
+func (c merge) Command() string {
+	return fmt.Sprintf("cat %s |\n  sort >%s", strings.Join(c.Inputs, " "), c.Output)
+}
+
+func workflow(q *flow.Queue) {
+	res := flow.Task{CPUs: 1, Memory: 2, Time: 1, Container: "docker://debian:10"}
+	merged := &merge{Task: res, Output: "counts.txt"}
+	merged.Name = "merge"
+	for _, sample := range []string{"b", "a"} {
+		c := &count{Task: res, Input: "/data/" + sample + ".txt", Output: sample + ".count"}
+		c.Name = "count"
+		merged.Inputs = append(merged.Inputs, c.Output)
+		q.Add(c)
+	}
+	q.Add(merged)
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	err := flow.InitConfig("", map[string]interface{}{
+		"flowdir": filepath.Join(dir, ".flow"),
+		"tmpdir":  filepath.Join(dir, ".flow", "tmp"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &flow.Queue{}
+	workflow(q)
+	Golden(t, q, filepath.Join("testdata", "workflow.golden"))
+}
+
+func Test_diff(t *testing.T) {
+	got := diff("a\nb\nc\n", "a\nx\nc\n")
+	want := "@@ line 2 @@\n-b\n+x\n"
+	if got != want {
+		t.Errorf("diff() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// removeBuildDirs removes the directories the workflow was compiled in in
+// the run directory, unless keep_build is set.
+func removeBuildDirs(conf *Config) {
+	if conf.GetBool("keep_build") || conf.currentRunID() == "" {
+		return
+	}
+	runDir, err := conf.runDir()
+	if err != nil {
+		return
+	}
+	dirs, _ := filepath.Glob(filepath.Join(runDir, "workflow*"))
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Unable to remove build directory: %v", err)
+		}
+	}
+}
+
+// CleanRuns removes the run directories in the flowdir of c that are neither
+// among the newest gc_keep_runs nor from the last gc_keep_days days, and the
+// build directories left in those it keeps. A limit of 0 keeps nothing by
+// itself, but at least one must be set. It returns the directories removed,
+// or that would be removed if dryRun is set. It takes the lock on the
+// flowdir, so it fails if a run is in progress.
+func (c *Config) CleanRuns(dryRun bool) ([]string, error) {
+	keepRuns, keepDays := c.GetInt("gc_keep_runs"), c.GetInt("gc_keep_days")
+	if keepRuns <= 0 && keepDays <= 0 {
+		return nil, fmt.Errorf("no runs to keep given, set gc_keep_runs or gc_keep_days")
+	}
+	if err := lockFlowdir(c); err != nil {
+		return nil, err
+	}
+	defer unlockFlowdir(c)
+	runsDir := filepath.Join(c.GetString("flowdir"), "runs")
+	infos, err := ioutil.ReadDir(runsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read runs: %v", err)
+	}
+	runs := []os.FileInfo{}
+	for _, info := range infos {
+		if info.IsDir() {
+			runs = append(runs, info)
+		}
+	}
+	// Newest first.
+	sort.Slice(runs, func(a, b int) bool { return runStarted(runs[a]).After(runStarted(runs[b])) })
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	removed := []string{}
+	for i, run := range runs {
+		dir := filepath.Join(runsDir, run.Name())
+		keep := (keepRuns > 0 && i < keepRuns) || (keepDays > 0 && runStarted(run).After(cutoff))
+		if keep {
+			builds, _ := filepath.Glob(filepath.Join(dir, "workflow*"))
+			for _, b := range builds {
+				if info, err := os.Stat(b); err == nil && info.IsDir() {
+					removed = append(removed, b)
+				}
+			}
+			continue
+		}
+		removed = append(removed, dir)
+	}
+	// Older versions compiled workflows in the flowdir itself.
+	builds, _ := filepath.Glob(filepath.Join(c.GetString("flowdir"), "workflow*"))
+	for _, b := range builds {
+		if info, err := os.Stat(b); err == nil && info.IsDir() {
+			removed = append(removed, b)
+		}
+	}
+	if dryRun {
+		return removed, nil
+	}
+	for _, dir := range removed {
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, fmt.Errorf("unable to remove %s: %v", dir, err)
+		}
+	}
+	return removed, nil
+}
+
+// runStarted returns when the run with the directory info started, from
+// its name, or when the directory was last modified if it has not been
+// named by RunDir.
+func runStarted(info os.FileInfo) time.Time {
+	if len(info.Name()) >= 17 {
+		if t, err := time.ParseInLocation("2006-01-02_150405", info.Name()[:17], time.Local); err == nil {
+			return t
+		}
+	}
+	return info.ModTime()
+}
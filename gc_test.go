@@ -0,0 +1,80 @@
+package flow
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_CleanRuns(t *testing.T) {
+	for _, key := range []string{"flowdir", "gc_keep_runs", "gc_keep_days"} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+	}
+	dir := t.TempDir()
+	defaultConfig.Set("flowdir", dir)
+	day := func(n int) string { return time.Now().AddDate(0, 0, -n).Format("2006-01-02_150405") + "_abcd1234" }
+	for _, d := range []string{
+		filepath.Join("runs", day(0), "workflow123"),
+		filepath.Join("runs", day(3)),
+		filepath.Join("runs", day(10)),
+		filepath.Join("runs", day(20)),
+		"workflow456",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		keepRuns int
+		keepDays int
+		want     []string
+	}{
+		{"newest runs", 2, 0, []string{"runs/" + day(0) + "/workflow123", "runs/" + day(10), "runs/" + day(20), "workflow456"}},
+		{"recent days", 0, 7, []string{"runs/" + day(0) + "/workflow123", "runs/" + day(10), "runs/" + day(20), "workflow456"}},
+		{"either", 3, 7, []string{"runs/" + day(0) + "/workflow123", "runs/" + day(20), "workflow456"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("gc_keep_runs", tt.keepRuns)
+			defaultConfig.Set("gc_keep_days", tt.keepDays)
+			removed, err := defaultConfig.CleanRuns(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range removed {
+				rel, _ := filepath.Rel(dir, r)
+				got = append(got, rel)
+			}
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if len(got) != len(tt.want) {
+				t.Fatalf("CleanRuns() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("CleanRuns() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+	defaultConfig.Set("gc_keep_runs", 1)
+	defaultConfig.Set("gc_keep_days", 0)
+	if _, err := defaultConfig.CleanRuns(false); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "runs", "*"))
+	if len(entries) != 1 {
+		t.Errorf("CleanRuns() left %v, want only the newest run", entries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "runs", day(0), "workflow123")); !os.IsNotExist(err) {
+		t.Errorf("CleanRuns() kept the build directory of the newest run")
+	}
+	defaultConfig.Set("gc_keep_runs", 0)
+	if _, err := defaultConfig.CleanRuns(false); err == nil {
+		t.Errorf("CleanRuns() without a policy should fail")
+	}
+}
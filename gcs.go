@@ -0,0 +1,64 @@
+package flow
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GCSStorage stages gs://bucket/object URIs using gcloud storage (see
+// gcloud_bin), which performs resumable, parallel (sliced) transfers by
+// default.
+type GCSStorage struct {
+	boundConfig
+}
+
+func (s GCSStorage) Stat(uri string) (bool, error) {
+	return statCommand(gcloudCommand(s.conf(), "storage", "ls", uri))
+}
+
+func (s GCSStorage) Fetch(uri, dst string) error {
+	return runCommand(gcloudCommand(s.conf(), "storage", "cp", uri, dst))
+}
+
+func (s GCSStorage) Store(src, uri string) error {
+	return runCommand(gcloudCommand(s.conf(), "storage", "cp", src, uri))
+}
+
+// Checksum returns the MD5 of the object. Composite objects do not have one.
+func (s GCSStorage) Checksum(uri string) (string, error) {
+	out, err := gcloudCommand(s.conf(), "storage", "objects", "describe", uri, "--format=value(md5_hash)").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to describe %s: %v", uri, err)
+	}
+	encoded := strings.TrimSpace(string(out))
+	if encoded == "" {
+		return "", nil
+	}
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("unexpected md5 hash for %s: %s", uri, encoded)
+	}
+	return "md5:" + hex.EncodeToString(digest), nil
+}
+
+// gcloudCommand returns a gcloud command. gcloud normally uses its own
+// credentials, so if application default credentials are configured through
+// GOOGLE_APPLICATION_CREDENTIALS they are passed on explicitly.
+func gcloudCommand(conf *Config, args ...string) *exec.Cmd {
+	cmd := exec.Command(conf.GetString("gcloud_bin"), args...)
+	if creds := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); creds != "" {
+		cmd.Env = append(os.Environ(), "CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE="+creds)
+	}
+	return cmd
+}
+
+func (s GCSStorage) withConfig(conf *Config) Storage {
+	s.config = conf
+	return s
+}
+
+var _ Storage = GCSStorage{}
@@ -1,14 +1,20 @@
 package flow
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -30,6 +36,78 @@ type job struct {
 	hasCompleted          bool
 	completedSuccessfully bool
 	BatchCommand          string
+	// optional holds the paths of inputs and outputs declared with the
+	// optional modifier.
+	optional map[string]bool
+	// temp holds the outputs declared with the temp modifier. They are
+	// removed once every job that consumes them has completed successfully.
+	temp        []string
+	tempRemoved bool
+	// protected holds the outputs declared with the protected modifier.
+	// They are made read-only once created and a job whose protected
+	// outputs already exist is never rerun unless unprotect is set.
+	protected []string
+	// publish lists the outputs to place in a results directory once the
+	// job has completed successfully.
+	publish []publishSpec
+	// resources are those of the command with any overrides from the
+	// config applied.
+	resources Resources
+	workDir   string
+	// idFile records the scheduler job ID while the job is running so that
+	// a later run can reattach to it if this process dies.
+	idFile string
+	// failure is why the job failed.
+	failure string
+	// submitted is when the job was last submitted and cost the estimated
+	// cost of running it, once it has finished.
+	submitted time.Time
+	cost      float64
+	// lostCount is the number of times the job has been resubmitted after
+	// its heartbeat stopped, preemptions the number of times it has been
+	// preempted and nodeFailures the number of times its node failed.
+	lostCount    int
+	preemptions  int
+	nodeFailures int
+	// timeouts is the number of times the job has been killed for running
+	// longer than its time.
+	timeouts int
+	// submitAttempts is the number of times submitting the job has failed
+	// in a row and nextSubmit when it may be tried again.
+	submitAttempts int
+	nextSubmit     time.Time
+	// The scheduler is polled for the state of the job at nextPoll.
+	pollInterval time.Duration
+	nextPoll     time.Time
+	// pipeTo is the job that streams the pipe outputs of this job, and
+	// pipeFrom the jobs whose pipe outputs this job streams, when pipes is
+	// set. Jobs that stream to another are run in its job.
+	pipeTo   *job
+	pipeFrom []*job
+	// packed are the jobs run after this one in its job, and packedIn the
+	// job this one is run in, for tasks with a pack.
+	packed   []*job
+	packedIn *job
+	// expected is how long jobs of its analysis took in previous runs, or
+	// 0 if unknown.
+	expected time.Duration
+}
+
+// backoff schedules the next poll of the job. Jobs are polled frequently
+// just after they are submitted, as short jobs finish quickly, and the
+// interval doubles with each poll up to poll_interval so long running jobs
+// are polled infrequently. Some jitter is added so thousands of jobs
+// submitted together are not all polled at once.
+func (j *job) backoff(conf *Config) {
+	j.pollInterval *= 2
+	if min := conf.GetDuration("poll_min_interval"); j.pollInterval < min {
+		j.pollInterval = min
+	}
+	if max := conf.GetDuration("poll_interval"); j.pollInterval > max {
+		j.pollInterval = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(j.pollInterval)/5+1)) - j.pollInterval/10
+	j.nextPoll = time.Now().Add(j.pollInterval + jitter)
 }
 
 // Command takes the original command line and allows adding pre- or post-
@@ -38,8 +116,8 @@ func (j job) Command() string {
 	return fmt.Sprintf(`set -o errexit
 set -o pipefail
 set -o verbose
-env | sort
-%s`, j.Cmd.Command())
+%s
+%s`, listEnv(j.resources.Secrets), j.Cmd.Command())
 }
 
 func (j job) isRunnable() bool {
@@ -47,6 +125,14 @@ func (j job) isRunnable() bool {
 		return false
 	}
 	for _, d := range j.Dependencies {
+		// Jobs streamed to this one run with it, once their own
+		// dependencies have completed.
+		if _, err := jobIndex(d, j.pipeFrom); err == nil {
+			if !d.isRunnable() {
+				return false
+			}
+			continue
+		}
 		if !d.hasCompleted {
 			return false
 		}
@@ -64,39 +150,113 @@ type graph struct {
 	running   []*job
 	completed []*job
 	failed    []*job
+	// allowedFailed are the failed jobs that were allowed to fail. They do
+	// not fail the workflow, only the jobs that depend on them are not run.
+	allowedFailed []*job
+	// spent is the estimated cost of the jobs that have finished, and
+	// overBudget is set once a job has been held back by the budget.
+	spent      float64
+	overBudget bool
+	// config is the config of the run.
+	config *Config
 }
 
-func newGraph(cmds []Commander) (graph, error) {
-	g := graph{}
+func newGraph(conf *Config, cmds []Commander) (graph, error) {
+	g := graph{config: conf}
+	history := map[string][]usageSample{}
+	if conf.GetBool("adaptive_resources") {
+		var err error
+		if history, err = usageHistory(conf); err != nil {
+			return g, err
+		}
+	}
 	for _, cmd := range cmds {
 		job := &job{
-			Cmd:     cmd,
-			UUID:    uuid.New(),
-			Inputs:  cmdInputs(cmd),
-			Outputs: cmdOutputs(cmd),
+			Cmd:       cmd,
+			UUID:      uuid.New(),
+			Inputs:    cmdInputs(cmd),
+			Outputs:   cmdOutputs(cmd),
+			optional:  cmdOptional(cmd),
+			temp:      cmdTag(cmd, "output", "temp"),
+			protected: cmdTag(cmd, "output", "protected"),
+		}
+		var err error
+		job.resources, err = resourcesFor(conf, cmd)
+		if err != nil {
+			return g, fmt.Errorf("invalid selectors in config: %v", err)
+		}
+		adaptResources(conf, &job.resources, history[cmd.AnalysisName()])
+		setAttempt(job)
+		if err := resolveSecrets(conf, job.resources.Secrets); err != nil {
+			return g, err
+		}
+		job.publish, err = cmdPublish(conf, cmd)
+		if err != nil {
+			return g, fmt.Errorf("invalid publish tag for %s: %v", cmd.AnalysisName(), err)
 		}
 		// What if the job has no outputs? Is this an error, if so we should
 		// check for this.
 		if len(job.Outputs) == 0 {
 			log.Fatal("Job has no defined outputs: " + job.Cmd.AnalysisName())
 		}
-		job.Stdout = fmt.Sprintf("%s.out", job.Outputs[0])
+		job.Stdout = fmt.Sprintf("%s.out", globReplacer.Replace(job.Outputs[0]))
 		dir, file := filepath.Split(job.Outputs[0])
 		job.doneFile = filepath.Join(dir, fmt.Sprintf(".%s.done", file))
 		job.doneFile = filepath.Join(
-			v.GetString("flowdir"),
+			conf.GetString("flowdir"),
 			"done",
 			strings.TrimSuffix(job.Stdout, ".out")+".done",
 		)
+		job.idFile = strings.TrimSuffix(job.doneFile, ".done") + ".jobid"
 		g.jobs = append(g.jobs, job)
 	}
+	g.jobs = dedupJobs(g.jobs)
+	if err := checkDuplicateOutputs(g.jobs); err != nil {
+		return g, err
+	}
 	for _, j := range g.jobs {
 		j.Dependencies = dependenciesFor(j, g.jobs)
-		g.pending = append(g.pending, j)
+	}
+	if cycle := findCycle(g.jobs); cycle != nil {
+		return g, fmt.Errorf("tasks depend on each other in a cycle: %s", describeCycle(cycle))
+	}
+	// Jobs that are rerun even if they have completed before.
+	forced := map[*job]bool{}
+	if targets := conf.GetStringSlice("targets"); len(targets) > 0 {
+		// Only run what is needed to produce the targets. Forcing them
+		// reruns the jobs that produce them, not their dependencies.
+		producers, err := producersOf(targets, g.jobs)
+		if err != nil {
+			return g, err
+		}
+		if conf.GetBool("force") {
+			for _, j := range producers {
+				forced[j] = true
+			}
+		}
+		g.jobs = withDependencies(producers, g.jobs)
+	} else if conf.GetBool("force") {
+		for _, j := range g.jobs {
+			forced[j] = true
+		}
+	}
+	if conf.GetBool("start_from_scratch") {
+		for _, j := range g.jobs {
+			forced[j] = true
+		}
+	}
+	g.pending = append(g.pending, g.jobs...)
+	for _, j := range g.jobs {
+		if err := checkInputsAvailable(conf, j); err != nil {
+			return g, err
+		}
 	}
 
-	if v.GetBool("start_from_scratch") {
+	if !conf.GetBool("dry_run") {
 		for _, j := range g.jobs {
+			if !forced[j] {
+				continue
+			}
 			err := os.Remove(j.doneFile)
 			if err != nil && !errors.Is(err, os.ErrNotExist) {
 				return g, fmt.Errorf("unable to remove done file: %s: %v", j.doneFile, err)
@@ -104,13 +264,25 @@ func newGraph(cmds []Commander) (graph, error) {
 		}
 	}
 	// If the done file exists for any pending job, mark it as complete and move
-	// it to the completed list.
+	// it to the completed list. Jobs whose protected outputs already exist are
+	// also treated as complete, even when starting from scratch.
 	pendingList := make([]*job, len(g.pending))
 	copy(pendingList, g.pending)
 	for _, p := range pendingList {
-		ok, err := fileExists(p.doneFile)
-		if err != nil {
-			return g, fmt.Errorf("unable to determine if file exists: %s: %v", p.doneFile, err)
+		ok := false
+		if !forced[p] {
+			var err error
+			ok, err = fileExists(p.doneFile)
+			if err != nil {
+				return g, fmt.Errorf("unable to determine if file exists: %s: %v", p.doneFile, err)
+			}
+		}
+		if !ok && !conf.GetBool("unprotect") {
+			var err error
+			ok, err = protectedOutputsExist(p)
+			if err != nil {
+				return g, err
+			}
 		}
 		if ok {
 			p.hasCompleted = true
@@ -123,9 +295,88 @@ func newGraph(cmds []Commander) (graph, error) {
 			g.pending = append(g.pending[:idx], g.pending[idx+1:]...)
 		}
 	}
+	if conf.GetBool("pipes") {
+		if err := g.connectPipes(); err != nil {
+			return g, err
+		}
+	}
 	return g, nil
 }
 
+// protectedOutputsExist reports whether the job has protected outputs and all
+// of them exist. It is an error for only some of them to exist, as running
+// the job would overwrite those that do.
+func protectedOutputsExist(j *job) (bool, error) {
+	if len(j.protected) == 0 {
+		return false, nil
+	}
+	existing := 0
+	for _, p := range j.protected {
+		ok, err := pathExists(p)
+		if err != nil {
+			return false, fmt.Errorf("unable to determine if file exists: %s: %v", p, err)
+		}
+		if ok {
+			existing++
+		}
+	}
+	switch existing {
+	case 0:
+		return false, nil
+	case len(j.protected):
+		return true, nil
+	default:
+		return false, fmt.Errorf("refusing to rerun %s, some of its protected outputs already exist (use --unprotect to override)", j.Cmd.AnalysisName())
+	}
+}
+
+// pathExists is like fileExists but also accepts a glob pattern, which exists
+// if it matches at least one file.
+func pathExists(p string) (bool, error) {
+	if !isGlob(p) {
+		return fileExists(p)
+	}
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return false, err
+	}
+	return len(matches) > 0, nil
+}
+
+// setProtected adds or removes write permission on the job's protected
+// outputs.
+func setProtected(j *job, protect bool) error {
+	for _, pattern := range j.protected {
+		paths := []string{pattern}
+		if isGlob(pattern) {
+			paths, _ = filepath.Glob(pattern)
+		}
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			mode := info.Mode().Perm()
+			if protect {
+				mode &^= 0222
+			} else {
+				mode |= 0200
+			}
+			if err := os.Chmod(p, mode); err != nil {
+				return fmt.Errorf("unable to change permissions of protected output: %s: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// globReplacer removes wildcard characters from paths derived from an output
+// pattern, such as the stdout and done files of the job.
+var globReplacer = strings.NewReplacer("*", "_", "?", "_", "[", "_", "]", "_")
+
 func dependenciesFor(j *job, allJobs []*job) []*job {
 	ds := []*job{}
 	for _, otherJob := range allJobs {
@@ -138,41 +389,181 @@ func dependenciesFor(j *job, allJobs []*job) []*job {
 	return ds
 }
 
-// What happens if a job fails? How do we stop subsequent jobs being run while
-// still exiting the loop eventually.
-func (g graph) Process() error {
-	var runner Runner
-	var err error
-	switch runnerStr := v.GetString("job_runner"); runnerStr {
-	case "pbs":
-		runner, err = NewPBSRunner()
+// findCycle returns the jobs of a cycle in the dependencies of jobs, each
+// depending on the next and the last on the first, or nil if there is none.
+func findCycle(jobs []*job) []*job {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*job]int)
+	stack := []*job{}
+	var visit func(j *job) []*job
+	visit = func(j *job) []*job {
+		state[j] = visiting
+		stack = append(stack, j)
+		for _, d := range j.Dependencies {
+			switch state[d] {
+			case visiting:
+				for i, s := range stack {
+					if s == d {
+						return append([]*job{}, stack[i:]...)
+					}
+				}
+			case unvisited:
+				if cycle := visit(d); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[j] = visited
+		return nil
+	}
+	for _, j := range jobs {
+		if state[j] == unvisited {
+			if cycle := visit(j); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// describeCycle writes a cycle from findCycle in the order the files flow,
+// as taskA → file → taskB → file → taskA.
+func describeCycle(cycle []*job) string {
+	parts := []string{}
+	for i := len(cycle) - 1; i >= 0; i-- {
+		producer := cycle[i]
+		consumer := cycle[(i-1+len(cycle))%len(cycle)]
+		parts = append(parts, producer.Cmd.AnalysisName(), sharedPath(producer.Outputs, consumer.Inputs))
+	}
+	parts = append(parts, cycle[len(cycle)-1].Cmd.AnalysisName())
+	return strings.Join(parts, " → ")
+}
+
+// sharedPath returns the first output that matches one of inputs.
+func sharedPath(outputs, inputs []string) string {
+	for _, out := range outputs {
+		if out != "" && hasIntersection([]string{out}, inputs) {
+			return out
+		}
+	}
+	return "?"
+}
+
+// producersOf returns the jobs that produce the given target outputs. It is
+// an error for a target not to be produced by any job.
+func producersOf(targets []string, jobs []*job) ([]*job, error) {
+	producers := []*job{}
+	for _, target := range targets {
+		abs, err := filepath.Abs(target)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		found := false
+		for _, j := range jobs {
+			if hasIntersection([]string{abs}, j.Outputs) {
+				found = true
+				if _, err := jobIndex(j, producers); err != nil {
+					producers = append(producers, j)
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("target is not produced by any task: %s", target)
+		}
+	}
+	return producers, nil
+}
+
+// withDependencies returns the jobs in selected along with everything they
+// depend on, directly or indirectly, in the order they appear in jobs.
+func withDependencies(selected []*job, jobs []*job) []*job {
+	needed := map[*job]bool{}
+	var visit func(j *job)
+	visit = func(j *job) {
+		if needed[j] {
+			return
+		}
+		needed[j] = true
+		for _, d := range j.Dependencies {
+			visit(d)
+		}
+	}
+	for _, j := range selected {
+		visit(j)
+	}
+	result := []*job{}
+	for _, j := range jobs {
+		if needed[j] {
+			result = append(result, j)
+		}
+	}
+	return result
+}
+
+// checkInputsAvailable returns an error if any required input of the job
+// neither exists nor is produced by one of its dependencies. Optional inputs
+// and patterns, which can only be resolved once upstream jobs have run, are
+// not checked.
+func checkInputsAvailable(conf *Config, j *job) error {
+	for _, in := range j.Inputs {
+		if in == "" || j.optional[in] || isGlob(in) {
+			continue
+		}
+		produced := false
+		for _, d := range j.Dependencies {
+			if hasIntersection([]string{in}, d.Outputs) {
+				produced = true
+				break
+			}
+		}
+		if produced {
+			continue
 		}
-	case "slurm":
-		runner, err = NewSlurmRunner()
+		ok, err := fileExists(in)
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to determine if input exists: %s: %v", in, err)
 		}
-	case "local":
-		runner = NewLocalRunner()
-	case "dummy":
-		runner = DummyRunner{}
-	default:
-		log.Fatalf("Unknown runner requested: %s", runnerStr)
+		// Staged inputs are downloaded when the job is submitted, so
+		// it is enough that they exist remotely.
+		if uri, staged := stagedURI(conf, in); staged && !ok {
+			ok, err = remoteExists(conf, uri)
+			if err != nil {
+				return fmt.Errorf("unable to determine if input exists: %s: %v", uri, err)
+			}
+			in = uri
+		}
+		if !ok {
+			return fmt.Errorf("input for %s does not exist and is not produced by any task: %s", j.Cmd.AnalysisName(), in)
+		}
+	}
+	return nil
+}
+
+// What happens if a job fails? How do we stop subsequent jobs being run while
+// still exiting the loop eventually.
+func (g graph) Process() error {
+	runner, err := newRunner(g.config)
+	if err != nil {
+		return err
 	}
 	// Ensure that however we leave this function any running jobs are
 	// terminated.
 	defer killRunningJobs(g, runner)
 
-	// "yyyy-MM-DD_HHmmss"
-	t := time.Now()
-	timestamp := fmt.Sprintf(
-		"%d-%02d-%02d_%02d%02d%02d",
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second(),
-	)
-	jobReportfile := fmt.Sprintf("jobreport_%s.csv", timestamp)
+	if err := g.reattach(runner); err != nil {
+		return err
+	}
+
+	runDir, err := g.config.runDir()
+	if err != nil {
+		return err
+	}
+	jobReportfile := filepath.Join(runDir, "jobreport.csv")
 	w, err := os.Create(jobReportfile)
 	if err != nil {
 		return fmt.Errorf("unable to create job report file: %v", err)
@@ -182,6 +573,7 @@ func (g graph) Process() error {
 	if err != nil {
 		return fmt.Errorf("unable to create job report: %v", err)
 	}
+	report.priced = pricingSet(g.config)
 
 	sigs := make(chan os.Signal, 1)
 	quit := make(chan bool)
@@ -199,9 +591,16 @@ func (g graph) Process() error {
 		if err != nil {
 			log.Printf("Signal handler unable to kill all jobs: %s", err)
 		}
+		unlockFlowdir(g.config)
 		os.Exit(1)
 	}()
 
+	durations := analysisDurations(g.config)
+	for _, j := range g.jobs {
+		j.expected = durations[j.Cmd.AnalysisName()]
+	}
+	prog := newProgress(append(append([]*job{}, g.pending...), g.running...), durations)
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	errs := make(chan error, 1)
@@ -214,7 +613,7 @@ func (g graph) Process() error {
 			errs <- fmt.Errorf("failed to submit jobs: %v", err)
 			return
 		}
-		log.Printf("%d pending, %d running, %d failed, %d done", len(g.pending), len(g.running), len(g.failed), len(g.completed))
+		log.Printf("%d pending, %d running, %d failed, %d done, %s", len(g.pending), len(g.running), len(g.failed), len(g.completed), prog)
 
 		for {
 			select {
@@ -232,21 +631,22 @@ func (g graph) Process() error {
 					errs <- fmt.Errorf("failed to submit jobs: %v", err)
 					return
 				}
-				if nSubmitted == 0 && len(g.pending) != 0 && len(g.running) == 0 {
+				if nSubmitted == 0 && len(g.pending) != 0 && len(g.running) == 0 && !g.retrying() {
 					// If no jobs were submitted but there are pending jobs
-					// and no running jobs it must mean jobs cannot run
-					// because of previous failures.
+					// and no running jobs (or jobs waiting to retry their
+					// submission) it must mean jobs cannot run because of
+					// previous failures.
 					log.Printf("There are no more jobs that can be run.")
 					return
 				}
 				if nCompleted > 0 || nSubmitted > 0 {
-					log.Printf("%d pending, %d running, %d failed, %d done", len(g.pending), len(g.running), len(g.failed), len(g.completed))
+					log.Printf("%d pending, %d running, %d failed, %d done, %s", len(g.pending), len(g.running), len(g.failed), len(g.completed), prog)
 				}
 				if len(g.pending) == 0 && len(g.running) == 0 {
 					log.Printf("There are no more jobs to run")
 					return
 				}
-				time.Sleep(60 * time.Second)
+				time.Sleep(g.untilNextPoll())
 			}
 		}
 	}()
@@ -263,15 +663,23 @@ func (g graph) Process() error {
 	if err != nil {
 		return fmt.Errorf("unable to finalise job report file: %v", err)
 	}
-	if len(g.failed) > 0 {
-		boldRed := color.New(color.Bold, color.FgRed).SprintfFunc()
-		log.Printf("Workflow completed with %d %s jobs, see stdout for details", len(g.failed), boldRed("FAILED"))
-		for _, job := range g.failed {
-			log.Printf("%s: %s, output: %s", boldRed("FAILED"), job.UUID, job.Stdout)
-		}
-	} else {
+	if len(g.failed) > 0 || len(g.allowedFailed) > 0 {
+		g.reportFailures()
+	}
+	if pricingSet(g.config) {
+		finished := append(append(append([]*job{}, g.completed...), g.failed...), g.allowedFailed...)
+		log.Printf("Estimated cost of the jobs run:\n%s", costSummary(g.config, finished))
+	}
+	if len(g.failed) == 0 {
 		greenBold := color.New(color.Bold, color.FgGreen).SprintfFunc()
 		log.Printf("Workflow completed %s", greenBold("SUCCESSFULLY"))
+		if g.config.GetBool("archive_logs") {
+			if fn, err := g.archiveLogs(); err != nil {
+				log.Printf("Unable to archive logs: %v", err)
+			} else if fn != "" {
+				log.Printf("Logs archived to %s", fn)
+			}
+		}
 	}
 	log.Printf("Completed with %d completed and %d failed (running = %d)", len(g.completed), len(g.failed), len(g.running))
 	if len(g.failed) > 0 {
@@ -280,15 +688,167 @@ func (g graph) Process() error {
 	return nil
 }
 
+// reportFailures logs the jobs that failed and those that were not run as a
+// result.
+func (g graph) reportFailures() {
+	boldRed := color.New(color.Bold, color.FgRed).SprintfFunc()
+	if len(g.failed) > 0 {
+		log.Printf("Workflow completed with %d %s jobs, see stdout for details", len(g.failed), boldRed("FAILED"))
+	}
+	summary := failureSummary(g.failed, g.allowedFailed)
+	log.Printf("Failed jobs:\n%s", summary)
+	if runDir, err := g.config.runDir(); err == nil {
+		fn := filepath.Join(runDir, "failures.txt")
+		if err := ioutil.WriteFile(fn, []byte(summary), 0644); err != nil {
+			log.Printf("Unable to write failure summary: %v", err)
+		} else {
+			log.Printf("Failure summary written to %s", fn)
+		}
+	}
+	if len(g.pending) == 0 {
+		return
+	}
+	names := []string{}
+	for _, job := range g.pending {
+		names = append(names, job.Cmd.AnalysisName())
+	}
+	sort.Strings(names)
+	if g.config.GetBool("keep_going") || len(g.failed) == 0 {
+		log.Printf("%d jobs were not run because they depend on failed jobs: %s", len(names), strings.Join(names, ", "))
+	} else {
+		log.Printf("%d jobs were not run after the first failure (use --keep-going to run those that do not depend on it): %s", len(names), strings.Join(names, ", "))
+	}
+}
+
+// dedupJobs drops the jobs that are identical to an earlier one, having the
+// same command, container, inputs and outputs, as happens when a helper
+// function adds the same task twice. The job that is kept produces the
+// outputs for the consumers of both.
+func dedupJobs(jobs []*job) []*job {
+	seen := make(map[string]*job)
+	kept := []*job{}
+	for _, j := range jobs {
+		inputs := append([]string{}, j.Inputs...)
+		outputs := append([]string{}, j.Outputs...)
+		sort.Strings(inputs)
+		sort.Strings(outputs)
+		key := strings.Join([]string{
+			j.Cmd.Command(),
+			j.resources.Container,
+			strings.Join(inputs, "\x00"),
+			strings.Join(outputs, "\x00"),
+		}, "\x01")
+		if first, ok := seen[key]; ok {
+			log.Printf("WARNING: %s is identical to %s and is only run once", j.Cmd.AnalysisName(), first.Cmd.AnalysisName())
+			continue
+		}
+		seen[key] = j
+		kept = append(kept, j)
+	}
+	return kept
+}
+
+// checkDuplicateOutputs returns an error listing every output declared by
+// more than one job, which would otherwise race to write it.
+func checkDuplicateOutputs(jobs []*job) error {
+	producer := make(map[string]*job)
+	problems := []string{}
+	for _, j := range jobs {
+		for _, out := range j.Outputs {
+			if other, ok := producer[out]; ok && other != j {
+				problems = append(problems, fmt.Sprintf("%s is an output of both %s and %s", out, other.Cmd.AnalysisName(), j.Cmd.AnalysisName()))
+				continue
+			}
+			producer[out] = j
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("tasks have the same outputs:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// describe logs the jobs that would be run, without running them.
+func (g graph) describe() {
+	log.Printf("Dry run: %d jobs would be run, %d are already done", len(g.pending), len(g.completed))
+	for _, j := range submitOrder(g.config, g.pending) {
+		log.Printf("Would run %s: %s", j.Cmd.AnalysisName(), strings.Join(j.Outputs, ", "))
+	}
+}
+
+// reattach adopts jobs submitted by a previous run that died before they
+// finished, rather than submitting them again. Jobs still known to the
+// scheduler are moved to the running list, whether they have finished or not,
+// and are then handled like any other running job. Only batch schedulers can
+// be reattached to; local jobs die with the process that ran them.
+func (g *graph) reattach(r Runner) error {
+	kr, ok := r.(reattachRunner)
+	if !ok {
+		return nil
+	}
+	pendingList := make([]*job, len(g.pending))
+	copy(pendingList, g.pending)
+	for _, p := range pendingList {
+		content, err := ioutil.ReadFile(p.idFile)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read job ID file: %s: %v", p.idFile, err)
+		}
+		p.ID = strings.TrimSpace(string(content))
+		known, err := kr.Known(p)
+		if err != nil || !known {
+			if err == nil {
+				err = errors.New("the scheduler has no record of it")
+			}
+			log.Printf("Unable to reattach to job %s, it will be resubmitted: %v", p.ID, err)
+			p.ID = ""
+			os.Remove(p.idFile)
+			continue
+		}
+		// The job was submitted when its ID was recorded, and runs in the
+		// directory it would be given now, which its heartbeat is looked
+		// for in.
+		if info, err := os.Stat(p.idFile); err == nil {
+			p.submitted = info.ModTime()
+		}
+		if err := expandInputs(p.Cmd); err == nil {
+			p.Inputs = cmdInputs(p.Cmd)
+			if dir, err := workDirPath(g.config, p); err == nil {
+				p.workDir = dir
+			}
+		}
+		log.Printf("Reattached to job %s (%s)", p.ID, p.Cmd.AnalysisName())
+		idx, err := jobIndex(p, g.pending)
+		if err != nil {
+			return err
+		}
+		g.pending = append(g.pending[:idx], g.pending[idx+1:]...)
+		g.running = append(g.running, p)
+	}
+	return nil
+}
+
+func recordJobID(j *job) error {
+	if err := os.MkdirAll(filepath.Dir(j.idFile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(j.idFile, []byte(j.ID+"\n"), 0644)
+}
+
 // Attempt to kill all running jobs. Returns an error if it fails to kill any of
-// the jobs.
+// the jobs. The IDs of the jobs killed are forgotten, so that the next run
+// resubmits them rather than reattaching to them.
 func killRunningJobs(g graph, r Runner) error {
 	errs := []error{}
 	for _, job := range g.running {
 		err := r.Kill(job)
 		if err != nil {
 			errs = append(errs, err)
+			continue
 		}
+		os.Remove(job.idFile)
 	}
 	if len(errs) == 0 {
 		return nil
@@ -297,104 +857,475 @@ func killRunningJobs(g graph, r Runner) error {
 	}
 }
 
+// submitPending submits the pending jobs whose dependencies have completed.
+// Once a job has failed no more are submitted, unless keep_going is set, in
+// which case only the jobs that depend on it are held back and a job that
+// cannot be submitted fails on its own rather than stopping the run.
 func (g *graph) submitPending(r Runner) (int, error) {
 	submitted := 0
-	pendingList := make([]*job, len(g.pending))
-	copy(pendingList, g.pending)
-	for _, pending := range pendingList {
-		if pending.isRunnable() {
-			ctx, err := newExecutionContext(pending)
-			if err != nil {
-				return submitted, fmt.Errorf("failed to create execution context for %s: %v", pending.UUID, err)
+	keepGoing := g.config.GetBool("keep_going")
+	if len(g.failed) > 0 && !keepGoing {
+		return submitted, nil
+	}
+	for _, pending := range submitOrder(g.config, g.pending) {
+		if pending.pipeTo != nil || pending.packedIn != nil || !pending.isRunnable() || time.Now().Before(pending.nextSubmit) || !g.withinBudget(pending) {
+			continue
+		}
+		if packKey(pending) != "" && pending.packed == nil {
+			pending.packed = g.packFor(pending)
+			for _, p := range pending.packed {
+				p.packedIn = pending
 			}
-			if err := r.Run(ctx); err != nil {
-				return submitted, fmt.Errorf("unable to run job: %v", err)
+		}
+		if err := g.submit(r, pending); err != nil {
+			// The jobs packed with it may be packed again.
+			unpack(pending)
+			if retrySubmit(g.config, pending, err) {
+				continue
 			}
-			// Display job information after it has been submitted
-			// so JobID is populated.
-			displayJob(pending)
-			idx, err := jobIndex(pending, g.pending)
-			if err != nil {
+			if !keepGoing && !pending.resources.AllowFailure {
 				return submitted, err
 			}
-			g.pending = append(g.pending[:idx], g.pending[idx+1:]...)
-			g.running = append(g.running, pending)
-			submitted++
+			g.fail(pending, err.Error())
+			continue
+		}
+		idx, err := jobIndex(pending, g.pending)
+		if err != nil {
+			return submitted, err
 		}
+		g.pending = append(g.pending[:idx], g.pending[idx+1:]...)
+		g.running = append(g.running, pending)
+		submitted++
 	}
 	return submitted, nil
 }
 
+// submitOrder returns a copy of jobs in the order they are submitted. This is
+// the order the tasks were added to the queue unless stable_order is set, in
+// which case it is topological: jobs are ordered by their depth in the
+// workflow (jobs without dependencies first), then by name and then by
+// outputs, so that the order does not depend on how the workflow adds them.
+// With longest_first jobs that are expected to take longest, from previous
+// runs, are then moved first, so they do not hold up the end of the run.
+func submitOrder(conf *Config, jobs []*job) []*job {
+	ordered := make([]*job, len(jobs))
+	copy(ordered, jobs)
+	if conf.GetBool("stable_order") {
+		topologicalOrder(ordered)
+	}
+	if conf.GetBool("longest_first") {
+		sort.SliceStable(ordered, func(a, b int) bool {
+			return ordered[a].expected > ordered[b].expected
+		})
+	}
+	return ordered
+}
+
+// topologicalOrder sorts jobs topologically, then by name and then by outputs.
+func topologicalOrder(ordered []*job) {
+	depths := map[*job]int{}
+	var depth func(j *job) int
+	depth = func(j *job) int {
+		if d, ok := depths[j]; ok {
+			return d
+		}
+		// Cycles are rejected when the graph is made; this only guards
+		// against recursing forever.
+		depths[j] = 0
+		d := 0
+		for _, dep := range j.Dependencies {
+			if x := depth(dep) + 1; x > d {
+				d = x
+			}
+		}
+		depths[j] = d
+		return d
+	}
+	sort.SliceStable(ordered, func(a, b int) bool {
+		x, y := ordered[a], ordered[b]
+		if dx, dy := depth(x), depth(y); dx != dy {
+			return dx < dy
+		}
+		if nx, ny := x.Cmd.AnalysisName(), y.Cmd.AnalysisName(); nx != ny {
+			return nx < ny
+		}
+		return strings.Join(x.Outputs, "\n") < strings.Join(y.Outputs, "\n")
+	})
+}
+
+func (g *graph) submit(r Runner, pending *job) error {
+	if err := stage(g.config, r, pending); err != nil {
+		return err
+	}
+	for _, p := range pending.packed {
+		if err := stage(g.config, r, p); err != nil {
+			return err
+		}
+		if _, err := newExecutionContext(g.config, p); err != nil {
+			return fmt.Errorf("failed to create execution context for %s: %v", p.UUID, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(p.Stdout), 0755); err != nil {
+			return fmt.Errorf("unable to create stdout directory for %s: %v", p.UUID, err)
+		}
+	}
+	ctx, err := newExecutionContext(g.config, pending)
+	if err != nil {
+		return fmt.Errorf("failed to create execution context for %s: %v", pending.UUID, err)
+	}
+	// The job of a pack runs the job scripts of each of its jobs, and
+	// asks for the resources they need between them.
+	resources := pending.resources
+	if len(pending.packed) > 0 {
+		if ctx.script, err = createPackFile(g.config, pending); err != nil {
+			return err
+		}
+		pending.resources = packResources(append([]*job{pending}, pending.packed...))
+	}
+	err = r.Run(ctx)
+	pending.resources = resources
+	if err != nil {
+		return submitError{fmt.Errorf("unable to run job: %v", err)}
+	}
+	pending.submitted = time.Now()
+	pending.submitAttempts = 0
+	pending.pollInterval = 0
+	pending.backoff(g.config)
+	if err := recordJobID(pending); err != nil {
+		log.Printf("Unable to record job ID: %s: %v", pending.idFile, err)
+	}
+	for _, p := range streamedFrom(pending) {
+		p.ID = pending.ID
+		p.submitted = pending.submitted
+	}
+	for _, p := range pending.packed {
+		p.ID = pending.ID
+		p.submitted = pending.submitted
+	}
+	// Display job information after it has been submitted
+	// so JobID is populated.
+	displayJob(pending)
+	return nil
+}
+
+// stage gets a job ready to run, and the jobs streamed to it.
+func stage(conf *Config, r Runner, pending *job) error {
+	setAttempt(pending)
+	// Upstream jobs have now completed, so any patterns in the
+	// inputs can be resolved to the files they produced.
+	if err := expandInputs(pending.Cmd); err != nil {
+		return fmt.Errorf("failed to expand inputs for %s: %v", pending.UUID, err)
+	}
+	pending.Inputs = cmdInputs(pending.Cmd)
+	// With unprotect set the job is allowed to overwrite its
+	// protected outputs.
+	if conf.GetBool("unprotect") {
+		if err := setProtected(pending, false); err != nil {
+			return err
+		}
+	}
+	if !simulated(r) {
+		if err := fetchInputs(conf, pending); err != nil {
+			return fmt.Errorf("failed to stage inputs for %s: %v", pending.UUID, err)
+		}
+	}
+	for _, f := range cmdFiles(pending.Cmd, "input") {
+		if err := f.verifyChecksum(); err != nil {
+			return fmt.Errorf("input for %s failed verification: %v", pending.UUID, err)
+		}
+	}
+	for _, p := range pending.pipeFrom {
+		if err := stage(conf, r, p); err != nil {
+			return err
+		}
+		if _, err := newExecutionContext(conf, p); err != nil {
+			return fmt.Errorf("failed to create execution context for %s: %v", p.UUID, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(p.Stdout), 0755); err != nil {
+			return fmt.Errorf("unable to create stdout directory for %s: %v", p.UUID, err)
+		}
+	}
+	return nil
+}
+
+// fail moves a pending or running job to the failed jobs, or those allowed
+// to fail.
+func (g *graph) fail(j *job, reason string) {
+	j.hasCompleted = true
+	j.failure = reason
+	unpack(j)
+	if j.resources.AllowFailure {
+		log.Printf("WARNING: job failed, which is allowed: %s %v: %s", j.Cmd.AnalysisName(), j.UUID, reason)
+		g.allowedFailed = append(g.allowedFailed, j)
+	} else {
+		bold := color.New(color.Bold, color.FgRed).SprintfFunc()
+		log.Printf("%s: job failed: %s %v: %s", bold("ERROR"), j.Cmd.AnalysisName(), j.UUID, reason)
+		g.failed = append(g.failed, j)
+	}
+	if idx, err := jobIndex(j, g.pending); err == nil {
+		g.pending = append(g.pending[:idx], g.pending[idx+1:]...)
+	} else if idx, err := jobIndex(j, g.running); err == nil {
+		g.running = append(g.running[:idx], g.running[idx+1:]...)
+	}
+}
+
 func (g *graph) checkCompleted(r Runner, report jobReport) (int, error) {
 	nCompleted := 0
 	runningList := make([]*job, len(g.running))
 	copy(runningList, g.running)
+	if br, ok := r.(batchRunner); ok {
+		due := []*job{}
+		for _, j := range runningList {
+			if !time.Now().Before(j.nextPoll) {
+				due = append(due, j)
+			}
+		}
+		if len(due) > 0 {
+			if err := br.Refresh(due); err != nil {
+				return nCompleted, err
+			}
+		}
+	}
 	for _, running := range runningList {
+		if time.Now().Before(running.nextPoll) {
+			continue
+		}
 		completed, err := r.Completed(running)
 		if err != nil {
 			return nCompleted, fmt.Errorf("unable to determine job state: %s: %s", running.ID, err)
 		}
+		if !completed && g.lost(r, running) {
+			continue
+		}
+		if completed && (g.timedOut(r, running) || g.preempted(r, running) || g.nodeFailed(r, running)) {
+			continue
+		}
+		if !completed {
+			running.backoff(g.config)
+		}
 		if completed {
 			nCompleted++
-			running.hasCompleted = true
 			successful, err := r.CompletedSuccessfully(running)
 			if err != nil {
 				return nCompleted, fmt.Errorf("unable to determine job state: %s: %s", running.ID, err)
 			}
-			idx, err := jobIndex(running, g.running)
-			if err != nil {
-				return nCompleted, err
-			}
-			if successful {
-				running.completedSuccessfully = true
-				// done files are only created on successful completion of a job.
-				green := color.New(color.Bold, color.FgGreen).SprintfFunc()
-				log.Printf("Job completed %s %s %s", green("SUCCESSFULLY"), running.UUID, running.ID)
-				err := os.MkdirAll(filepath.Dir(running.doneFile), 0755)
-				if err != nil {
-					return nCompleted, fmt.Errorf("unable to create done file directory for job: %s: %s", running.ID, err)
+			packed := running.packed
+			running.packed = nil
+			for i, j := range append([]*job{running}, packed...) {
+				j.packedIn = nil
+				ok := successful
+				if len(packed) > 0 && !simulated(r) {
+					ok = packedSuccessfully(j)
 				}
-				_, err = os.Create(running.doneFile)
-				if err != nil {
-					return nCompleted, fmt.Errorf("unable to create done file for job: %s: %s", running.ID, err)
+				if i > 0 {
+					nCompleted++
 				}
-				resources, err := r.ResourcesUsed(running)
-				if err != nil {
-					log.Printf("Failed to get resources for job: %v: %v", running.UUID, err)
-				} else {
-					err = report.Add(running, resources)
-					if err != nil {
-						log.Printf("Unable to update job report file: %v", err)
+				if err := g.finish(r, j, ok, report); err != nil {
+					return nCompleted, err
+				}
+				// Jobs streamed to this one ran in its job, which
+				// fails if any of them did.
+				for _, p := range streamedFrom(j) {
+					nCompleted++
+					if err := g.finish(r, p, ok, report); err != nil {
+						return nCompleted, err
 					}
 				}
-				g.completed = append(g.completed, running)
-				g.running = append(g.running[:idx], g.running[idx+1:]...)
-			} else {
-				bold := color.New(color.Bold, color.FgRed).SprintfFunc()
-				log.Printf("%s: job failed: %v, %v: stdout written to %s", bold("ERROR"), running.UUID, running.ID, running.Stdout)
-				g.failed = append(g.failed, running)
-				g.running = append(g.running[:idx], g.running[idx+1:]...)
 			}
 		}
 	}
 	return nCompleted, nil
 }
 
+// finish records that a job has completed, successfully or not, and moves it
+// to the completed or failed jobs.
+func (g *graph) finish(r Runner, j *job, successful bool, report jobReport) error {
+	j.hasCompleted = true
+	os.Remove(j.idFile)
+	if successful && !simulated(r) {
+		if err := expandOutputs(j); err != nil {
+			log.Printf("Job %s: %v", j.UUID, err)
+			successful = false
+		} else if err := setOutputMode(g.config, j); err != nil {
+			log.Printf("Job %s: %v", j.UUID, err)
+			successful = false
+		} else if err := publishOutputs(g.config, j); err != nil {
+			log.Printf("Job %s: %v", j.UUID, err)
+			successful = false
+		} else if err := storeOutputs(g.config, j); err != nil {
+			log.Printf("Job %s: %v", j.UUID, err)
+			successful = false
+		}
+	}
+	if !successful {
+		g.charge(j, resourcesUsed{})
+		g.fail(j, fmt.Sprintf("job %s failed, stdout written to %s", j.ID, j.Stdout))
+		return nil
+	}
+	j.completedSuccessfully = true
+	if err := setProtected(j, true); err != nil {
+		log.Printf("Unable to protect outputs of job %s: %v", j.UUID, err)
+	}
+	// done files are only created on successful completion of a job.
+	green := color.New(color.Bold, color.FgGreen).SprintfFunc()
+	log.Printf("Job completed %s %s %s", green("SUCCESSFULLY"), j.UUID, j.ID)
+	err := os.MkdirAll(filepath.Dir(j.doneFile), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create done file directory for job: %s: %s", j.ID, err)
+	}
+	_, err = os.Create(j.doneFile)
+	if err != nil {
+		return fmt.Errorf("unable to create done file for job: %s: %s", j.ID, err)
+	}
+	resources, err := r.ResourcesUsed(j)
+	g.charge(j, resources)
+	if err != nil {
+		log.Printf("Failed to get resources for job: %v: %v", j.UUID, err)
+	} else {
+		err = report.Add(j, resources)
+		if err != nil {
+			log.Printf("Unable to update job report file: %v", err)
+		}
+	}
+	if err := recordUsage(g.config, j, resources); err != nil {
+		log.Printf("Unable to record usage of job %s: %v", j.UUID, err)
+	}
+	g.completed = append(g.completed, j)
+	// Jobs streamed to another are still pending when it completes.
+	if idx, err := jobIndex(j, g.running); err == nil {
+		g.running = append(g.running[:idx], g.running[idx+1:]...)
+	} else if idx, err := jobIndex(j, g.pending); err == nil {
+		g.pending = append(g.pending[:idx], g.pending[idx+1:]...)
+	}
+	if !g.config.GetBool("keep_temp") {
+		for _, d := range j.Dependencies {
+			g.removeTemp(d)
+		}
+	}
+	return nil
+}
+
+// untilNextPoll returns how long to wait before polling the scheduler again,
+// which is until the next running job is due to be polled.
+func (g *graph) untilNextPoll() time.Duration {
+	wait := g.config.GetDuration("poll_interval")
+	for _, j := range g.running {
+		if d := time.Until(j.nextPoll); d < wait {
+			wait = d
+		}
+	}
+	for _, j := range g.pending {
+		if d := time.Until(j.nextSubmit); d > 0 && d < wait {
+			wait = d
+		}
+	}
+	if min := g.config.GetDuration("poll_min_interval"); wait < min {
+		wait = min
+	}
+	return wait
+}
+
+// lost checks the heartbeat of a running job. If the heartbeat has stopped
+// the job is assumed to have died without the scheduler noticing (e.g., its
+// node failed); it is killed and returned to the pending list so that it is
+// resubmitted, up to heartbeat_resubmits times, after which it is failed.
+// Returns true if the job was no longer considered running.
+func (g *graph) lost(r Runner, j *job) bool {
+	if j.workDir == "" {
+		return false
+	}
+	info, err := os.Stat(heartbeatFile(j.workDir))
+	if err != nil {
+		// The job has not started yet.
+		return false
+	}
+	silence := time.Since(info.ModTime())
+	if silence < g.config.GetDuration("heartbeat_timeout") {
+		return false
+	}
+	idx, err := jobIndex(j, g.running)
+	if err != nil {
+		return false
+	}
+	g.running = append(g.running[:idx], g.running[idx+1:]...)
+	if err := r.Kill(j); err != nil {
+		log.Printf("Unable to kill lost job %s: %v", j.ID, err)
+	}
+	os.Remove(heartbeatFile(j.workDir))
+	os.Remove(j.idFile)
+	unpack(j)
+	j.lostCount++
+	if j.lostCount > g.config.GetInt("heartbeat_resubmits") {
+		log.Printf("Job %s (%s) has no heartbeat for %s, giving up", j.ID, j.Cmd.AnalysisName(), silence.Round(time.Second))
+		g.fail(j, fmt.Sprintf("no heartbeat for %s after %d resubmits", silence.Round(time.Second), j.lostCount-1))
+		return true
+	}
+	log.Printf("Job %s (%s) has no heartbeat for %s, resubmitting", j.ID, j.Cmd.AnalysisName(), silence.Round(time.Second))
+	g.pending = append(g.pending, j)
+	return true
+}
+
+func heartbeatFile(dir string) string {
+	return filepath.Join(dir, ".heartbeat")
+}
+
+// exitCodeFile is written by the job script with the exit code of the job.
+func exitCodeFile(dir string) string {
+	return filepath.Join(dir, ".exitcode")
+}
+
+// removeTemp deletes the temp outputs of j if every job that consumes them
+// has completed successfully. Outputs that are not consumed by any job are
+// kept.
+func (g *graph) removeTemp(j *job) {
+	if len(j.temp) == 0 || j.tempRemoved {
+		return
+	}
+	consumers := 0
+	for _, other := range g.jobs {
+		if !hasIntersection(other.Inputs, j.temp) {
+			continue
+		}
+		if !other.completedSuccessfully {
+			return
+		}
+		consumers++
+	}
+	if consumers == 0 {
+		return
+	}
+	for _, t := range j.temp {
+		paths := []string{t}
+		if isGlob(t) {
+			paths, _ = filepath.Glob(t)
+		}
+		for _, p := range paths {
+			err := os.Remove(p)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Unable to remove temp output: %s: %v", p, err)
+				continue
+			}
+			log.Printf("Removed temp output: %s", p)
+		}
+	}
+	j.tempRemoved = true
+}
+
 type executionContext struct {
 	job    *job
 	dir    string
 	script string
 }
 
-func newExecutionContext(j *job) (executionContext, error) {
+func newExecutionContext(conf *Config, j *job) (executionContext, error) {
 	cxt := executionContext{
 		job: j,
 	}
 	var err error
-	cxt.dir, err = ioutil.TempDir(v.GetString("flowdir"), "flow")
+	cxt.dir, err = workDir(conf, j)
 	if err != nil {
-		return executionContext{}, fmt.Errorf("failed to create temp directory: %v", err)
+		return executionContext{}, fmt.Errorf("failed to create work directory: %v", err)
 	}
+	j.workDir = cxt.dir
 	jobFn, err := filepath.Abs(filepath.Join(cxt.dir, "job.sh"))
 	if err != nil {
 		return executionContext{}, fmt.Errorf("unable to get absolute path of job.sh: %v", err)
@@ -405,28 +1336,66 @@ func newExecutionContext(j *job) (executionContext, error) {
 		return executionContext{}, fmt.Errorf("unable to get absolute path of script.sh: %v", err)
 	}
 
-	if err := createJobFile(jobFn, scriptFn, j); err != nil {
-		return executionContext{}, fmt.Errorf("unable to create job file: %v", err)
-	}
 	if err := createScriptFile(scriptFn, j); err != nil {
 		return executionContext{}, fmt.Errorf("unable to create script file: %v", err)
 	}
+	if err := writeStdin(j, cxt.dir); err != nil {
+		return executionContext{}, err
+	}
+	if err := createJobFile(conf, jobFn, scriptFn, j); err != nil {
+		return executionContext{}, fmt.Errorf("unable to create job file: %v", err)
+	}
 	return cxt, nil
 }
 
-func createJobFile(jobFile, scriptFile string, j *job) error {
-	r := j.Cmd.Resources()
-	shell := "/bin/bash"
-	singularityBin := v.GetString("singularity_bin")
+// workDir creates the directory the job is run in. Each job has its own
+// directory under flowdir/work, named after a hash of the job so the same job
+// always uses the same directory, whichever attempt at running it this is.
+// Anything left from a previous attempt is removed.
+func workDir(conf *Config, j *job) (string, error) {
+	dir, err := workDirPath(conf, j)
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// workDirPath returns the directory the job is run in, without creating it.
+func workDirPath(conf *Config, j *job) (string, error) {
+	h := sha256.New()
+	fmt.Fprintln(h, j.Cmd.AnalysisName())
+	fmt.Fprintln(h, strings.Join(j.Inputs, "\n"))
+	fmt.Fprintln(h, strings.Join(j.Outputs, "\n"))
+	firstAttempt(j, func() { fmt.Fprintln(h, j.Cmd.Command()) })
+	sum := hex.EncodeToString(h.Sum(nil))
+	return filepath.Abs(filepath.Join(conf.GetString("flowdir"), "work", sum[:2], sum[2:]))
+}
+
+func createJobFile(conf *Config, jobFile, scriptFile string, j *job) error {
+	r := j.resources
+	r.Env = mergeEnv(r.Env, taskEnv(conf, j))
+	shell := scriptInterpreter(scriptFile)
+	singularityBin := conf.GetString("singularity_bin")
 	if singularityBin == "" {
 		singularityBin = "singularity"
 	}
+	secrets, hidden, err := secretExports(conf, r.Secrets, r.Container != "")
+	if err != nil {
+		return err
+	}
 	// slurm _requires_ a shebang line
 	var content strings.Builder
-	content.WriteString(`#!/usr/bin/env bash
-set -o verbose
-env | sort
-`)
+	content.WriteString("#!/usr/bin/env bash\nset -o verbose\n")
+	umask, err := umaskCommand(conf)
+	if err != nil {
+		return err
+	}
+	content.WriteString(umask)
+	content.WriteString(ulimitCommands(r.Ulimits))
+	content.WriteString(listEnv(hidden) + "\n")
 	ds := []string{}
 	for _, fn := range j.Outputs {
 		ds = append(ds, filepath.Dir(fn))
@@ -437,22 +1406,91 @@ env | sort
 	}
 
 	content.WriteString(fmt.Sprintf("cat %s | sed s'/^/# SCRIPT: /'\n", scriptFile))
+	content.WriteString(fmt.Sprintf("cd %s\n", filepath.Dir(jobFile)))
+
+	// Touch the heartbeat file while the job runs so the engine can tell a
+	// running job from one whose node died.
+	content.WriteString(fmt.Sprintf(
+		"(while true; do touch %s; sleep %d; done) &\nheartbeat=$!\n",
+		heartbeatFile(filepath.Dir(jobFile)),
+		int(conf.GetDuration("heartbeat_interval").Seconds())))
+	content.WriteString("trap 'kill $heartbeat 2>/dev/null; [ -n \"$scratch\" ] && rm -rf \"$scratch\"; [ -n \"$tmpfs\" ] && rm -rf \"$tmpfs\"' EXIT\n")
+
+	content.WriteString(envExports(r.Env, r.Container != ""))
+	// set -o verbose echoes the command that reads each secret, not its
+	// value.
+	content.WriteString(secrets)
+	content.WriteString(moduleLoad(conf, r.Modules))
+	activate, err := condaActivate(conf, r.Conda)
+	if err != nil {
+		return err
+	}
+	content.WriteString(activate)
+
+	extraArgs := r.SingularityExtraArgs
+	cleanEnv := conf.GetBool("clean_env")
+	if cleanEnv && r.Container != "" {
+		content.WriteString(passthroughExports(conf))
+		extraArgs += " --cleanenv"
+	}
+	if r.Tmpfs > 0 {
+		content.WriteString(tmpfsPrologue(conf, r.Tmpfs))
+		extraArgs += ` -B "$tmpfs":/tmp`
+	}
+	if r.Scratch {
+		inputs, outputs := scratchPaths(j)
+		content.WriteString(scratchPrologue(inputs, outputs))
+		extraArgs += ` -B "$scratch" --pwd "$scratch"`
+	}
+	if r.Checkpoint != "" {
+		prologue, err := checkpointPrologue(conf, filepath.Dir(jobFile))
+		if err != nil {
+			return err
+		}
+		content.WriteString(prologue)
+		extraArgs += ` -B "$FLOW_CHECKPOINT_DIR"`
+	}
+	content.WriteString(pipePrologue(j))
 
 	// Typically flowdir is inside a users home directory and this is
 	// automatically bound in, but it may not be and the -C option may be
 	// provided.
 	if r.Container != "" {
+		exports, err := singularityExports(conf)
+		if err != nil {
+			return err
+		}
+		content.WriteString(exports)
 		content.WriteString(fmt.Sprintf(
-			"%s exec %s -B %s:/flowdir %s %s /flowdir/%s",
+			"%s exec %s -B %s:/flowdir %s %s%s /flowdir/%s",
 			singularityBin,
-			r.SingularityExtraArgs,
+			extraArgs,
 			filepath.Dir(scriptFile),
 			r.Container,
+			checkpointCommand(conf, r),
 			shell,
 			filepath.Base(scriptFile)))
+	} else if cleanEnv {
+		content.WriteString(fmt.Sprintf("env -i %s %s%s %s", cleanEnvArgs(conf, r), checkpointCommand(conf, r), shell, scriptFile))
 	} else {
-		content.WriteString(fmt.Sprintf("%s %s", shell, scriptFile))
+		content.WriteString(fmt.Sprintf("%s%s %s", checkpointCommand(conf, r), shell, scriptFile))
 	}
+	content.WriteString(stdinRedirect(j, filepath.Dir(jobFile)))
+
+	content.WriteString("\nstatus=$?\n")
+	content.WriteString(pipeEpilogue(j))
+	if r.Checkpoint != "" {
+		content.WriteString(checkpointEpilogue())
+	}
+	if r.Scratch {
+		_, outputs := scratchPaths(j)
+		content.WriteString(scratchEpilogue(outputs))
+	} else if r.Isolated {
+		_, outputs := scratchPaths(j)
+		content.WriteString(isolatedEpilogue(outputs))
+	}
+	content.WriteString(fmt.Sprintf("echo $status >%s\n", exitCodeFile(filepath.Dir(jobFile))))
+	content.WriteString("exit $status\n")
 
 	if err := ioutil.WriteFile(jobFile, []byte(content.String()), 0664); err != nil {
 		return fmt.Errorf("failed to write job script content: %v", err)
@@ -460,6 +1498,113 @@ env | sort
 	return nil
 }
 
+// moduleLoad returns the shell commands that load modules. The module
+// command is only defined in login shells, so the first of modules_init that
+// exists is sourced to define it if needed.
+func moduleLoad(conf *Config, modules []string) string {
+	if len(modules) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("if ! type module >/dev/null 2>&1; then\n")
+	b.WriteString(fmt.Sprintf("  for f in %s; do\n", strings.Join(quoteAll(conf.GetStringSlice("modules_init")), " ")))
+	b.WriteString("    if [ -f \"$f\" ]; then . \"$f\"; break; fi\n  done\nfi\n")
+	b.WriteString(fmt.Sprintf("module load %s || exit 1\n", strings.Join(quoteAll(modules), " ")))
+	return b.String()
+}
+
+func quoteAll(xs []string) []string {
+	ys := []string{}
+	for _, x := range xs {
+		ys = append(ys, shellQuote(x))
+	}
+	return ys
+}
+
+// taskEnv returns the variables that tell the command of j about itself: the
+// name of its task, the run, which attempt at running it this is, and the
+// CPUs and memory, in MB, it was given.
+func taskEnv(conf *Config, j *job) map[string]string {
+	return map[string]string{
+		"FLOW_TASK_NAME": j.Cmd.AnalysisName(),
+		"FLOW_RUN_ID":    conf.currentRunID(),
+		"FLOW_ATTEMPT":   strconv.Itoa(j.attempt()),
+		"FLOW_CPUS":      strconv.Itoa(j.resources.CPUs),
+		"FLOW_MEMORY_MB": strconv.Itoa(j.resources.Memory * 1024),
+	}
+}
+
+// attempt returns which attempt at running j this is, counting from 1: it
+// is resubmitted when it is lost, preempted, its node fails or it runs out
+// of time.
+func (j *job) attempt() int {
+	return 1 + j.lostCount + j.preemptions + j.nodeFailures + j.timeouts
+}
+
+// envExports returns the shell commands that export env. Singularity passes
+// SINGULARITYENV_ prefixed variables into the container whatever its
+// environment options, so they are exported for containers too.
+func envExports(env map[string]string, container bool) string {
+	keys := []string{}
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(env[k]))
+		if container {
+			fmt.Fprintf(&b, "export SINGULARITYENV_%s=%s\n", k, shellQuote(env[k]))
+		}
+	}
+	return b.String()
+}
+
+// passthroughExports returns the shell commands that pass the host variables
+// allowed by env_passthrough into a container run with --cleanenv.
+func passthroughExports(conf *Config) string {
+	var b strings.Builder
+	for _, k := range conf.GetStringSlice("env_passthrough") {
+		fmt.Fprintf(&b, "[ -n \"${%s+x}\" ] && export SINGULARITYENV_%s=\"$%s\"\n", k, k, k)
+	}
+	return b.String()
+}
+
+// cleanEnvArgs returns the arguments to env -i that set the variables of a
+// task run without a container: those allowed by env_passthrough, PATH, as
+// nothing could be found without it, LD_LIBRARY_PATH if modules are loaded,
+// secrets, and the environment of the task.
+func cleanEnvArgs(conf *Config, r Resources) string {
+	env := r.Env
+	args := []string{}
+	seen := make(map[string]bool)
+	passed := append([]string{"PATH"}, conf.GetStringSlice("env_passthrough")...)
+	if len(r.Modules) > 0 {
+		passed = append(passed, "LD_LIBRARY_PATH")
+	}
+	if r.Tmpfs > 0 {
+		passed = append(passed, "TMPDIR")
+	}
+	if r.Checkpoint != "" {
+		passed = append(passed, "FLOW_CHECKPOINT_DIR")
+	}
+	for _, k := range append(passed, r.Secrets...) {
+		if _, ok := env[k]; !ok && !seen[k] {
+			args = append(args, fmt.Sprintf("${%s+%s=\"$%s\"}", k, k, k))
+			seen[k] = true
+		}
+	}
+	keys := []string{}
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, k+"="+shellQuote(env[k]))
+	}
+	return strings.Join(args, " ")
+}
+
 func unique(xs []string) []string {
 	m := make(map[string]bool)
 	for _, x := range xs {
@@ -472,31 +1617,80 @@ func unique(xs []string) []string {
 	return ys
 }
 
+// scriptInterpreter returns the interpreter named by the shebang line of the
+// script, or bash if it has none. The script is run with it explicitly so it
+// need not be executable, or even on a file system that allows it.
+func scriptInterpreter(scriptFile string) string {
+	f, err := os.Open(scriptFile)
+	if err != nil {
+		return "/bin/bash"
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	if !strings.HasPrefix(line, "#!") || strings.TrimSpace(line[2:]) == "" {
+		return "/bin/bash"
+	}
+	return strings.TrimSpace(line[2:])
+}
+
 func createScriptFile(scriptFile string, j *job) error {
-	content := j.Command()
+	var content string
+	if r := j.resources; r.Scratch {
+		inputs, outputs := scratchPaths(j)
+		withPaths(j.Cmd, inputs, outputs, func() { content = j.Command() })
+	} else if r.Isolated {
+		// Inputs are read in place, only outputs are written to the
+		// work directory.
+		_, outputs := scratchPaths(j)
+		withPaths(j.Cmd, nil, outputs, func() { content = j.Command() })
+	} else {
+		content = j.Command()
+	}
 	if err := ioutil.WriteFile(scriptFile, []byte(content+"\n"), 0664); err != nil {
 		return fmt.Errorf("failed to write script content: %v", err)
 	}
 	return nil
 }
 
-// Return the value (i.e., the path) of all input fields.
-func cmdTag(c Commander, t string) []string {
+// parseTypeTag splits a type struct tag, such as "output,optional", into its
+// kind ("input" or "output") and the set of modifiers that follow it.
+func parseTypeTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	modifiers := make(map[string]bool)
+	for _, m := range parts[1:] {
+		modifiers[strings.TrimSpace(m)] = true
+	}
+	return strings.TrimSpace(parts[0]), modifiers
+}
+
+// Return the value (i.e., the path) of all fields of kind t. If modifier is
+// not empty, only fields that also carry that modifier are returned.
+func cmdTag(c Commander, t string, modifier string) []string {
 	inputs := []string{}
-	v := reflect.ValueOf(c).Elem()
+	v := taskValue(c)
 	for i := 0; i < v.NumField(); i++ {
-		tag := v.Type().Field(i).Tag.Get("type")
-		if tag == t {
-			val := v.Field(i)
-			switch val.Kind() {
-			case reflect.String:
-				inputs = append(inputs, val.String())
-			case reflect.Slice:
-				if val.Type().Elem().Name() == "string" {
-					for j := 0; j < val.Len(); j++ {
-						inputs = append(inputs, val.Index(j).String())
-					}
-				}
+		kind, modifiers := parseTypeTag(v.Type().Field(i).Tag.Get("type"))
+		if kind != t {
+			continue
+		}
+		val := v.Field(i)
+		values := []reflect.Value{}
+		switch {
+		case isPathType(val.Type()):
+			values = append(values, val)
+		case val.Kind() == reflect.Slice && isPathType(val.Type().Elem()):
+			for j := 0; j < val.Len(); j++ {
+				values = append(values, val.Index(j))
+			}
+		}
+		for _, x := range values {
+			// Flags set on a File are equivalent to tag modifiers.
+			fileFlag := false
+			if x.Type() == fileType && x.CanInterface() {
+				fileFlag = x.Interface().(File).modifiers()[modifier]
+			}
+			if modifier == "" || modifiers[modifier] || fileFlag {
+				inputs = append(inputs, pathValue(x).String())
 			}
 		}
 	}
@@ -504,12 +1698,23 @@ func cmdTag(c Commander, t string) []string {
 }
 
 func cmdInputs(c Commander) []string {
-	return cmdTag(c, "input")
+	return cmdTag(c, "input", "")
 }
 
 // Return the value (i.e., the path) of all output fields.
 func cmdOutputs(c Commander) []string {
-	return cmdTag(c, "output")
+	return cmdTag(c, "output", "")
+}
+
+// Return the paths of all inputs and outputs marked optional.
+func cmdOptional(c Commander) map[string]bool {
+	optional := make(map[string]bool)
+	for _, kind := range []string{"input", "output"} {
+		for _, p := range cmdTag(c, kind, "optional") {
+			optional[p] = true
+		}
+	}
+	return optional
 }
 
 func hasIntersection(list1, list2 []string) bool {
@@ -525,7 +1730,7 @@ func hasIntersection(list1, list2 []string) bool {
 			if j == "" {
 				continue
 			}
-			if i == j {
+			if pathsMatch(i, j) {
 				return true
 			}
 		}
@@ -533,6 +1738,128 @@ func hasIntersection(list1, list2 []string) bool {
 	return false
 }
 
+// isGlob reports whether the path contains any of the wildcard characters
+// understood by filepath.Match.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// pathsMatch reports whether two declared paths refer to the same file(s).
+// Either path may be a glob pattern, in which case a concrete path matching
+// the pattern, or an identical pattern, is considered a match.
+func pathsMatch(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if isGlob(a) && !isGlob(b) {
+		ok, _ := filepath.Match(a, b)
+		return ok
+	}
+	if isGlob(b) && !isGlob(a) {
+		ok, _ := filepath.Match(b, a)
+		return ok
+	}
+	return false
+}
+
+// expandOutputs replaces any glob pattern in the job's outputs with the files
+// that match it and checks that every output was created. It is called once
+// the job has completed, as the files produced can not be known in advance.
+// Optional outputs that were not created are dropped rather than treated as
+// an error.
+func expandOutputs(j *job) error {
+	outputs := []string{}
+	for _, o := range j.Outputs {
+		if o == "" {
+			continue
+		}
+		if !isGlob(o) {
+			ok, err := fileExists(o)
+			if err != nil {
+				return fmt.Errorf("unable to determine if output exists: %s: %v", o, err)
+			}
+			if ok {
+				outputs = append(outputs, o)
+			} else if !j.optional[o] {
+				return fmt.Errorf("output was not created: %s", o)
+			}
+			continue
+		}
+		matches, err := filepath.Glob(o)
+		if err != nil {
+			return fmt.Errorf("invalid output pattern: %s: %v", o, err)
+		}
+		if len(matches) == 0 && !j.optional[o] {
+			return fmt.Errorf("output pattern matched no files: %s", o)
+		}
+		outputs = append(outputs, matches...)
+	}
+	j.Outputs = outputs
+	return nil
+}
+
+// expandInputs replaces any glob pattern in the input fields of the command
+// with the files that match it, so that Command() sees the concrete paths
+// produced by upstream jobs. A pattern in a string field must match exactly
+// one file; in a []string field it is replaced by all matching files.
+func expandInputs(c Commander) error {
+	v := taskValue(c)
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		kind, modifiers := parseTypeTag(t.Field(i).Tag.Get("type"))
+		if kind != "input" {
+			continue
+		}
+		val := v.Field(i)
+		if !val.CanSet() {
+			continue
+		}
+		switch {
+		case isPathType(val.Type()):
+			p := pathValue(val)
+			if !isGlob(p.String()) {
+				continue
+			}
+			matches, err := filepath.Glob(p.String())
+			if err != nil {
+				return fmt.Errorf("invalid input pattern: %s: %v", p.String(), err)
+			}
+			switch {
+			case len(matches) == 0 && modifiers["optional"]:
+				p.SetString("")
+			case len(matches) != 1:
+				return fmt.Errorf("input pattern %s matched %d files, expected 1", p.String(), len(matches))
+			default:
+				p.SetString(matches[0])
+			}
+		case val.Kind() == reflect.Slice && isPathType(val.Type().Elem()):
+			expanded := reflect.MakeSlice(val.Type(), 0, val.Len())
+			for j := 0; j < val.Len(); j++ {
+				elem := val.Index(j)
+				p := pathValue(elem).String()
+				if !isGlob(p) {
+					expanded = reflect.Append(expanded, elem)
+					continue
+				}
+				matches, err := filepath.Glob(p)
+				if err != nil {
+					return fmt.Errorf("invalid input pattern: %s: %v", p, err)
+				}
+				// Each match keeps any metadata attached to the
+				// pattern.
+				for _, m := range matches {
+					x := reflect.New(elem.Type()).Elem()
+					x.Set(elem)
+					pathValue(x).SetString(m)
+					expanded = reflect.Append(expanded, x)
+				}
+			}
+			val.Set(expanded)
+		}
+	}
+	return nil
+}
+
 type resourcesUsed struct {
 	CPUPercent      int
 	MemoryUsed      int
@@ -545,7 +1872,7 @@ type resourcesUsed struct {
 }
 
 func displayJob(j *job) error {
-	r := j.Cmd.Resources()
+	r := j.resources
 	c := []string{}
 	for _, line := range strings.Split(j.Command(), "\n") {
 		c = append(c, fmt.Sprintf("  %s", line))
@@ -561,6 +1888,7 @@ func displayJob(j *job) error {
 %s: %s
 %s: %s
 %s: %s
+%s: %s
 %s:
 %s
 %s:
@@ -574,6 +1902,7 @@ func displayJob(j *job) error {
 		bold("DoneFile"), j.doneFile,
 		bold("Container"), r.Container,
 		bold("Extra Args"), r.SingularityExtraArgs,
+		bold("Work Dir"), j.workDir,
 		bold("Script"), indentedCmd,
 		bold("Batch Command"), j.BatchCommand)
 	return nil
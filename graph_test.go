@@ -1,9 +1,16 @@
 package flow
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/google/uuid"
 )
 
 func Test_fileExists(t *testing.T) {
@@ -35,3 +42,712 @@ func Test_fileExists(t *testing.T) {
 		})
 	}
 }
+
+func Test_pathsMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{"identical", "/a/b.txt", "/a/b.txt", true},
+		{"different", "/a/b.txt", "/a/c.txt", false},
+		{"pattern_left", "/a/*.vcf.gz", "/a/x.vcf.gz", true},
+		{"pattern_right", "/a/x.vcf.gz", "/a/*.vcf.gz", true},
+		{"pattern_no_match", "/a/*.vcf.gz", "/b/x.vcf.gz", false},
+		{"identical_patterns", "/a/*.bam", "/a/*.bam", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathsMatch(tt.a, tt.b); got != tt.want {
+				t.Errorf("pathsMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseTypeTag(t *testing.T) {
+	tests := []struct {
+		tag          string
+		wantKind     string
+		wantOptional bool
+	}{
+		{"input", "input", false},
+		{"output,optional", "output", true},
+		{"input, optional", "input", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			kind, modifiers := parseTypeTag(tt.tag)
+			if kind != tt.wantKind {
+				t.Errorf("parseTypeTag() kind = %v, want %v", kind, tt.wantKind)
+			}
+			if modifiers["optional"] != tt.wantOptional {
+				t.Errorf("parseTypeTag() optional = %v, want %v", modifiers["optional"], tt.wantOptional)
+			}
+		})
+	}
+}
+
+type fileTask struct {
+	Task
+	Ref     File     `type:"input"`
+	Reads   []File   `type:"input"`
+	Index   string   `type:"output,optional"`
+	Outputs []string `type:"output"`
+	Scratch File     `type:"output"`
+}
+
+func (t *fileTask) Command() string { return "" }
+
+func Test_cmdTag(t *testing.T) {
+	c := &fileTask{
+		Ref:     File{Path: "/ref.fa"},
+		Reads:   []File{{Path: "/r1.fq"}, {Path: "/r2.fq"}},
+		Index:   "/out.bai",
+		Outputs: []string{"/out.bam"},
+		Scratch: File{Path: "/tmp.bam", Temp: true},
+	}
+	tests := []struct {
+		name     string
+		kind     string
+		modifier string
+		want     []string
+	}{
+		{"inputs", "input", "", []string{"/ref.fa", "/r1.fq", "/r2.fq"}},
+		{"outputs", "output", "", []string{"/out.bai", "/out.bam", "/tmp.bam"}},
+		{"optional", "output", "optional", []string{"/out.bai"}},
+		{"temp_file", "output", "temp", []string{"/tmp.bam"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cmdTag(c, tt.kind, tt.modifier); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cmdTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withDependencies(t *testing.T) {
+	a := &job{Outputs: []string{"/a.txt"}}
+	b := &job{Inputs: []string{"/a.txt"}, Outputs: []string{"/b.txt"}}
+	c := &job{Inputs: []string{"/b.txt"}, Outputs: []string{"/c.txt"}}
+	d := &job{Outputs: []string{"/d.txt"}}
+	jobs := []*job{a, b, c, d}
+	for _, j := range jobs {
+		j.UUID = uuid.New()
+	}
+	for _, j := range jobs {
+		j.Dependencies = dependenciesFor(j, jobs)
+	}
+	tests := []struct {
+		name     string
+		selected []*job
+		want     []*job
+	}{
+		{"leaf", []*job{a}, []*job{a}},
+		{"chain", []*job{c}, []*job{a, b, c}},
+		{"independent", []*job{d, b}, []*job{a, b, d}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withDependencies(tt.selected, jobs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resourcesFor(t *testing.T) {
+	defer defaultConfig.Set("selectors", nil)
+	defaultConfig.Set("selectors", []interface{}{
+		map[interface{}]interface{}{"withName": "bwa_*", "memory": 32},
+		map[interface{}]interface{}{"withLabel": "align", "cpus": 16, "memory": 8},
+		map[interface{}]interface{}{"withName": "gatk", "env": map[interface{}]interface{}{"JAVA_OPTS": "-Xmx4g"}},
+	})
+	tests := []struct {
+		name string
+		task Task
+		want Resources
+	}{
+		{"none", Task{Name: "sort", CPUs: 1, Memory: 1, Time: 1}, Resources{CPUs: 1, Memory: 1, Time: 1}},
+		{"label", Task{Name: "star", CPUs: 1, Memory: 1, Time: 1, Labels: []string{"align"}}, Resources{CPUs: 16, Memory: 8, Time: 1, Labels: []string{"align"}}},
+		{"name_wins", Task{Name: "bwa_mem", CPUs: 1, Memory: 1, Time: 1, Labels: []string{"align"}}, Resources{CPUs: 16, Memory: 32, Time: 1, Labels: []string{"align"}}},
+		{"env", Task{Name: "gatk", CPUs: 1, Memory: 1, Time: 1, Env: map[string]string{"TMPDIR": "/scratch", "JAVA_OPTS": "-Xmx1g"}}, Resources{CPUs: 1, Memory: 1, Time: 1, Env: map[string]string{"TMPDIR": "/scratch", "JAVA_OPTS": "-Xmx4g"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resourcesFor(defaultConfig, &fileTask{Task: tt.task})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resourcesFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type renderTask struct {
+	Task
+	Input  File     `type:"input"`
+	Others []string `type:"input"`
+	Output string   `type:"output"`
+}
+
+func (t renderTask) Command() string {
+	return Render(t, "sort --parallel {{.Resources.CPUs}} {{.Input}} {{join .Others \" \"}} >{{quote .Output}} # {{.Name}}")
+}
+
+func Test_Render(t *testing.T) {
+	task := renderTask{
+		Task:   Task{Name: "sort", CPUs: 4},
+		Input:  File{Path: "/a.txt"},
+		Others: []string{"/b.txt", "/c.txt"},
+		Output: "/my out.txt",
+	}
+	want := "sort --parallel 4 /a.txt /b.txt /c.txt >'/my out.txt' # sort"
+	if got := task.Command(); got != want {
+		t.Errorf("Command() = %v, want %v", got, want)
+	}
+}
+
+func Test_Render_config(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewConfig("", map[string]interface{}{
+		"flowdir": filepath.Join(dir, ".flow"),
+		"tmpdir":  filepath.Join(dir, "tmp"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("selectors", []interface{}{
+		map[string]interface{}{"withName": "sort", "cpus": 8},
+	})
+	task := &renderTask{Task: Task{Name: "sort", CPUs: 4}, Input: File{Path: "/a.txt"}, Output: "/out.txt"}
+	if err := freezeTask(c, task); err != nil {
+		t.Fatal(err)
+	}
+	want := "sort --parallel 8 /a.txt  >/out.txt # sort"
+	if got := task.Command(); got != want {
+		t.Errorf("Command() = %v, want %v", got, want)
+	}
+	if got := c.Render(renderTask{Task: Task{Name: "sort"}}, "{{.Resources.CPUs}}"); got != "8" {
+		t.Errorf("Config.Render() = %v, want 8", got)
+	}
+}
+
+func Test_ShellTask(t *testing.T) {
+	task := &ShellTask{
+		Name:    "compress",
+		Cmd:     "gzip -c {{index .Inputs 0}} >{{index .Outputs 0}}",
+		Inputs:  []string{"/out.txt"},
+		Outputs: []string{"/out.txt.gz"},
+		Res:     Resources{CPUs: 2},
+	}
+	if got, want := task.Command(), "gzip -c /out.txt >/out.txt.gz"; got != want {
+		t.Errorf("Command() = %v, want %v", got, want)
+	}
+	if got := task.Resources(); got.CPUs != 2 || got.Memory != 16 {
+		t.Errorf("Resources() = %+v", got)
+	}
+	if got := cmdOutputs(task); !reflect.DeepEqual(got, []string{"/out.txt.gz"}) {
+		t.Errorf("cmdOutputs() = %v", got)
+	}
+}
+
+func Test_ScriptTask(t *testing.T) {
+	task := &ScriptTask{
+		Interpreter: "python3",
+		Script: `
+			with open("{{index .Inputs 0}}") as f:
+			    print(len(f.readlines()))
+		`,
+		Inputs:  []string{"/reads.txt"},
+		Outputs: []string{"/count.txt"},
+	}
+	want := "#!/usr/bin/env python3\nwith open(\"/reads.txt\") as f:\n    print(len(f.readlines()))"
+	if got := task.Command(); got != want {
+		t.Errorf("Command() = %q, want %q", got, want)
+	}
+	dir, err := ioutil.TempDir("", "flow-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "script.sh")
+	if err := ioutil.WriteFile(fn, []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := scriptInterpreter(fn); got != "/usr/bin/env python3" {
+		t.Errorf("scriptInterpreter() = %v", got)
+	}
+	if err := ioutil.WriteFile(fn, []byte("echo hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := scriptInterpreter(fn); got != "/bin/bash" {
+		t.Errorf("scriptInterpreter() = %v", got)
+	}
+}
+
+func Test_envExports(t *testing.T) {
+	env := map[string]string{"TMPDIR": "/scratch", "JAVA_OPTS": "-Xmx4g -Xss2m"}
+	tests := []struct {
+		name      string
+		container bool
+		want      string
+	}{
+		{"host", false, "export JAVA_OPTS='-Xmx4g -Xss2m'\nexport TMPDIR=/scratch\n"},
+		{"container", true, "export JAVA_OPTS='-Xmx4g -Xss2m'\nexport SINGULARITYENV_JAVA_OPTS='-Xmx4g -Xss2m'\nexport TMPDIR=/scratch\nexport SINGULARITYENV_TMPDIR=/scratch\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envExports(env, tt.container); got != tt.want {
+				t.Errorf("envExports() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_taskEnv(t *testing.T) {
+	defer func(id string) { defaultConfig.runID = id }(defaultConfig.runID)
+	defaultConfig.runID = "2026-01-01_000000_abcd1234"
+	j := &job{
+		Cmd:         &fileTask{Task: Task{Name: "align"}},
+		resources:   Resources{CPUs: 8, Memory: 16},
+		preemptions: 1,
+		timeouts:    1,
+	}
+	want := "export FLOW_ATTEMPT=3\nexport FLOW_CPUS=8\nexport FLOW_MEMORY_MB=16384\nexport FLOW_RUN_ID=2026-01-01_000000_abcd1234\nexport FLOW_TASK_NAME=align\n"
+	if got := envExports(taskEnv(defaultConfig, j), false); got != want {
+		t.Errorf("envExports(taskEnv()) = %q, want %q", got, want)
+	}
+}
+
+func Test_cleanEnvArgs(t *testing.T) {
+	defer defaultConfig.Set("env_passthrough", nil)
+	defaultConfig.Set("env_passthrough", []string{"HOME", "PATH"})
+	got := cleanEnvArgs(defaultConfig, Resources{
+		Env:     map[string]string{"TMPDIR": "/my scratch", "HOME": "/home/x"},
+		Secrets: []string{"TOKEN"},
+	})
+	want := `${PATH+PATH="$PATH"} ${TOKEN+TOKEN="$TOKEN"} HOME=/home/x TMPDIR='/my scratch'`
+	if got != want {
+		t.Errorf("cleanEnvArgs() = %v, want %v", got, want)
+	}
+}
+
+func Test_moduleLoad(t *testing.T) {
+	defer defaultConfig.Set("modules_init", nil)
+	defaultConfig.Set("modules_init", []string{"/etc/profile.d/lmod.sh"})
+	if got := moduleLoad(defaultConfig, nil); got != "" {
+		t.Errorf("moduleLoad() = %q, want \"\"", got)
+	}
+	want := `if ! type module >/dev/null 2>&1; then
+  for f in /etc/profile.d/lmod.sh; do
+    if [ -f "$f" ]; then . "$f"; break; fi
+  done
+fi
+module load bwa/0.7.17 samtools/1.19 || exit 1
+`
+	if got := moduleLoad(defaultConfig, []string{"bwa/0.7.17", "samtools/1.19"}); got != want {
+		t.Errorf("moduleLoad() = %q, want %q", got, want)
+	}
+}
+
+func Test_applyProfile(t *testing.T) {
+	defer defaultConfig.Set("profiles", nil)
+	defer defaultConfig.Set("job_runner", defaultConfig.GetString("job_runner"))
+	defaultConfig.Set("profiles", map[string]interface{}{
+		"cluster": map[string]interface{}{"job_runner": "slurm"},
+	})
+	if err := defaultConfig.applyProfile("cluster"); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultConfig.GetString("job_runner"); got != "slurm" {
+		t.Errorf("job_runner = %v, want slurm", got)
+	}
+	if err := defaultConfig.applyProfile("cloud"); err == nil {
+		t.Errorf("applyProfile() of an undefined profile should fail")
+	}
+}
+
+type failingRunner struct{ DummyRunner }
+
+func (r failingRunner) Run(ctx executionContext) error {
+	return fmt.Errorf("no scheduler")
+}
+
+func Test_submitPending(t *testing.T) {
+	dir := t.TempDir()
+	defer defaultConfig.Set("flowdir", defaultConfig.GetString("flowdir"))
+	defer defaultConfig.Set("keep_going", false)
+	defaultConfig.Set("flowdir", filepath.Join(dir, ".flow"))
+	newJob := func(name string) *job {
+		out := filepath.Join(dir, name+".txt")
+		return &job{
+			Cmd:     &fileTask{Task: Task{Name: name}, Outputs: []string{out}},
+			UUID:    uuid.New(),
+			Outputs: []string{out},
+		}
+	}
+	failed, blocked, independent := newJob("a"), newJob("b"), newJob("c")
+	failed.hasCompleted = true
+	blocked.Dependencies = []*job{failed}
+	tests := []struct {
+		name       string
+		keepGoing  bool
+		wantFailed int
+	}{
+		{"fail_fast", false, 1},
+		{"keep_going", true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("keep_going", tt.keepGoing)
+			independent.hasCompleted = false
+			g := graph{config: defaultConfig, pending: []*job{blocked, independent}, failed: []*job{failed}}
+			n, err := g.submitPending(failingRunner{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 0 || len(g.failed) != tt.wantFailed {
+				t.Errorf("submitPending() = %d, %d failed, want 0, %d failed", n, len(g.failed), tt.wantFailed)
+			}
+		})
+	}
+}
+
+func Test_submitPending_allowFailure(t *testing.T) {
+	dir := t.TempDir()
+	defer defaultConfig.Set("flowdir", defaultConfig.GetString("flowdir"))
+	defaultConfig.Set("flowdir", filepath.Join(dir, ".flow"))
+	out := filepath.Join(dir, "qc.txt")
+	qc := &job{
+		Cmd:       &fileTask{Task: Task{Name: "qc"}, Outputs: []string{out}},
+		UUID:      uuid.New(),
+		Outputs:   []string{out},
+		resources: Resources{AllowFailure: true},
+	}
+	g := graph{config: defaultConfig, pending: []*job{qc}}
+	if _, err := g.submitPending(failingRunner{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.failed) != 0 || len(g.allowedFailed) != 1 || len(g.pending) != 0 {
+		t.Errorf("submitPending() left %d failed, %d allowed, %d pending, want 0, 1, 0", len(g.failed), len(g.allowedFailed), len(g.pending))
+	}
+}
+
+func Test_checkDuplicateOutputs(t *testing.T) {
+	a := &job{Cmd: &fileTask{Task: Task{Name: "a"}}, Outputs: []string{"/a.txt", "/shared.txt"}}
+	b := &job{Cmd: &fileTask{Task: Task{Name: "b"}}, Outputs: []string{"/b.txt"}}
+	c := &job{Cmd: &fileTask{Task: Task{Name: "c"}}, Outputs: []string{"/shared.txt"}}
+	if err := checkDuplicateOutputs([]*job{a, b}); err != nil {
+		t.Errorf("checkDuplicateOutputs() = %v, want nil", err)
+	}
+	err := checkDuplicateOutputs([]*job{a, b, c})
+	if err == nil || !strings.Contains(err.Error(), "/shared.txt is an output of both a and c") {
+		t.Errorf("checkDuplicateOutputs() = %v, want an error naming a and c", err)
+	}
+}
+
+func Test_dedupJobs(t *testing.T) {
+	newJob := func(name, cmd string, outputs ...string) *job {
+		return &job{Cmd: &ShellTask{Name: name, Cmd: cmd}, Inputs: []string{"/in.txt"}, Outputs: outputs}
+	}
+	a := newJob("sort_1", "sort /in.txt", "/out.txt")
+	b := newJob("sort_2", "sort /in.txt", "/out.txt")
+	c := newJob("sort_3", "sort -u /in.txt", "/out.txt")
+	d := newJob("sort_4", "sort /in.txt", "/other.txt")
+	got := dedupJobs([]*job{a, b, c, d})
+	if want := []*job{a, c, d}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dedupJobs() kept %d jobs, want %d", len(got), len(want))
+	}
+}
+
+func Test_findCycle(t *testing.T) {
+	newJob := func(name string, inputs, outputs []string) *job {
+		return &job{Cmd: &fileTask{Task: Task{Name: name}}, UUID: uuid.New(), Inputs: inputs, Outputs: outputs}
+	}
+	a := newJob("a", []string{"/c.txt"}, []string{"/a.txt"})
+	b := newJob("b", []string{"/a.txt"}, []string{"/b.txt"})
+	c := newJob("c", []string{"/b.txt"}, []string{"/c.txt"})
+	d := newJob("d", []string{"/a.txt"}, []string{"/d.txt"})
+	tests := []struct {
+		name string
+		jobs []*job
+		want string
+	}{
+		{"none", []*job{b, d}, ""},
+		{"cycle", []*job{d, a, b, c}, "b → /b.txt → c → /c.txt → a → /a.txt → b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, j := range tt.jobs {
+				j.Dependencies = dependenciesFor(j, tt.jobs)
+			}
+			got := ""
+			if cycle := findCycle(tt.jobs); cycle != nil {
+				got = describeCycle(cycle)
+			}
+			if got != tt.want {
+				t.Errorf("findCycle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_submitOrder(t *testing.T) {
+	defer defaultConfig.Set("stable_order", false)
+	newJob := func(name, output string, deps ...*job) *job {
+		return &job{Cmd: &fileTask{Task: Task{Name: name}}, UUID: uuid.New(), Outputs: []string{output}, Dependencies: deps}
+	}
+	a := newJob("a", "/a.txt")
+	b2 := newJob("b", "/b2.txt")
+	b1 := newJob("b", "/b1.txt")
+	c := newJob("c", "/c.txt", a)
+	d := newJob("d", "/d.txt", c)
+	jobs := []*job{d, c, b2, a, b1}
+	tests := []struct {
+		name   string
+		stable bool
+		want   []*job
+	}{
+		{"added", false, []*job{d, c, b2, a, b1}},
+		{"stable", true, []*job{a, b1, b2, c, d}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("stable_order", tt.stable)
+			got := submitOrder(defaultConfig, jobs)
+			if !reflect.DeepEqual(got, tt.want) {
+				names := []string{}
+				for _, j := range got {
+					names = append(names, j.Outputs[0])
+				}
+				t.Errorf("submitOrder() = %v", names)
+			}
+		})
+	}
+}
+
+type preemptingRunner struct{ DummyRunner }
+
+func (r preemptingRunner) Preempted(j *job) (bool, error) { return true, nil }
+
+func Test_preempted(t *testing.T) {
+	defer defaultConfig.Set("preempt_resubmits", defaultConfig.Get("preempt_resubmits"))
+	defer defaultConfig.Set("preempt_fallback_after", defaultConfig.Get("preempt_fallback_after"))
+	defer defaultConfig.Set("preempt_fallback", nil)
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defaultConfig.Set("flowdir", t.TempDir())
+	defaultConfig.Set("preempt_resubmits", 2)
+	defaultConfig.Set("preempt_fallback_after", 2)
+	defaultConfig.Set("preempt_fallback", map[string]interface{}{"queue": "ondemand"})
+	j := &job{Cmd: &fileTask{Task: Task{Name: "a"}}, UUID: uuid.New(), resources: Resources{Queue: "spot"}}
+	g := graph{config: defaultConfig}
+	if g.preempted(DummyRunner{}, j) {
+		t.Fatalf("preempted() = true for a runner that cannot preempt")
+	}
+	wantQueues := []string{"spot", "ondemand"}
+	for i, want := range wantQueues {
+		g.running = []*job{j}
+		g.pending = nil
+		if !g.preempted(preemptingRunner{}, j) || len(g.pending) != 1 || len(g.running) != 0 {
+			t.Fatalf("preemption %d: job was not resubmitted", i+1)
+		}
+		if j.resources.Queue != want {
+			t.Errorf("preemption %d: queue = %s, want %s", i+1, j.resources.Queue, want)
+		}
+	}
+	g.running = []*job{j}
+	g.pending = nil
+	if !g.preempted(preemptingRunner{}, j) || len(g.failed) != 1 || len(g.pending) != 0 {
+		t.Errorf("job was not failed after %d preemptions", j.preemptions)
+	}
+}
+
+type nodeFailingRunner struct{ DummyRunner }
+
+func (r nodeFailingRunner) NodeFailed(j *job) (bool, error) { return true, nil }
+
+func Test_nodeFailed(t *testing.T) {
+	defer defaultConfig.Set("node_fail_resubmits", defaultConfig.Get("node_fail_resubmits"))
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defaultConfig.Set("node_fail_resubmits", 1)
+	defaultConfig.Set("flowdir", t.TempDir())
+	j := &job{Cmd: &fileTask{Task: Task{Name: "a"}}, UUID: uuid.New(), ID: "42"}
+	g := graph{config: defaultConfig, running: []*job{j}}
+	if g.nodeFailed(DummyRunner{}, j) {
+		t.Fatalf("nodeFailed() = true for a runner that cannot tell")
+	}
+	if !g.nodeFailed(nodeFailingRunner{}, j) || len(g.pending) != 1 || len(g.running) != 0 {
+		t.Fatalf("job was not resubmitted")
+	}
+	g.running = []*job{j}
+	g.pending = nil
+	if !g.nodeFailed(nodeFailingRunner{}, j) || len(g.failed) != 1 || len(g.pending) != 0 {
+		t.Errorf("job was not failed after %d node failures", j.nodeFailures)
+	}
+	runDir, err := RunDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := ioutil.ReadFile(filepath.Join(runDir, "requeues.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(report)), "\n"); len(lines) != 3 || !strings.HasSuffix(lines[1], "\t42\tnode failed") {
+		t.Errorf("requeues.tsv =\n%s", report)
+	}
+}
+
+type timingOutRunner struct{ DummyRunner }
+
+func (r timingOutRunner) TimedOut(j *job) (bool, error) { return true, nil }
+
+func Test_timedOut(t *testing.T) {
+	for _, key := range []string{"timeout_resubmits", "timeout_time_factor", "timeout_max_time", "flowdir"} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+	}
+	defaultConfig.Set("timeout_resubmits", 5)
+	defaultConfig.Set("timeout_time_factor", 1.5)
+	defaultConfig.Set("timeout_max_time", 8)
+	defaultConfig.Set("flowdir", t.TempDir())
+	j := &job{Cmd: &fileTask{Task: Task{Name: "a"}}, UUID: uuid.New(), ID: "42", resources: Resources{Time: 3}}
+	g := graph{config: defaultConfig}
+	for i, want := range []int{5, 8} {
+		g.running = []*job{j}
+		g.pending = nil
+		if !g.timedOut(timingOutRunner{}, j) || len(g.pending) != 1 {
+			t.Fatalf("timeout %d: job was not resubmitted", i+1)
+		}
+		if j.resources.Time != want {
+			t.Errorf("timeout %d: time = %d, want %d", i+1, j.resources.Time, want)
+		}
+	}
+	// It has had the most time it may have.
+	g.running = []*job{j}
+	g.pending = nil
+	if !g.timedOut(timingOutRunner{}, j) || len(g.failed) != 1 || len(g.pending) != 0 {
+		t.Errorf("job was not failed at timeout_max_time")
+	}
+}
+
+func Test_tmpfsPrologue(t *testing.T) {
+	dir := t.TempDir()
+	defer defaultConfig.Set("tmpfs_dir", defaultConfig.Get("tmpfs_dir"))
+	defaultConfig.Set("tmpfs_dir", dir)
+	out, err := exec.Command("bash", "-c", tmpfsPrologue(defaultConfig, 1)+"echo $TMPDIR").CombinedOutput()
+	if err != nil {
+		t.Fatalf("tmpfsPrologue() failed: %v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); filepath.Dir(got) != dir {
+		t.Errorf("TMPDIR = %s, want a directory in %s", got, dir)
+	}
+	out, err = exec.Command("bash", "-c", tmpfsPrologue(defaultConfig, 1<<20)+"echo $TMPDIR").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "less than") {
+		t.Errorf("tmpfsPrologue() should fail without enough space: %s", out)
+	}
+}
+
+func Test_scratchPrologue_quoting(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "my input's.txt")
+	if err := ioutil.WriteFile(in, []byte("hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out $dir")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inputs := map[string]string{in: "in dir/my input's.txt"}
+	outputs := map[string]string{filepath.Join(outDir, "o *.txt"): "out dir/o *.txt"}
+	script := "TMPDIR=" + shellQuote(dir) + "\n" + scratchPrologue(inputs, outputs) +
+		"cp \"in dir/my input's.txt\" \"out dir/o 1.txt\"\nstatus=0\n" + scratchEpilogue(outputs)
+	out, err := exec.Command("bash", "-c", script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("script failed: %v: %s\n%s", err, out, script)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "o 1.txt")); err != nil {
+		t.Errorf("output was not copied back: %v\n%s", err, script)
+	}
+}
+
+type reattachingRunner struct {
+	DummyRunner
+	known map[string]bool
+}
+
+func (r reattachingRunner) Known(j *job) (bool, error) { return r.known[j.ID], nil }
+
+func Test_reattach(t *testing.T) {
+	exists := func(fn string) bool {
+		_, err := os.Stat(fn)
+		return err == nil
+	}
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	dir := t.TempDir()
+	defaultConfig.Set("flowdir", dir)
+	jobs := []*job{}
+	for _, id := range []string{"1", "2"} {
+		j := &job{Cmd: &fileTask{Task: Task{Name: id}}, UUID: uuid.New(), idFile: filepath.Join(dir, "done", id+".jobid")}
+		j.ID = id
+		if err := recordJobID(j); err != nil {
+			t.Fatal(err)
+		}
+		j.ID = ""
+		jobs = append(jobs, j)
+	}
+	g := graph{config: defaultConfig, pending: append([]*job{}, jobs...)}
+	if err := g.reattach(reattachingRunner{known: map[string]bool{"1": true}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.running) != 1 || g.running[0] != jobs[0] || len(g.pending) != 1 || g.pending[0] != jobs[1] {
+		t.Fatalf("reattach() running %v, pending %v", g.running, g.pending)
+	}
+	if j := jobs[0]; j.ID != "1" || j.submitted.IsZero() || j.workDir == "" {
+		t.Errorf("reattached job has ID %q, submitted %v, work directory %q", j.ID, j.submitted, j.workDir)
+	}
+	if j := jobs[1]; j.ID != "" || exists(j.idFile) {
+		t.Errorf("job unknown to the scheduler has ID %q and its ID file was not removed", j.ID)
+	}
+	if err := killRunningJobs(g, DummyRunner{}); err != nil {
+		t.Fatal(err)
+	}
+	if exists(jobs[0].idFile) {
+		t.Errorf("ID file of a killed job was not removed")
+	}
+}
+
+type optionalInputTask struct {
+	Task
+	In  string `type:"input,optional"`
+	Out string `type:"output"`
+}
+
+func (t *optionalInputTask) Command() string { return "true" }
+
+func Test_newGraph_missingInput(t *testing.T) {
+	dir := t.TempDir()
+	conf := newConfig()
+	conf.Set("flowdir", filepath.Join(dir, ".flow"))
+	conf.Set("dry_run", true)
+	tests := []struct {
+		name    string
+		task    Commander
+		wantErr bool
+	}{
+		{"required", &mockTask{Task: Task{Name: "sort"}, In: []string{filepath.Join(dir, "missing.txt")}, Out: filepath.Join(dir, "out.txt")}, true},
+		{"optional", &optionalInputTask{Task: Task{Name: "sort"}, In: filepath.Join(dir, "missing.txt"), Out: filepath.Join(dir, "out.txt")}, false},
+		{"pattern", &mockTask{Task: Task{Name: "sort"}, In: []string{filepath.Join(dir, "*.txt")}, Out: filepath.Join(dir, "out.txt")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newGraph(conf, []Commander{tt.task}); (err != nil) != tt.wantErr {
+				t.Errorf("newGraph() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
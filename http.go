@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// HTTPStorage fetches http, https and ftp URLs using curl (see curl_bin).
+// Downloads are kept in a content addressed cache in the flowdir, so a file is
+// only stored once however many URLs it is fetched from, and is not
+// downloaded again if its sha256 checksum is given and it is already cached.
+type HTTPStorage struct {
+	boundConfig
+}
+
+func (s HTTPStorage) Stat(uri string) (bool, error) {
+	return statCommand(exec.Command(s.conf().GetString("curl_bin"), "-fsIL", "-o", os.DevNull, uri))
+}
+
+func (s HTTPStorage) Fetch(uri, dst string) error {
+	if err := os.MkdirAll(cacheDir(s.conf()), 0755); err != nil {
+		return fmt.Errorf("unable to create cache directory: %v", err)
+	}
+	w, err := ioutil.TempFile(cacheDir(s.conf()), "download")
+	if err != nil {
+		return fmt.Errorf("unable to create download file: %v", err)
+	}
+	w.Close()
+	defer os.Remove(w.Name())
+	if err := runCommand(exec.Command(s.conf().GetString("curl_bin"), "-fsSL", "--retry", "3", "-o", w.Name(), uri)); err != nil {
+		return err
+	}
+	digest, err := fileDigest(w.Name(), "sha256")
+	if err != nil {
+		return err
+	}
+	cached := filepath.Join(cacheDir(s.conf()), digest)
+	if ok, _ := fileExists(cached); !ok {
+		if err := os.Rename(w.Name(), cached); err != nil {
+			return fmt.Errorf("unable to add %s to cache: %v", uri, err)
+		}
+	}
+	return placeFile(cached, dst, "link")
+}
+
+func (s HTTPStorage) Store(src, uri string) error {
+	return errors.New("uploading to http, https or ftp URLs is not supported")
+}
+
+func (s HTTPStorage) Checksum(uri string) (string, error) {
+	return "", nil
+}
+
+func (s HTTPStorage) withConfig(conf *Config) Storage {
+	s.config = conf
+	return s
+}
+
+var _ Storage = HTTPStorage{}
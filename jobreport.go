@@ -10,6 +10,8 @@ import (
 type jobReport struct {
 	w         io.Writer
 	csvWriter *csv.Writer
+	// priced is set if pricing is configured, to fill in the cost column.
+	priced bool
 }
 
 func NewJobreport(w io.Writer) (jobReport, error) {
@@ -26,6 +28,7 @@ func NewJobreport(w io.Writer) (jobReport, error) {
 		"cpus_requested",
 		"memory_requested",
 		"walltime_requested",
+		"cost",
 	}
 	if err := writer.Write(record); err != nil {
 		return jobReport{}, fmt.Errorf("unable to write header: %v", err)
@@ -37,6 +40,10 @@ func NewJobreport(w io.Writer) (jobReport, error) {
 }
 
 func (r jobReport) Add(j *job, ru resourcesUsed) error {
+	cost := ""
+	if r.priced {
+		cost = strconv.FormatFloat(j.cost, 'f', 4, 64)
+	}
 	record := []string{
 		j.ID,
 		j.Cmd.AnalysisName(),
@@ -49,6 +56,7 @@ func (r jobReport) Add(j *job, ru resourcesUsed) error {
 		strconv.Itoa(ru.CPURequested),
 		strconv.Itoa(ru.MemoryRequested),
 		strconv.Itoa(ru.TimeRequested),
+		cost,
 	}
 	return r.csvWriter.Write(record)
 }
@@ -0,0 +1,475 @@
+package flow
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LintProblem is a likely mistake in a workflow found by Lint.
+type LintProblem struct {
+	Pos     token.Position
+	Message string
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Pos, p.Message)
+}
+
+// pathName matches the names of fields that usually hold a path.
+var pathName = regexp.MustCompile(`(?i)(path|paths|file|files|dir|bam|cram|sam|vcf|bcf|bed|fasta|fastq|fa|fq|gtf|gff|log|ref|reference|index|input|inputs|output|outputs)$`)
+
+// templateField matches the fields a template refers to, such as Input in
+// {{.Input}} or {{join .Inputs " "}}.
+var templateField = regexp.MustCompile(`\.([A-Z][A-Za-z0-9_]*)`)
+
+// lintTask is a struct of a Go workflow that defines a task.
+type lintTask struct {
+	name   string
+	fields map[string]*lintField
+	// embedded are the types of the embedded fields.
+	embedded []string
+	// values are the literals assigned to each field of the task anywhere
+	// in the workflow.
+	values map[string][]string
+	// command and recv are the body and receiver of the Command method.
+	command *ast.BlockStmt
+	recv    string
+	// isTask is set for structs that look like tasks: they have type tags,
+	// embed Task or have a Command method.
+	isTask bool
+}
+
+type lintField struct {
+	name string
+	typ  string
+	kind string
+	pos  token.Pos
+}
+
+// Lint statically inspects the task structs of the Go workflow fn, a file or
+// a directory of them, for common mistakes: fields that look like paths but
+// have no type tag, outputs that are also inputs of the same task, commands
+// using paths that are neither inputs nor outputs, and selectors in c
+// matching the name of no task. The workflow is not built or run.
+func (c *Config) Lint(fn string) ([]LintProblem, error) {
+	if isSubmission(fn) {
+		return nil, fmt.Errorf("only Go workflows can be linted, submissions are checked when they are loaded")
+	}
+	files := []string{fn}
+	if info, err := os.Stat(fn); err != nil {
+		return nil, fmt.Errorf("unable to read workflow: %v", err)
+	} else if info.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(fn, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		files = []string{}
+		for _, m := range matches {
+			if !strings.HasSuffix(m, "_test.go") {
+				files = append(files, m)
+			}
+		}
+	}
+	fset := token.NewFileSet()
+	parsed := []*ast.File{}
+	for _, f := range files {
+		file, err := parser.ParseFile(fset, f, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse workflow: %v", err)
+		}
+		parsed = append(parsed, file)
+	}
+	l := &linter{fset: fset, config: c, tasks: map[string]*lintTask{}, names: map[string]bool{}}
+	for _, file := range parsed {
+		l.collectTypes(file)
+	}
+	for _, file := range parsed {
+		l.collectMethods(file)
+	}
+	for _, file := range parsed {
+		l.checkLiterals(file)
+	}
+	for _, t := range l.sortedTasks() {
+		if t.isTask {
+			l.checkFields(t)
+			l.checkCommand(t)
+		}
+	}
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		a, b := l.problems[i].Pos, l.problems[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Line < b.Line
+	})
+	// Problems with the config come after those of the workflow.
+	if err := l.checkSelectors(); err != nil {
+		return nil, err
+	}
+	return l.problems, nil
+}
+
+type linter struct {
+	fset     *token.FileSet
+	config   *Config
+	tasks    map[string]*lintTask
+	problems []LintProblem
+	// names are the analysis names of the tasks that are known statically.
+	// dynamicNames is set if some are only known when the workflow runs.
+	names        map[string]bool
+	dynamicNames bool
+}
+
+func (l *linter) report(pos token.Pos, format string, args ...interface{}) {
+	l.problems = append(l.problems, LintProblem{Pos: l.fset.Position(pos), Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) sortedTasks() []*lintTask {
+	tasks := []*lintTask{}
+	for _, name := range sortedMapKeys(l.tasks) {
+		tasks = append(tasks, l.tasks[name])
+	}
+	return tasks
+}
+
+func (l *linter) collectTypes(file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return true
+		}
+		t := &lintTask{name: spec.Name.Name, fields: map[string]*lintField{}, values: map[string][]string{}}
+		for _, f := range st.Fields.List {
+			typ := types.ExprString(f.Type)
+			if len(f.Names) == 0 {
+				t.embedded = append(t.embedded, strings.TrimPrefix(typ, "*"))
+				if typ == "Task" || typ == "flow.Task" {
+					t.isTask = true
+				}
+				continue
+			}
+			kind := ""
+			if f.Tag != nil {
+				tag, _ := strconv.Unquote(f.Tag.Value)
+				kind, _ = parseTypeTag(reflect.StructTag(tag).Get("type"))
+			}
+			if kind != "" {
+				t.isTask = true
+			}
+			for _, name := range f.Names {
+				t.fields[name.Name] = &lintField{name: name.Name, typ: typ, kind: kind, pos: name.Pos()}
+			}
+		}
+		l.tasks[t.name] = t
+		return true
+	})
+}
+
+func (l *linter) collectMethods(file *ast.File) {
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || len(fn.Recv.List) == 0 || fn.Body == nil {
+			continue
+		}
+		recv := fn.Recv.List[0]
+		t, ok := l.tasks[strings.TrimPrefix(types.ExprString(recv.Type), "*")]
+		if !ok {
+			continue
+		}
+		switch fn.Name.Name {
+		case "Command":
+			t.isTask = true
+			t.command = fn.Body
+			if len(recv.Names) > 0 {
+				t.recv = recv.Names[0].Name
+			}
+		case "AnalysisName":
+			if name, ok := returnedString(fn.Body); ok {
+				l.names[name] = true
+			} else {
+				l.dynamicNames = true
+			}
+		}
+	}
+}
+
+// returnedString returns the string of a function body that only returns a
+// string literal.
+func returnedString(body *ast.BlockStmt) (string, bool) {
+	if len(body.List) != 1 {
+		return "", false
+	}
+	ret, ok := body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return "", false
+	}
+	return stringLit(ret.Results[0])
+}
+
+func stringLit(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// checkLiterals records the values given to the fields of tasks and reports
+// tasks that are given the same path as an input and an output.
+func (l *linter) checkLiterals(file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok || lit.Type == nil {
+			return true
+		}
+		typ := types.ExprString(lit.Type)
+		if typ == "Task" || typ == "flow.Task" {
+			l.taskName(lit)
+			return true
+		}
+		t, ok := l.tasks[typ]
+		if !ok {
+			return true
+		}
+		inputs := map[string]bool{}
+		outputs := map[string]token.Pos{}
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			for _, value := range fieldValues(kv.Value) {
+				if s, ok := stringLit(value); ok {
+					t.values[key.Name] = append(t.values[key.Name], s)
+				}
+				f, ok := t.fields[key.Name]
+				if !ok {
+					continue
+				}
+				expr := types.ExprString(value)
+				if expr == `""` {
+					continue
+				}
+				switch f.kind {
+				case "input":
+					inputs[expr] = true
+				case "output":
+					outputs[expr] = value.Pos()
+				}
+			}
+		}
+		for _, expr := range sortedMapKeys(outputs) {
+			if inputs[expr] {
+				l.report(outputs[expr], "%s is both an input and an output of %s", expr, t.name)
+			}
+		}
+		return true
+	})
+}
+
+// fieldValues returns the expressions of the paths given to a field by e,
+// looking inside slice and File literals.
+func fieldValues(e ast.Expr) []ast.Expr {
+	lit, ok := e.(*ast.CompositeLit)
+	if !ok {
+		return []ast.Expr{e}
+	}
+	if _, ok := lit.Type.(*ast.ArrayType); !ok {
+		// A File, whose type is elided in a slice of them.
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok && types.ExprString(kv.Key) == "Path" {
+				return []ast.Expr{kv.Value}
+			}
+		}
+		return nil
+	}
+	values := []ast.Expr{}
+	for _, elt := range lit.Elts {
+		values = append(values, fieldValues(elt)...)
+	}
+	return values
+}
+
+// taskName records the Name given in a Task literal.
+func (l *linter) taskName(lit *ast.CompositeLit) {
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "Name" {
+			if name, ok := stringLit(kv.Value); ok {
+				l.names[name] = true
+			} else {
+				l.dynamicNames = true
+			}
+		}
+	}
+}
+
+func (l *linter) checkFields(t *lintTask) {
+	for _, name := range sortedMapKeys(t.fields) {
+		f := t.fields[name]
+		if f.kind != "" || !ast.IsExported(f.name) {
+			continue
+		}
+		switch elem := strings.TrimPrefix(f.typ, "[]"); {
+		case elem == "File" || elem == "flow.File":
+			l.report(f.pos, "field %s of %s is a %s but has no type tag", f.name, t.name, f.typ)
+		case elem == "string" && pathName.MatchString(f.name):
+			l.report(f.pos, "field %s of %s looks like a path but has no type tag", f.name, t.name)
+		}
+	}
+}
+
+// checkCommand reports the fields used by the command of a task, directly or
+// in a template given to Render, that are given paths in the workflow but
+// are neither inputs nor outputs, and the fields templates use that the task
+// does not have.
+func (l *linter) checkCommand(t *lintTask) {
+	if t.command == nil {
+		return
+	}
+	known, complete := l.allFields(t, map[string]bool{})
+	used := map[string]token.Pos{}
+	ast.Inspect(t.command, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok && t.recv != "" && x.Name == t.recv {
+				used[n.Sel.Name] = n.Sel.Pos()
+			}
+		case *ast.CallExpr:
+			if name := types.ExprString(n.Fun); (name != "Render" && name != "flow.Render") || len(n.Args) != 2 {
+				return true
+			}
+			tpl, ok := stringLit(n.Args[1])
+			if !ok {
+				return true
+			}
+			for _, m := range templateField.FindAllStringSubmatch(tpl, -1) {
+				if _, ok := used[m[1]]; !ok {
+					used[m[1]] = n.Args[1].Pos()
+				}
+				if complete && !known[m[1]] && m[1] != "Resources" {
+					l.report(n.Args[1].Pos(), "Command of %s uses %s, which is not a field of the task", t.name, m[1])
+				}
+			}
+		}
+		return true
+	})
+	for _, name := range sortedMapKeys(used) {
+		f, ok := t.fields[name]
+		if !ok || f.kind != "" || pathName.MatchString(f.name) {
+			continue
+		}
+		for _, value := range t.values[name] {
+			if looksLikePath(value) {
+				l.report(used[name], "Command of %s uses %s, which is given the path %q but is not an input or output", t.name, name, value)
+				break
+			}
+		}
+	}
+}
+
+// allFields returns the names of the fields of t, including those of embedded
+// structs, and whether all of them are known.
+func (l *linter) allFields(t *lintTask, seen map[string]bool) (map[string]bool, bool) {
+	fields := map[string]bool{}
+	seen[t.name] = true
+	for name := range t.fields {
+		fields[name] = true
+	}
+	complete := true
+	for _, e := range t.embedded {
+		switch {
+		case e == "Task" || e == "flow.Task":
+			tt := reflect.TypeOf(Task{})
+			for i := 0; i < tt.NumField(); i++ {
+				fields[tt.Field(i).Name] = true
+			}
+		case l.tasks[e] != nil && !seen[e]:
+			inner, ok := l.allFields(l.tasks[e], seen)
+			complete = complete && ok
+			for name := range inner {
+				fields[name] = true
+			}
+		default:
+			complete = false
+		}
+	}
+	return fields, complete
+}
+
+// looksLikePath reports whether s is probably a path rather than some other
+// string.
+func looksLikePath(s string) bool {
+	if strings.ContainsAny(s, " \t\n") {
+		return false
+	}
+	ext := filepath.Ext(s)
+	return strings.Contains(s, "/") || (len(ext) > 1 && len(ext) <= 7)
+}
+
+// checkSelectors reports the withName selectors of the config that match no
+// task, when the names of all tasks are known.
+func (l *linter) checkSelectors() error {
+	if l.dynamicNames {
+		return nil
+	}
+	conf := l.config
+	selectors, err := configSelectors(conf)
+	if err != nil {
+		return fmt.Errorf("invalid selectors in config: %v", err)
+	}
+	config := conf.v.ConfigFileUsed()
+	if config == "" {
+		config = "config"
+	}
+	for _, sel := range selectors {
+		pattern, ok := sel["withname"].(string)
+		if !ok {
+			continue
+		}
+		matched := false
+		for name := range l.names {
+			ok, _ := filepath.Match(pattern, name)
+			matched = matched || ok
+		}
+		if !matched {
+			l.problems = append(l.problems, LintProblem{
+				Pos:     token.Position{Filename: config},
+				Message: fmt.Sprintf("selector withName %q matches no task (tasks are named %s)", pattern, strings.Join(sortedMapKeys(l.names), ", ")),
+			})
+		}
+	}
+	return nil
+}
+
+// sortedMapKeys returns the keys of m, a map with string keys, in order.
+func sortedMapKeys(m interface{}) []string {
+	keys := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,86 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const lintWorkflow = `package main
+
+import (
+	"fmt"
+
+	"github.com/jje42/flow"
+)
+
+type Align struct {
+	flow.Task
+	Reads  []flow.File ` + "`type:\"input\"`" + `
+	Ref    flow.File
+	Output string ` + "`type:\"output\"`" + `
+	Log    string
+	Sample string
+}
+
+func (t Align) Command() string {
+	return fmt.Sprintf("bwa mem %s %s >%s 2>%s", t.Ref.Path, t.Sample, t.Output, t.Log)
+}
+
+type Sort struct {
+	Input  string ` + "`type:\"input\"`" + `
+	Output string ` + "`type:\"output\"`" + `
+}
+
+func (t Sort) AnalysisName() string { return "sort" }
+
+func (t Sort) Command() string {
+	return flow.Render(t, "sort {{.Input}} >{{.Ouput}}")
+}
+
+func main() {
+	flow.Main(func(q *flow.Queue) {
+		q.Add(&Align{
+			Task:   flow.Task{Name: "align"},
+			Reads:  []flow.File{{Path: "r1.fq"}},
+			Ref:    flow.File{Path: "ref.fa"},
+			Output: "out.sam",
+			Sample: "samples/s1.txt",
+		})
+		q.Add(&Sort{Input: "out.sam", Output: "out.sam"})
+	})
+}
+`
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "workflow.go")
+	if err := ioutil.WriteFile(fn, []byte(lintWorkflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer defaultConfig.Set("selectors", nil)
+	defaultConfig.Set("selectors", []interface{}{
+		map[string]interface{}{"withName": "align", "cpus": 4},
+		map[string]interface{}{"withName": "Sort", "cpus": 2},
+	})
+	problems, err := defaultConfig.Lint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		fn + `:12:2: field Ref of Align is a flow.File but has no type tag`,
+		fn + `:14:2: field Log of Align looks like a path but has no type tag`,
+		fn + `:19:61: Command of Align uses Sample, which is given the path "samples/s1.txt" but is not an input or output`,
+		fn + `:30:24: Command of Sort uses Ouput, which is not a field of the task`,
+		fn + `:42:41: "out.sam" is both an input and an output of Sort`,
+		`config: selector withName "Sort" matches no task (tasks are named align, sort)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func lockPath(conf *Config) (string, error) {
+	return filepath.Abs(filepath.Join(conf.GetString("flowdir"), "lock"))
+}
+
+// lockFlowdir takes the lock on the flowdir of conf. The lock is a file
+// created with O_EXCL, which unlike flock(2) is atomic on NFS; one left by a
+// process on this host that no longer exists is removed.
+func lockFlowdir(conf *Config) error {
+	fn, err := lockPath(conf)
+	if err != nil {
+		return err
+	}
+	if conf.GetBool("force_unlock") {
+		if err := os.Remove(fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to remove lock: %s: %v", fn, err)
+		}
+	} else if stale, holder := staleLock(fn); stale {
+		log.Printf("Removing stale lock held by %s", holder)
+		os.Remove(fn)
+	}
+	err = createLock(fn)
+	if errors.Is(err, os.ErrExist) {
+		holder, _ := ioutil.ReadFile(fn)
+		return fmt.Errorf("flowdir is in use by another run (%s), use --force-unlock if this lock is stale", strings.TrimSpace(string(holder)))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create lock: %s: %v", fn, err)
+	}
+	conf.mu.Lock()
+	conf.lockFile = fn
+	conf.mu.Unlock()
+	return nil
+}
+
+// createLock creates the lock file fn, recording this process as its holder.
+// The error satisfies errors.Is(err, os.ErrExist) if the lock is held.
+func createLock(fn string) error {
+	w, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	host, _ := os.Hostname()
+	_, err = fmt.Fprintf(w, "%s %d %s\n", host, os.Getpid(), time.Now().Format(time.RFC3339))
+	return err
+}
+
+// unlockFlowdir releases the lock on the flowdir of conf, if it is held.
+func unlockFlowdir(conf *Config) {
+	conf.mu.Lock()
+	defer conf.mu.Unlock()
+	if conf.lockFile == "" {
+		return
+	}
+	if err := os.Remove(conf.lockFile); err != nil {
+		log.Printf("Unable to remove lock: %s: %v", conf.lockFile, err)
+	}
+	conf.lockFile = ""
+}
+
+// staleLock reports whether the lock file was left by a process on this host
+// that is no longer running. It also returns the lock holder.
+func staleLock(fn string) (bool, string) {
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return false, ""
+	}
+	holder := strings.TrimSpace(string(content))
+	fields := strings.Fields(holder)
+	if len(fields) < 2 {
+		return false, holder
+	}
+	host, _ := os.Hostname()
+	if fields[0] != host {
+		return false, holder
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false, holder
+	}
+	// Signal 0 checks whether the process exists without signalling it.
+	err = syscall.Kill(pid, 0)
+	return errors.Is(err, syscall.ESRCH), holder
+}
@@ -0,0 +1,192 @@
+package flow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// MockRun records what running a workflow would do, without running
+// anything. It is made by Queue.MockRun for the unit tests of workflows.
+type MockRun struct {
+	// Jobs are the jobs that were started, in the order they would have
+	// been submitted.
+	Jobs []MockJob
+	// Skipped are the jobs that were not started because a job failed.
+	Skipped []MockJob
+}
+
+// MockJob is a job of a MockRun.
+type MockJob struct {
+	Name      string
+	Command   string
+	Inputs    []string
+	Outputs   []string
+	Resources Resources
+	// Dependencies are the names of the jobs this job depends on.
+	Dependencies []string
+	// Round is the number of jobs that had to complete, one after the
+	// other, before this job could start; jobs that can start at once are
+	// in round 0.
+	Round  int
+	Failed bool
+}
+
+// MockRun runs the workflow in q with a mock executor. Each job is started
+// once the jobs it depends on have completed, as they would be on a real
+// run, but its command is only rendered and recorded, and it completes at
+// once. The jobs whose names match one of the fail patterns (as for
+// filepath.Match) fail. As on a real run, no more jobs are started after a
+// failure, unless keep_going is set or the job is allowed to fail, and the
+// jobs that depend on a failed job are never started. Inputs need not
+// exist, the file system is not used at all.
+func (q *Queue) MockRun(fail ...string) (*MockRun, error) {
+	conf := q.queueConfig()
+	for _, pattern := range fail {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s: %v", pattern, err)
+		}
+	}
+	jobs, errs := staticJobs(conf, q.tasks)
+	if len(errs) > 0 {
+		msgs := []string{}
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return nil, fmt.Errorf("invalid workflow:\n  %s", strings.Join(msgs, "\n  "))
+	}
+	run := &MockRun{}
+	stopped := false
+	pending := jobs
+	for round := 0; len(pending) > 0; round++ {
+		ready, waiting := []*job{}, []*job{}
+		for _, j := range submitOrder(conf, pending) {
+			if j.isRunnable() && !stopped {
+				ready = append(ready, j)
+			} else {
+				waiting = append(waiting, j)
+			}
+		}
+		if len(ready) == 0 {
+			break
+		}
+		for _, j := range ready {
+			m, err := mockJob(j, round)
+			if err != nil {
+				return nil, err
+			}
+			for _, pattern := range fail {
+				if ok, _ := filepath.Match(pattern, m.Name); ok {
+					m.Failed = true
+				}
+			}
+			j.hasCompleted = true
+			j.completedSuccessfully = !m.Failed
+			if m.Failed && !j.resources.AllowFailure && !conf.GetBool("keep_going") {
+				stopped = true
+			}
+			run.Jobs = append(run.Jobs, m)
+		}
+		pending = waiting
+	}
+	for _, j := range pending {
+		m, err := mockJob(j, -1)
+		if err != nil {
+			return nil, err
+		}
+		run.Skipped = append(run.Skipped, m)
+	}
+	return run, nil
+}
+
+func mockJob(j *job, round int) (MockJob, error) {
+	m := MockJob{
+		Name:      j.Cmd.AnalysisName(),
+		Inputs:    j.Inputs,
+		Outputs:   j.Outputs,
+		Resources: j.resources,
+		Round:     round,
+	}
+	for _, d := range j.Dependencies {
+		m.Dependencies = append(m.Dependencies, d.Cmd.AnalysisName())
+	}
+	if round < 0 {
+		return m, nil
+	}
+	var err error
+	if m.Command, err = renderCommand(j.Cmd); err != nil {
+		return m, fmt.Errorf("unable to render command of %s: %v", m.Name, err)
+	}
+	return m, nil
+}
+
+// Job returns the first job of the run, started or skipped, named name.
+func (r *MockRun) Job(name string) (MockJob, bool) {
+	for _, jobs := range [][]MockJob{r.Jobs, r.Skipped} {
+		for _, j := range jobs {
+			if j.Name == name {
+				return j, true
+			}
+		}
+	}
+	return MockJob{}, false
+}
+
+// Ran reports whether a job named name was started.
+func (r *MockRun) Ran(name string) bool {
+	for _, j := range r.Jobs {
+		if j.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the names of the jobs that were started, in order.
+func (r *MockRun) Names() []string {
+	names := []string{}
+	for _, j := range r.Jobs {
+		names = append(names, j.Name)
+	}
+	return names
+}
+
+// DependsOn reports whether a job named a depends, directly or through other
+// jobs, on a job named b.
+func (r *MockRun) DependsOn(a, b string) bool {
+	deps := map[string][]string{}
+	for _, jobs := range [][]MockJob{r.Jobs, r.Skipped} {
+		for _, j := range jobs {
+			deps[j.Name] = append(deps[j.Name], j.Dependencies...)
+		}
+	}
+	seen := map[string]bool{}
+	queue := deps[a]
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == b {
+			return true
+		}
+		if !seen[name] {
+			seen[name] = true
+			queue = append(queue, deps[name]...)
+		}
+	}
+	return false
+}
+
+// Before reports whether a job named a was started in an earlier round than
+// a job named b, that is b was started after a completed.
+func (r *MockRun) Before(a, b string) bool {
+	ja, jb := -1, -1
+	for _, j := range r.Jobs {
+		if j.Name == a && ja < 0 {
+			ja = j.Round
+		}
+		if j.Name == b && jb < 0 {
+			jb = j.Round
+		}
+	}
+	return ja >= 0 && jb >= 0 && ja < jb
+}
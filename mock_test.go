@@ -0,0 +1,87 @@
+package flow
+
+import (
+	"reflect"
+	"testing"
+)
+
+type mockTask struct {
+	Task
+	In  []string `type:"input"`
+	Out string   `type:"output"`
+}
+
+func (t *mockTask) Command() string {
+	return Render(t, `cat {{join .In " "}} >{{.Out}}`)
+}
+
+func TestQueue_MockRun(t *testing.T) {
+	defer defaultConfig.Set("keep_going", false)
+	newQueue := func() *Queue {
+		q := &Queue{}
+		q.Add(
+			&mockTask{Task: Task{Name: "a"}, In: []string{"/data/in.txt"}, Out: "/out/a.txt"},
+			&mockTask{Task: Task{Name: "b"}, In: []string{"/data/in.txt"}, Out: "/out/b.txt"},
+			&mockTask{Task: Task{Name: "c"}, In: []string{"/out/a.txt"}, Out: "/out/c.txt"},
+			&mockTask{Task: Task{Name: "d"}, In: []string{"/out/b.txt", "/out/c.txt"}, Out: "/out/d.txt"},
+		)
+		return q
+	}
+	tests := []struct {
+		name        string
+		keepGoing   bool
+		fail        []string
+		wantRan     []string
+		wantSkipped []string
+	}{
+		{"success", false, nil, []string{"a", "b", "c", "d"}, nil},
+		{"fail_fast", false, []string{"a"}, []string{"a", "b"}, []string{"c", "d"}},
+		{"fail_fast_later", false, []string{"c"}, []string{"a", "b", "c"}, []string{"d"}},
+		{"keep_going", true, []string{"b"}, []string{"a", "b", "c"}, []string{"d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("keep_going", tt.keepGoing)
+			run, err := newQueue().MockRun(tt.fail...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := run.Names(); !reflect.DeepEqual(got, tt.wantRan) {
+				t.Errorf("MockRun() ran %v, want %v", got, tt.wantRan)
+			}
+			skipped := []string{}
+			for _, j := range run.Skipped {
+				skipped = append(skipped, j.Name)
+			}
+			if len(skipped) == 0 {
+				skipped = nil
+			}
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("MockRun() skipped %v, want %v", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestMockRun_helpers(t *testing.T) {
+	q := &Queue{}
+	q.Add(
+		&mockTask{Task: Task{Name: "a"}, In: []string{"/data/in.txt"}, Out: "/out/a.txt"},
+		&mockTask{Task: Task{Name: "b"}, In: []string{"/out/a.txt"}, Out: "/out/b.txt"},
+		&mockTask{Task: Task{Name: "c"}, In: []string{"/out/b.txt"}, Out: "/out/c.txt"},
+		&mockTask{Task: Task{Name: "x"}, In: []string{"/data/in.txt"}, Out: "/out/x.txt"},
+	)
+	run, err := q.MockRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, ok := run.Job("b"); !ok || j.Command != "cat /out/a.txt >/out/b.txt" || j.Round != 1 {
+		t.Errorf("Job(b) = %+v, %v", j, ok)
+	}
+	if !run.DependsOn("c", "a") || run.DependsOn("a", "c") || run.DependsOn("x", "a") {
+		t.Errorf("DependsOn() is wrong")
+	}
+	if !run.Before("a", "c") || run.Before("a", "x") {
+		t.Errorf("Before() is wrong")
+	}
+}
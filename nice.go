@@ -0,0 +1,41 @@
+package flow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// priorityArgs returns args, a command, run under nice and ionice as set by
+// local_nice and local_ionice.
+func priorityArgs(conf *Config, args []string) ([]string, error) {
+	if class := conf.GetString("local_ionice"); class != "" {
+		ionice, err := ioniceArgs(class)
+		if err != nil {
+			return nil, err
+		}
+		args = append(ionice, args...)
+	}
+	if n := conf.GetInt("local_nice"); n != 0 {
+		if n < 0 || n > 19 {
+			return nil, fmt.Errorf("local_nice must be from 0 to 19, not %d", n)
+		}
+		args = append([]string{"nice", "-n", strconv.Itoa(n)}, args...)
+	}
+	return args, nil
+}
+
+func ioniceArgs(class string) ([]string, error) {
+	parts := strings.SplitN(class, ":", 2)
+	switch {
+	case parts[0] == "idle" && len(parts) == 1:
+		return []string{"ionice", "-c", "3"}, nil
+	case parts[0] == "best-effort" && len(parts) == 1:
+		return []string{"ionice", "-c", "2"}, nil
+	case parts[0] == "best-effort":
+		if n, err := strconv.Atoi(parts[1]); err == nil && n >= 0 && n <= 7 {
+			return []string{"ionice", "-c", "2", "-n", parts[1]}, nil
+		}
+	}
+	return nil, fmt.Errorf("local_ionice must be idle, best-effort or best-effort:N with N from 0 to 7, not %s", class)
+}
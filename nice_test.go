@@ -0,0 +1,39 @@
+package flow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_priorityArgs(t *testing.T) {
+	defer defaultConfig.Set("local_nice", defaultConfig.Get("local_nice"))
+	defer defaultConfig.Set("local_ionice", defaultConfig.Get("local_ionice"))
+	tests := []struct {
+		name    string
+		nice    int
+		ionice  string
+		want    []string
+		wantErr bool
+	}{
+		{"none", 0, "", []string{"bash", "job.sh"}, false},
+		{"nice", 10, "", []string{"nice", "-n", "10", "bash", "job.sh"}, false},
+		{"idle", 0, "idle", []string{"ionice", "-c", "3", "bash", "job.sh"}, false},
+		{"both", 19, "best-effort:7", []string{"nice", "-n", "19", "ionice", "-c", "2", "-n", "7", "bash", "job.sh"}, false},
+		{"negative", -5, "", nil, true},
+		{"realtime", 0, "realtime", nil, true},
+		{"bad_level", 0, "best-effort:9", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Set("local_nice", tt.nice)
+			defaultConfig.Set("local_ionice", tt.ionice)
+			got, err := priorityArgs(defaultConfig, []string{"bash", "job.sh"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("priorityArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("priorityArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
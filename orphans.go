@@ -0,0 +1,98 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OrphanJob is a scheduler job submitted by a run that died before the job
+// finished. Unless a later run reattaches to it, nothing will ever collect its
+// results.
+type OrphanJob struct {
+	// ID is the scheduler job ID.
+	ID string
+	// Output is the first output of the job, identifying what it was
+	// producing.
+	Output string
+
+	idFile string
+}
+
+// FindOrphans returns the jobs recorded in the flowdir of c that are still
+// running or queued on the scheduler. It takes the lock on the flowdir, so it fails if
+// a run is in progress, as the jobs of a live run are not orphans.
+func (c *Config) FindOrphans() ([]OrphanJob, error) {
+	if err := lockFlowdir(c); err != nil {
+		return nil, err
+	}
+	defer unlockFlowdir(c)
+	runner, err := newRunner(c)
+	if err != nil {
+		return nil, err
+	}
+	orphans := []OrphanJob{}
+	doneDir, err := filepath.Abs(filepath.Join(c.GetString("flowdir"), "done"))
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(doneDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".jobid") {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		j := &job{ID: strings.TrimSpace(string(content))}
+		if kr, ok := runner.(reattachRunner); ok {
+			// A job the scheduler has no record of has no state,
+			// which is not the same as still running.
+			if known, err := kr.Known(j); err != nil || !known {
+				return nil
+			}
+		}
+		completed, err := runner.Completed(j)
+		if err != nil || completed {
+			// The scheduler no longer knows about the job, or it
+			// has finished; either way it is not using any
+			// allocation.
+			return nil
+		}
+		rel, _ := filepath.Rel(doneDir, strings.TrimSuffix(path, ".jobid"))
+		orphans = append(orphans, OrphanJob{
+			ID:     j.ID,
+			Output: string(filepath.Separator) + rel,
+			idFile: path,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for orphaned jobs: %v", err)
+	}
+	return orphans, nil
+}
+
+// CancelOrphan cancels the scheduler job, with the runner of c, and forgets
+// it, so later runs will not try to reattach to it.
+func (c *Config) CancelOrphan(o OrphanJob) error {
+	runner, err := newRunner(c)
+	if err != nil {
+		return err
+	}
+	if err := runner.Kill(&job{ID: o.ID}); err != nil {
+		return err
+	}
+	if err := os.Remove(o.idFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove job ID file: %s: %v", o.idFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FindOrphans(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"flowdir", "job_runner"} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+	}
+	defaultConfig.Set("flowdir", filepath.Join(dir, ".flow"))
+	defaultConfig.Set("job_runner", "slurm")
+	// sacct only has a record of the first job.
+	defer fakeCommand(t, "sacct", "1001|RUNNING\n")()
+	doneDir := filepath.Join(dir, ".flow", "done", "out")
+	if err := os.MkdirAll(doneDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range map[string]string{"a.txt": "1001", "b.txt": "1002"} {
+		if err := ioutil.WriteFile(filepath.Join(doneDir, name+".jobid"), []byte(id+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphans, err := defaultConfig.FindOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != "1001" || orphans[0].Output != "/out/a.txt" {
+		t.Errorf("FindOrphans() = %+v, want only the job sacct knows of", orphans)
+	}
+}
@@ -0,0 +1,111 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// packKey returns what the jobs run in a single job with j have in common,
+// or "" if j is run on its own.
+func packKey(j *job) string {
+	if j.resources.Pack != "" {
+		return "pack:" + j.resources.Pack
+	}
+	if j.resources.Batch > 0 {
+		return "batch:" + j.Cmd.AnalysisName()
+	}
+	return ""
+}
+
+// packFor returns the pending jobs to run in the same job as j, which must
+// have a pack or batch: those with the same pack, or analysis, that can be
+// run now and are not already packed with another, up to its batch size, or
+// pack_size, with j.
+func (g *graph) packFor(j *job) []*job {
+	packed := []*job{}
+	size := g.config.GetInt("pack_size")
+	if j.resources.Batch > 0 {
+		size = j.resources.Batch
+	}
+	for _, p := range submitOrder(g.config, g.pending) {
+		if len(packed)+1 >= size {
+			break
+		}
+		if p == j || p.packedIn != nil || len(p.packed) > 0 || p.pipeTo != nil {
+			continue
+		}
+		if packKey(p) == packKey(j) && p.isRunnable() {
+			packed = append(packed, p)
+		}
+	}
+	return packed
+}
+
+// unpack releases the jobs packed with j, which is no longer going to run
+// them, so that they can be submitted on their own or in another pack.
+func unpack(j *job) {
+	for _, p := range j.packed {
+		p.packedIn = nil
+	}
+	j.packed = nil
+}
+
+// packResources returns the resources of a job that runs jobs one after
+// another.
+func packResources(jobs []*job) Resources {
+	r := jobs[0].resources
+	r.Time = 0
+	for _, j := range jobs {
+		if j.resources.CPUs > r.CPUs {
+			r.CPUs = j.resources.CPUs
+		}
+		if j.resources.Memory > r.Memory {
+			r.Memory = j.resources.Memory
+		}
+		r.Time += j.resources.Time
+	}
+	return r
+}
+
+// createPackFile writes the script that runs the job scripts of j and the
+// jobs packed with it in turn, in the work directory of j, and returns its
+// path. It touches the heartbeat file of j throughout, as j's own script
+// only does while it runs, records how each went in pack.tsv and fails if
+// any of them failed. The output of j's script goes to the job's stdout,
+// that of the others to their own.
+func createPackFile(conf *Config, j *job) (string, error) {
+	var b strings.Builder
+	b.WriteString("#!/usr/bin/env bash\n")
+	b.WriteString(fmt.Sprintf(
+		"(while true; do touch %s; sleep %d; done) &\nheartbeat=$!\ntrap 'kill $heartbeat 2>/dev/null' EXIT\n",
+		shellQuote(heartbeatFile(j.workDir)),
+		int(conf.GetDuration("heartbeat_interval").Seconds())))
+	b.WriteString("status=0\n")
+	tsv := shellQuote(filepath.Join(j.workDir, "pack.tsv"))
+	b.WriteString(fmt.Sprintf("printf 'uuid\\tanalysis_name\\texit_status\\tseconds\\n' >%s\n", tsv))
+	for i, p := range append([]*job{j}, j.packed...) {
+		redirect := ""
+		if i > 0 {
+			redirect = fmt.Sprintf(" >%s 2>&1", shellQuote(p.Stdout))
+		}
+		b.WriteString(fmt.Sprintf("start=$SECONDS\n(cd %s && bash job.sh%s)\ns=$?\n", shellQuote(p.workDir), redirect))
+		b.WriteString(fmt.Sprintf("printf '%%s\\t%%s\\t%%d\\t%%d\\n' %s %s $s $((SECONDS - start)) >>%s\n", p.UUID, shellQuote(p.Cmd.AnalysisName()), tsv))
+		b.WriteString("[ $s -eq 0 ] || status=1\n")
+	}
+	b.WriteString("exit $status\n")
+	fn := filepath.Join(j.workDir, "pack.sh")
+	if err := ioutil.WriteFile(fn, []byte(b.String()), 0664); err != nil {
+		return "", fmt.Errorf("unable to write pack script: %v", err)
+	}
+	return fn, nil
+}
+
+// packedSuccessfully reports whether a job run in a pack succeeded, from
+// the exit code its job script recorded, as the pack fails if any of its
+// jobs did.
+func packedSuccessfully(j *job) bool {
+	code, ok := exitCode(j)
+	return ok && code == 0
+}
@@ -0,0 +1,125 @@
+package flow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type packTask struct {
+	Task
+	Cmd string
+	Out string `type:"output"`
+}
+
+func (t *packTask) Command() string { return t.Cmd + " && touch " + t.Out }
+
+func Test_pack(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"flowdir", "pack_size", "heartbeat_interval"} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+	}
+	defaultConfig.Set("flowdir", filepath.Join(dir, ".flow"))
+	defaultConfig.Set("pack_size", 3)
+	defaultConfig.Set("heartbeat_interval", "1s")
+	task := func(name, cmd string, cpus, hours int) Commander {
+		return &packTask{Task: Task{Name: name, CPUs: cpus, Memory: 1, Time: hours, Pack: "short"}, Cmd: cmd, Out: filepath.Join(dir, name+".txt")}
+	}
+	g, err := newGraph(defaultConfig, []Commander{task("a", "true", 1, 1), task("b", "false", 4, 2), task("c", "true", 2, 1), task("d", "true", 1, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := g.jobs[0]
+	if r := packResources(append([]*job{leader}, g.packFor(leader)...)); r.CPUs != 4 || r.Time != 4 {
+		t.Errorf("packResources() = %d CPUs, %dh, want 4, 4", r.CPUs, r.Time)
+	}
+	r := NewLocalRunner()
+	if n, err := g.submitPending(r); err != nil || n != 2 {
+		t.Fatalf("submitPending() = %d, %v, want a pack of 3 and one of 1", n, err)
+	}
+	if len(leader.packed) != 2 || g.jobs[3].packedIn != nil || len(g.jobs[3].packed) != 0 {
+		t.Fatalf("packed %d jobs with the first, want 2", len(leader.packed))
+	}
+	if leader.resources.CPUs != 1 {
+		t.Errorf("resources of the first job were changed to %d CPUs", leader.resources.CPUs)
+	}
+	report, err := NewJobreport(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.nextPoll = time.Time{}
+	g.jobs[3].nextPoll = time.Time{}
+	if n, err := g.checkCompleted(r, report); err != nil || n != 4 {
+		t.Fatalf("checkCompleted() = %d, %v, want 4", n, err)
+	}
+	if len(g.completed) != 3 || len(g.failed) != 1 || g.failed[0] != g.jobs[1] {
+		t.Errorf("%d completed, %d failed, want b failed and the others completed", len(g.completed), len(g.failed))
+	}
+	b, err := ioutil.ReadFile(filepath.Join(leader.workDir, "pack.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], g.jobs[1].UUID.String()+"\tb\t1\t") {
+		t.Errorf("pack.tsv =\n%s", b)
+	}
+}
+
+func Test_packFor_batch(t *testing.T) {
+	task := func(name string, batch int) *job {
+		return &job{Cmd: &packTask{Task: Task{Name: name}}, resources: Resources{Batch: batch}}
+	}
+	index := []*job{task("index", 2), task("index", 2), task("index", 2)}
+	other := task("count", 2)
+	unbatched := task("index", 0)
+	g := graph{config: defaultConfig, pending: append(append([]*job{}, index...), other, unbatched)}
+	packed := g.packFor(index[0])
+	if len(packed) != 1 || packed[0] != index[1] {
+		t.Errorf("packFor() = %d jobs, want the next index job", len(packed))
+	}
+	if packKey(unbatched) != "" {
+		t.Errorf("packKey() = %q for a job without a pack or batch", packKey(unbatched))
+	}
+}
+
+func Test_unpack(t *testing.T) {
+	conf := newConfig()
+	conf.Set("flowdir", filepath.Join(t.TempDir(), ".flow"))
+	tests := []struct {
+		name   string
+		giveUp func(g *graph, j *job)
+	}{
+		{"fail", func(g *graph, j *job) { g.fail(j, "unable to run job") }},
+		{"requeue", func(g *graph, j *job) { g.requeue(DummyRunner{}, j, "preempted") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := func() *job {
+				return &job{UUID: uuid.New(), Cmd: &packTask{Task: Task{Name: "a"}}, resources: Resources{Pack: "short"}}
+			}
+			leader, members := task(), []*job{task(), task()}
+			leader.packed = members
+			for _, p := range members {
+				p.packedIn = leader
+			}
+			g := &graph{config: conf, pending: append([]*job{}, members...), running: []*job{leader}}
+			tt.giveUp(g, leader)
+			if len(leader.packed) != 0 {
+				t.Errorf("leader still has %d jobs packed with it", len(leader.packed))
+			}
+			for i, p := range members {
+				if p.packedIn != nil {
+					t.Errorf("job %d is still packed in the leader", i)
+				}
+			}
+			if len(g.pending) != len(members) {
+				t.Errorf("%d jobs pending, want the %d that were packed", len(g.pending), len(members))
+			}
+		})
+	}
+}
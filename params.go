@@ -0,0 +1,186 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ParamMap holds the parameters of a workflow, as read from its parameter
+// file.
+type ParamMap map[string]interface{}
+
+// Params reads the parameter file of the config read by InitConfig.
+func Params() (ParamMap, error) {
+	return currentConfig().Params()
+}
+
+// Params reads the parameter file, params.yaml unless params_file is set in
+// c. A missing file is only an error if params_file was set explicitly.
+func (c *Config) Params() (ParamMap, error) {
+	data, err := readParamsFile(c)
+	if err != nil || data == nil {
+		return ParamMap{}, err
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse parameter file: %v", err)
+	}
+	p := ParamMap{}
+	for k, val := range raw {
+		p[k] = plainValue(val)
+	}
+	return p, nil
+}
+
+// LoadParams reads the parameter file of the config read by InitConfig into
+// the struct pointed to by out, as Config.LoadParams does.
+func LoadParams(out interface{}) error {
+	return currentConfig().LoadParams(out)
+}
+
+// LoadParams reads the parameter file, as Params does, into the struct
+// pointed to by out. Unknown parameters are an error, as are fields tagged
+// `params:"required"` that are not set by the file.
+func (c *Config) LoadParams(out interface{}) error {
+	val := reflect.ValueOf(out)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("LoadParams needs a pointer to a struct, not %T", out)
+	}
+	data, err := readParamsFile(c)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		if err := yaml.UnmarshalStrict(data, out); err != nil {
+			return fmt.Errorf("failed to parse parameter file: %v", err)
+		}
+	}
+	s := val.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		field := s.Type().Field(i)
+		if field.Tag.Get("params") == "required" && s.Field(i).IsZero() {
+			return fmt.Errorf("required parameter is not set: %s", paramName(field))
+		}
+	}
+	return nil
+}
+
+// paramName returns the name of the parameter for a struct field, as it
+// appears in the file.
+func paramName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("yaml"), ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+func readParamsFile(conf *Config) ([]byte, error) {
+	fn := conf.GetString("params_file")
+	explicit := fn != ""
+	if !explicit {
+		fn = "params.yaml"
+	}
+	data, err := ioutil.ReadFile(fn)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parameter file: %v", err)
+	}
+	return data, nil
+}
+
+// plainValue converts the maps decoded from YAML to map[string]interface{}
+// so that they are easier to work with.
+func plainValue(x interface{}) interface{} {
+	switch x := x.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range x {
+			m[fmt.Sprint(k)] = plainValue(val)
+		}
+		return m
+	case []interface{}:
+		for i := range x {
+			x[i] = plainValue(x[i])
+		}
+		return x
+	}
+	return x
+}
+
+// String returns a string parameter. Numbers and booleans are converted to
+// strings.
+func (p ParamMap) String(key string) (string, error) {
+	val, ok := p[key]
+	if !ok {
+		return "", fmt.Errorf("parameter is not set: %s", key)
+	}
+	switch val.(type) {
+	case string, int, float64, bool:
+		return fmt.Sprint(val), nil
+	}
+	return "", fmt.Errorf("parameter %s is not a string: %v", key, val)
+}
+
+// Int returns an integer parameter.
+func (p ParamMap) Int(key string) (int, error) {
+	val, ok := p[key]
+	if !ok {
+		return 0, fmt.Errorf("parameter is not set: %s", key)
+	}
+	switch val := val.(type) {
+	case int:
+		return val, nil
+	case string:
+		if n, err := strconv.Atoi(val); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("parameter %s is not an integer: %v", key, val)
+}
+
+// Bool returns a boolean parameter.
+func (p ParamMap) Bool(key string) (bool, error) {
+	val, ok := p[key]
+	if !ok {
+		return false, fmt.Errorf("parameter is not set: %s", key)
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("parameter %s is not a boolean: %v", key, val)
+	}
+	return b, nil
+}
+
+// Strings returns a list parameter. A single string is returned as a list of
+// one.
+func (p ParamMap) Strings(key string) ([]string, error) {
+	val, ok := p[key]
+	if !ok {
+		return nil, fmt.Errorf("parameter is not set: %s", key)
+	}
+	switch val := val.(type) {
+	case string:
+		return []string{val}, nil
+	case []interface{}:
+		ss := []string{}
+		for _, x := range val {
+			switch x.(type) {
+			case string, int, float64, bool:
+				ss = append(ss, fmt.Sprint(x))
+			default:
+				return nil, fmt.Errorf("parameter %s is not a list of strings: %v", key, val)
+			}
+		}
+		return ss, nil
+	}
+	return nil, fmt.Errorf("parameter %s is not a list: %v", key, val)
+}
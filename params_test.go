@@ -0,0 +1,39 @@
+package flow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ParamMap(t *testing.T) {
+	p := ParamMap{
+		"genome":  "hg38",
+		"threads": 8,
+		"dedup":   true,
+		"samples": []interface{}{"a", "b"},
+	}
+	if got, err := p.String("genome"); err != nil || got != "hg38" {
+		t.Errorf("String() = %v, %v", got, err)
+	}
+	if got, err := p.String("threads"); err != nil || got != "8" {
+		t.Errorf("String() = %v, %v", got, err)
+	}
+	if got, err := p.Int("threads"); err != nil || got != 8 {
+		t.Errorf("Int() = %v, %v", got, err)
+	}
+	if _, err := p.Int("genome"); err == nil {
+		t.Errorf("Int() of a string should fail")
+	}
+	if got, err := p.Bool("dedup"); err != nil || !got {
+		t.Errorf("Bool() = %v, %v", got, err)
+	}
+	if got, err := p.Strings("samples"); err != nil || !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Strings() = %v, %v", got, err)
+	}
+	if got, err := p.Strings("genome"); err != nil || !reflect.DeepEqual(got, []string{"hg38"}) {
+		t.Errorf("Strings() = %v, %v", got, err)
+	}
+	if _, err := p.String("missing"); err == nil {
+		t.Errorf("String() of a missing parameter should fail")
+	}
+}
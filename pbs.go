@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +14,12 @@ import (
 )
 
 type PBSRunner struct {
+	boundConfig
 	jobIDs map[uuid.UUID]string
+	// results caches the qstat results fetched by Refresh, and unknown the
+	// jobs it found qstat has no record of.
+	results map[string]qstatResult
+	unknown map[string]bool
 }
 
 func NewPBSRunner() (*PBSRunner, error) {
@@ -31,8 +37,9 @@ func NewPBSRunner() (*PBSRunner, error) {
 }
 
 func (r *PBSRunner) Run(ctx executionContext) error {
+	conf := r.conf()
 	jobName := ctx.job.Cmd.AnalysisName()
-	resources := ctx.job.Cmd.Resources()
+	resources := ctx.job.resources
 	cmd := exec.Command(
 		"qsub",
 		"-N", jobName,
@@ -40,10 +47,23 @@ func (r *PBSRunner) Run(ctx executionContext) error {
 		"-j", "oe",
 		"-l", fmt.Sprintf("select=1:ncpus=%d:mem=%dgb", resources.CPUs, resources.Memory),
 		"-l", fmt.Sprintf("walltime=%02d:00:00", resources.Time),
-		"--",
-		"/bin/bash",
-		ctx.script,
 	)
+	// qsub does not pass its environment on, so secrets read from it are
+	// passed by name, which keeps their values off the command line.
+	_, secretVars, err := secretExports(conf, resources.Secrets, false)
+	if err != nil {
+		return err
+	}
+	if len(secretVars) > 0 {
+		cmd.Args = append(cmd.Args, "-v", strings.Join(secretVars, ","))
+	}
+	cmd.Args = append(cmd.Args, pbsOptions(conf, resources)...)
+	extra, err := schedulerArgs(conf, resources)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append(cmd.Args, extra...)
+	cmd.Args = append(cmd.Args, "--", "/bin/bash", ctx.script)
 	ctx.job.BatchCommand = strings.Join(cmd.Args, " ")
 	cmd.Dir = ctx.dir
 	out, err := cmd.CombinedOutput()
@@ -55,21 +75,76 @@ func (r *PBSRunner) Run(ctx executionContext) error {
 	return nil
 }
 
+// pbsOptions returns the qsub options for the account and queue of a job,
+// from its resources or the pbs config. PBS has no equivalent of QOS.
+func pbsOptions(conf *Config, r Resources) []string {
+	opts := []string{}
+	account := r.Account
+	if account == "" {
+		account = conf.GetString("pbs.account")
+	}
+	if account != "" {
+		opts = append(opts, "-A", account)
+	}
+	queue := r.Queue
+	if queue == "" {
+		queue = conf.GetString("pbs.queue")
+	}
+	if queue != "" {
+		opts = append(opts, "-q", queue)
+	}
+	return opts
+}
+
 func (r *PBSRunner) Completed(j *job) (bool, error) {
 	// jobId := r.jobIDs[j.ID]
 	jobId := j.ID
-	q, err := qstat(jobId)
+	q, err := r.qstat(jobId)
 	if err != nil {
 		return false, err
 	}
 	return q.State == "F", nil
 }
 
+// Known reports whether qstat has a record of the job.
+func (r *PBSRunner) Known(j *job) (bool, error) {
+	if r.unknown[j.ID] {
+		return false, nil
+	}
+	if _, err := r.qstat(j.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NodeFailed reports whether PBS could not run the job on its node, which it
+// reports with a negative exit status such as JOB_EXEC_RETRY (-3).
+func (r *PBSRunner) NodeFailed(j *job) (bool, error) {
+	q, err := r.qstat(j.ID)
+	if err != nil {
+		return false, err
+	}
+	return q.State == "F" && q.ExitStatus < 0 && q.ExitStatus != jobExecKillWalltime, nil
+}
+
+// jobExecKillWalltime is the exit status PBS gives a job it killed for
+// running longer than its walltime.
+const jobExecKillWalltime = -29
+
+// TimedOut reports whether PBS killed the job for exceeding its walltime.
+func (r *PBSRunner) TimedOut(j *job) (bool, error) {
+	q, err := r.qstat(j.ID)
+	if err != nil {
+		return false, err
+	}
+	return q.State == "F" && q.ExitStatus == jobExecKillWalltime, nil
+}
+
 func (r *PBSRunner) CompletedSuccessfully(j *job) (bool, error) {
 	if j.ID == "" {
 		return false, fmt.Errorf("job has no ID")
 	}
-	q, err := qstat(j.ID)
+	q, err := r.qstat(j.ID)
 	if err != nil {
 		return false, err
 	}
@@ -81,7 +156,7 @@ func (r *PBSRunner) CompletedSuccessfully(j *job) (bool, error) {
 }
 
 func (r *PBSRunner) ResourcesUsed(j *job) (resourcesUsed, error) {
-	q, err := qstat(j.ID)
+	q, err := r.qstat(j.ID)
 	if err != nil {
 		return resourcesUsed{}, err
 	}
@@ -114,6 +189,74 @@ func (r *PBSRunner) ResourcesUsed(j *job) (resourcesUsed, error) {
 	}, nil
 }
 
+// unknownJob matches the error qstat gives for each job it has no record of.
+var unknownJob = regexp.MustCompile(`Unknown Job Id (\S+)`)
+
+// Refresh fetches the state of all the jobs with one qstat command per batch.
+// qstat fails if any of the jobs is unknown, but still reports the others, so
+// only a batch that fails for another reason is left to be queried one job at
+// a time.
+func (r *PBSRunner) Refresh(jobs []*job) error {
+	r.results = make(map[string]qstatResult)
+	r.unknown = make(map[string]bool)
+	for _, batch := range jobBatches(jobs) {
+		args := append([]string{"-xf", "-F", "json"}, batch...)
+		out, err := exec.Command("qstat", args...).Output()
+		if err != nil {
+			ee, ok := err.(*exec.ExitError)
+			if !ok {
+				continue
+			}
+			unknown, other := parseQstatErrors(string(ee.Stderr))
+			if other {
+				continue
+			}
+			for _, id := range unknown {
+				r.unknown[id] = true
+			}
+		}
+		if len(strings.TrimSpace(string(out))) == 0 {
+			continue
+		}
+		var q qstatResultList
+		if err := json.Unmarshal(out, &q); err != nil {
+			return fmt.Errorf("failed to determine job states: failed to unmarshal JSON: %v", err)
+		}
+		for id, result := range q.Jobs {
+			r.results[id] = result
+		}
+	}
+	return nil
+}
+
+// parseQstatErrors returns the jobs qstat reported as unknown in stderr, and
+// whether it reported anything else.
+func parseQstatErrors(stderr string) (unknown []string, other bool) {
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if m := unknownJob.FindStringSubmatch(line); m != nil {
+			unknown = append(unknown, m[1])
+		} else {
+			other = true
+		}
+	}
+	return unknown, other
+}
+
+// qstat returns the qstat result for the job, from the last Refresh if
+// possible.
+func (r *PBSRunner) qstat(jobId string) (qstatResult, error) {
+	if q, ok := r.results[jobId]; ok {
+		return q, nil
+	}
+	if r.unknown[jobId] {
+		return qstatResult{}, fmt.Errorf("failed to determine job state: qstat has no record of job %s", jobId)
+	}
+	return qstat(jobId)
+}
+
 func (r *PBSRunner) Kill(j *job) error {
 	if j.ID == "" {
 		return errors.New("job has no ID")
@@ -236,3 +379,7 @@ func convertWalltime(s string) (int, error) {
 	}
 	return (hours * 60 * 60) + (minutes * 60) + seconds, nil
 }
+
+var _ batchRunner = &PBSRunner{}
+var _ nodeFailureRunner = &PBSRunner{}
+var _ reattachRunner = &PBSRunner{}
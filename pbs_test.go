@@ -1,6 +1,12 @@
 package flow
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
 
 func Test_convertMemory(t *testing.T) {
 	type args struct {
@@ -32,3 +38,66 @@ func Test_convertMemory(t *testing.T) {
 		})
 	}
 }
+
+func Test_pbsOptions(t *testing.T) {
+	defer defaultConfig.Set("pbs", nil)
+	defaultConfig.Set("pbs", map[string]interface{}{"account": "proj1", "queue": "normal"})
+	tests := []struct {
+		name string
+		r    Resources
+		want []string
+	}{
+		{"config", Resources{}, []string{"-A", "proj1", "-q", "normal"}},
+		{"task", Resources{Account: "proj2", Queue: "express"}, []string{"-A", "proj2", "-q", "express"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pbsOptions(defaultConfig, tt.r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pbsOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_PBSRunner_Refresh(t *testing.T) {
+	defer fakeScript(t, "qstat", `echo '{"Jobs": {"1.pbs": {"Job_Name": "a", "job_state": "F", "Exit_status": 0}}}'
+echo "qstat: Unknown Job Id 2.pbs" >&2
+exit 153
+`)()
+	known, unknown := &job{ID: "1.pbs"}, &job{ID: "2.pbs"}
+	r := &PBSRunner{}
+	if err := r.Refresh([]*job{known, unknown}); err != nil {
+		t.Fatal(err)
+	}
+	// The results must all come from the Refresh.
+	defer fakeScript(t, "qstat", "exit 1\n")()
+	if ok, err := r.CompletedSuccessfully(known); err != nil || !ok {
+		t.Errorf("CompletedSuccessfully() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.Known(unknown); err != nil || ok {
+		t.Errorf("Known() = %v, %v, want false", ok, err)
+	}
+	if _, err := r.Completed(unknown); err == nil {
+		t.Errorf("Completed() of an unknown job should fail")
+	}
+}
+
+func Test_PBSRunner_Run_secrets(t *testing.T) {
+	defer fakeScript(t, "qsub", "echo 1.pbs\n")()
+	conf := newConfig()
+	conf.Set("secrets", map[string]interface{}{
+		"token": map[string]interface{}{"env": "FLOW_TEST_TOKEN"},
+	})
+	j := &job{
+		Cmd:       &fileTask{Task: Task{Name: "a"}},
+		resources: Resources{Secrets: []string{"TOKEN"}},
+	}
+	r := &PBSRunner{jobIDs: map[uuid.UUID]string{}}
+	r.config = conf
+	if err := r.Run(executionContext{job: j, dir: t.TempDir(), script: "job.sh"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(j.BatchCommand, " -v FLOW_TEST_TOKEN ") {
+		t.Errorf("BatchCommand = %q, want the secret passed with -v", j.BatchCommand)
+	}
+}
@@ -0,0 +1,131 @@
+package flow
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// parseMode parses permission bits from the config: a string of octal
+// digits, such as "0002", or a number, which is what YAML makes of unquoted
+// octal digits.
+func parseMode(val interface{}) (os.FileMode, error) {
+	var mode uint64
+	switch x := val.(type) {
+	case int:
+		mode = uint64(x)
+	case string:
+		var err error
+		if mode, err = strconv.ParseUint(x, 8, 32); err != nil {
+			return 0, fmt.Errorf("must be octal permissions such as 0002, not %s", x)
+		}
+	default:
+		return 0, fmt.Errorf("must be octal permissions such as 0002, not %v", val)
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("must be octal permissions such as 0002, not %o", mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// umaskCommand returns the command that sets the umask in job scripts, or
+// nothing if umask is not set and jobs inherit the umask flow runs with.
+func umaskCommand(conf *Config) (string, error) {
+	if conf.GetString("umask") == "" {
+		return "", nil
+	}
+	mask, err := parseMode(conf.Get("umask"))
+	if err != nil {
+		return "", fmt.Errorf("umask %v", err)
+	}
+	return fmt.Sprintf("umask %04o\n", mask), nil
+}
+
+// setOutputMode adds the permissions in output_mode to the outputs of a job,
+// and everything in those that are directories. Directories also get the
+// execute permission for whoever output_mode lets read them, so that their
+// contents can be reached. Tools in containers often ignore the umask, which
+// output_mode makes up for.
+func setOutputMode(conf *Config, j *job) error {
+	if conf.GetString("output_mode") == "" {
+		return nil
+	}
+	mode, err := parseMode(conf.Get("output_mode"))
+	if err != nil {
+		return fmt.Errorf("output_mode %v", err)
+	}
+	for _, pattern := range j.Outputs {
+		paths := []string{pattern}
+		if isGlob(pattern) {
+			paths, _ = filepath.Glob(pattern)
+		}
+		for _, p := range paths {
+			err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if info.Mode()&os.ModeSymlink != 0 {
+					return nil
+				}
+				add := mode
+				if info.IsDir() {
+					add |= (mode & 0444) >> 2
+				}
+				if info.Mode().Perm()|add == info.Mode().Perm() {
+					return nil
+				}
+				return os.Chmod(path, info.Mode().Perm()|add)
+			})
+			if err != nil {
+				return fmt.Errorf("unable to change permissions of output: %s: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// publishGroup returns the id of the group in publish_group, a name or a
+// number, or -1 if it is not set.
+func publishGroup(conf *Config) (int, error) {
+	name := conf.GetString("publish_group")
+	if name == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, fmt.Errorf("unknown publish_group: %v", err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// setGroup gives p the group gid and, if p is a directory and recursive is
+// set, everything in it. Directories are also made setgid so that files
+// created in them later get the group too. Symlinks are changed, not what
+// they point to.
+func setGroup(p string, gid int, recursive bool) error {
+	return filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, -1, gid); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := os.Chmod(path, info.Mode().Perm()|os.ModeSetgid); err != nil {
+				return err
+			}
+			if !recursive {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,99 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_parseMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     interface{}
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"string", "0002", 0002, false},
+		{"short", "22", 0022, false},
+		{"yaml_number", 0027, 0027, false},
+		{"not_octal", "0029", 0, true},
+		{"too_big", "1777", 0, true},
+		{"bool", true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMode(tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMode() = %o, want %o", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_setOutputMode(t *testing.T) {
+	defer defaultConfig.Set("output_mode", defaultConfig.Get("output_mode"))
+	dir := t.TempDir()
+	file := filepath.Join(dir, "out.txt")
+	outDir := filepath.Join(dir, "out")
+	nested := filepath.Join(outDir, "part.txt")
+	if err := os.Mkdir(outDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{file, nested} {
+		if err := ioutil.WriteFile(fn, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j := &job{Outputs: []string{file, outDir, filepath.Join(dir, "missing.txt")}}
+	defaultConfig.Set("output_mode", "")
+	if err := setOutputMode(defaultConfig, j); err != nil {
+		t.Fatal(err)
+	}
+	defaultConfig.Set("output_mode", "0040")
+	if err := setOutputMode(defaultConfig, j); err != nil {
+		t.Fatal(err)
+	}
+	for fn, want := range map[string]os.FileMode{file: 0640, outDir: 0750, nested: 0640} {
+		info, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("mode of %s = %o, want %o", fn, got, want)
+		}
+	}
+}
+
+func Test_setGroup(t *testing.T) {
+	dir := t.TempDir()
+	results := filepath.Join(dir, "results")
+	nested := filepath.Join(results, "sample", "out.txt")
+	if err := os.MkdirAll(filepath.Dir(nested), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(nested, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setGroup(results, os.Getgid(), false); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(results, "sample")); info.Mode()&os.ModeSetgid != 0 {
+		t.Errorf("setGroup() should not change what is in a directory unless recursive")
+	}
+	if err := setGroup(results, os.Getgid(), true); err != nil {
+		t.Fatal(err)
+	}
+	for fn, setgid := range map[string]bool{results: true, filepath.Join(results, "sample"): true, nested: false} {
+		info, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode()&os.ModeSetgid != 0; got != setgid {
+			t.Errorf("%s setgid = %v, want %v", fn, got, setgid)
+		}
+	}
+}
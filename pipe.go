@@ -0,0 +1,171 @@
+package flow
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// connectPipes pairs the jobs with pipe outputs with the job that streams
+// them and makes sure each group of streamed jobs is run as a whole. A pipe
+// output is only streamed when exactly one task reads it and neither uses
+// scratch or isolated; otherwise it is written to disk.
+func (g *graph) connectPipes() error {
+	for _, p := range g.jobs {
+		pipes := cmdTag(p.Cmd, "output", "pipe")
+		if len(pipes) == 0 {
+			continue
+		}
+		c, reason := pipeConsumer(p, pipes, g.jobs)
+		if c == nil {
+			log.Printf("Writing the pipe outputs of %s to disk: %s", p.Cmd.AnalysisName(), reason)
+			continue
+		}
+		p.pipeTo = c
+		c.pipeFrom = append(c.pipeFrom, p)
+		// The pipe leaves nothing behind once the job is done.
+		for _, o := range pipes {
+			p.optional[o] = true
+		}
+	}
+	for _, j := range g.jobs {
+		if j.pipeTo != nil || len(j.pipeFrom) == 0 {
+			continue
+		}
+		group := append([]*job{j}, streamedFrom(j)...)
+		for _, p := range group[1:] {
+			j.resources.CPUs += p.resources.CPUs
+			j.resources.Memory += p.resources.Memory
+			if p.resources.Time > j.resources.Time {
+				j.resources.Time = p.resources.Time
+			}
+		}
+		if err := g.rerunGroup(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipeConsumer returns the job that reads every pipe output of p, or nil and
+// why they cannot be streamed.
+func pipeConsumer(p *job, pipes []string, jobs []*job) (*job, string) {
+	var consumer *job
+	for _, o := range pipes {
+		readers := []*job{}
+		for _, j := range jobs {
+			if j != p && hasIntersection(j.Inputs, []string{o}) {
+				readers = append(readers, j)
+			}
+		}
+		switch {
+		case len(readers) == 0:
+			return nil, fmt.Sprintf("%s is not read by any task", o)
+		case len(readers) > 1:
+			return nil, fmt.Sprintf("%s is read by %d tasks", o, len(readers))
+		case consumer != nil && readers[0] != consumer:
+			return nil, "they are read by different tasks"
+		}
+		consumer = readers[0]
+	}
+	for _, j := range []*job{p, consumer} {
+		if j.resources.Scratch || j.resources.Isolated {
+			return nil, fmt.Sprintf("%s uses scratch or isolated", j.Cmd.AnalysisName())
+		}
+	}
+	return consumer, ""
+}
+
+// streamedFrom returns the jobs that stream to j, directly or through other
+// streamed jobs.
+func streamedFrom(j *job) []*job {
+	jobs := []*job{}
+	for _, p := range j.pipeFrom {
+		jobs = append(jobs, p)
+		jobs = append(jobs, streamedFrom(p)...)
+	}
+	return jobs
+}
+
+// rerunGroup returns the completed jobs of a group of streamed jobs to the
+// pending jobs if any of the group is pending, as the output streamed
+// between them was never written.
+func (g *graph) rerunGroup(group []*job) error {
+	pending := false
+	for _, j := range group {
+		if _, err := jobIndex(j, g.pending); err == nil {
+			pending = true
+		}
+	}
+	if !pending {
+		return nil
+	}
+	for _, j := range group {
+		idx, err := jobIndex(j, g.completed)
+		if err != nil {
+			continue
+		}
+		if !g.config.GetBool("dry_run") {
+			if err := os.Remove(j.doneFile); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to remove done file: %s: %v", j.doneFile, err)
+			}
+		}
+		j.hasCompleted = false
+		j.completedSuccessfully = false
+		g.completed = append(g.completed[:idx], g.completed[idx+1:]...)
+		g.pending = append(g.pending, j)
+	}
+	return nil
+}
+
+// pipePrologue returns the shell commands that create the named pipes j
+// reads and start the jobs that write them in the background. Once a
+// producer has finished the pipes it writes are opened, and closed, so that
+// a consumer waiting for a producer that failed before opening them sees
+// the end of its input rather than waiting forever.
+func pipePrologue(j *job) string {
+	var b strings.Builder
+	for _, p := range j.pipeFrom {
+		pipes := quoteAll(cmdTag(p.Cmd, "output", "pipe"))
+		for _, o := range pipes {
+			b.WriteString(fmt.Sprintf("rm -f %s && mkfifo %s || exit 1\n", o, o))
+		}
+		b.WriteString(fmt.Sprintf("(bash %s >%s 2>&1; s=$?; %s; exit $s) &\nproducers=\"$producers $!\"\n",
+			shellQuote(filepath.Join(p.workDir, "job.sh")),
+			shellQuote(p.Stdout),
+			unblockPipes(pipes)))
+	}
+	return b.String()
+}
+
+// pipeEpilogue returns the shell commands that wait for the jobs that write
+// the pipes j reads, failing the job if any of them failed, and remove the
+// pipes. Until they have finished the pipes are opened, and closed, so that
+// a producer waiting for a consumer that finished without reading them gets
+// a broken pipe rather than waiting forever.
+func pipeEpilogue(j *job) string {
+	if len(j.pipeFrom) == 0 {
+		return ""
+	}
+	pipes := []string{}
+	for _, p := range j.pipeFrom {
+		pipes = append(pipes, quoteAll(cmdTag(p.Cmd, "output", "pipe"))...)
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("while kill -0 $producers 2>/dev/null; do %s; sleep 1; done\n", unblockPipes(pipes)))
+	b.WriteString("for p in $producers; do wait $p || [ $status -ne 0 ] || status=1; done\n")
+	b.WriteString(fmt.Sprintf("rm -f %s\n", strings.Join(pipes, " ")))
+	return b.String()
+}
+
+// unblockPipes returns the shell command that opens and closes each of
+// pipes, which must already be quoted, for reading and writing.
+func unblockPipes(pipes []string) string {
+	cmds := []string{}
+	for _, o := range pipes {
+		cmds = append(cmds, fmt.Sprintf(": 3<>%s", o))
+	}
+	return strings.Join(cmds, "; ")
+}
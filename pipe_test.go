@@ -0,0 +1,114 @@
+package flow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type producerTask struct {
+	Task
+	Sam string `type:"output,pipe"`
+}
+
+func (t *producerTask) Command() string { return "printf 'b\\na\\n' >" + t.Sam }
+
+type consumerTask struct {
+	Task
+	Sam string `type:"input"`
+	Bam string `type:"output"`
+}
+
+func (t *consumerTask) Command() string { return "sort " + t.Sam + " >" + t.Bam }
+
+func Test_connectPipes(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"flowdir", "pipes", "heartbeat_interval"} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+	}
+	defaultConfig.Set("flowdir", filepath.Join(dir, ".flow"))
+	defaultConfig.Set("pipes", true)
+	defaultConfig.Set("heartbeat_interval", "1s")
+	sam, bam := filepath.Join(dir, "a.sam"), filepath.Join(dir, "a.bam")
+	align := &producerTask{Task: Task{Name: "align", CPUs: 4, Memory: 8, Time: 2}, Sam: sam}
+	sort := &consumerTask{Task: Task{Name: "sort", CPUs: 1, Memory: 2, Time: 1}, Sam: sam, Bam: bam}
+	g, err := newGraph(defaultConfig, []Commander{align, sort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, consumer := g.jobs[0], g.jobs[1]
+	if producer.pipeTo != consumer || len(consumer.pipeFrom) != 1 {
+		t.Fatalf("producer and consumer were not connected")
+	}
+	if r := consumer.resources; r.CPUs != 5 || r.Memory != 10 || r.Time != 2 {
+		t.Errorf("consumer resources = %d CPUs, %dGB, %dh, want 5, 10, 2", r.CPUs, r.Memory, r.Time)
+	}
+	if !consumer.isRunnable() {
+		t.Errorf("consumer should be runnable with its producer")
+	}
+
+	r := NewLocalRunner()
+	n, err := g.submitPending(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(g.running) != 1 || g.running[0] != consumer {
+		t.Fatalf("submitPending() = %d, want only the consumer submitted", n)
+	}
+	report, err := NewJobreport(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.nextPoll = time.Time{}
+	if n, err := g.checkCompleted(r, report); err != nil || n != 2 {
+		t.Fatalf("checkCompleted() = %d, %v, want 2 completed", n, err)
+	}
+	if len(g.completed) != 2 || len(g.pending) != 0 {
+		t.Errorf("%d completed, %d pending, want 2, 0", len(g.completed), len(g.pending))
+	}
+	if b, err := ioutil.ReadFile(bam); err != nil || string(b) != "a\nb\n" {
+		t.Errorf("consumer output = %q, %v", b, err)
+	}
+	if _, err := os.Stat(sam); !os.IsNotExist(err) {
+		t.Errorf("named pipe %s was not removed", sam)
+	}
+
+	// The producer is rerun with its consumer, as nothing was written.
+	os.Remove(consumer.doneFile)
+	g, err = newGraph(defaultConfig, []Commander{align, sort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.pending) != 2 {
+		t.Errorf("%d pending, want the producer and consumer", len(g.pending))
+	}
+}
+
+func Test_pipeConsumer(t *testing.T) {
+	producer := &job{Cmd: &producerTask{Task: Task{Name: "align"}}}
+	reader := func(name string, scratch bool) *job {
+		return &job{Cmd: &consumerTask{Task: Task{Name: name}}, Inputs: []string{"/a.sam"}, resources: Resources{Scratch: scratch}}
+	}
+	sort, index := reader("sort", false), reader("index", false)
+	tests := []struct {
+		name string
+		jobs []*job
+		want *job
+	}{
+		{"one_reader", []*job{producer, sort}, sort},
+		{"no_reader", []*job{producer}, nil},
+		{"two_readers", []*job{producer, sort, index}, nil},
+		{"scratch", []*job{producer, reader("sort", true)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := pipeConsumer(producer, []string{"/a.sam"}, tt.jobs)
+			if got != tt.want {
+				t.Errorf("pipeConsumer() = %v, %q", got, reason)
+			}
+		})
+	}
+}
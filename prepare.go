@@ -0,0 +1,118 @@
+package flow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ociReference matches a bare OCI image reference, such as ubuntu:22.04,
+// which is taken to be a docker:// image: an optional registry host, a path,
+// and a tag or digest.
+var ociReference = regexp.MustCompile(`^([a-zA-Z0-9.-]+(:[0-9]+)?/)?[a-z0-9]+([._-]+[a-z0-9]+)*(/[a-z0-9]+([._-]+[a-z0-9]+)*)*(:[\w][\w.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+
+var imageExtensions = []string{".sif", ".simg", ".img"}
+
+// resolveContainer returns container with a docker:// scheme if it is a bare
+// OCI reference rather than an image file.
+func resolveContainer(container string) string {
+	if container == "" || isRemoteImage(container) || strings.HasPrefix(container, buildScheme) {
+		return container
+	}
+	if ok, _ := fileExists(container); ok {
+		return container
+	}
+	for _, ext := range imageExtensions {
+		if strings.HasSuffix(container, ext) {
+			return container
+		}
+	}
+	if ociReference.MatchString(container) {
+		return "docker://" + container
+	}
+	return container
+}
+
+// isRemoteImage reports whether singularity has to fetch or convert the
+// container before it can run it.
+func isRemoteImage(container string) bool {
+	for _, scheme := range containerSchemes {
+		if strings.HasPrefix(container, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// imagePath returns the SIF file in the image cache for a remote container.
+func imagePath(conf *Config, container string) (string, error) {
+	dir, err := singularityCacheDir(conf)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(container))
+	return filepath.Join(dir, "images", hex.EncodeToString(sum[:])[:16]+".sif"), nil
+}
+
+// pullImage converts a remote container to a SIF file in the image cache,
+// unless it is already there, and returns its path.
+func pullImage(conf *Config, container string) (string, error) {
+	fn, err := imagePath(conf, container)
+	if err != nil {
+		return "", err
+	}
+	if ok, _ := fileExists(fn); ok {
+		return fn, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return "", err
+	}
+	env, err := singularityEnv(conf)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Pulling %s", container)
+	tmp := fn + ".part"
+	os.Remove(tmp)
+	cmd := exec.Command(conf.GetString("singularity_bin"), "pull", tmp, container)
+	cmd.Env = append(os.Environ(), env...)
+	if err := runCommand(cmd); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return fn, os.Rename(tmp, fn)
+}
+
+// prepare pulls the containers, converting them to SIF files in the image
+// cache, and stages the remote inputs of the pending jobs, so that jobs do
+// not wait on a node for a registry or a download.
+func (g *graph) prepare() error {
+	images := map[string]string{}
+	for _, j := range g.pending {
+		c := j.resources.Container
+		if !isRemoteImage(c) {
+			continue
+		}
+		if _, ok := images[c]; !ok {
+			fn, err := pullImage(g.config, c)
+			if err != nil {
+				return fmt.Errorf("unable to pull container for %s: %s: %v", j.Cmd.AnalysisName(), c, err)
+			}
+			images[c] = fn
+		}
+		j.resources.Container = images[c]
+	}
+	for _, j := range g.pending {
+		if err := fetchInputs(g.config, j); err != nil {
+			return fmt.Errorf("failed to stage inputs for %s: %v", j.Cmd.AnalysisName(), err)
+		}
+	}
+	log.Printf("Prepared %d containers for %d jobs", len(images), len(g.pending))
+	return nil
+}
@@ -0,0 +1,89 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_prepare(t *testing.T) {
+	dir := t.TempDir()
+	// A stand in for singularity that records each pull.
+	bin := filepath.Join(dir, "singularity")
+	pulls := filepath.Join(dir, "pulls")
+	script := "#!/bin/sh\necho \"$3\" >>" + pulls + "\ntouch \"$2\"\n"
+	if err := ioutil.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]interface{}{
+		"flowdir":              filepath.Join(dir, ".flow"),
+		"tmpdir":               filepath.Join(dir, "tmp"),
+		"singularity_bin":      bin,
+		"singularity_cachedir": "",
+	} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+		defaultConfig.Set(key, value)
+	}
+	local := filepath.Join(dir, "local.sif")
+	newJob := func(container string) *job {
+		return &job{Cmd: &fileTask{Task: Task{Name: "a"}}, resources: Resources{Container: container}}
+	}
+	g := graph{config: defaultConfig, pending: []*job{newJob("docker://ubuntu:22.04"), newJob("docker://ubuntu:22.04"), newJob(local), newJob("")}}
+	if err := g.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	image, err := imagePath(defaultConfig, "docker://ubuntu:22.04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{image, image, local, ""} {
+		if got := g.pending[i].resources.Container; got != want {
+			t.Errorf("container of job %d = %s, want %s", i, got, want)
+		}
+	}
+	if ok, _ := fileExists(image); !ok {
+		t.Errorf("image was not pulled to %s", image)
+	}
+	g.pending = []*job{newJob("docker://ubuntu:22.04")}
+	if err := g.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(pulls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "docker://ubuntu:22.04" {
+		t.Errorf("pulled %q, want docker://ubuntu:22.04 once", got)
+	}
+}
+
+func Test_resolveContainer(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "tool")
+	if err := ioutil.WriteFile(image, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		container string
+		want      string
+	}{
+		{"", ""},
+		{image, image},
+		{"tools/bwa.sif", "tools/bwa.sif"},
+		{"ubuntu:22.04", "docker://ubuntu:22.04"},
+		{"quay.io/biocontainers/samtools:1.17--h00cdaf9_0", "docker://quay.io/biocontainers/samtools:1.17--h00cdaf9_0"},
+		{"localhost:5000/tools/bwa@sha256:" + strings.Repeat("a", 64), "docker://localhost:5000/tools/bwa@sha256:" + strings.Repeat("a", 64)},
+		{"docker://ubuntu:22.04", "docker://ubuntu:22.04"},
+		{"oras://ghcr.io/org/tool:1.0", "oras://ghcr.io/org/tool:1.0"},
+		{"library://sylabs/default/alpine:3.18", "library://sylabs/default/alpine:3.18"},
+		{"build://Singularity.def", "build://Singularity.def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.container, func(t *testing.T) {
+			if got := resolveContainer(tt.container); got != tt.want {
+				t.Errorf("resolveContainer() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
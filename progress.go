@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// progress estimates how much of a run is done, and when it will finish.
+// Each job is weighted by how long jobs of its analysis took in previous
+// runs, from the usage they recorded or their job reports, so that a thousand jobs that take seconds
+// do not count for more than a few that take hours. Jobs of an analysis
+// that has not been run before are given the average weight. The time left
+// is the time taken so far scaled by the weight of the jobs left.
+type progress struct {
+	start   time.Time
+	weights map[*job]float64
+	total   float64
+}
+
+func newProgress(jobs []*job, durations map[string]time.Duration) progress {
+	p := progress{start: time.Now(), weights: map[*job]float64{}}
+	known, sum := 0, 0.0
+	for _, j := range jobs {
+		if d, ok := durations[j.Cmd.AnalysisName()]; ok {
+			p.weights[j] = d.Seconds()
+			known++
+			sum += d.Seconds()
+		}
+	}
+	unknown := 1.0
+	if known > 0 {
+		unknown = sum / float64(known)
+	}
+	for _, j := range jobs {
+		if _, ok := p.weights[j]; !ok {
+			p.weights[j] = unknown
+		}
+		p.total += p.weights[j]
+	}
+	return p
+}
+
+// fraction returns the fraction of the run that is done.
+func (p progress) fraction() float64 {
+	if p.total == 0 {
+		return 1
+	}
+	done := 0.0
+	for j, w := range p.weights {
+		if j.hasCompleted {
+			done += w
+		}
+	}
+	return done / p.total
+}
+
+func (p progress) String() string {
+	f := p.fraction()
+	s := fmt.Sprintf("%.0f%% done", 100*f)
+	if f == 0 || f == 1 {
+		return s
+	}
+	left := time.Duration(float64(time.Since(p.start)) * (1 - f) / f).Round(time.Minute)
+	return fmt.Sprintf("%s, about %s left (ETA %s)", s, left, time.Now().Add(left).Format("Jan 2 15:04"))
+}
+
+// analysisDurations returns the mean time the jobs of each analysis took in
+// the previous runs in flowdir, from the usage they recorded or, for runs
+// before usage was recorded, their job reports.
+func analysisDurations(conf *Config) map[string]time.Duration {
+	fns, _ := filepath.Glob(filepath.Join(conf.GetString("flowdir"), "runs", "*", "jobreport.csv"))
+	sums := map[string]int{}
+	counts := map[string]int{}
+	for _, fn := range fns {
+		f, err := os.Open(fn)
+		if err != nil {
+			continue
+		}
+		records, _ := csv.NewReader(f).ReadAll()
+		f.Close()
+		if len(records) == 0 {
+			continue
+		}
+		name, used := -1, -1
+		for i, col := range records[0] {
+			switch col {
+			case "analysis_name":
+				name = i
+			case "walltime_used":
+				used = i
+			}
+		}
+		if name < 0 || used < 0 {
+			continue
+		}
+		for _, r := range records[1:] {
+			if len(r) <= name || len(r) <= used {
+				continue
+			}
+			if secs, err := strconv.Atoi(r[used]); err == nil && secs > 0 {
+				sums[r[name]] += secs
+				counts[r[name]]++
+			}
+		}
+	}
+	durations := map[string]time.Duration{}
+	for name, sum := range sums {
+		durations[name] = time.Duration(sum/counts[name]) * time.Second
+	}
+	if stats, err := usageStats(conf); err == nil {
+		for _, s := range stats {
+			if s.MeanTime > 0 {
+				durations[s.Name] = s.MeanTime
+			}
+		}
+	}
+	return durations
+}
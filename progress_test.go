@@ -0,0 +1,43 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_progress(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	dir := t.TempDir()
+	defaultConfig.Set("flowdir", dir)
+	run := filepath.Join(dir, "runs", "2026-01-01_000000_abcd1234")
+	if err := os.MkdirAll(run, 0755); err != nil {
+		t.Fatal(err)
+	}
+	report := "job_id,analysis_name,exit_status,walltime_used\n1,align,0,3000\n2,align,0,1000\n3,qc,0,0\n"
+	if err := ioutil.WriteFile(filepath.Join(run, "jobreport.csv"), []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	durations := analysisDurations(defaultConfig)
+	if len(durations) != 1 || durations["align"] != 2000*time.Second {
+		t.Fatalf("analysisDurations() = %v, want align 2000s", durations)
+	}
+	newJob := func(name string) *job { return &job{Cmd: &fileTask{Task: Task{Name: name}}} }
+	align, qc := newJob("align"), newJob("qc")
+	p := newProgress([]*job{align, qc}, durations)
+	if got := p.String(); got != "0% done" {
+		t.Errorf("progress = %s, want 0%% done", got)
+	}
+	// qc has no history, so it is given the average weight.
+	qc.hasCompleted = true
+	if got := p.fraction(); got != 0.5 {
+		t.Errorf("fraction() = %v, want 0.5", got)
+	}
+	p.start = time.Now().Add(-time.Hour)
+	if got := p.String(); !strings.HasPrefix(got, "50% done, about 1h0m0s left") {
+		t.Errorf("progress = %s", got)
+	}
+}
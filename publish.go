@@ -0,0 +1,177 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// publishSpec is an output to be published to Dir, or uploaded if Dir is a
+// URI, once its job has completed successfully.
+type publishSpec struct {
+	Path string
+	Dir  string
+	Mode string
+}
+
+var publishModes = map[string]bool{
+	"copy":     true,
+	"move":     true,
+	"symlink":  true,
+	"hardlink": true,
+	"link":     true,
+}
+
+// cmdPublish returns the outputs of c that carry a publish tag.
+func cmdPublish(conf *Config, c Commander) ([]publishSpec, error) {
+	specs := []publishSpec{}
+	defaultMode := conf.GetString("publish_mode")
+	v := taskValue(c)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		tag, ok := field.Tag.Lookup("publish")
+		if !ok {
+			continue
+		}
+		kind, _ := parseTypeTag(field.Tag.Get("type"))
+		if kind != "output" {
+			return specs, fmt.Errorf("publish tag on %s, which is not an output", field.Name)
+		}
+		parts := strings.Split(tag, ",")
+		dir := strings.TrimSpace(parts[0])
+		mode := defaultMode
+		if len(parts) > 1 {
+			mode = strings.TrimSpace(parts[1])
+		}
+		if dir == "" {
+			return specs, fmt.Errorf("publish tag on %s has no directory", field.Name)
+		}
+		if !publishModes[mode] {
+			return specs, fmt.Errorf("unknown publish mode for %s: %s", field.Name, mode)
+		}
+		val := v.Field(i)
+		paths := []string{}
+		switch {
+		case isPathType(val.Type()):
+			paths = append(paths, pathValue(val).String())
+		case val.Kind() == reflect.Slice && isPathType(val.Type().Elem()):
+			for j := 0; j < val.Len(); j++ {
+				paths = append(paths, pathValue(val.Index(j)).String())
+			}
+		}
+		for _, p := range paths {
+			if p != "" {
+				specs = append(specs, publishSpec{Path: p, Dir: dir, Mode: mode})
+			}
+		}
+	}
+	return specs, nil
+}
+
+// publishOutputs places each published output of the job in its destination
+// directory. Patterns are expanded so that every matching file is published.
+func publishOutputs(conf *Config, j *job) error {
+	gid, err := publishGroup(conf)
+	if err != nil {
+		return err
+	}
+	for _, spec := range j.publish {
+		paths := []string{spec.Path}
+		if isGlob(spec.Path) {
+			paths, _ = filepath.Glob(spec.Path)
+		}
+		for _, p := range paths {
+			if ok, _ := fileExists(p); !ok && j.optional[spec.Path] {
+				continue
+			}
+			if isURI(spec.Dir) {
+				if err := publishRemote(conf, j, p, spec.Dir); err != nil {
+					return fmt.Errorf("unable to publish %s to %s: %v", p, spec.Dir, err)
+				}
+				continue
+			}
+			dst, err := filepath.Abs(filepath.Join(spec.Dir, filepath.Base(p)))
+			if err != nil {
+				return err
+			}
+			if err := placeFile(p, dst, spec.Mode); err != nil {
+				return fmt.Errorf("unable to publish %s to %s: %v", p, spec.Dir, err)
+			}
+			if gid < 0 {
+				continue
+			}
+			if err := setGroup(filepath.Dir(dst), gid, false); err != nil {
+				return fmt.Errorf("unable to set the group of %s: %v", spec.Dir, err)
+			}
+			if err := setGroup(dst, gid, true); err != nil {
+				return fmt.Errorf("unable to set the group of %s: %v", dst, err)
+			}
+		}
+	}
+	return nil
+}
+
+// publishRemote uploads p into the remote directory dir.
+func publishRemote(conf *Config, j *job, p string, dir string) error {
+	uri := strings.TrimSuffix(dir, "/") + "/" + filepath.Base(p)
+	if err := store(conf, p, uri); err != nil {
+		return err
+	}
+	if strings.HasPrefix(uri, "irods://") {
+		return irodsAddMetadata(conf, uri, map[string]string{
+			"flow_analysis": j.Cmd.AnalysisName(),
+			"flow_job":      j.UUID.String(),
+			"flow_source":   p,
+		})
+	}
+	return nil
+}
+
+// placeFile makes src available at dst using the given mode. Any existing
+// file at dst is replaced.
+func placeFile(src, dst, mode string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	switch mode {
+	case "copy":
+		return copyFile(src, dst)
+	case "hardlink":
+		return os.Link(src, dst)
+	case "symlink":
+		return os.Symlink(src, dst)
+	case "link":
+		return linkFile(src, dst)
+	case "move":
+		// Downstream jobs may still need the file at its original
+		// location, so a symlink is left in its place.
+		if err := os.Rename(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				return err
+			}
+			if err := os.Remove(src); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(dst, src)
+	default:
+		return fmt.Errorf("unknown mode: %s", mode)
+	}
+}
+
+// linkFile hardlinks src to dst, or symlinks it if they are on different
+// filesystems.
+func linkFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		return os.Symlink(src, dst)
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type publishTask struct {
+	Task
+	VCF   string   `type:"output" publish:"results/vcf"`
+	BAMs  []string `type:"output" publish:"results/bam, symlink"`
+	Index string   `type:"output"`
+}
+
+func (t *publishTask) Command() string { return "true" }
+
+type badPublishTask struct {
+	Task
+	In  string `type:"input" publish:"results"`
+	Out string `type:"output"`
+}
+
+func (t *badPublishTask) Command() string { return "true" }
+
+func Test_cmdPublish(t *testing.T) {
+	conf := newConfig()
+	conf.Set("publish_mode", "copy")
+	tests := []struct {
+		name    string
+		task    Commander
+		want    []publishSpec
+		wantErr bool
+	}{
+		{
+			"default_and_explicit_modes",
+			&publishTask{VCF: "a.vcf", BAMs: []string{"a.bam", "b.bam"}, Index: "a.vcf.tbi"},
+			[]publishSpec{
+				{Path: "a.vcf", Dir: "results/vcf", Mode: "copy"},
+				{Path: "a.bam", Dir: "results/bam", Mode: "symlink"},
+				{Path: "b.bam", Dir: "results/bam", Mode: "symlink"},
+			},
+			false,
+		},
+		{"empty_paths", &publishTask{}, []publishSpec{}, false},
+		{"not_an_output", &badPublishTask{In: "a.txt", Out: "b.txt"}, nil, true},
+		{"no_directory", &struct {
+			publishTask
+			Out string `type:"output" publish:",copy"`
+		}{}, nil, true},
+		{"unknown_mode", &struct {
+			publishTask
+			Out string `type:"output" publish:"results,teleport"`
+		}{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cmdPublish(conf, tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cmdPublish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("cmdPublish() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("cmdPublish()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_placeFile(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantSymlink bool
+		wantSrcLink bool
+		wantErr     bool
+	}{
+		{"copy", false, false, false},
+		{"hardlink", false, false, false},
+		{"symlink", true, false, false},
+		{"link", false, false, false},
+		{"move", false, true, false},
+		{"teleport", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "out.txt")
+			dst := filepath.Join(dir, "results", "out.txt")
+			if err := ioutil.WriteFile(src, []byte("new\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// An earlier publication is overwritten.
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(dst, []byte("old\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := placeFile(src, dst, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("placeFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "new\n" {
+				t.Errorf("published file = %q, %v, want the output", b, err)
+			}
+			if info, err := os.Lstat(dst); err != nil || (info.Mode()&os.ModeSymlink != 0) != tt.wantSymlink {
+				t.Errorf("published file is a symlink: %v, want %v", err == nil && info.Mode()&os.ModeSymlink != 0, tt.wantSymlink)
+			}
+			// Downstream jobs can still read a moved output.
+			if b, err := ioutil.ReadFile(src); err != nil || string(b) != "new\n" {
+				t.Errorf("output = %q, %v after publishing", b, err)
+			}
+			if info, err := os.Lstat(src); err != nil || (info.Mode()&os.ModeSymlink != 0) != tt.wantSrcLink {
+				t.Errorf("output is a symlink: %v, want %v", err == nil && info.Mode()&os.ModeSymlink != 0, tt.wantSrcLink)
+			}
+		})
+	}
+}
+
+func Test_placeFile_missing(t *testing.T) {
+	dir := t.TempDir()
+	for _, mode := range []string{"copy", "hardlink", "link", "move"} {
+		if err := placeFile(filepath.Join(dir, "missing.txt"), filepath.Join(dir, "results", mode+".txt"), mode); err == nil {
+			t.Errorf("placeFile() of a missing file with %s should fail", mode)
+		}
+	}
+}
+
+func Test_publishOutputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.vcf", "b.vcf", "c.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := filepath.Join(dir, "results")
+	tests := []struct {
+		name     string
+		specs    []publishSpec
+		optional map[string]bool
+		want     []string
+		wantErr  bool
+	}{
+		{"file", []publishSpec{{Path: filepath.Join(dir, "c.txt"), Dir: results, Mode: "copy"}}, nil, []string{"c.txt"}, false},
+		{"pattern", []publishSpec{{Path: filepath.Join(dir, "*.vcf"), Dir: results, Mode: "copy"}}, nil, []string{"a.vcf", "b.vcf"}, false},
+		{"optional_missing", []publishSpec{{Path: filepath.Join(dir, "d.txt"), Dir: results, Mode: "copy"}}, map[string]bool{filepath.Join(dir, "d.txt"): true}, nil, false},
+		{"missing", []publishSpec{{Path: filepath.Join(dir, "d.txt"), Dir: results, Mode: "copy"}}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.RemoveAll(results); err != nil {
+				t.Fatal(err)
+			}
+			j := &job{Cmd: &publishTask{}, publish: tt.specs, optional: tt.optional}
+			err := publishOutputs(newConfig(), j)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publishOutputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, name := range tt.want {
+				if _, err := os.Stat(filepath.Join(results, name)); err != nil {
+					t.Errorf("%s was not published: %v", name, err)
+				}
+			}
+			if tt.want == nil {
+				if _, err := os.Stat(results); err == nil && !tt.wantErr {
+					t.Errorf("nothing should have been published")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// preempted checks whether a job that has finished was preempted, in which
+// case it has not failed: it is returned to the pending list to be
+// resubmitted, up to preempt_resubmits times, after which it is failed. Once
+// a job has been preempted preempt_fallback_after times the resources in
+// preempt_fallback apply to it, so that it can move to capacity that is not
+// preempted. Returns true if the job was preempted.
+func (g *graph) preempted(r Runner, j *job) bool {
+	pr, ok := r.(preemptionRunner)
+	if !ok {
+		return false
+	}
+	preempted, err := pr.Preempted(j)
+	if err != nil {
+		log.Printf("Unable to determine if job %s was preempted: %v", j.ID, err)
+		return false
+	}
+	if !preempted || !g.requeue(r, j, "preempted") {
+		return preempted
+	}
+	j.preemptions++
+	if j.preemptions > g.config.GetInt("preempt_resubmits") {
+		log.Printf("Job %s (%s) was preempted, giving up", j.ID, j.Cmd.AnalysisName())
+		g.fail(j, fmt.Sprintf("preempted %d times", j.preemptions))
+		return true
+	}
+	fallback := g.config.GetStringMap("preempt_fallback")
+	if len(fallback) > 0 && j.preemptions == g.config.GetInt("preempt_fallback_after") {
+		log.Printf("Job %s (%s) was preempted %d times, resubmitting with the preempt_fallback resources", j.ID, j.Cmd.AnalysisName(), j.preemptions)
+		applyOverrides(&j.resources, fallback)
+	} else {
+		log.Printf("Job %s (%s) was preempted, resubmitting", j.ID, j.Cmd.AnalysisName())
+	}
+	g.pending = append(g.pending, j)
+	return true
+}
+
+// nodeFailed checks whether a job that has finished died because the node it
+// ran on failed, or vanished from the scheduler without a record of how it
+// ended, in which case it is returned to the pending list to be resubmitted,
+// up to node_fail_resubmits times, after which it is failed. Returns true if
+// the node failed.
+func (g *graph) nodeFailed(r Runner, j *job) bool {
+	nr, ok := r.(nodeFailureRunner)
+	if !ok {
+		return false
+	}
+	failed, err := nr.NodeFailed(j)
+	if err != nil {
+		log.Printf("Unable to determine if the node of job %s failed: %v", j.ID, err)
+		return false
+	}
+	if !failed || !g.requeue(r, j, "node failed") {
+		return failed
+	}
+	j.nodeFailures++
+	if j.nodeFailures > g.config.GetInt("node_fail_resubmits") {
+		log.Printf("The node of job %s (%s) failed, giving up", j.ID, j.Cmd.AnalysisName())
+		g.fail(j, fmt.Sprintf("node failed %d times", j.nodeFailures))
+		return true
+	}
+	log.Printf("The node of job %s (%s) failed, resubmitting", j.ID, j.Cmd.AnalysisName())
+	g.pending = append(g.pending, j)
+	return true
+}
+
+// timedOut checks whether a job that has finished was killed for running
+// longer than its time, in which case it is returned to the pending list to
+// be resubmitted with its time multiplied by timeout_time_factor, up to
+// timeout_max_time hours if that is set, up to timeout_resubmits times.
+// It is failed once it has run for timeout_max_time, or timed out too many
+// times. Returns true if the job timed out.
+func (g *graph) timedOut(r Runner, j *job) bool {
+	tr, ok := r.(timeoutRunner)
+	if !ok {
+		return false
+	}
+	timedOut, err := tr.TimedOut(j)
+	if err != nil {
+		log.Printf("Unable to determine if job %s timed out: %v", j.ID, err)
+		return false
+	}
+	if !timedOut || !g.requeue(r, j, fmt.Sprintf("timed out after %dh", j.resources.Time)) {
+		return timedOut
+	}
+	j.timeouts++
+	time := extendedTime(g.config, j.resources.Time)
+	if j.timeouts > g.config.GetInt("timeout_resubmits") || time <= j.resources.Time {
+		log.Printf("Job %s (%s) timed out after %dh, giving up", j.ID, j.Cmd.AnalysisName(), j.resources.Time)
+		g.fail(j, fmt.Sprintf("timed out after %dh, %d times", j.resources.Time, j.timeouts))
+		return true
+	}
+	log.Printf("Job %s (%s) timed out after %dh, resubmitting with %dh", j.ID, j.Cmd.AnalysisName(), j.resources.Time, time)
+	j.resources.Time = time
+	g.pending = append(g.pending, j)
+	return true
+}
+
+// extendedTime returns the time, in hours, to give a job that timed out
+// after hours.
+func extendedTime(conf *Config, hours int) int {
+	extended := int(math.Ceil(float64(hours) * conf.GetFloat64("timeout_time_factor")))
+	if max := conf.GetInt("timeout_max_time"); max > 0 && extended > max {
+		extended = max
+	}
+	return extended
+}
+
+// requeue takes a job that did not run to the end through no fault of its
+// own off the running list, so that it can be resubmitted, and records why
+// in requeues.tsv in the run directory. Returns false if the job was not
+// running.
+func (g *graph) requeue(r Runner, j *job, reason string) bool {
+	idx, err := jobIndex(j, g.running)
+	if err != nil {
+		return false
+	}
+	g.running = append(g.running[:idx], g.running[idx+1:]...)
+	// The jobs packed with it did not finish either, and are submitted
+	// again in their own right.
+	unpack(j)
+	// The job has usually ended, and cannot be killed, but one the
+	// scheduler lost track of may not have: it must not run alongside the
+	// job that replaces it.
+	r.Kill(j)
+	os.Remove(j.idFile)
+	// Capacity used until the job stopped is paid for all the same.
+	g.charge(j, resourcesUsed{})
+	if err := recordRequeue(g.config, j, reason); err != nil {
+		log.Printf("Unable to record requeue: %v", err)
+	}
+	return true
+}
+
+func recordRequeue(conf *Config, j *job, reason string) error {
+	runDir, err := conf.runDir()
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(runDir, "requeues.tsv")
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(fn, []byte("time\tanalysis_name\tuuid\tjob_id\treason\n"), 0644); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\t%s\n", time.Now().Format(time.RFC3339), j.Cmd.AnalysisName(), j.UUID, j.ID, reason)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package flow
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// submitError is an error returned by the runner when submitting a job, as
+// opposed to an error preparing it.
+type submitError struct{ error }
+
+// transientError reports whether err is a submission error that may succeed
+// if tried again, such as a scheduler that timed out or a brief quota limit,
+// from the patterns in submit_retry_patterns.
+func transientError(conf *Config, err error) bool {
+	var se submitError
+	if !errors.As(err, &se) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range conf.GetStringSlice("submit_retry_patterns") {
+		if pattern != "" && strings.Contains(msg, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// retrySubmit schedules another attempt to submit j after err, if err is
+// transient and the job has not been tried submit_retries times already.
+// The delay between attempts starts at submit_retry_delay and doubles with
+// each attempt. Returns false if the error should fail the job.
+func retrySubmit(conf *Config, j *job, err error) bool {
+	if !transientError(conf, err) || j.submitAttempts >= conf.GetInt("submit_retries") {
+		return false
+	}
+	delay := conf.GetDuration("submit_retry_delay") << uint(j.submitAttempts)
+	j.submitAttempts++
+	j.nextSubmit = time.Now().Add(delay)
+	log.Printf("WARNING: unable to submit %s (attempt %d), retrying in %s: %v", j.Cmd.AnalysisName(), j.submitAttempts, delay, err)
+	return true
+}
+
+// retrying reports whether any pending job is waiting to retry its
+// submission, and will be submitted.
+func (g *graph) retrying() bool {
+	if len(g.failed) > 0 && !g.config.GetBool("keep_going") {
+		return false
+	}
+	for _, j := range g.pending {
+		if j.submitAttempts > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func Test_retrySubmit(t *testing.T) {
+	defer defaultConfig.Set("submit_retries", defaultConfig.Get("submit_retries"))
+	defer defaultConfig.Set("submit_retry_delay", defaultConfig.Get("submit_retry_delay"))
+	defer defaultConfig.Set("submit_retry_patterns", defaultConfig.Get("submit_retry_patterns"))
+	defaultConfig.Set("submit_retries", 2)
+	defaultConfig.Set("submit_retry_delay", "1m")
+	defaultConfig.Set("submit_retry_patterns", []string{"socket timed out"})
+	timeout := submitError{fmt.Errorf("sbatch: error: Socket timed out on send/recv operation")}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not_transient", submitError{fmt.Errorf("sbatch: error: invalid partition specified")}, false},
+		{"not_submission", fmt.Errorf("failed to stage inputs: socket timed out"), false},
+		{"transient", timeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{Cmd: &fileTask{Task: Task{Name: "a"}}, UUID: uuid.New()}
+			if got := retrySubmit(defaultConfig, j, tt.err); got != tt.want {
+				t.Errorf("retrySubmit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	j := &job{Cmd: &fileTask{Task: Task{Name: "a"}}, UUID: uuid.New()}
+	for _, delay := range []time.Duration{time.Minute, 2 * time.Minute} {
+		if !retrySubmit(defaultConfig, j, timeout) {
+			t.Fatalf("retrySubmit() = false, want true")
+		}
+		if d := time.Until(j.nextSubmit); d > delay || d < delay-time.Second {
+			t.Errorf("retry in %v, want %v", d, delay)
+		}
+	}
+	if retrySubmit(defaultConfig, j, timeout) {
+		t.Errorf("retrySubmit() = true after submit_retries attempts")
+	}
+}
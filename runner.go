@@ -2,10 +2,13 @@ package flow
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Runner interface {
@@ -17,6 +20,164 @@ type Runner interface {
 	Kill(*job) error
 }
 
+// batchRunner is implemented by runners that can query the state of many jobs
+// with a single scheduler command. Refresh is called once per poll cycle with
+// all the jobs due to be polled, and the runner answers Completed,
+// CompletedSuccessfully and ResourcesUsed for those jobs from the result.
+type batchRunner interface {
+	Refresh([]*job) error
+}
+
+// preemptionRunner is implemented by runners that can tell that a job was
+// stopped because the capacity it ran on was reclaimed, such as a SLURM job
+// preempted by a higher priority one, rather than because it failed.
+type preemptionRunner interface {
+	Preempted(*job) (bool, error)
+}
+
+// nodeFailureRunner is implemented by runners that can tell that a job died
+// because the node it ran on failed, or vanished without a record of how it
+// ended, rather than because it failed.
+type nodeFailureRunner interface {
+	NodeFailed(*job) (bool, error)
+}
+
+// timeoutRunner is implemented by runners that can tell that the scheduler
+// killed a job for running longer than the time it asked for.
+type timeoutRunner interface {
+	TimedOut(*job) (bool, error)
+}
+
+// reattachRunner is implemented by batch schedulers, whose jobs outlive the
+// process that submitted them, so that a later run can reattach to them.
+// Known reports whether the scheduler still has a record of the job.
+type reattachRunner interface {
+	Known(*job) (bool, error)
+}
+
+// simulatedRunner is implemented by runners that only pretend to run jobs,
+// such as DummyRunner. Their jobs are not given their inputs and leave no
+// outputs behind.
+type simulatedRunner interface {
+	Simulated() bool
+}
+
+// simulated reports whether r only pretends to run jobs.
+func simulated(r Runner) bool {
+	s, ok := r.(simulatedRunner)
+	return ok && s.Simulated()
+}
+
+// batchSize limits the number of job IDs given to a single scheduler command
+// to keep the command line a reasonable length.
+const batchSize = 500
+
+// jobBatches splits the IDs of jobs into batches of at most batchSize.
+func jobBatches(jobs []*job) [][]string {
+	batches := [][]string{}
+	batch := []string{}
+	for _, j := range jobs {
+		if j.ID == "" {
+			continue
+		}
+		batch = append(batch, j.ID)
+		if len(batch) == batchSize {
+			batches = append(batches, batch)
+			batch = []string{}
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// schedulerArgs returns the options from scheduler_args in the config
+// followed by those of the job's resources, which are added verbatim to the
+// command that submits it.
+func schedulerArgs(conf *Config, r Resources) ([]string, error) {
+	args := []string{}
+	for _, s := range []string{conf.GetString("scheduler_args"), r.SchedulerArgs} {
+		xs, err := splitArgs(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler args: %s: %v", s, err)
+		}
+		args = append(args, xs...)
+	}
+	return args, nil
+}
+
+// splitArgs splits s into arguments as a shell would, with single and double
+// quotes and backslash escapes, but without any expansions.
+func splitArgs(s string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// newRunner returns the Runner selected by job_runner.
+func newRunner(conf *Config) (Runner, error) {
+	switch runnerStr := conf.GetString("job_runner"); runnerStr {
+	case "pbs":
+		r, err := NewPBSRunner()
+		if r != nil {
+			r.config = conf
+		}
+		return r, err
+	case "slurm":
+		r, err := NewSlurmRunner()
+		if r != nil {
+			r.config = conf
+		}
+		return r, err
+	case "local":
+		r := NewLocalRunner()
+		r.config = conf
+		return r, nil
+	case "dummy":
+		return DummyRunner{}, nil
+	default:
+		return nil, fmt.Errorf("unknown runner requested: %s", runnerStr)
+	}
+}
+
 // DummyRunner does not actually run jobs, it just accepts jobs to run and
 // always reports that they completed successfully.
 type DummyRunner struct{}
@@ -41,11 +202,19 @@ func (r DummyRunner) Kill(j *job) error {
 	return nil
 }
 
+func (r DummyRunner) Simulated() bool {
+	return true
+}
+
 var _ Runner = DummyRunner{}
+var _ simulatedRunner = DummyRunner{}
 
 type LocalRunner struct {
+	boundConfig
 	cmd *exec.Cmd
 	err error
+	// used is what the job used, as far as its cgroup recorded.
+	used resourcesUsed
 }
 
 func NewLocalRunner() *LocalRunner {
@@ -53,17 +222,49 @@ func NewLocalRunner() *LocalRunner {
 }
 
 func (r *LocalRunner) Run(cxt executionContext) error {
+	conf := r.conf()
 	os.MkdirAll(filepath.Dir(cxt.job.Stdout), 0755)
 	w, err := os.Create(cxt.job.Stdout)
 	if err != nil {
 		return fmt.Errorf("failed to create stdout file: %s, %s", cxt.job.Stdout, err)
 	}
 	defer w.Close()
-	r.cmd = exec.Command("bash", cxt.script)
+	cg, err := newCgroup(conf, cxt.job)
+	if err != nil {
+		return err
+	}
+	args := []string{"bash", cxt.script}
+	if cg != nil {
+		args = cg.command(cxt.script)
+	}
+	if args, err = priorityArgs(conf, args); err != nil {
+		if cg != nil {
+			cg.remove()
+		}
+		return err
+	}
+	r.cmd = exec.Command(args[0], args[1:]...)
 	r.cmd.Dir = cxt.dir
 	r.cmd.Stdout = w
 	r.cmd.Stderr = w
+	start := time.Now()
 	r.err = r.cmd.Run()
+	r.used = resourcesUsed{
+		CPURequested:    cxt.job.resources.CPUs,
+		MemoryRequested: cxt.job.resources.Memory,
+		TimeUsed:        int(time.Since(start).Seconds()),
+		ExitStatus:      r.cmd.ProcessState.ExitCode(),
+	}
+	if cg != nil {
+		r.used.MemoryUsed = cg.memoryUsed()
+		if cg.oomKilled() {
+			log.Printf("Job %s was killed for using more than its %dGB of memory", cxt.job.UUID, cxt.job.resources.Memory)
+			fmt.Fprintf(w, "flow: killed for using more than %dGB of memory (out of memory)\n", cxt.job.resources.Memory)
+		}
+		if err := cg.remove(); err != nil {
+			log.Printf("Unable to remove cgroup: %v", err)
+		}
+	}
 	cxt.job.ID = cxt.job.UUID.String()
 	return nil // this is the job was run without error, not that the job completed successfully.
 }
@@ -77,7 +278,7 @@ func (r *LocalRunner) CompletedSuccessfully(j *job) (bool, error) {
 }
 
 func (r *LocalRunner) ResourcesUsed(j *job) (resourcesUsed, error) {
-	return resourcesUsed{}, nil
+	return r.used, nil
 }
 
 func (r *LocalRunner) Kill(j *job) error {
@@ -0,0 +1,29 @@
+package flow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_splitArgs(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"--constraint=avx2  --reservation r1", []string{"--constraint=avx2", "--reservation", "r1"}, false},
+		{`--comment="my job" -L 'lic:1' a\ b`, []string{"--comment=my job", "-L", "lic:1", "a b"}, false},
+		{`--comment="unterminated`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%v) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%v) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
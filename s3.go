@@ -0,0 +1,89 @@
+package flow
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// S3Storage stages s3://bucket/key URIs using the AWS CLI (see aws_bin).
+type S3Storage struct {
+	boundConfig
+}
+
+func (s S3Storage) Stat(uri string) (bool, error) {
+	return statCommand(exec.Command(s.conf().GetString("aws_bin"), "s3", "ls", uri))
+}
+
+func (s S3Storage) Fetch(uri, dst string) error {
+	return runCommand(exec.Command(s.conf().GetString("aws_bin"), "s3", "cp", "--only-show-errors", uri, dst))
+}
+
+func (s S3Storage) Store(src, uri string) error {
+	return runCommand(exec.Command(s.conf().GetString("aws_bin"), "s3", "cp", "--only-show-errors", src, uri))
+}
+
+// Checksum returns the SHA-256 checksum S3 holds for the object, if it was
+// uploaded with one, or else its ETag. The ETag is only the MD5 of an object
+// uploaded in a single part without encryption, or with SSE-S3 (AES256),
+// so any other is not a checksum.
+func (s S3Storage) Checksum(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI: %s: %v", uri, err)
+	}
+	cmd := exec.Command(
+		s.conf().GetString("aws_bin"), "s3api", "head-object",
+		"--bucket", u.Host,
+		"--key", strings.TrimPrefix(u.Path, "/"),
+		"--checksum-mode", "ENABLED",
+		"--output", "json",
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to get checksum of %s: %v", uri, err)
+	}
+	var head s3Head
+	if err := json.Unmarshal(out, &head); err != nil {
+		return "", fmt.Errorf("unexpected head-object output for %s: %v", uri, err)
+	}
+	return head.checksum()
+}
+
+// s3Head is the part of the output of aws s3api head-object used by Checksum.
+type s3Head struct {
+	ETag                 string
+	ServerSideEncryption string
+	ChecksumSHA256       string
+}
+
+func (h s3Head) checksum() (string, error) {
+	// Checksums of objects uploaded in parts are of the checksums of the
+	// parts, and end with the number of parts.
+	if h.ChecksumSHA256 != "" && !strings.Contains(h.ChecksumSHA256, "-") {
+		digest, err := base64.StdEncoding.DecodeString(h.ChecksumSHA256)
+		if err != nil {
+			return "", fmt.Errorf("unexpected checksum: %s", h.ChecksumSHA256)
+		}
+		return "sha256:" + hex.EncodeToString(digest), nil
+	}
+	etag := strings.Trim(h.ETag, `"`)
+	if etag == "" || strings.Contains(etag, "-") {
+		return "", nil
+	}
+	if h.ServerSideEncryption != "" && h.ServerSideEncryption != "AES256" {
+		return "", nil
+	}
+	return "md5:" + etag, nil
+}
+
+func (s S3Storage) withConfig(conf *Config) Storage {
+	s.config = conf
+	return s
+}
+
+var _ Storage = S3Storage{}
@@ -0,0 +1,31 @@
+package flow
+
+import "testing"
+
+func Test_s3Head_checksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		head    s3Head
+		want    string
+		wantErr bool
+	}{
+		{"unencrypted", s3Head{ETag: `"d41d8cd98f00b204e9800998ecf8427e"`}, "md5:d41d8cd98f00b204e9800998ecf8427e", false},
+		{"sse-s3", s3Head{ETag: `"d41d8cd98f00b204e9800998ecf8427e"`, ServerSideEncryption: "AES256"}, "md5:d41d8cd98f00b204e9800998ecf8427e", false},
+		{"sse-kms", s3Head{ETag: `"0f343b0931126a20f133d67c2b018a3b"`, ServerSideEncryption: "aws:kms"}, "", false},
+		{"multipart", s3Head{ETag: `"0f343b0931126a20f133d67c2b018a3b-4"`}, "", false},
+		{"sha256", s3Head{ETag: `"0f343b0931126a20f133d67c2b018a3b"`, ServerSideEncryption: "aws:kms", ChecksumSHA256: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", false},
+		{"multipart sha256", s3Head{ETag: `"0f343b0931126a20f133d67c2b018a3b-4"`, ChecksumSHA256: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=-4"}, "", false},
+		{"invalid sha256", s3Head{ChecksumSHA256: "!"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.head.checksum()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("checksum() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
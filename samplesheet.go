@@ -0,0 +1,159 @@
+package flow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SampleSheet is a table of samples read by ReadSampleSheet.
+type SampleSheet struct {
+	// Columns are the names of the columns, from the header.
+	Columns []string
+	Samples []Sample
+}
+
+// Sample is one row of a SampleSheet.
+type Sample struct {
+	// Line is the line of the sample sheet the sample was read from.
+	Line   int
+	values map[string]string
+	dir    string
+}
+
+// ReadSampleSheet reads a CSV file, or a TSV file if fn ends in .tsv or .txt.
+// The first line is the header, naming the columns, which must be unique and
+// include every column in required. Blank lines and lines starting with # are
+// ignored, and values are trimmed of surrounding white space.
+func ReadSampleSheet(fn string, required ...string) (*SampleSheet, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".tsv", ".txt":
+		r.Comma = '\t'
+	}
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("sample sheet is empty: %s", fn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sample sheet: %s: %v", fn, err)
+	}
+	s := &SampleSheet{}
+	seen := map[string]bool{}
+	for i, col := range header {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			return nil, fmt.Errorf("sample sheet has an unnamed column: %s: column %d", fn, i+1)
+		}
+		if seen[col] {
+			return nil, fmt.Errorf("sample sheet has duplicate column: %s: %s", fn, col)
+		}
+		seen[col] = true
+		s.Columns = append(s.Columns, col)
+	}
+	for _, col := range required {
+		if !seen[col] {
+			return nil, fmt.Errorf("sample sheet is missing column: %s: %s", fn, col)
+		}
+	}
+	dir := filepath.Dir(fn)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sample sheet: %s: %v", fn, err)
+		}
+		line, _ := r.FieldPos(0)
+		sample := Sample{Line: line, values: map[string]string{}, dir: dir}
+		for i, value := range record {
+			sample.values[s.Columns[i]] = strings.TrimSpace(value)
+		}
+		s.Samples = append(s.Samples, sample)
+	}
+	return s, nil
+}
+
+// Get returns the value of a column, or "" if the sample sheet does not
+// have it.
+func (s Sample) Get(col string) string {
+	return s.values[col]
+}
+
+// Required returns the value of a column, which must not be empty.
+func (s Sample) Required(col string) (string, error) {
+	value := s.values[col]
+	if value == "" {
+		return "", fmt.Errorf("line %d: %s is empty", s.Line, col)
+	}
+	return value, nil
+}
+
+// Int returns the value of a column as an integer.
+func (s Sample) Int(col string) (int, error) {
+	n, err := strconv.Atoi(s.values[col])
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %s is not an integer: %q", s.Line, col, s.values[col])
+	}
+	return n, nil
+}
+
+// Float returns the value of a column as a number.
+func (s Sample) Float(col string) (float64, error) {
+	x, err := strconv.ParseFloat(s.values[col], 64)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %s is not a number: %q", s.Line, col, s.values[col])
+	}
+	return x, nil
+}
+
+// Bool returns the value of a column as a boolean, accepting true/false,
+// yes/no and 1/0 in any case.
+func (s Sample) Bool(col string) (bool, error) {
+	switch strings.ToLower(s.values[col]) {
+	case "true", "yes", "y", "1":
+		return true, nil
+	case "false", "no", "n", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("line %d: %s is not a boolean: %q", s.Line, col, s.values[col])
+}
+
+// Path returns the value of a column as a path. Relative paths are taken to
+// be relative to the directory of the sample sheet.
+func (s Sample) Path(col string) (string, error) {
+	p, err := s.Required(col)
+	if err != nil {
+		return "", err
+	}
+	if filepath.IsAbs(p) || isURI(p) {
+		return p, nil
+	}
+	return filepath.Join(s.dir, p), nil
+}
+
+// Unique returns an error if any two samples have the same value in col,
+// such as a sample ID.
+func (s *SampleSheet) Unique(col string) error {
+	lines := map[string]int{}
+	for _, sample := range s.Samples {
+		value := sample.Get(col)
+		if line, ok := lines[value]; ok {
+			return fmt.Errorf("line %d: %s %q is also used on line %d", sample.Line, col, value, line)
+		}
+		lines[value] = sample.Line
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ReadSampleSheet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-samplesheet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		required []string
+		want     int
+		wantErr  bool
+	}{
+		{"csv", "s.csv", "id,reads\n# comment\ns1, a.fq\n\ns2,/b.fq\n", []string{"id"}, 2, false},
+		{"tsv", "s.tsv", "id\treads\ns1\ta.fq\n", nil, 1, false},
+		{"missing_column", "s.csv", "id,reads\ns1,a.fq\n", []string{"id", "lane"}, 0, true},
+		{"duplicate_column", "s.csv", "id,id\ns1,s2\n", nil, 0, true},
+		{"wrong_fields", "s.csv", "id,reads\ns1\n", nil, 0, true},
+		{"empty", "s.csv", "", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(fn, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadSampleSheet(fn, tt.required...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadSampleSheet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && len(got.Samples) != tt.want {
+				t.Errorf("ReadSampleSheet() read %d samples, want %d", len(got.Samples), tt.want)
+			}
+		})
+	}
+}
+
+func Test_Sample(t *testing.T) {
+	s := Sample{Line: 2, dir: "/data", values: map[string]string{"reads": "a.fq", "lanes": "4", "paired": "yes", "abs": "/b.fq"}}
+	if got, err := s.Path("reads"); err != nil || got != "/data/a.fq" {
+		t.Errorf("Path() = %v, %v", got, err)
+	}
+	if got, err := s.Path("abs"); err != nil || got != "/b.fq" {
+		t.Errorf("Path() = %v, %v", got, err)
+	}
+	if got, err := s.Int("lanes"); err != nil || got != 4 {
+		t.Errorf("Int() = %v, %v", got, err)
+	}
+	if _, err := s.Int("reads"); err == nil {
+		t.Errorf("Int() of a path should fail")
+	}
+	if got, err := s.Bool("paired"); err != nil || !got {
+		t.Errorf("Bool() = %v, %v", got, err)
+	}
+	if _, err := s.Required("missing"); err == nil {
+		t.Errorf("Required() of a missing column should fail")
+	}
+}
@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// scratchPaths returns the relative path in the scratch directory to use for
+// each input and output of the job. Each file is placed in its own directory
+// so files with the same name do not collide.
+func scratchPaths(j *job) (map[string]string, map[string]string) {
+	inputs := make(map[string]string)
+	for i, p := range j.Inputs {
+		if p != "" {
+			inputs[p] = fmt.Sprintf("in/%d/%s", i, filepath.Base(p))
+		}
+	}
+	outputs := make(map[string]string)
+	for i, p := range j.Outputs {
+		if p != "" {
+			outputs[p] = fmt.Sprintf("out/%d/%s", i, filepath.Base(p))
+		}
+	}
+	return inputs, outputs
+}
+
+// withPaths temporarily replaces the paths in the input and output fields of
+// c according to the mappings while fn is called.
+func withPaths(c Commander, inputs, outputs map[string]string, fn func()) {
+	original := []func(){}
+	val := taskValue(c)
+	for i := 0; i < val.NumField(); i++ {
+		kind, _ := parseTypeTag(val.Type().Field(i).Tag.Get("type"))
+		mapping := inputs
+		if kind == "output" {
+			mapping = outputs
+		} else if kind != "input" {
+			continue
+		}
+		field := val.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		values := []reflect.Value{}
+		switch {
+		case isPathType(field.Type()):
+			values = append(values, pathValue(field))
+		case field.Kind() == reflect.Slice && isPathType(field.Type().Elem()):
+			for j := 0; j < field.Len(); j++ {
+				values = append(values, pathValue(field.Index(j)))
+			}
+		}
+		for _, x := range values {
+			x := x
+			p := x.String()
+			if m, ok := mapping[p]; ok {
+				x.SetString(m)
+				original = append(original, func() { x.SetString(p) })
+			}
+		}
+	}
+	defer func() {
+		for _, restore := range original {
+			restore()
+		}
+	}()
+	fn()
+}
+
+// scratchPrologue returns the job script lines that create the scratch
+// directory, copy the inputs into it and change into it.
+func scratchPrologue(inputs, outputs map[string]string) string {
+	var b strings.Builder
+	// The scratch directory is removed by the EXIT trap set in the job
+	// script.
+	b.WriteString("scratch=$(mktemp -d \"${TMPDIR:-/tmp}/flow.XXXXXX\")\n")
+	for src, dst := range inputs {
+		b.WriteString(fmt.Sprintf("mkdir -p \"$scratch\"/%s && cp -pL %s \"$scratch\"/%s || exit 1\n", shellQuote(filepath.Dir(dst)), shellQuote(src), shellQuote(dst)))
+	}
+	for _, dst := range outputs {
+		b.WriteString(fmt.Sprintf("mkdir -p \"$scratch\"/%s\n", shellQuote(filepath.Dir(dst))))
+	}
+	b.WriteString("cd \"$scratch\"\n")
+	return b.String()
+}
+
+// tmpfsPrologue returns the job script lines that create the temporary
+// directory in memory of a task with Tmpfs set.
+func tmpfsPrologue(conf *Config, size int) string {
+	var b strings.Builder
+	// The directory is removed by the EXIT trap set in the job script.
+	b.WriteString(fmt.Sprintf("tmpfs=$(mktemp -d %s/flow.XXXXXX) || exit 1\n", shellQuote(conf.GetString("tmpfs_dir"))))
+	b.WriteString(fmt.Sprintf("if [ \"$(df -Pk \"$tmpfs\" | awk 'NR == 2 {print $4}')\" -lt %d ]; then\n", size*1024*1024))
+	b.WriteString(fmt.Sprintf("  echo \"less than %dGB free in $tmpfs\" >&2\n  exit 1\nfi\n", size))
+	b.WriteString("export TMPDIR=\"$tmpfs\"\n")
+	b.WriteString("export SINGULARITYENV_TMPDIR=/tmp APPTAINERENV_TMPDIR=/tmp\n")
+	return b.String()
+}
+
+// scratchEpilogue returns the job script lines that copy the outputs back if
+// the command succeeded.
+func scratchEpilogue(outputs map[string]string) string {
+	var b strings.Builder
+	b.WriteString("if [ $status -eq 0 ]; then\n")
+	for dst, src := range outputs {
+		// src may be a pattern, and optional outputs may not exist.
+		b.WriteString(fmt.Sprintf("  for f in \"$scratch\"/%s; do [ -e \"$f\" ] && cp -p \"$f\" %s/; done\n", shellPattern(src), shellQuote(filepath.Dir(dst))))
+	}
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+// isolatedEpilogue returns the job script lines that link the outputs written
+// to the work directory of an isolated task out to their declared locations
+// if the command succeeded. A hardlink is used where possible and a symlink
+// otherwise.
+func isolatedEpilogue(outputs map[string]string) string {
+	var b strings.Builder
+	b.WriteString("if [ $status -eq 0 ]; then\n")
+	for dst, src := range outputs {
+		dir := shellQuote(filepath.Dir(dst))
+		b.WriteString(fmt.Sprintf("  for f in \"$PWD\"/%s; do [ -e \"$f\" ] && { ln -f \"$f\" %s/ 2>/dev/null || ln -sf \"$f\" %s/; }; done\n", shellPattern(src), dir, dir))
+	}
+	b.WriteString("fi\n")
+	return b.String()
+}
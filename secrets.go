@@ -0,0 +1,186 @@
+package flow
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretSource is where the value of a secret is read from. Job scripts only
+// refer to the source, so values are read when the job runs and never
+// written to disk by flow.
+type secretSource struct {
+	name  string
+	env   string
+	file  string
+	vault string
+	field string
+	// vaultBin is the vault command of the config it was read from.
+	vaultBin string
+}
+
+// maskedValues are the values of the secrets read by any config, which are
+// masked in the log of the process. maskedMu guards them.
+var (
+	maskedMu      sync.Mutex
+	maskedValues  = make(map[string]bool)
+	maskLogOutput sync.Once
+)
+
+// configSecret returns the source of the named secret from the config.
+func configSecret(conf *Config, name string) (secretSource, error) {
+	s := secretSource{name: name, vaultBin: conf.GetString("vault_bin")}
+	raw, ok := conf.GetStringMap("secrets")[strings.ToLower(name)]
+	if !ok {
+		return s, fmt.Errorf("secret %s is not defined in the config", name)
+	}
+	m := stringMap(raw)
+	s.env, s.file, s.vault, s.field = m["env"], m["file"], m["vault"], m["field"]
+	n := 0
+	for _, x := range []string{s.env, s.file, s.vault} {
+		if x != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return s, fmt.Errorf("secret %s must have one of env, file or vault", name)
+	}
+	if s.vault != "" && s.field == "" {
+		return s, fmt.Errorf("secret %s must have a field to read from vault", name)
+	}
+	return s, nil
+}
+
+// read returns the value of the secret.
+func (s secretSource) read() (string, error) {
+	switch {
+	case s.env != "":
+		val, ok := os.LookupEnv(s.env)
+		if !ok {
+			return "", fmt.Errorf("secret %s: %s is not set", s.name, s.env)
+		}
+		return val, nil
+	case s.file != "":
+		b, err := ioutil.ReadFile(s.file)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %v", s.name, err)
+		}
+		return strings.TrimRight(string(b), "\n"), nil
+	default:
+		out, err := exec.Command(s.vaultBin, "kv", "get", "-field="+s.field, s.vault).Output()
+		if err != nil {
+			return "", fmt.Errorf("secret %s: unable to read %s from vault: %v", s.name, s.vault, err)
+		}
+		return strings.TrimRight(string(out), "\n"), nil
+	}
+}
+
+// shell returns a shell expression that reads the value of the secret.
+func (s secretSource) shell() string {
+	switch {
+	case s.env != "":
+		return fmt.Sprintf(`"$%s"`, s.env)
+	case s.file != "":
+		return fmt.Sprintf(`"$(cat %s)"`, shellQuote(s.file))
+	default:
+		return fmt.Sprintf(`"$(%s kv get -field=%s %s)"`, shellQuote(s.vaultBin), shellQuote(s.field), shellQuote(s.vault))
+	}
+}
+
+// resolveSecrets checks that the named secrets can be read, so that a
+// missing one is found before any job is submitted, and from then on masks
+// their values in the log.
+func resolveSecrets(conf *Config, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	conf.mu.Lock()
+	defer conf.mu.Unlock()
+	for _, name := range names {
+		if _, ok := conf.secrets[name]; ok {
+			continue
+		}
+		s, err := configSecret(conf, name)
+		if err != nil {
+			return err
+		}
+		val, err := s.read()
+		if err != nil {
+			return err
+		}
+		conf.secrets[name] = val
+		maskedMu.Lock()
+		maskedValues[val] = true
+		maskedMu.Unlock()
+	}
+	maskLogOutput.Do(func() {
+		log.SetOutput(maskingWriter{log.Writer()})
+	})
+	return nil
+}
+
+// maskSecrets replaces the values of the secrets read so far in s.
+func maskSecrets(s string) string {
+	maskedMu.Lock()
+	defer maskedMu.Unlock()
+	values := []string{}
+	for val := range maskedValues {
+		if val != "" {
+			values = append(values, val)
+		}
+	}
+	// Replace longer values first in case one contains another.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, val := range values {
+		s = strings.ReplaceAll(s, val, "****")
+	}
+	return s
+}
+
+type maskingWriter struct {
+	w io.Writer
+}
+
+func (m maskingWriter) Write(p []byte) (int, error) {
+	if _, err := m.w.Write([]byte(maskSecrets(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// listEnv returns the shell command that lists the environment of a job,
+// masking the values of the hidden variables.
+func listEnv(hidden []string) string {
+	if len(hidden) == 0 {
+		return "env | sort"
+	}
+	return fmt.Sprintf("env | sort | sed -E 's/^(%s)=.*/\\1=****/'", strings.Join(hidden, "|"))
+}
+
+// secretExports returns the shell commands that export the named secrets
+// for a job, along with the host variables they are read from, which must not
+// be shown in its output.
+func secretExports(conf *Config, names []string, container bool) (string, []string, error) {
+	var b strings.Builder
+	hidden := []string{}
+	for _, name := range names {
+		s, err := configSecret(conf, name)
+		if err != nil {
+			return "", nil, err
+		}
+		if s.env != "" {
+			hidden = append(hidden, s.env)
+		}
+		fmt.Fprintf(&b, "export %s=%s\n", name, s.shell())
+		if container {
+			fmt.Fprintf(&b, "export SINGULARITYENV_%s=\"$%s\"\n", name, name)
+		}
+	}
+	return b.String(), hidden, nil
+}
@@ -0,0 +1,91 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_secretExports(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(fn, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer defaultConfig.Set("secrets", nil)
+	defaultConfig.Set("secrets", map[string]interface{}{
+		"token":    map[string]interface{}{"env": "FLOW_TEST_TOKEN"},
+		"password": map[string]interface{}{"file": fn},
+		"key":      map[string]interface{}{"vault": "secret/data/s3", "field": "key"},
+		"broken":   map[string]interface{}{"env": "X", "file": fn},
+	})
+	tests := []struct {
+		name      string
+		names     []string
+		container bool
+		want      string
+		hidden    []string
+		wantErr   bool
+	}{
+		{"env", []string{"TOKEN"}, false, "export TOKEN=\"$FLOW_TEST_TOKEN\"\n", []string{"FLOW_TEST_TOKEN"}, false},
+		{"file", []string{"PASSWORD"}, true, "export PASSWORD=\"$(cat " + fn + ")\"\nexport SINGULARITYENV_PASSWORD=\"$PASSWORD\"\n", []string{}, false},
+		{"vault", []string{"KEY"}, false, "export KEY=\"$(vault kv get -field=key secret/data/s3)\"\n", []string{}, false},
+		{"undefined", []string{"MISSING"}, false, "", nil, true},
+		{"broken", []string{"BROKEN"}, false, "", nil, true},
+	}
+	defaultConfig.Set("vault_bin", "vault")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hidden, err := secretExports(defaultConfig, tt.names, tt.container)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secretExports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("secretExports() = %q, want %q", got, tt.want)
+			}
+			if len(hidden) != len(tt.hidden) {
+				t.Errorf("secretExports() hidden = %v, want %v", hidden, tt.hidden)
+			}
+		})
+	}
+}
+
+func Test_resolveSecrets_config(t *testing.T) {
+	dir := t.TempDir()
+	confs := []*Config{}
+	for _, val := range []string{"first", "second"} {
+		fn := filepath.Join(dir, val)
+		if err := ioutil.WriteFile(fn, []byte(val+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c := newConfig()
+		c.Set("secrets", map[string]interface{}{"token": map[string]interface{}{"file": fn}})
+		if err := resolveSecrets(c, []string{"TOKEN"}); err != nil {
+			t.Fatal(err)
+		}
+		confs = append(confs, c)
+	}
+	if got := confs[1].secrets["TOKEN"]; got != "second" {
+		t.Errorf("resolveSecrets() read %q, want the value of the second config", got)
+	}
+	if got, want := maskSecrets("first second"), "**** ****"; got != want {
+		t.Errorf("maskSecrets() = %v, want %v", got, want)
+	}
+}
+
+func Test_maskSecrets(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(fn, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer defaultConfig.Set("secrets", nil)
+	defaultConfig.Set("secrets", map[string]interface{}{"password": map[string]interface{}{"file": fn}})
+	if err := resolveSecrets(defaultConfig, []string{"PASSWORD"}); err != nil {
+		t.Fatal(err)
+	}
+	got := maskSecrets("curl -u me:hunter2 https://example.com")
+	if want := "curl -u me:**** https://example.com"; got != want {
+		t.Errorf("maskSecrets() = %v, want %v", got, want)
+	}
+}
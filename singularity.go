@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// singularityCacheDir returns the directory singularity caches images in.
+func singularityCacheDir(conf *Config) (string, error) {
+	dir := conf.GetString("singularity_cachedir")
+	if dir == "" {
+		dir = filepath.Join(conf.GetString("flowdir"), "cache", "singularity")
+	}
+	return filepath.Abs(dir)
+}
+
+// singularityEnv returns the variables that set the cache and temporary
+// directories of singularity, under the names both singularity and apptainer
+// use, creating the directories if needed.
+func singularityEnv(conf *Config) ([]string, error) {
+	cacheDir, err := singularityCacheDir(conf)
+	if err != nil {
+		return nil, err
+	}
+	tmpDir := conf.GetString("singularity_tmpdir")
+	if tmpDir == "" {
+		tmpDir = conf.GetString("tmpdir")
+	}
+	if tmpDir, err = filepath.Abs(tmpDir); err != nil {
+		return nil, err
+	}
+	for _, d := range []string{cacheDir, tmpDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("unable to create singularity directory: %v", err)
+		}
+	}
+	env := []string{}
+	for _, prefix := range []string{"SINGULARITY", "APPTAINER"} {
+		env = append(env, prefix+"_CACHEDIR="+cacheDir, prefix+"_TMPDIR="+tmpDir)
+	}
+	return env, nil
+}
+
+// singularityExports returns the shell commands that export singularityEnv.
+func singularityExports(conf *Config) (string, error) {
+	env, err := singularityEnv(conf)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		b.WriteString(fmt.Sprintf("export %s=%s\n", parts[0], shellQuote(parts[1])))
+	}
+	return b.String(), nil
+}
+
+// PruneImageCache removes the files in the singularity cache of c that have
+// not been modified for olderThan, or all of them if olderThan is 0, and the
+// directories left empty. It returns the number of files removed and their
+// size. Images that are removed are pulled again when next needed.
+func (c *Config) PruneImageCache(olderThan time.Duration) (int, int64, error) {
+	dir, err := singularityCacheDir(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ok, _ := fileExists(dir); !ok {
+		return 0, 0, nil
+	}
+	removed, freed := 0, int64(0)
+	dirs := []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if olderThan > 0 && time.Since(info.ModTime()) < olderThan {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, freed, fmt.Errorf("unable to prune image cache: %v", err)
+	}
+	// Deepest first, so that directories emptied by removing their
+	// subdirectories are removed too. Remove fails on those not empty.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,69 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_singularityExports(t *testing.T) {
+	dir := t.TempDir()
+	for key, value := range map[string]interface{}{
+		"flowdir":              filepath.Join(dir, ".flow"),
+		"tmpdir":               filepath.Join(dir, "tmp"),
+		"singularity_cachedir": "",
+	} {
+		defer defaultConfig.Set(key, defaultConfig.Get(key))
+		defaultConfig.Set(key, value)
+	}
+	got, err := singularityExports(defaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := filepath.Join(dir, ".flow", "cache", "singularity")
+	for _, want := range []string{
+		"export SINGULARITY_CACHEDIR=" + cacheDir + "\n",
+		"export APPTAINER_CACHEDIR=" + cacheDir + "\n",
+		"export SINGULARITY_TMPDIR=" + filepath.Join(dir, "tmp") + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("singularityExports() = %q, want it to contain %q", got, want)
+		}
+	}
+	if ok, _ := fileExists(cacheDir); !ok {
+		t.Errorf("singularityExports() did not create %s", cacheDir)
+	}
+}
+
+func Test_PruneImageCache(t *testing.T) {
+	dir := t.TempDir()
+	defer defaultConfig.Set("singularity_cachedir", defaultConfig.Get("singularity_cachedir"))
+	defaultConfig.Set("singularity_cachedir", dir)
+	old := filepath.Join(dir, "cache", "oci-tmp", "old.sif")
+	recent := filepath.Join(dir, "cache", "blob", "recent")
+	for _, fn := range []string{old, recent} {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	then := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(old, then, then); err != nil {
+		t.Fatal(err)
+	}
+	removed, freed, err := defaultConfig.PruneImageCache(24 * time.Hour)
+	if err != nil || removed != 1 || freed != 10 {
+		t.Errorf("PruneImageCache() = %d, %d, %v, want 1, 10, nil", removed, freed, err)
+	}
+	if ok, _ := fileExists(filepath.Dir(old)); ok {
+		t.Errorf("PruneImageCache() left empty directory %s", filepath.Dir(old))
+	}
+	if removed, _, _ := defaultConfig.PruneImageCache(0); removed != 1 {
+		t.Errorf("PruneImageCache(0) removed %d files, want 1", removed)
+	}
+}
@@ -7,33 +7,109 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type SlurmRunner struct {
+	boundConfig
+	// states caches the job states fetched by Refresh.
+	states map[string]string
 }
 
 func NewSlurmRunner() (*SlurmRunner, error) {
 	return &SlurmRunner{}, nil
 }
 
+// Refresh fetches the state of all the jobs with one sacct command per batch.
+// Jobs sacct has no record of are cached with an empty state, as they would
+// have if asked about one at a time.
+func (r *SlurmRunner) Refresh(jobs []*job) error {
+	r.states = make(map[string]string)
+	for _, batch := range jobBatches(jobs) {
+		states, err := sacctStates(batch)
+		if err != nil {
+			return err
+		}
+		for _, id := range batch {
+			r.states[id] = states[id]
+		}
+	}
+	return nil
+}
+
+// state returns the state of the job, from the last Refresh if possible.
+func (r *SlurmRunner) state(j *job) (string, error) {
+	if state, ok := r.states[j.ID]; ok {
+		return state, nil
+	}
+	return jobState(j)
+}
+
+// sacctStates returns the state of each of the jobs with ids that sacct has a
+// record of: that of its batch step or, for a job that has none yet, such as
+// one still pending, that of the job as a whole. Jobs sacct has no record of
+// are missing.
+func sacctStates(ids []string) (map[string]string, error) {
+	cmd := exec.Command("sacct", "-j", strings.Join(ids, ","), "-o", "jobid,state", "-n", "-P")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine job states: %s: %s", err, string(out))
+	}
+	states := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 2 {
+			continue
+		}
+		// Jobs cancelled by a user are "CANCELLED by <uid>".
+		state := strings.Fields(fields[1])
+		if len(state) == 0 {
+			continue
+		}
+		switch id := fields[0]; {
+		case strings.HasSuffix(id, ".batch"):
+			states[strings.TrimSuffix(id, ".batch")] = state[0]
+		case !strings.Contains(id, "."):
+			if _, ok := states[id]; !ok {
+				states[id] = state[0]
+			}
+		}
+	}
+	return states, nil
+}
+
 func (r *SlurmRunner) Run(ctx executionContext) error {
 	jobName := ctx.job.Cmd.AnalysisName()
-	resources := ctx.job.Cmd.Resources()
-	tmpdir, err := filepath.Abs(v.GetString("tmpdir"))
+	resources := ctx.job.resources
+	conf := r.conf()
+	tmpdir, err := filepath.Abs(conf.GetString("tmpdir"))
 	if err != nil {
 		return fmt.Errorf("failed to get abs path of tmpdir: %s", err)
 	}
+	// Secrets read from the environment are exported by name, so their
+	// values are not on the command line.
+	_, secretVars, err := secretExports(conf, resources.Secrets, false)
+	if err != nil {
+		return err
+	}
+	export := append([]string{"TMPDIR=" + tmpdir}, secretVars...)
 	cmd := exec.Command(
 		"sbatch",
 		"--job-name", jobName,
 		"-o", ctx.job.Stdout,
 		"--parsable",
-		fmt.Sprintf("--export=TMPDIR=%s", tmpdir),
+		"--export="+strings.Join(export, ","),
 		fmt.Sprintf("--cpus-per-task=%d", resources.CPUs),
 		fmt.Sprintf("--mem=%dG", resources.Memory),
 		fmt.Sprintf("--time=%02d:00:00", resources.Time),
-		ctx.script,
 	)
+	cmd.Args = append(cmd.Args, slurmOptions(conf, resources)...)
+	extra, err := schedulerArgs(conf, resources)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append(cmd.Args, extra...)
+	cmd.Args = append(cmd.Args, ctx.script)
 	ctx.job.BatchCommand = strings.Join(cmd.Args, " ")
 	cmd.Dir = ctx.dir
 	out, err := cmd.CombinedOutput()
@@ -45,25 +121,94 @@ func (r *SlurmRunner) Run(ctx executionContext) error {
 	return nil
 }
 
+// slurmOptions returns the sbatch options for the account, partition and QOS
+// of a job, from its resources or the slurm config.
+func slurmOptions(conf *Config, r Resources) []string {
+	opts := []string{}
+	for _, o := range []struct{ flag, val, key string }{
+		{"--account", r.Account, "slurm.account"},
+		{"--partition", r.Queue, "slurm.partition"},
+		{"--qos", r.QOS, "slurm.qos"},
+	} {
+		val := o.val
+		if val == "" {
+			val = conf.GetString(o.key)
+		}
+		if val != "" {
+			opts = append(opts, o.flag+"="+val)
+		}
+	}
+	return opts
+}
+
 func (r *SlurmRunner) Completed(j *job) (bool, error) {
-	state, err := jobState(j)
-	return (state == "COMPLETED" || state == "FAILED" || state == "CANCELLED"), err
+	state, err := r.state(j)
+	if err != nil {
+		return false, err
+	}
+	switch state {
+	case "COMPLETED", "FAILED", "CANCELLED", "PREEMPTED", "NODE_FAIL", "BOOT_FAIL", "TIMEOUT", "OUT_OF_MEMORY", "DEADLINE":
+		return true, nil
+	}
+	return vanished(r.conf(), j, state), nil
+}
+
+// Known reports whether sacct has a record of the job.
+func (r *SlurmRunner) Known(j *job) (bool, error) {
+	state, err := r.state(j)
+	return state != "", err
+}
+
+// vanished reports whether sacct has no record of a job, not even one that
+// is pending, that was submitted long enough ago that it should.
+func vanished(conf *Config, j *job, state string) bool {
+	return state == "" && !j.submitted.IsZero() && time.Since(j.submitted) > conf.GetDuration("vanished_timeout")
+}
+
+// NodeFailed reports whether the node of the job failed, or the job
+// vanished.
+func (r *SlurmRunner) NodeFailed(j *job) (bool, error) {
+	state, err := r.state(j)
+	return state == "NODE_FAIL" || state == "BOOT_FAIL" || vanished(r.conf(), j, state), err
+}
+
+// TimedOut reports whether the job was killed for running longer than its
+// time. The batch step of such a job is usually cancelled, and only the job
+// as a whole is recorded as TIMEOUT.
+func (r *SlurmRunner) TimedOut(j *job) (bool, error) {
+	state, err := r.state(j)
+	if err != nil || !strings.HasPrefix(state, "CANCELLED") {
+		return state == "TIMEOUT", err
+	}
+	out, err := exec.Command("sacct", "-j", j.ID, "-X", "-o", "state", "-n", "-P").CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("unable to determine job state: %s: %s", err, string(out))
+	}
+	return strings.TrimSpace(string(out)) == "TIMEOUT", nil
+}
+
+// Preempted reports whether the job was preempted. Jobs requeued on
+// preemption are pending again rather than preempted.
+func (r *SlurmRunner) Preempted(j *job) (bool, error) {
+	state, err := r.state(j)
+	return state == "PREEMPTED", err
 }
 
 func (r *SlurmRunner) CompletedSuccessfully(j *job) (bool, error) {
-	state, err := jobState(j)
+	state, err := r.state(j)
+	if state == "OUT_OF_MEMORY" {
+		log.Printf("Job %s was killed for using more than its %dGB of memory", j.ID, j.resources.Memory)
+	}
 	return state == "COMPLETED", err
 }
 
+// jobState returns the state of the job, or "" if sacct has no record of it.
 func jobState(j *job) (string, error) {
-	jobId := fmt.Sprintf("%s.batch", j.ID)
-	cmd := exec.Command("sacct", "-j", jobId, "-o", "state", "-n", "-P")
-	out, err := cmd.CombinedOutput()
+	states, err := sacctStates([]string{j.ID})
 	if err != nil {
-		return "", fmt.Errorf("unable to determine job state: %s: %s", err, string(out))
+		return "", err
 	}
-	state := strings.TrimSuffix(string(out), "\n")
-	return state, nil
+	return states[j.ID], nil
 }
 
 func (r *SlurmRunner) ResourcesUsed(j *job) (resourcesUsed, error) {
@@ -81,3 +226,8 @@ func (r *SlurmRunner) Kill(j *job) error {
 	}
 	return nil
 }
+
+var _ batchRunner = &SlurmRunner{}
+var _ preemptionRunner = &SlurmRunner{}
+var _ nodeFailureRunner = &SlurmRunner{}
+var _ reattachRunner = &SlurmRunner{}
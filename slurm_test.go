@@ -0,0 +1,96 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeCommand puts a command called name that prints out on the PATH until
+// the returned function is called.
+func fakeCommand(t *testing.T, name, out string) func() {
+	return fakeScript(t, name, "cat <<'EOF'\n"+out+"EOF\n")
+}
+
+// fakeScript puts a command called name that runs the shell script body
+// first on the PATH, and returns a function that restores the PATH.
+func fakeScript(t *testing.T, name, body string) func() {
+	bin := t.TempDir()
+	script := "#!/bin/sh\n" + body
+	if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+":"+path)
+	return func() { os.Setenv("PATH", path) }
+}
+
+func Test_slurmOptions(t *testing.T) {
+	defer defaultConfig.Set("slurm", nil)
+	defaultConfig.Set("slurm", map[string]interface{}{"account": "proj1", "partition": "normal"})
+	tests := []struct {
+		name string
+		r    Resources
+		want []string
+	}{
+		{"config", Resources{}, []string{"--account=proj1", "--partition=normal"}},
+		{"task", Resources{Queue: "gpu", QOS: "high"}, []string{"--account=proj1", "--partition=gpu", "--qos=high"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slurmOptions(defaultConfig, tt.r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("slurmOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_SlurmRunner_vanished(t *testing.T) {
+	defer defaultConfig.Set("vanished_timeout", defaultConfig.Get("vanished_timeout"))
+	defaultConfig.Set("vanished_timeout", "10m")
+	tests := []struct {
+		name          string
+		sacct         string
+		wantCompleted bool
+		wantNodeFail  bool
+	}{
+		{"pending", "123|PENDING\n", false, false},
+		{"running", "123|RUNNING\n123.batch|RUNNING\n123.extern|RUNNING\n", false, false},
+		{"cancelled while pending", "123|CANCELLED by 1000\n", true, false},
+		{"failed", "123|FAILED\n123.batch|FAILED\n", true, false},
+		{"out of memory", "123|OUT_OF_MEMORY\n123.batch|OUT_OF_MEMORY\n", true, false},
+		{"deadline", "123|DEADLINE\n", true, false},
+		{"no record", "", true, true},
+	}
+	for _, tt := range tests {
+		for _, refresh := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s refresh=%v", tt.name, refresh), func(t *testing.T) {
+				defer fakeCommand(t, "sacct", tt.sacct)()
+				j := &job{ID: "123", submitted: time.Now().Add(-time.Hour)}
+				r := &SlurmRunner{}
+				if refresh {
+					if err := r.Refresh([]*job{j}); err != nil {
+						t.Fatal(err)
+					}
+					// The states must all come from the Refresh.
+					defer fakeScript(t, "sacct", "exit 1\n")()
+				}
+				completed, err := r.Completed(j)
+				if err != nil || completed != tt.wantCompleted {
+					t.Errorf("Completed() = %v, %v, want %v", completed, err, tt.wantCompleted)
+				}
+				if ok, err := r.CompletedSuccessfully(j); err != nil || ok {
+					t.Errorf("CompletedSuccessfully() = %v, %v, want false", ok, err)
+				}
+				failed, err := r.NodeFailed(j)
+				if err != nil || failed != tt.wantNodeFail {
+					t.Errorf("NodeFailed() = %v, %v, want %v", failed, err, tt.wantNodeFail)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SubmissionVersion is the version of the Submission schema understood by
+// this version of flow.
+const SubmissionVersion = 1
+
+// Submission describes a workflow as data, so that it can be written by
+// programs that are not written in Go and sent as JSON, or written by hand in
+// YAML. Within a version the schema only changes in backwards compatible
+// ways. An example in JSON:
+//
+//	{
+//	  "version": 1,
+//	  "name": "example",
+//	  "params": {"word": "hello"},
+//	  "tasks": [
+//	    {
+//	      "id": "create",
+//	      "name": "CreateInput",
+//	      "command": "echo {{.Params.word}} >{{.Outputs.out}}",
+//	      "outputs": {"out": "input.txt"},
+//	      "resources": {"cpus": 1, "memory": 1, "time": 1, "container": "docker://debian:10"}
+//	    },
+//	    {
+//	      "name": "ToUpper",
+//	      "command": "tr '[:lower:]' '[:upper:]' <{{.Inputs.in}} >{{.Outputs.out}}",
+//	      "inputs": {"in": "input.txt"},
+//	      "outputs": {"out": "output.txt"}
+//	    }
+//	  ]
+//	}
+//
+// Tasks depend on the tasks producing their inputs, as for tasks written in
+// Go. A task can also be made to wait for others by listing their ids in
+// "after".
+type Submission struct {
+	Version int               `json:"version" yaml:"version"`
+	Name    string            `json:"name,omitempty" yaml:"name"`
+	Params  map[string]string `json:"params,omitempty" yaml:"params"`
+	Tasks   []TaskSpec        `json:"tasks" yaml:"tasks"`
+}
+
+// TaskSpec describes one task of a Submission. Command is a text/template
+// rendered with the named Inputs, Outputs and the Params of the submission.
+type TaskSpec struct {
+	ID        string            `json:"id,omitempty" yaml:"id"`
+	Name      string            `json:"name" yaml:"name"`
+	Command   string            `json:"command" yaml:"command"`
+	Inputs    map[string]string `json:"inputs,omitempty" yaml:"inputs"`
+	Outputs   map[string]string `json:"outputs" yaml:"outputs"`
+	After     []string          `json:"after,omitempty" yaml:"after"`
+	Resources ResourceSpec      `json:"resources,omitempty" yaml:"resources"`
+}
+
+// ResourceSpec is the resources of a TaskSpec. Unset values take the same
+// defaults as Task.
+type ResourceSpec struct {
+	CPUs                 int               `json:"cpus,omitempty" yaml:"cpus"`
+	Memory               int               `json:"memory,omitempty" yaml:"memory"`
+	Time                 int               `json:"time,omitempty" yaml:"time"`
+	Container            string            `json:"container,omitempty" yaml:"container"`
+	SingularityExtraArgs string            `json:"singularity_extra_args,omitempty" yaml:"singularity_extra_args"`
+	Scratch              bool              `json:"scratch,omitempty" yaml:"scratch"`
+	Isolated             bool              `json:"isolated,omitempty" yaml:"isolated"`
+	Tmpfs                int               `json:"tmpfs,omitempty" yaml:"tmpfs"`
+	Labels               []string          `json:"labels,omitempty" yaml:"labels"`
+	Env                  map[string]string `json:"env,omitempty" yaml:"env"`
+	Ulimits              map[string]string `json:"ulimits,omitempty" yaml:"ulimits"`
+	Secrets              []string          `json:"secrets,omitempty" yaml:"secrets"`
+	Modules              []string          `json:"modules,omitempty" yaml:"modules"`
+	Conda                string            `json:"conda,omitempty" yaml:"conda"`
+	Account              string            `json:"account,omitempty" yaml:"account"`
+	Queue                string            `json:"queue,omitempty" yaml:"queue"`
+	QOS                  string            `json:"qos,omitempty" yaml:"qos"`
+	SchedulerArgs        string            `json:"scheduler_args,omitempty" yaml:"scheduler_args"`
+	AllowFailure         bool              `json:"allow_failure,omitempty" yaml:"allow_failure"`
+	OutputSize           string            `json:"output_size,omitempty" yaml:"output_size"`
+	Pack                 string            `json:"pack,omitempty" yaml:"pack"`
+	Batch                int               `json:"batch,omitempty" yaml:"batch"`
+	Checkpoint           string            `json:"checkpoint,omitempty" yaml:"checkpoint"`
+}
+
+// DecodeSubmission reads a JSON Submission from r. Unknown fields are an
+// error, as they are most likely mistakes.
+func DecodeSubmission(r io.Reader) (*Submission, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	var s Submission
+	if err := d.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode submission: %v", err)
+	}
+	if s.Version != SubmissionVersion {
+		return nil, fmt.Errorf("unsupported submission version: %d (expected %d)", s.Version, SubmissionVersion)
+	}
+	return &s, nil
+}
+
+// Commanders validates the submission and returns its tasks, ready to be
+// added to a Queue.
+func (s *Submission) Commanders() ([]Commander, error) {
+	tasks := []*TemplateTask{}
+	byID := make(map[string]*TemplateTask)
+	for _, spec := range s.Tasks {
+		t, err := spec.task(s.Params)
+		if err != nil {
+			return nil, err
+		}
+		if spec.ID != "" {
+			if _, ok := byID[spec.ID]; ok {
+				return nil, fmt.Errorf("duplicate task id: %s", spec.ID)
+			}
+			byID[spec.ID] = t
+		}
+		tasks = append(tasks, t)
+	}
+	cmds := []Commander{}
+	for i, spec := range s.Tasks {
+		for _, id := range spec.After {
+			other, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("task %s runs after unknown task: %s", spec.Name, id)
+			}
+			// Waiting on the outputs of the other task makes it a
+			// dependency like any other.
+			for _, f := range other.Outputs {
+				tasks[i].Needs = append(tasks[i].Needs, f.Path)
+			}
+		}
+		cmds = append(cmds, tasks[i])
+	}
+	return cmds, nil
+}
+
+func (t TaskSpec) task(params map[string]string) (*TemplateTask, error) {
+	if t.Name == "" {
+		return nil, fmt.Errorf("task has no name")
+	}
+	if t.Command == "" {
+		return nil, fmt.Errorf("task %s has no command", t.Name)
+	}
+	if len(t.Outputs) == 0 {
+		return nil, fmt.Errorf("task %s has no outputs", t.Name)
+	}
+	r := t.Resources
+	task := &TemplateTask{
+		Task: Task{
+			Name:                 t.Name,
+			CPUs:                 r.CPUs,
+			Memory:               r.Memory,
+			Time:                 r.Time,
+			Container:            r.Container,
+			SingularityExtraArgs: r.SingularityExtraArgs,
+			Scratch:              r.Scratch,
+			Isolated:             r.Isolated,
+			Tmpfs:                r.Tmpfs,
+			Labels:               r.Labels,
+			Env:                  r.Env,
+			Ulimits:              r.Ulimits,
+			Secrets:              r.Secrets,
+			Modules:              r.Modules,
+			Conda:                r.Conda,
+			Account:              r.Account,
+			Queue:                r.Queue,
+			QOS:                  r.QOS,
+			SchedulerArgs:        r.SchedulerArgs,
+			AllowFailure:         r.AllowFailure,
+			OutputSize:           r.OutputSize,
+			Pack:                 r.Pack,
+			Batch:                r.Batch,
+			Checkpoint:           r.Checkpoint,
+		},
+		Template: t.Command,
+		Params:   params,
+	}
+	task.InputNames, task.Inputs = sortedPaths(t.Inputs)
+	task.OutputNames, task.Outputs = sortedPaths(t.Outputs)
+	// Catch mistakes in the template now rather than when the job runs.
+	if _, err := renderCommand(task); err != nil {
+		return nil, fmt.Errorf("invalid command for task %s: %v", t.Name, err)
+	}
+	return task, nil
+}
+
+func sortedPaths(m map[string]string) ([]string, []File) {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := []File{}
+	for _, name := range names {
+		files = append(files, File{Path: m[name], Name: name})
+	}
+	return names, files
+}
+
+// renderCommand calls t.Command, returning an error instead of panicking if
+// the template cannot be rendered.
+func renderCommand(t Commander) (cmd string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return t.Command(), nil
+}
@@ -0,0 +1,100 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_TaskSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    TaskSpec
+		want    string
+		wantErr bool
+	}{
+		{
+			"ok",
+			TaskSpec{
+				Name:    "sort",
+				Command: "sort {{.Inputs.in}} >{{.Outputs.out}}",
+				Inputs:  map[string]string{"in": "a.txt"},
+				Outputs: map[string]string{"out": "b.txt"},
+			},
+			"sort a.txt >b.txt",
+			false,
+		},
+		{"no_name", TaskSpec{Command: "true", Outputs: map[string]string{"out": "b.txt"}}, "", true},
+		{"no_command", TaskSpec{Name: "x", Outputs: map[string]string{"out": "b.txt"}}, "", true},
+		{"no_outputs", TaskSpec{Name: "x", Command: "true"}, "", true},
+		{"bad_template", TaskSpec{Name: "x", Command: "{{.Inputs.in", Outputs: map[string]string{"out": "b.txt"}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.task.task(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("task() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Command() != tt.want {
+				t.Errorf("Command() = %v, want %v", got.Command(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_TemplateTask_expandedInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.fq", "2.fq", "ref.fa"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := TaskSpec{
+		Name:    "align",
+		Command: "align {{.Inputs.ref}} {{.Inputs.reads}} {{.Inputs.unpaired}} >{{.Outputs.out}}",
+		Inputs: map[string]string{
+			"reads":    filepath.Join(dir, "*.fq"),
+			"ref":      filepath.Join(dir, "ref.fa"),
+			"unpaired": filepath.Join(dir, "*.unpaired.fq"),
+		},
+		Outputs: map[string]string{"out": filepath.Join(dir, "out.bam")},
+	}
+	task, err := spec.task(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expandInputs(task); err != nil {
+		t.Fatal(err)
+	}
+	want := "align " + filepath.Join(dir, "ref.fa") + " " + filepath.Join(dir, "1.fq") + " " + filepath.Join(dir, "2.fq") + "  >" + filepath.Join(dir, "out.bam")
+	if got := task.Command(); got != want {
+		t.Errorf("Command() = %q, want %q", got, want)
+	}
+}
+
+func Test_DecodeSubmission(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"ok", `{"version": 1, "tasks": [{"id": "a", "name": "A", "command": "true >{{.Outputs.out}}", "outputs": {"out": "a.txt"}}, {"name": "B", "command": "true", "outputs": {"out": "b.txt"}, "after": ["a"]}]}`, false},
+		{"bad_version", `{"version": 2, "tasks": []}`, true},
+		{"unknown_field", `{"version": 1, "tasks": [], "extra": true}`, true},
+		{"unknown_after", `{"version": 1, "tasks": [{"name": "B", "command": "true", "outputs": {"out": "b.txt"}, "after": ["a"]}]}`, true},
+		{"duplicate_id", `{"version": 1, "tasks": [{"id": "a", "name": "A", "command": "true", "outputs": {"out": "a.txt"}}, {"id": "a", "name": "B", "command": "true", "outputs": {"out": "b.txt"}}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := DecodeSubmission(strings.NewReader(tt.json))
+			if err == nil {
+				_, err = s.Commanders()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeSubmission() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
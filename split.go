@@ -0,0 +1,135 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitTask splits a file of records, such as a FASTQ or BED file, into
+// files of consecutive records, the chunks, of about the same number of
+// records each, to scatter the work on it. SplitFastq, SplitBed and
+// SplitIntervals make one for those formats:
+//
+//	split := flow.SplitIntervals("targets.interval_list", "chunks/targets", 20)
+//	q.Add(split)
+//	flow.Map(q, split.Chunks, func(intervals string) flow.Commander {
+//		return &CallVariants{Intervals: intervals, ...}
+//	})
+//
+// Input may be compressed with gzip, in which case the chunks are too.
+type SplitTask struct {
+	Name   string
+	Input  string   `type:"input"`
+	Chunks []string `type:"output"`
+	// RecordLines is the number of lines of a record: 4 for FASTQ, 1 for
+	// most others.
+	RecordLines int
+	// Header matches the lines at the start of Input that are copied to
+	// the start of every chunk, such as the @ lines of an interval list.
+	Header string
+	// Res are the resources of the task. Unset values take the same
+	// defaults as Task.
+	Res Resources
+}
+
+// SplitFastq splits a FASTQ file into n chunks, named prefix.0001.fastq
+// and so on. The files of paired reads are split alike.
+func SplitFastq(input, prefix string, n int) *SplitTask {
+	return newSplitTask("split_fastq", input, prefix, ".fastq", n, 4, "")
+}
+
+// SplitBed splits a BED file into n chunks of consecutive regions, named
+// prefix.0001.bed and so on, with any track, browser and comment lines at
+// its start copied to each.
+func SplitBed(input, prefix string, n int) *SplitTask {
+	return newSplitTask("split_bed", input, prefix, ".bed", n, 1, "^(#|track|browser)")
+}
+
+// SplitIntervals splits a Picard interval list into n chunks of consecutive
+// intervals, named prefix.0001.interval_list and so on, each with the
+// header of the list.
+func SplitIntervals(input, prefix string, n int) *SplitTask {
+	return newSplitTask("split_intervals", input, prefix, ".interval_list", n, 1, "^@")
+}
+
+func newSplitTask(name, input, prefix, ext string, n, lines int, header string) *SplitTask {
+	if n < 1 {
+		panic(fmt.Sprintf("flow: cannot split %s into %d chunks", input, n))
+	}
+	if strings.HasSuffix(input, ".gz") {
+		ext += ".gz"
+	}
+	return &SplitTask{
+		Name:        name,
+		Input:       input,
+		Chunks:      chunkPaths(prefix, ext, n),
+		RecordLines: lines,
+		Header:      header,
+		Res:         Resources{CPUs: 1, Memory: 2, Time: 4},
+	}
+}
+
+// chunkPaths returns the names of n chunks: prefix.0001.ext and so on.
+func chunkPaths(prefix, ext string, n int) []string {
+	paths := []string{}
+	for i := 1; i <= n; i++ {
+		paths = append(paths, fmt.Sprintf("%s.%04d%s", prefix, i, ext))
+	}
+	return paths
+}
+
+func (t *SplitTask) AnalysisName() string {
+	return Task{Name: t.Name}.AnalysisName()
+}
+
+// splitProgram is the awk program that writes the records of its input to
+// the chunks in outs, one per line, given the total number of lines of
+// records. The records are split by their position so that the chunks of
+// paired FASTQ files match.
+const splitProgram = `BEGIN { n = split(outs, out, "\n"); records = total / lines }
+!body && header != "" && $0 ~ header { h = h $0 "\n"; next }
+{
+	body = 1
+	if (k % lines == 0) {
+		c = int(k / lines * n / records) + 1
+		if (c != cur) { if (cur) close(out[cur]); cur = c; printf "%s", h > out[c]; made[c] = 1 }
+	}
+	print > out[c]
+	k++
+}
+END { for (c = 1; c <= n; c++) if (!(c in made)) printf "%s", h > out[c] }`
+
+func (t *SplitTask) Command() string {
+	lines := t.RecordLines
+	if lines < 1 {
+		lines = 1
+	}
+	// Compressed chunks are written uncompressed and compressed at the end.
+	outs := []string{}
+	compressed := []string{}
+	for _, c := range t.Chunks {
+		if strings.HasSuffix(c, ".gz") {
+			c = strings.TrimSuffix(c, ".gz")
+			compressed = append(compressed, c)
+		}
+		outs = append(outs, c)
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(
+		"total=$(gzip -cdf %s | awk -v header=%s '!body && header != \"\" && $0 ~ header {next} {body = 1; n++} END {print n + 0}')\n",
+		shellQuote(t.Input), shellQuote(t.Header)))
+	b.WriteString(fmt.Sprintf(
+		"gzip -cdf %s | awk -v lines=%d -v total=\"$total\" -v header=%s -v outs=%s %s\n",
+		shellQuote(t.Input), lines, shellQuote(t.Header),
+		shellQuote(strings.Join(outs, "\\n")), shellQuote(splitProgram)))
+	if len(compressed) > 0 {
+		b.WriteString(fmt.Sprintf("gzip -f %s\n", strings.Join(quoteAll(compressed), " ")))
+	}
+	return b.String()
+}
+
+func (t *SplitTask) Resources() Resources {
+	task := Task{}
+	task.SetResources(t.Res)
+	return task.Resources()
+}
@@ -0,0 +1,70 @@
+package flow
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_SplitTask(t *testing.T) {
+	dir := t.TempDir()
+	intervals := filepath.Join(dir, "targets.interval_list")
+	content := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:1000\n"
+	for i := 1; i <= 5; i++ {
+		content += strings.Repeat("x", i) + "\n"
+	}
+	if err := ioutil.WriteFile(intervals, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fastq := filepath.Join(dir, "reads.fastq.gz")
+	f, err := os.Create(fastq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	for i := 0; i < 3; i++ {
+		w.Write([]byte("@r\nACGT\n+\nIIII\n"))
+	}
+	w.Close()
+	f.Close()
+
+	tests := []struct {
+		name    string
+		task    *SplitTask
+		records []int
+		header  string
+	}{
+		{"intervals", SplitIntervals(intervals, filepath.Join(dir, "chunks", "targets"), 2), []int{3, 2}, "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:1000\n"},
+		{"more_chunks_than_records", SplitIntervals(intervals, filepath.Join(dir, "many", "targets"), 6), []int{1, 1, 1, 1, 1, 0}, "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:1000\n"},
+		{"fastq", SplitFastq(fastq, filepath.Join(dir, "reads"), 2), []int{2, 1}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.MkdirAll(filepath.Dir(tt.task.Chunks[0]), 0755)
+			cmd := exec.Command("bash", "-e", "-o", "pipefail", "-c", tt.task.Command())
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+			for i, chunk := range tt.task.Chunks {
+				b, err := exec.Command("gzip", "-cdf", chunk).Output()
+				if err != nil {
+					t.Fatalf("chunk %s: %v", chunk, err)
+				}
+				if !strings.HasPrefix(string(b), tt.header) {
+					t.Errorf("chunk %s has no header:\n%s", chunk, b)
+				}
+				lines := strings.Count(strings.TrimPrefix(string(b), tt.header), "\n")
+				if want := tt.records[i] * tt.task.RecordLines; lines != want {
+					t.Errorf("chunk %s has %d lines, want %d", chunk, lines, want)
+				}
+			}
+		})
+	}
+	if got := SplitFastq(fastq, "reads", 1).Chunks[0]; got != "reads.0001.fastq.gz" {
+		t.Errorf("chunk name = %s, want reads.0001.fastq.gz", got)
+	}
+}
@@ -0,0 +1,204 @@
+package flow
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateDirs are the directories of the flowdir that hold its state, runs
+// first so that ImportState knows whether it has read the file before when
+// it comes to the usage.
+var stateDirs = []string{"runs", "done", "stats"}
+
+// ExportState writes the state of the flowdir of c, its done files, recorded
+// usage and run directories, to the tar file fn, gzipped if fn ends in .gz,
+// so that a partially completed run can be resumed on another system with
+// ImportState. Staged inputs, caches and work directories are left out.
+func (c *Config) ExportState(fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return fmt.Errorf("unable to create state file: %v", err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(fn, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	flowdir := c.GetString("flowdir")
+	for _, d := range stateDirs {
+		err := filepath.Walk(filepath.Join(flowdir, d), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(flowdir, path)
+			if err != nil {
+				return err
+			}
+			// Build directories and the IDs of jobs on this
+			// system's scheduler are no use elsewhere.
+			if info.IsDir() && strings.HasPrefix(info.Name(), "workflow") && filepath.Dir(filepath.Dir(rel)) == "runs" {
+				return filepath.SkipDir
+			}
+			if !info.Mode().IsRegular() || strings.HasSuffix(path, ".jobid") {
+				return nil
+			}
+			return archiveFile(tw, path, rel)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to export state: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("unable to write state file: %v", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("unable to write state file: %v", err)
+		}
+	}
+	return f.Close()
+}
+
+// ImportStateResult counts what ImportState read.
+type ImportStateResult struct {
+	// Done is the number of jobs registered as done and Runs the number
+	// of run directories added.
+	Done int
+	Runs int
+	// Missing are the outputs of jobs registered as done that do not
+	// exist yet, as the data may still be being copied.
+	Missing []string
+}
+
+// ImportState reads the state in the tar file fn, written by ExportState,
+// into the flowdir of c. rebase maps the directories the outputs of the jobs
+// were in to those they are in now, as for AdoptFlowdir. Jobs already done
+// and run directories already present are left as they are, and the usage
+// is not added again if all of the runs were. It takes the lock on the
+// flowdir, so it fails if a run is in progress.
+func (c *Config) ImportState(fn string, rebase map[string]string) (ImportStateResult, error) {
+	result := ImportStateResult{}
+	f, err := os.Open(fn)
+	if err != nil {
+		return result, fmt.Errorf("unable to open state file: %v", err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(fn, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return result, fmt.Errorf("unable to read state file: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := lockFlowdir(c); err != nil {
+		return result, err
+	}
+	defer unlockFlowdir(c)
+	flowdir := c.GetString("flowdir")
+	runs := map[string]bool{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("unable to read state file: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.FromSlash(hdr.Name)
+		parts := strings.SplitN(name, string(filepath.Separator), 3)
+		if len(parts) < 2 || filepath.IsAbs(name) || hasParentRef(name) {
+			return result, fmt.Errorf("invalid state file: unexpected entry %s", hdr.Name)
+		}
+		switch parts[0] {
+		case "done":
+			output := rebasePath(string(filepath.Separator)+strings.TrimSuffix(strings.TrimPrefix(name, "done"+string(filepath.Separator)), ".done"), rebase)
+			done := filepath.Join(flowdir, "done", output+".done")
+			if ok, _ := fileExists(done); ok {
+				continue
+			}
+			if err := writeStateFile(done, tr); err != nil {
+				return result, err
+			}
+			result.Done++
+			if ok, _ := pathExists(output); !ok {
+				result.Missing = append(result.Missing, output)
+			}
+		case "stats":
+			if name != filepath.Join("stats", "usage.tsv") || (len(runs) > 0 && result.Runs == 0) {
+				continue
+			}
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return result, fmt.Errorf("unable to read state file: %v", err)
+			}
+			if err := appendUsage(c, content); err != nil {
+				return result, err
+			}
+		case "runs":
+			dst := filepath.Join(flowdir, name)
+			isNew, seen := runs[parts[1]]
+			if !seen {
+				_, err := os.Stat(filepath.Join(flowdir, "runs", parts[1]))
+				isNew = os.IsNotExist(err)
+				runs[parts[1]] = isNew
+				if isNew {
+					result.Runs++
+				}
+			}
+			if !isNew {
+				continue
+			}
+			if err := writeStateFile(dst, tr); err != nil {
+				return result, err
+			}
+		default:
+			return result, fmt.Errorf("invalid state file: unexpected entry %s", hdr.Name)
+		}
+	}
+	return result, nil
+}
+
+// writeStateFile writes the content of r to fn.
+func writeStateFile(fn string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return fmt.Errorf("unable to create directory: %v", err)
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return fmt.Errorf("unable to import state: %v", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("unable to import state: %v", err)
+	}
+	return f.Close()
+}
+
+// hasParentRef reports whether the relative path p refers to a parent
+// directory, and so could be written outside the flowdir.
+func hasParentRef(p string) bool {
+	for _, elem := range strings.Split(p, string(filepath.Separator)) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ExportState(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	dir := t.TempDir()
+	oldFlowdir, newFlowdir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	write := func(fn, content string) {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := "2026-01-01_000000_abcd1234"
+	write(filepath.Join(oldFlowdir, "done", "scratch", "proj", "a.bam.done"), "")
+	write(filepath.Join(oldFlowdir, "done", "scratch", "proj", "b.bam.jobid"), "123")
+	write(filepath.Join(oldFlowdir, "stats", "usage.tsv"), "time\tanalysis_name\nx\talign\n")
+	write(filepath.Join(oldFlowdir, "runs", run, "jobreport.csv"), "job_id\n")
+	write(filepath.Join(oldFlowdir, "runs", run, "workflow123", "workflow.so"), "")
+	write(filepath.Join(oldFlowdir, "work", "ab", "cdef", "job.sh"), "")
+	write(filepath.Join(dir, "data", "proj", "a.bam"), "")
+
+	for _, fn := range []string{filepath.Join(dir, "run.tar"), filepath.Join(dir, "run.tar.gz")} {
+		t.Run(filepath.Base(fn), func(t *testing.T) {
+			defaultConfig.Set("flowdir", oldFlowdir)
+			if err := defaultConfig.ExportState(fn); err != nil {
+				t.Fatal(err)
+			}
+			flowdir := filepath.Join(newFlowdir, filepath.Base(fn))
+			if err := os.MkdirAll(flowdir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			defaultConfig.Set("flowdir", flowdir)
+			result, err := defaultConfig.ImportState(fn, map[string]string{"/scratch/proj": filepath.Join(dir, "data", "proj")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Done != 1 || result.Runs != 1 || len(result.Missing) != 0 {
+				t.Errorf("ImportState() = %+v, want 1 done and 1 run", result)
+			}
+			for _, p := range []string{
+				filepath.Join("done", dir, "data", "proj", "a.bam.done"),
+				filepath.Join("runs", run, "jobreport.csv"),
+				filepath.Join("stats", "usage.tsv"),
+			} {
+				if ok, _ := fileExists(filepath.Join(flowdir, p)); !ok {
+					t.Errorf("ImportState() did not create %s", p)
+				}
+			}
+			for _, p := range []string{
+				filepath.Join("runs", run, "workflow123"),
+				filepath.Join("work"),
+			} {
+				if _, err := os.Stat(filepath.Join(flowdir, p)); !os.IsNotExist(err) {
+					t.Errorf("ImportState() created %s, which should not be exported", p)
+				}
+			}
+			if matches, _ := filepath.Glob(filepath.Join(flowdir, "done", "*", "*", "*.jobid")); len(matches) > 0 {
+				t.Errorf("ImportState() created %v, which should not be exported", matches)
+			}
+			// Importing again changes nothing.
+			result, err = defaultConfig.ImportState(fn, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Runs != 0 {
+				t.Errorf("ImportState() again = %+v, want no runs", result)
+			}
+			history, err := usageHistory(defaultConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history["align"]) != 1 {
+				t.Errorf("usage history = %v, want one align sample", history)
+			}
+		})
+	}
+}
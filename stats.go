@@ -0,0 +1,227 @@
+package flow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// usageColumns are the columns of stats/usage.tsv in the flowdir, to which
+// the resources of each successful job are appended. Memory is in GB and
+// time in seconds.
+var usageColumns = []string{
+	"time", "analysis_name", "cpus_requested", "memory_requested",
+	"time_requested", "memory_used", "walltime_used",
+}
+
+// usageSample is what a job of an analysis asked for and used.
+type usageSample struct {
+	MemoryRequested int
+	TimeRequested   int
+	MemoryUsed      int
+	TimeUsed        int
+}
+
+func usageFile(conf *Config) string {
+	return filepath.Join(conf.GetString("flowdir"), "stats", "usage.tsv")
+}
+
+// recordUsage appends what j, which succeeded, used to the usage file.
+func recordUsage(conf *Config, j *job, used resourcesUsed) error {
+	if conf.GetBool("dry_run") {
+		return nil
+	}
+	secs := used.TimeUsed
+	if secs <= 0 && !j.submitted.IsZero() {
+		secs = int(time.Since(j.submitted).Seconds())
+	}
+	fn := usageFile(conf)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return fmt.Errorf("unable to create stats directory: %v", err)
+	}
+	_, err := os.Stat(fn)
+	header := os.IsNotExist(err)
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open usage file: %v", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	if header {
+		w.Write(usageColumns)
+	}
+	r := j.resources
+	w.Write([]string{
+		time.Now().Format(time.RFC3339),
+		j.Cmd.AnalysisName(),
+		strconv.Itoa(r.CPUs),
+		strconv.Itoa(r.Memory),
+		strconv.Itoa(r.Time),
+		strconv.Itoa(used.MemoryUsed),
+		strconv.Itoa(secs),
+	})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("unable to write usage file: %v", err)
+	}
+	return nil
+}
+
+// usageHistory returns the samples in the usage file by analysis, oldest
+// first. Lines it cannot parse are skipped.
+func usageHistory(conf *Config) (map[string][]usageSample, error) {
+	history := map[string][]usageSample{}
+	f, err := os.Open(usageFile(conf))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open usage file: %v", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	r.FieldsPerRecord = -1
+	cols := map[string]int{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read usage file: %v", err)
+		}
+		if len(cols) == 0 {
+			for i, c := range rec {
+				cols[c] = i
+			}
+			continue
+		}
+		field := func(name string) int {
+			i, ok := cols[name]
+			if !ok || i >= len(rec) {
+				return 0
+			}
+			x, _ := strconv.Atoi(rec[i])
+			return x
+		}
+		i, ok := cols["analysis_name"]
+		if !ok || i >= len(rec) {
+			continue
+		}
+		history[rec[i]] = append(history[rec[i]], usageSample{
+			MemoryRequested: field("memory_requested"),
+			TimeRequested:   field("time_requested"),
+			MemoryUsed:      field("memory_used"),
+			TimeUsed:        field("walltime_used"),
+		})
+	}
+	return history, nil
+}
+
+// percentile returns the pth percentile of xs, by the nearest rank, or 0 if
+// there are none.
+func percentile(xs []int, p float64) int {
+	if len(xs) == 0 {
+		return 0
+	}
+	sorted := append([]int{}, xs...)
+	sort.Ints(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// suggestResources returns the memory, in GB, and time, in hours, to ask for
+// from the 95th percentile of what samples used plus adaptive_margin, rounded
+// up. Either is 0 if no sample recorded it.
+func suggestResources(conf *Config, samples []usageSample) (memory, hours int) {
+	margin := conf.GetFloat64("adaptive_margin")
+	mem, secs := []int{}, []int{}
+	for _, s := range samples {
+		if s.MemoryUsed > 0 {
+			mem = append(mem, s.MemoryUsed)
+		}
+		if s.TimeUsed > 0 {
+			secs = append(secs, s.TimeUsed)
+		}
+	}
+	if p := percentile(mem, 95); p > 0 {
+		memory = int(math.Ceil(float64(p) * (1 + margin)))
+	}
+	if p := percentile(secs, 95); p > 0 {
+		hours = int(math.Ceil(float64(p) * (1 + margin) / 3600))
+	}
+	return memory, hours
+}
+
+// AnalysisStats summarises the recorded usage of the jobs of an analysis.
+type AnalysisStats struct {
+	Name string
+	Runs int
+	// MeanTime and MaxTime are how long its jobs took and MaxMemory the
+	// most memory, in GB, any of them used.
+	MeanTime  time.Duration
+	MaxTime   time.Duration
+	MaxMemory int
+	// SuggestedMemory, in GB, and SuggestedTime, in hours, are what its
+	// jobs should ask for, or 0 if unknown.
+	SuggestedMemory int
+	SuggestedTime   int
+}
+
+// UsageStats returns the recorded usage of each analysis that has been run
+// with the flowdir of c, by name.
+func (c *Config) UsageStats() ([]AnalysisStats, error) {
+	return usageStats(c)
+}
+
+func usageStats(conf *Config) ([]AnalysisStats, error) {
+	history, err := usageHistory(conf)
+	if err != nil {
+		return nil, err
+	}
+	stats := []AnalysisStats{}
+	for name, samples := range history {
+		s := AnalysisStats{Name: name, Runs: len(samples)}
+		sum := 0
+		for _, x := range samples {
+			sum += x.TimeUsed
+			if d := time.Duration(x.TimeUsed) * time.Second; d > s.MaxTime {
+				s.MaxTime = d
+			}
+			if x.MemoryUsed > s.MaxMemory {
+				s.MaxMemory = x.MemoryUsed
+			}
+		}
+		s.MeanTime = time.Duration(sum/len(samples)) * time.Second
+		s.SuggestedMemory, s.SuggestedTime = suggestResources(conf, samples)
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Name < stats[b].Name })
+	return stats, nil
+}
+
+// adaptResources sets the memory and time of r from samples, if there are
+// enough of them. Memory is left as it is if no memory used was recorded.
+func adaptResources(conf *Config, r *Resources, samples []usageSample) {
+	if len(samples) == 0 || len(samples) < conf.GetInt("adaptive_min_samples") {
+		return
+	}
+	memory, hours := suggestResources(conf, samples)
+	if memory > 0 {
+		r.Memory = memory
+	}
+	if hours > 0 {
+		r.Time = hours
+	}
+}
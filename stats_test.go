@@ -0,0 +1,118 @@
+package flow
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_recordUsage(t *testing.T) {
+	defer defaultConfig.Set("flowdir", defaultConfig.Get("flowdir"))
+	defer defaultConfig.Set("adaptive_margin", defaultConfig.Get("adaptive_margin"))
+	defaultConfig.Set("flowdir", t.TempDir())
+	defaultConfig.Set("adaptive_margin", 0.2)
+	newJob := func(name string) *job {
+		return &job{
+			Cmd:       &fileTask{Task: Task{Name: name}},
+			resources: Resources{CPUs: 1, Memory: 8, Time: 2},
+			submitted: time.Now().Add(-time.Minute),
+		}
+	}
+	for _, used := range []resourcesUsed{
+		{MemoryUsed: 4, TimeUsed: 3600},
+		{MemoryUsed: 6, TimeUsed: 1800},
+	} {
+		if err := recordUsage(defaultConfig, newJob("align"), used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The time since it was submitted is recorded when the runner does
+	// not report the time used.
+	if err := recordUsage(defaultConfig, newJob("qc"), resourcesUsed{}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := defaultConfig.UsageStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("UsageStats() = %v, want 2 analyses", stats)
+	}
+	align, qc := stats[0], stats[1]
+	if align.Name != "align" || align.Runs != 2 || align.MeanTime != 45*time.Minute || align.MaxTime != time.Hour || align.MaxMemory != 6 {
+		t.Errorf("align stats = %+v", align)
+	}
+	if align.SuggestedMemory != 8 || align.SuggestedTime != 2 {
+		t.Errorf("align suggested %d GB, %d h, want 8 GB, 2 h", align.SuggestedMemory, align.SuggestedTime)
+	}
+	if qc.MeanTime < time.Minute || qc.MaxMemory != 0 || qc.SuggestedMemory != 0 {
+		t.Errorf("qc stats = %+v", qc)
+	}
+	if d := analysisDurations(defaultConfig)["align"]; d != 45*time.Minute {
+		t.Errorf("analysisDurations() align = %v, want 45m", d)
+	}
+}
+
+func Test_percentile(t *testing.T) {
+	tests := []struct {
+		xs   []int
+		p    float64
+		want int
+	}{
+		{nil, 95, 0},
+		{[]int{3}, 95, 3},
+		{[]int{5, 1, 4, 2, 3}, 50, 3},
+		{[]int{5, 1, 4, 2, 3}, 95, 5},
+		{[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 100}, 95, 20},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.xs, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %d, want %d", tt.xs, tt.p, got, tt.want)
+		}
+	}
+}
+
+func Test_submitOrder_longestFirst(t *testing.T) {
+	defer defaultConfig.Set("longest_first", defaultConfig.Get("longest_first"))
+	defaultConfig.Set("longest_first", true)
+	newJob := func(name string, d time.Duration) *job {
+		return &job{Cmd: &fileTask{Task: Task{Name: name}}, expected: d}
+	}
+	jobs := []*job{newJob("a", time.Minute), newJob("b", 0), newJob("c", time.Hour), newJob("d", time.Minute)}
+	got := []string{}
+	for _, j := range submitOrder(defaultConfig, jobs) {
+		got = append(got, j.Cmd.AnalysisName())
+	}
+	if want := "c a d b"; strings.Join(got, " ") != want {
+		t.Errorf("submitOrder() = %v, want %s", got, want)
+	}
+}
+
+func Test_adaptResources(t *testing.T) {
+	defer defaultConfig.Set("adaptive_margin", defaultConfig.Get("adaptive_margin"))
+	defer defaultConfig.Set("adaptive_min_samples", defaultConfig.Get("adaptive_min_samples"))
+	defaultConfig.Set("adaptive_margin", 0.5)
+	defaultConfig.Set("adaptive_min_samples", 3)
+	samples := []usageSample{
+		{MemoryUsed: 10, TimeUsed: 600},
+		{MemoryUsed: 20, TimeUsed: 1200},
+	}
+	tests := []struct {
+		name    string
+		samples []usageSample
+		want    Resources
+	}{
+		{"too few samples", samples, Resources{Memory: 64, Time: 24}},
+		{"enough samples", append(samples, usageSample{MemoryUsed: 12, TimeUsed: 3000}), Resources{Memory: 30, Time: 2}},
+		{"memory not recorded", []usageSample{{TimeUsed: 60}, {TimeUsed: 60}, {TimeUsed: 60}}, Resources{Memory: 64, Time: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Resources{Memory: 64, Time: 24}
+			adaptResources(defaultConfig, &r, tt.samples)
+			if r.Memory != tt.want.Memory || r.Time != tt.want.Time {
+				t.Errorf("adaptResources() = %d GB, %d h, want %d GB, %d h", r.Memory, r.Time, tt.want.Memory, tt.want.Time)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Stdiner is implemented by tasks whose command reads content the task
+// generates, such as a list of files, on its standard input.
+type Stdiner interface {
+	Stdin() string
+}
+
+// stdinFile is the file in the work directory that holds the content of a
+// Stdiner.
+const stdinFile = "stdin"
+
+// writeStdin writes the content of a Stdiner to the work directory dir.
+func writeStdin(j *job, dir string) error {
+	s, ok := j.Cmd.(Stdiner)
+	if !ok {
+		return nil
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, stdinFile), []byte(s.Stdin()), 0644); err != nil {
+		return fmt.Errorf("unable to write stdin: %v", err)
+	}
+	return nil
+}
+
+// stdinRedirect returns the redirection of the standard input of the command
+// of j in the job script, or "" if it does not read one.
+func stdinRedirect(j *job, dir string) string {
+	if _, ok := j.Cmd.(Stdiner); ok {
+		return " <" + shellQuote(filepath.Join(dir, stdinFile))
+	}
+	ins := cmdTag(j.Cmd, "input", "stdin")
+	if len(ins) == 0 || ins[0] == "" {
+		return ""
+	}
+	if j.resources.Scratch {
+		inputs, _ := scratchPaths(j)
+		if rel, ok := inputs[ins[0]]; ok {
+			return ` <"$scratch"/` + shellQuote(rel)
+		}
+	}
+	return " <" + shellQuote(ins[0])
+}
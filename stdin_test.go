@@ -0,0 +1,74 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type stdinTask struct {
+	Task
+	Reads string `type:"input,stdin"`
+	Index string `type:"output"`
+}
+
+func (t *stdinTask) Command() string { return "index" }
+
+type generatedStdinTask struct {
+	Task
+	Index string `type:"output"`
+}
+
+func (t *generatedStdinTask) Command() string { return "index" }
+func (t *generatedStdinTask) Stdin() string   { return "a.txt\nb.txt\n" }
+
+type twoStdinTask struct {
+	Task
+	Reads []string `type:"input,stdin"`
+	Ref   string   `type:"input,stdin"`
+}
+
+func (t *twoStdinTask) Command() string { return "" }
+
+func Test_stdinRedirect(t *testing.T) {
+	dir := t.TempDir()
+	tagged := &job{Cmd: &stdinTask{Reads: "/data/reads.fq", Index: "/data/reads.idx"}, Inputs: []string{"/data/reads.fq"}}
+	if got, want := stdinRedirect(tagged, dir), " </data/reads.fq"; got != want {
+		t.Errorf("stdinRedirect() = %q, want %q", got, want)
+	}
+	tagged.resources.Scratch = true
+	if got, want := stdinRedirect(tagged, dir), ` <"$scratch"/in/0/reads.fq`; got != want {
+		t.Errorf("stdinRedirect() with scratch = %q, want %q", got, want)
+	}
+	generated := &job{Cmd: &generatedStdinTask{}}
+	if err := writeStdin(generated, dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, stdinFile)); string(b) != "a.txt\nb.txt\n" {
+		t.Errorf("stdin file = %q", b)
+	}
+	if got, want := stdinRedirect(generated, dir), " <"+filepath.Join(dir, stdinFile); got != want {
+		t.Errorf("stdinRedirect() = %q, want %q", got, want)
+	}
+	if got := stdinRedirect(&job{Cmd: &fileTask{}}, dir); got != "" {
+		t.Errorf("stdinRedirect() = %q for a task without stdin", got)
+	}
+}
+
+func Test_checkTags_stdin(t *testing.T) {
+	if errs := checkTags(&stdinTask{}); len(errs) > 0 {
+		t.Errorf("checkTags() = %v", errs)
+	}
+	errs := checkTags(&twoStdinTask{})
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{"field Reads: stdin must be on a single input", "more than one stdin"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("checkTags() = %s, want it to contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage provides access to files on a remote filesystem. Each Storage is
+// registered for a URI scheme with RegisterStorage. Inputs and outputs given
+// as URIs are replaced by paths in the staging directory when the task is
+// added; inputs are fetched before the job runs and outputs stored once it
+// has completed successfully.
+type Storage interface {
+	// Stat reports whether the file at uri exists.
+	Stat(uri string) (bool, error)
+	// Fetch downloads the file at uri to the local path dst.
+	Fetch(uri, dst string) error
+	// Store uploads the local file src to uri.
+	Store(src, uri string) error
+	// Checksum returns the checksum of the file at uri in the same form
+	// as File.Checksum, e.g. "md5:<hex>", or "" if it is not known.
+	Checksum(uri string) (string, error)
+}
+
+var storages = map[string]Storage{
+	"s3":    S3Storage{},
+	"gs":    GCSStorage{},
+	"az":    AzureStorage{},
+	"http":  HTTPStorage{},
+	"https": HTTPStorage{},
+	"ftp":   HTTPStorage{},
+	"irods": IRODSStorage{},
+}
+
+// RegisterStorage makes s available for URIs with the given scheme, replacing
+// any Storage already registered for it.
+func RegisterStorage(scheme string, s Storage) {
+	storages[scheme] = s
+}
+
+// configurable is implemented by the storages that take settings from the
+// config. withConfig returns a copy of the storage that uses conf.
+type configurable interface {
+	withConfig(conf *Config) Storage
+}
+
+// storageFor returns the Storage registered for the scheme of uri.
+func storageFor(conf *Config, uri string) (Storage, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URI: %s: %v", uri, err)
+	}
+	// Azure blobs are usually given as https URLs.
+	s, ok := storages[u.Scheme]
+	if isAzureBlob(u) {
+		s, ok = storages["az"], true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported URI scheme: %s", uri)
+	}
+	if c, ok := s.(configurable); ok {
+		s = c.withConfig(conf)
+	}
+	return s, nil
+}
+
+// isURI reports whether the path is a URI rather than a local path.
+func isURI(p string) bool {
+	return strings.Contains(p, "://")
+}
+
+func stagingDir(conf *Config) string {
+	dir, _ := filepath.Abs(filepath.Join(conf.GetString("flowdir"), "staging"))
+	return dir
+}
+
+func cacheDir(conf *Config) string {
+	dir, _ := filepath.Abs(filepath.Join(conf.GetString("flowdir"), "cache", "sha256"))
+	return dir
+}
+
+// queryDirPrefix starts the name of the directory that keeps the query of a
+// staged URI, which is the escaped "?".
+const queryDirPrefix = "%3F"
+
+// stagedPath returns the local path used for the URI. The query of the URI,
+// such as the signature of a presigned URL, is kept in a directory above the
+// file, named by escaping "?" and the query, so that the file keeps its name.
+func stagedPath(conf *Config, uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI: %s: %v", uri, err)
+	}
+	p := filepath.Join(stagingDir(conf), u.Scheme, u.Host, u.Path)
+	if u.RawQuery != "" {
+		p = filepath.Join(filepath.Dir(p), url.QueryEscape("?"+u.RawQuery), filepath.Base(p))
+	}
+	return p, nil
+}
+
+// stagedURI is the inverse of stagedPath. It returns the URI that the local
+// path stands in for, and false if the path is not in the staging directory.
+func stagedURI(conf *Config, p string) (string, bool) {
+	rel, err := filepath.Rel(stagingDir(conf), p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	rest := parts[2]
+	if dir, base := path.Split(rest); dir != "" {
+		if q := path.Base(dir); strings.HasPrefix(q, queryDirPrefix) {
+			if query, err := url.QueryUnescape(q); err == nil {
+				rest = strings.TrimSuffix(strings.TrimSuffix(dir, "/"), q) + base + query
+			}
+		}
+	}
+	return fmt.Sprintf("%s://%s/%s", parts[0], parts[1], rest), true
+}
+
+// fetch downloads the file at uri to the local path dst. checksum is the
+// expected checksum of the file, if known. If it is a sha256 checksum of a
+// file already in the download cache, the cached copy is used instead.
+func fetch(conf *Config, uri, dst, checksum string) error {
+	s, err := storageFor(conf, uri)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if algorithm, digest := splitChecksum(checksum); checksum != "" && algorithm == "sha256" {
+		cached := filepath.Join(cacheDir(conf), strings.ToLower(digest))
+		if ok, _ := fileExists(cached); ok {
+			return placeFile(cached, dst, "link")
+		}
+	}
+	// Download to a temporary name first so an interrupted transfer is
+	// never mistaken for a staged file. The partial file is left in place
+	// on failure so that tools that support it can resume the download.
+	tmp := dst + ".part"
+	if err := s.Fetch(uri, tmp); err != nil {
+		return err
+	}
+	want, err := s.Checksum(uri)
+	if err != nil {
+		return fmt.Errorf("unable to get checksum of %s: %v", uri, err)
+	}
+	if want != "" {
+		if err := (File{Path: tmp, Checksum: want}).verifyChecksum(); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("download of %s is corrupt: %v", uri, err)
+		}
+	}
+	return os.Rename(tmp, dst)
+}
+
+// store uploads the local file src to uri.
+func store(conf *Config, src, uri string) error {
+	s, err := storageFor(conf, uri)
+	if err != nil {
+		return err
+	}
+	return s.Store(src, uri)
+}
+
+// remoteExists reports whether the file at uri exists.
+func remoteExists(conf *Config, uri string) (bool, error) {
+	s, err := storageFor(conf, uri)
+	if err != nil {
+		return false, err
+	}
+	return s.Stat(uri)
+}
+
+// fetchInputs downloads any staged inputs of the job that are not already
+// present and are not produced by one of its dependencies.
+func fetchInputs(conf *Config, j *job) error {
+	checksums := make(map[string]string)
+	for _, f := range cmdFiles(j.Cmd, "input") {
+		checksums[f.Path] = f.Checksum
+	}
+	for _, in := range j.Inputs {
+		uri, ok := stagedURI(conf, in)
+		if !ok {
+			continue
+		}
+		produced := false
+		for _, d := range j.Dependencies {
+			if hasIntersection([]string{in}, d.Outputs) {
+				produced = true
+				break
+			}
+		}
+		if produced {
+			continue
+		}
+		exists, err := fileExists(in)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := fetch(conf, uri, in, checksums[in]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeOutputs uploads any staged outputs of the job.
+func storeOutputs(conf *Config, j *job) error {
+	for _, out := range j.Outputs {
+		uri, ok := stagedURI(conf, out)
+		if !ok {
+			continue
+		}
+		if err := store(conf, out, uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCommand runs cmd, returning its output in the error if it fails.
+func runCommand(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// statCommand runs a command that succeeds if a remote file exists. A non-zero
+// exit status is taken to mean it does not; failing to run the command at all
+// is an error.
+func statCommand(cmd *exec.Cmd) (bool, error) {
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr):
+		return false, nil
+	default:
+		return false, err
+	}
+}
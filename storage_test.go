@@ -0,0 +1,55 @@
+package flow
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_stagedURI(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{"s3_key", "s3://bucket/key.txt"},
+		{"s3_nested_key", "s3://bucket/a/b/c.vcf.gz"},
+		{"gs_object", "gs://bucket/ref/hg38.fa"},
+		{"azure_blob", "https://account.blob.core.windows.net/container/x.bam"},
+		{"ftp", "ftp://ftp.ensembl.org/pub/release-104/gtf/x.gtf.gz"},
+		{"irods", "irods://tempZone/home/alice/x.cram"},
+		{"presigned", "https://bucket.s3.amazonaws.com/a/x.bam?X-Amz-Expires=3600&X-Amz-Signature=ab%2Bc"},
+		{"query_at_root", "https://example.com/x.bam?v=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := stagedPath(defaultConfig, tt.uri)
+			if err != nil {
+				t.Fatalf("stagedPath() error = %v", err)
+			}
+			got, ok := stagedURI(defaultConfig, p)
+			if !ok {
+				t.Fatalf("stagedURI(%s) not recognised as staged", p)
+			}
+			if got != tt.uri {
+				t.Errorf("stagedURI() = %v, want %v", got, tt.uri)
+			}
+		})
+	}
+	a, _ := stagedPath(defaultConfig, "https://example.com/x.bam?v=1")
+	b, _ := stagedPath(defaultConfig, "https://example.com/x.bam?v=2")
+	if a == b {
+		t.Errorf("stagedPath() gave URIs that differ in their query the same path %s", a)
+	}
+	if filepath.Base(a) != "x.bam" {
+		t.Errorf("stagedPath() = %s, want it to keep the file name", a)
+	}
+	if _, ok := stagedURI(defaultConfig, "/not/staged.txt"); ok {
+		t.Errorf("stagedURI() recognised a local path as staged")
+	}
+}
+
+func Test_freezeTask_invalidURI(t *testing.T) {
+	task := &mockTask{Task: Task{Name: "sort"}, In: []string{"s3://bucket/%zz"}, Out: "out.txt"}
+	if err := freezeTask(defaultConfig, task); err == nil {
+		t.Errorf("freezeTask() of an invalid URI should fail")
+	}
+}
@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ulimitFlags are the options of ulimit for the limits tasks may set.
+var ulimitFlags = map[string]string{
+	"nofile":  "-n",
+	"nproc":   "-u",
+	"stack":   "-s",
+	"core":    "-c",
+	"memlock": "-l",
+}
+
+func checkUlimits(ulimits map[string]string) error {
+	for _, name := range sortedStringKeys(ulimits) {
+		if _, ok := ulimitFlags[name]; !ok {
+			return fmt.Errorf("unknown ulimit %s, not one of core, memlock, nofile, nproc or stack", name)
+		}
+		val := ulimits[name]
+		if n, err := strconv.Atoi(val); (err != nil || n < 0) && val != "unlimited" {
+			return fmt.Errorf("ulimit %s must be a number or unlimited, not %s", name, val)
+		}
+	}
+	return nil
+}
+
+// ulimitCommands returns the job script lines that set the limits.
+func ulimitCommands(ulimits map[string]string) string {
+	var b strings.Builder
+	for _, name := range sortedStringKeys(ulimits) {
+		fmt.Fprintf(&b, "ulimit -S %s %s || exit 1\n", ulimitFlags[name], shellQuote(ulimits[name]))
+	}
+	return b.String()
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+package flow
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func Test_checkUlimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		ulimits map[string]string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", map[string]string{"nofile": "1024", "stack": "unlimited"}, false},
+		{"unknown", map[string]string{"files": "1024"}, true},
+		{"not_a_number", map[string]string{"nproc": "lots"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkUlimits(tt.ulimits); (err != nil) != tt.wantErr {
+				t.Errorf("checkUlimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_ulimitCommands(t *testing.T) {
+	script := ulimitCommands(map[string]string{"nofile": "64", "core": "0"})
+	if want := "ulimit -S -c 0 || exit 1\nulimit -S -n 64 || exit 1\n"; script != want {
+		t.Errorf("ulimitCommands() = %q, want %q", script, want)
+	}
+	out, err := exec.Command("bash", "-c", script+"ulimit -S -n").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "64" {
+		t.Errorf("nofile = %s, want 64", got)
+	}
+}
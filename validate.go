@@ -0,0 +1,189 @@
+package flow
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// typeModifiers are the modifiers that may follow input or output in a type
+// tag.
+var typeModifiers = map[string]bool{
+	"optional":  true,
+	"temp":      true,
+	"protected": true,
+	"stdin":     true,
+	"pipe":      true,
+}
+
+// containerSchemes are the prefixes of containers singularity fetches;
+// anything else must be an image on disk, once bare OCI references have
+// been given the docker:// scheme by resolveContainer.
+var containerSchemes = []string{"docker://", "library://", "shub://", "oras://", "docker-archive:", "oci-archive:"}
+
+// Validate runs every check that can be made before the workflow is run,
+// without submitting anything, and returns all the problems found: invalid
+// tags, resources that cannot be resolved, missing containers, outputs shared
+// by tasks, dependency cycles and inputs that do not exist and are not
+// produced by any task. It is meant for tests and CI.
+func (q *Queue) Validate() []error {
+	conf := q.queueConfig()
+	jobs, errs := staticJobs(conf, q.tasks)
+	for _, j := range jobs {
+		name := j.Cmd.AnalysisName()
+		errs = append(errs, checkResources(name, j.resources)...)
+		if err := resolveSecrets(conf, j.resources.Secrets); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+		if err := checkInputsAvailable(conf, j); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// staticJobs returns the jobs of tasks with their dependencies and resources,
+// and the problems found with them that do not need the file system: invalid
+// tags, outputs shared by tasks and dependency cycles. Tasks with invalid
+// tags are left out.
+func staticJobs(conf *Config, tasks []Commander) ([]*job, []error) {
+	errs := []error{}
+	jobs := []*job{}
+	for _, task := range tasks {
+		name := task.AnalysisName()
+		if tagErrs := checkTags(task); len(tagErrs) > 0 {
+			errs = append(errs, tagErrs...)
+			continue
+		}
+		if err := freezeTask(conf, task); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		j := &job{
+			Cmd:      task,
+			UUID:     uuid.New(),
+			Inputs:   cmdInputs(task),
+			Outputs:  cmdOutputs(task),
+			optional: cmdOptional(task),
+		}
+		if len(j.Outputs) == 0 {
+			errs = append(errs, fmt.Errorf("%s: task has no outputs", name))
+		}
+		var err error
+		if j.resources, err = resourcesFor(conf, task); err != nil {
+			errs = append(errs, fmt.Errorf("invalid selectors in config: %v", err))
+		}
+		if _, err := cmdPublish(conf, task); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid publish tag: %v", name, err))
+		}
+		jobs = append(jobs, j)
+	}
+	jobs = dedupJobs(jobs)
+	if err := checkDuplicateOutputs(jobs); err != nil {
+		errs = append(errs, err)
+	}
+	for _, j := range jobs {
+		j.Dependencies = dependenciesFor(j, jobs)
+	}
+	if cycle := findCycle(jobs); cycle != nil {
+		errs = append(errs, fmt.Errorf("tasks depend on each other in a cycle: %s", describeCycle(cycle)))
+	}
+	return jobs, errs
+}
+
+// checkTags returns the problems with the type tags of a task, which would
+// otherwise panic when it is run.
+func checkTags(c Commander) []error {
+	errs := []error{}
+	stdins := 0
+	val := taskValue(c)
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		tag := field.Tag.Get("type")
+		if tag == "" {
+			continue
+		}
+		kind, modifiers := parseTypeTag(tag)
+		if kind != "input" && kind != "output" {
+			errs = append(errs, fmt.Errorf("%s: field %s: type tag must be input or output, not %q", c.AnalysisName(), field.Name, kind))
+			continue
+		}
+		for m := range modifiers {
+			if !typeModifiers[m] {
+				errs = append(errs, fmt.Errorf("%s: field %s: unknown type modifier %q", c.AnalysisName(), field.Name, m))
+			}
+		}
+		t := field.Type
+		if !isPathType(t) && !(t.Kind() == reflect.Slice && isPathType(t.Elem())) {
+			errs = append(errs, fmt.Errorf("%s: field %s: type:%q on a %s, not a string, File or slice of them", c.AnalysisName(), field.Name, tag, t))
+		}
+		if modifiers["pipe"] && (kind != "output" || !isPathType(t) || modifiers["protected"]) {
+			errs = append(errs, fmt.Errorf("%s: field %s: pipe must be on a single output that is not protected", c.AnalysisName(), field.Name))
+		}
+		if modifiers["stdin"] {
+			stdins++
+			if kind != "input" || !isPathType(t) {
+				errs = append(errs, fmt.Errorf("%s: field %s: stdin must be on a single input", c.AnalysisName(), field.Name))
+			}
+		}
+	}
+	if _, ok := c.(Stdiner); ok {
+		stdins++
+	}
+	if stdins > 1 {
+		errs = append(errs, fmt.Errorf("%s: more than one stdin", c.AnalysisName()))
+	}
+	return errs
+}
+
+// checkResources returns the problems with the resources of a task.
+func checkResources(name string, r Resources) []error {
+	errs := []error{}
+	if r.CPUs <= 0 || r.Memory <= 0 || r.Time <= 0 {
+		errs = append(errs, fmt.Errorf("%s: resources must be positive: CPUs %d; Memory %d; Time %d", name, r.CPUs, r.Memory, r.Time))
+	}
+	if err := checkUlimits(r.Ulimits); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", name, err))
+	}
+	if r.Checkpoint != "" && r.Checkpoint != "dmtcp" && r.Checkpoint != "native" {
+		errs = append(errs, fmt.Errorf("%s: checkpoint must be dmtcp or native, not %q", name, r.Checkpoint))
+	}
+	if r.Batch < 0 {
+		errs = append(errs, fmt.Errorf("%s: batch must not be negative: %d", name, r.Batch))
+	}
+	if r.Tmpfs < 0 {
+		errs = append(errs, fmt.Errorf("%s: tmpfs must not be negative: %d", name, r.Tmpfs))
+	}
+	if def, ok := buildDefinition(r.Container); ok {
+		if _, err := os.Stat(def); err != nil {
+			errs = append(errs, fmt.Errorf("%s: container definition does not exist: %s", name, def))
+		}
+	} else if r.Container != "" {
+		remote := false
+		for _, scheme := range containerSchemes {
+			remote = remote || strings.HasPrefix(r.Container, scheme)
+		}
+		if !remote {
+			if _, err := os.Stat(r.Container); err != nil {
+				errs = append(errs, fmt.Errorf("%s: container does not exist: %s", name, r.Container))
+			}
+		}
+	}
+	if r.Conda != "" && isCondaFile(r.Conda) {
+		if _, err := os.Stat(r.Conda); err != nil {
+			errs = append(errs, fmt.Errorf("%s: conda environment file does not exist: %s", name, r.Conda))
+		}
+	}
+	if _, err := splitArgs(r.SchedulerArgs); err != nil {
+		errs = append(errs, fmt.Errorf("%s: invalid scheduler args: %v", name, err))
+	}
+	if r.OutputSize != "" {
+		if _, err := parseOutputSize(r.OutputSize); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type badTagTask struct {
+	Task
+	Count  int    `type:"input"`
+	Output string `type:"output,permanent"`
+}
+
+func (t *badTagTask) Command() string { return "" }
+
+type validateTask struct {
+	Task
+	In  []File   `type:"input"`
+	Out []string `type:"output"`
+}
+
+func (t *validateTask) Command() string { return "" }
+
+func TestQueue_Validate(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	task := func(name string, inputs []File, outputs ...string) *validateTask {
+		return &validateTask{Task: Task{Name: name}, In: inputs, Out: outputs}
+	}
+	tests := []struct {
+		name  string
+		tasks []Commander
+		want  []string
+	}{
+		{
+			"valid",
+			[]Commander{
+				task("a", nil, path("a.txt")),
+				task("b", []File{{Path: path("a.txt")}}, path("b.txt")),
+			},
+			nil,
+		},
+		{
+			"all_problems",
+			[]Commander{
+				&badTagTask{Task: Task{Name: "tags"}},
+				task("missing", []File{{Path: path("missing.txt")}}, path("c.txt")),
+				task("shared", nil, path("c.txt")),
+				task("x", []File{{Path: path("y.txt")}}, path("x.txt")),
+				task("y", []File{{Path: path("x.txt")}}, path("y.txt")),
+				&validateTask{Task: Task{Name: "resources", CPUs: -1, Container: path("missing.sif")}, Out: []string{path("r.txt")}},
+			},
+			[]string{
+				"tags: field Count: type:\"input\" on a int",
+				"tags: field Output: unknown type modifier \"permanent\"",
+				"resources: resources must be positive",
+				"resources: container does not exist",
+				"is an output of both missing and shared",
+				"cycle",
+				"input for missing does not exist",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Queue{}
+			q.Add(tt.tasks...)
+			errs := q.Validate()
+			if len(errs) != len(tt.want) {
+				t.Errorf("Validate() = %v, want %d errors", errs, len(tt.want))
+			}
+			for _, want := range tt.want {
+				found := false
+				for _, err := range errs {
+					found = found || strings.Contains(err.Error(), want)
+				}
+				if !found {
+					t.Errorf("Validate() = %v, want an error containing %q", errs, want)
+				}
+			}
+		})
+	}
+}
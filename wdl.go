@@ -0,0 +1,960 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ImportWDL converts a WDL (version 1.0) workflow, or a document holding a
+// single task, with the inputs in the JSON file inputsFile into a
+// Submission. Only the common subset of WDL is supported: tasks with input,
+// command, output and runtime sections, File, String, Int, Float and Boolean
+// values and arrays of them, workflows made of calls, and expressions built
+// from literals, references, string interpolation, + and basename(). Imports,
+// structs, scatter and conditionals are rejected.
+//
+// Inputs are keyed as in Cromwell, e.g. "main.reads" for an input of the
+// workflow main or "main.align.threads" for an input of its call align. Each
+// call runs in its own directory under outdir and its File outputs are
+// found there.
+func ImportWDL(fn, inputsFile, outdir string) (*Submission, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WDL document: %v", err)
+	}
+	doc, err := parseWDL(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	inputs := map[string]interface{}{}
+	if inputsFile != "" {
+		data, err := ioutil.ReadFile(inputsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inputs: %v", err)
+		}
+		raw := map[string]interface{}{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse inputs: %v", err)
+		}
+		for k, v := range raw {
+			inputs[k] = wdlJSONValue(v)
+		}
+	}
+	s := &Submission{Version: SubmissionVersion}
+	if doc.workflow == nil {
+		if len(doc.tasks) != 1 {
+			return nil, fmt.Errorf("%s: a document without a workflow must have exactly one task", fn)
+		}
+		for _, t := range doc.tasks {
+			s.Name = t.name
+			call := &wdlCall{task: t.name, alias: t.name, inputs: map[string]wdlExpr{}}
+			spec, _, err := call.spec(t, wdlEnv{}, inputs, t.name, outdir)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", fn, err)
+			}
+			s.Tasks = append(s.Tasks, spec)
+		}
+		return s, nil
+	}
+	if err := doc.importWorkflow(s, inputs, outdir); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return s, nil
+}
+
+type wdlDocument struct {
+	tasks    map[string]*wdlTask
+	workflow *wdlWorkflow
+}
+
+type wdlDecl struct {
+	typ  string
+	name string
+	expr wdlExpr
+}
+
+type wdlTask struct {
+	name    string
+	inputs  []wdlDecl
+	command string
+	outputs []wdlDecl
+	runtime map[string]wdlExpr
+}
+
+type wdlCall struct {
+	task   string
+	alias  string
+	inputs map[string]wdlExpr
+}
+
+type wdlWorkflow struct {
+	name   string
+	inputs []wdlDecl
+	calls  []*wdlCall
+}
+
+// wdlEnv holds the values in scope: strings, []string and bools.
+type wdlEnv map[string]interface{}
+
+func (d *wdlDocument) importWorkflow(s *Submission, inputs map[string]interface{}, outdir string) error {
+	w := d.workflow
+	s.Name = w.name
+	env := wdlEnv{}
+	for _, decl := range w.inputs {
+		if value, ok := inputs[w.name+"."+decl.name]; ok {
+			env[decl.name] = value
+		} else if decl.expr != nil {
+			value, err := decl.expr.eval(env)
+			if err != nil {
+				return fmt.Errorf("input %s: %v", decl.name, err)
+			}
+			env[decl.name] = value
+		} else if !strings.HasSuffix(decl.typ, "?") {
+			return fmt.Errorf("no value for workflow input: %s", decl.name)
+		}
+	}
+	// Calls may refer to the outputs of calls that come after them, so keep
+	// converting those whose inputs can be evaluated until none are left.
+	done := map[string]bool{}
+	for len(done) < len(w.calls) {
+		progress := false
+		var lastErr error
+		for _, call := range w.calls {
+			if done[call.alias] {
+				continue
+			}
+			t, ok := d.tasks[call.task]
+			if !ok {
+				return fmt.Errorf("call to unknown task: %s", call.task)
+			}
+			prefix := w.name + "." + call.alias
+			spec, outputs, err := call.spec(t, env, inputs, prefix, outdir)
+			if err != nil {
+				lastErr = fmt.Errorf("call %s: %v", call.alias, err)
+				continue
+			}
+			spec.ID = call.alias
+			for name, path := range outputs {
+				env[call.alias+"."+name] = path
+			}
+			s.Tasks = append(s.Tasks, spec)
+			done[call.alias] = true
+			progress = true
+		}
+		if !progress {
+			return lastErr
+		}
+	}
+	return nil
+}
+
+// spec builds the TaskSpec for a call of t, returning it along with the
+// paths of its File outputs. Inputs not set by the call are taken from
+// inputs, keyed by prefix and the input's name, or their defaults.
+func (c *wdlCall) spec(t *wdlTask, env wdlEnv, inputs map[string]interface{}, prefix, outdir string) (TaskSpec, map[string]string, error) {
+	local := wdlEnv{}
+	files := map[string]string{}
+	for _, decl := range t.inputs {
+		var value interface{}
+		if expr, ok := c.inputs[decl.name]; ok {
+			v, err := expr.eval(env)
+			if err != nil {
+				return TaskSpec{}, nil, err
+			}
+			value = v
+		} else if v, ok := inputs[prefix+"."+decl.name]; ok {
+			value = v
+		} else if decl.expr != nil {
+			v, err := decl.expr.eval(local)
+			if err != nil {
+				return TaskSpec{}, nil, fmt.Errorf("input %s: %v", decl.name, err)
+			}
+			value = v
+		} else if strings.HasSuffix(decl.typ, "?") {
+			value = ""
+		} else {
+			return TaskSpec{}, nil, fmt.Errorf("no value for input: %s", decl.name)
+		}
+		local[decl.name] = value
+		if strings.HasPrefix(decl.typ, "File") || strings.HasPrefix(decl.typ, "Array[File]") {
+			switch value := value.(type) {
+			case string:
+				if value != "" {
+					files[decl.name] = value
+				}
+			case []string:
+				for i, v := range value {
+					files[fmt.Sprintf("%s_%d", decl.name, i)] = v
+				}
+			}
+		}
+	}
+
+	dir := filepath.Join(outdir, c.alias)
+	command, err := interpolate(t.command, local)
+	if err != nil {
+		return TaskSpec{}, nil, fmt.Errorf("command: %v", err)
+	}
+	spec := TaskSpec{
+		Name:    t.name,
+		Inputs:  files,
+		Outputs: map[string]string{},
+	}
+	outputs := map[string]string{}
+	for _, decl := range t.outputs {
+		if decl.typ != "File" {
+			// Only files are passed between tasks.
+			continue
+		}
+		value, err := decl.expr.eval(local)
+		if err != nil {
+			return TaskSpec{}, nil, fmt.Errorf("output %s: %v", decl.name, err)
+		}
+		path, ok := value.(string)
+		if !ok {
+			return TaskSpec{}, nil, fmt.Errorf("output %s is not a path", decl.name)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		spec.Outputs[decl.name] = path
+		outputs[decl.name] = path
+	}
+	if len(outputs) == 0 {
+		return TaskSpec{}, nil, fmt.Errorf("task %s has no File outputs", t.name)
+	}
+	for key, expr := range t.runtime {
+		value, err := expr.eval(local)
+		if err != nil {
+			return TaskSpec{}, nil, fmt.Errorf("runtime %s: %v", key, err)
+		}
+		s := fmt.Sprint(value)
+		switch key {
+		case "cpu":
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return TaskSpec{}, nil, fmt.Errorf("invalid cpu: %s", s)
+			}
+			spec.Resources.CPUs = int(n + 0.5)
+		case "memory":
+			gb, err := wdlMemory(s)
+			if err != nil {
+				return TaskSpec{}, nil, err
+			}
+			spec.Resources.Memory = gb
+		case "docker", "container":
+			spec.Resources.Container = "docker://" + s
+		}
+	}
+	// The command runs in its own directory, where relative outputs are
+	// written, and is a template so actions must be escaped.
+	command = fmt.Sprintf("mkdir -p %s && cd %s\n%s", shellQuote(dir), shellQuote(dir), command)
+	spec.Command = strings.ReplaceAll(command, "{{", `{{"{{"}}`)
+	return spec, outputs, nil
+}
+
+var wdlMemoryPattern = regexp.MustCompile(`^\s*([0-9.]+)\s*([A-Za-z]*)\s*$`)
+
+// wdlMemory converts a WDL memory string such as "4 GB" or "3500 MiB" to
+// whole gigabytes, rounding up.
+func wdlMemory(s string) (int, error) {
+	m := wdlMemoryPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory: %s", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory: %s", s)
+	}
+	switch strings.ToUpper(m[2]) {
+	case "B":
+		n /= 1 << 30
+	case "K", "KB", "KIB":
+		n /= 1 << 20
+	case "M", "MB", "MIB":
+		n /= 1 << 10
+	case "", "G", "GB", "GIB":
+	case "T", "TB", "TIB":
+		n *= 1 << 10
+	default:
+		return 0, fmt.Errorf("invalid memory unit: %s", s)
+	}
+	gb := int(n)
+	if float64(gb) < n {
+		gb++
+	}
+	return gb, nil
+}
+
+// wdlJSONValue converts a value from an inputs file to a string, []string or
+// bool.
+func wdlJSONValue(x interface{}) interface{} {
+	switch x := x.(type) {
+	case []interface{}:
+		values := []string{}
+		for _, y := range x {
+			values = append(values, fmt.Sprint(wdlJSONValue(y)))
+		}
+		return values
+	case bool:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(x)
+}
+
+// Expressions ----------
+
+type wdlExpr interface {
+	eval(env wdlEnv) (interface{}, error)
+}
+
+type wdlLiteral struct{ value interface{} }
+
+func (e wdlLiteral) eval(env wdlEnv) (interface{}, error) { return e.value, nil }
+
+type wdlRef struct{ name string }
+
+func (e wdlRef) eval(env wdlEnv) (interface{}, error) {
+	value, ok := env[e.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown value: %s", e.name)
+	}
+	return value, nil
+}
+
+// wdlString is a string literal; parts are strings or placeholders.
+type wdlString struct{ parts []interface{} }
+
+func (e wdlString) eval(env wdlEnv) (interface{}, error) {
+	var b strings.Builder
+	for _, part := range e.parts {
+		switch part := part.(type) {
+		case string:
+			b.WriteString(part)
+		case wdlPlaceholder:
+			s, err := part.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(s)
+		}
+	}
+	return b.String(), nil
+}
+
+type wdlPlaceholder struct {
+	expr wdlExpr
+	sep  string
+}
+
+func (p wdlPlaceholder) eval(env wdlEnv) (string, error) {
+	value, err := p.expr.eval(env)
+	if err != nil {
+		return "", err
+	}
+	if values, ok := value.([]string); ok {
+		return strings.Join(values, p.sep), nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+type wdlAdd struct{ a, b wdlExpr }
+
+func (e wdlAdd) eval(env wdlEnv) (interface{}, error) {
+	a, err := e.a.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.b.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	x, errA := strconv.ParseFloat(fmt.Sprint(a), 64)
+	y, errB := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if errA == nil && errB == nil {
+		return strconv.FormatFloat(x+y, 'f', -1, 64), nil
+	}
+	return fmt.Sprint(a) + fmt.Sprint(b), nil
+}
+
+type wdlFunc struct {
+	name string
+	args []wdlExpr
+}
+
+func (e wdlFunc) eval(env wdlEnv) (interface{}, error) {
+	args := []string{}
+	for _, arg := range e.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprint(value))
+	}
+	switch {
+	case e.name == "basename" && len(args) == 1:
+		return filepath.Base(args[0]), nil
+	case e.name == "basename" && len(args) == 2:
+		return strings.TrimSuffix(filepath.Base(args[0]), args[1]), nil
+	}
+	return nil, fmt.Errorf("unsupported function: %s with %d arguments", e.name, len(args))
+}
+
+// interpolate replaces the placeholders in a command.
+func interpolate(s string, env wdlEnv) (string, error) {
+	str, err := parseInterpolated(s, false)
+	if err != nil {
+		return "", err
+	}
+	value, err := str.eval(env)
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// parseInterpolated splits s into text and placeholders. "${" only starts a
+// placeholder if dollar is true; in commands written with <<< >>> it is left
+// to the shell.
+func parseInterpolated(s string, dollar bool) (wdlString, error) {
+	str := wdlString{}
+	text := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if i+1 < len(s) && s[i+1] == '{' && (s[i] == '~' || (dollar && s[i] == '$')) {
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return str, fmt.Errorf("unterminated placeholder: %s", s[i:])
+			}
+			p, err := parsePlaceholder(s[i+2 : end])
+			if err != nil {
+				return str, err
+			}
+			str.parts = append(str.parts, text.String(), p)
+			text.Reset()
+			i = end
+			continue
+		}
+		text.WriteByte(s[i])
+	}
+	str.parts = append(str.parts, text.String())
+	return str, nil
+}
+
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var wdlSepOption = regexp.MustCompile(`^\s*sep\s*=\s*("[^"]*"|'[^']*')`)
+
+func parsePlaceholder(s string) (wdlPlaceholder, error) {
+	p := wdlPlaceholder{sep: " "}
+	if m := wdlSepOption.FindStringSubmatch(s); m != nil {
+		p.sep = m[1][1 : len(m[1])-1]
+		s = s[len(m[0]):]
+	}
+	sc := &wdlScanner{src: s}
+	expr, err := sc.expr()
+	if err != nil {
+		return p, err
+	}
+	if sc.skip(); !sc.eof() {
+		return p, fmt.Errorf("unsupported placeholder: %s", s)
+	}
+	p.expr = expr
+	return p, nil
+}
+
+// Parsing ----------
+
+type wdlScanner struct {
+	src string
+	pos int
+}
+
+func (sc *wdlScanner) eof() bool { return sc.pos >= len(sc.src) }
+
+// skip skips whitespace and comments.
+func (sc *wdlScanner) skip() {
+	for !sc.eof() {
+		c := sc.src[sc.pos]
+		if c == '#' {
+			for !sc.eof() && sc.src[sc.pos] != '\n' {
+				sc.pos++
+			}
+			continue
+		}
+		if !unicode.IsSpace(rune(c)) {
+			return
+		}
+		sc.pos++
+	}
+}
+
+func (sc *wdlScanner) peek() byte {
+	sc.skip()
+	if sc.eof() {
+		return 0
+	}
+	return sc.src[sc.pos]
+}
+
+func (sc *wdlScanner) expect(s string) error {
+	sc.skip()
+	if !strings.HasPrefix(sc.src[sc.pos:], s) {
+		return sc.errorf("expected %q", s)
+	}
+	sc.pos += len(s)
+	return nil
+}
+
+func (sc *wdlScanner) ident() (string, error) {
+	sc.skip()
+	start := sc.pos
+	for !sc.eof() {
+		c := rune(sc.src[sc.pos])
+		if !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || (c == '.' && sc.pos > start)) {
+			break
+		}
+		sc.pos++
+	}
+	if sc.pos == start {
+		return "", sc.errorf("expected a name")
+	}
+	return sc.src[start:sc.pos], nil
+}
+
+func (sc *wdlScanner) errorf(format string, args ...interface{}) error {
+	line := strings.Count(sc.src[:sc.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func parseWDL(src string) (*wdlDocument, error) {
+	doc := &wdlDocument{tasks: map[string]*wdlTask{}}
+	sc := &wdlScanner{src: src}
+	for sc.skip(); !sc.eof(); sc.skip() {
+		keyword, err := sc.ident()
+		if err != nil {
+			return nil, err
+		}
+		switch keyword {
+		case "version":
+			version, err := sc.ident()
+			if err != nil {
+				return nil, err
+			}
+			if version != "1.0" && version != "1.1" {
+				return nil, sc.errorf("unsupported WDL version: %s", version)
+			}
+		case "task":
+			t, err := sc.task()
+			if err != nil {
+				return nil, err
+			}
+			doc.tasks[t.name] = t
+		case "workflow":
+			if doc.workflow, err = sc.workflow(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, sc.errorf("unsupported: %s", keyword)
+		}
+	}
+	return doc, nil
+}
+
+func (sc *wdlScanner) task() (*wdlTask, error) {
+	name, err := sc.ident()
+	if err != nil {
+		return nil, err
+	}
+	t := &wdlTask{name: name, runtime: map[string]wdlExpr{}}
+	if err := sc.expect("{"); err != nil {
+		return nil, err
+	}
+	for sc.peek() != '}' {
+		if sc.eof() {
+			return nil, sc.errorf("unterminated task %s", name)
+		}
+		section, err := sc.ident()
+		if err != nil {
+			return nil, err
+		}
+		switch section {
+		case "input":
+			if t.inputs, err = sc.decls(); err != nil {
+				return nil, err
+			}
+		case "output":
+			if t.outputs, err = sc.decls(); err != nil {
+				return nil, err
+			}
+		case "command":
+			if t.command, err = sc.command(); err != nil {
+				return nil, err
+			}
+		case "runtime":
+			if err := sc.expect("{"); err != nil {
+				return nil, err
+			}
+			for sc.peek() != '}' {
+				key, err := sc.ident()
+				if err != nil {
+					return nil, err
+				}
+				if err := sc.expect(":"); err != nil {
+					return nil, err
+				}
+				if t.runtime[key], err = sc.expr(); err != nil {
+					return nil, err
+				}
+			}
+			sc.pos++
+		case "meta", "parameter_meta":
+			if err := sc.skipBlock(); err != nil {
+				return nil, err
+			}
+		default:
+			// Private declarations are not supported.
+			return nil, sc.errorf("unsupported in task %s: %s", name, section)
+		}
+	}
+	sc.pos++
+	return t, nil
+}
+
+func (sc *wdlScanner) workflow() (*wdlWorkflow, error) {
+	name, err := sc.ident()
+	if err != nil {
+		return nil, err
+	}
+	w := &wdlWorkflow{name: name}
+	if err := sc.expect("{"); err != nil {
+		return nil, err
+	}
+	for sc.peek() != '}' {
+		if sc.eof() {
+			return nil, sc.errorf("unterminated workflow %s", name)
+		}
+		keyword, err := sc.ident()
+		if err != nil {
+			return nil, err
+		}
+		switch keyword {
+		case "input":
+			if w.inputs, err = sc.decls(); err != nil {
+				return nil, err
+			}
+		case "call":
+			call, err := sc.call()
+			if err != nil {
+				return nil, err
+			}
+			w.calls = append(w.calls, call)
+		case "output", "meta", "parameter_meta":
+			// Workflow outputs are only of interest to other engines.
+			if err := sc.skipBlock(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, sc.errorf("unsupported in workflow %s: %s", name, keyword)
+		}
+	}
+	sc.pos++
+	return w, nil
+}
+
+func (sc *wdlScanner) call() (*wdlCall, error) {
+	task, err := sc.ident()
+	if err != nil {
+		return nil, err
+	}
+	c := &wdlCall{task: task, alias: task, inputs: map[string]wdlExpr{}}
+	if sc.peekWord("as") {
+		sc.ident()
+		if c.alias, err = sc.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if sc.peek() != '{' {
+		return c, nil
+	}
+	sc.pos++
+	if sc.peekWord("input") {
+		sc.ident()
+		if err := sc.expect(":"); err != nil {
+			return nil, err
+		}
+	}
+	for sc.peek() != '}' {
+		name, err := sc.ident()
+		if err != nil {
+			return nil, err
+		}
+		if sc.peek() == '=' {
+			sc.pos++
+			if c.inputs[name], err = sc.expr(); err != nil {
+				return nil, err
+			}
+		} else {
+			// "input: x" is short for "input: x = x".
+			c.inputs[name] = wdlRef{name}
+		}
+		if sc.peek() == ',' {
+			sc.pos++
+		}
+	}
+	sc.pos++
+	return c, nil
+}
+
+// peekWord reports whether the next token is word.
+func (sc *wdlScanner) peekWord(word string) bool {
+	sc.skip()
+	rest := sc.src[sc.pos:]
+	if !strings.HasPrefix(rest, word) {
+		return false
+	}
+	if len(rest) == len(word) {
+		return true
+	}
+	c := rune(rest[len(word)])
+	return !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_')
+}
+
+func (sc *wdlScanner) skipBlock() error {
+	if sc.peek() != '{' {
+		return sc.errorf("expected {")
+	}
+	end := matchingBrace(sc.src, sc.pos)
+	if end < 0 {
+		return sc.errorf("unterminated block")
+	}
+	sc.pos = end + 1
+	return nil
+}
+
+func (sc *wdlScanner) decls() ([]wdlDecl, error) {
+	if err := sc.expect("{"); err != nil {
+		return nil, err
+	}
+	decls := []wdlDecl{}
+	for sc.peek() != '}' {
+		if sc.eof() {
+			return nil, sc.errorf("unterminated declarations")
+		}
+		typ, err := sc.typ()
+		if err != nil {
+			return nil, err
+		}
+		name, err := sc.ident()
+		if err != nil {
+			return nil, err
+		}
+		d := wdlDecl{typ: typ, name: name}
+		if sc.peek() == '=' {
+			sc.pos++
+			if d.expr, err = sc.expr(); err != nil {
+				return nil, err
+			}
+		}
+		decls = append(decls, d)
+	}
+	sc.pos++
+	return decls, nil
+}
+
+func (sc *wdlScanner) typ() (string, error) {
+	name, err := sc.ident()
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "File", "String", "Int", "Float", "Boolean":
+	case "Array":
+		if err := sc.expect("["); err != nil {
+			return "", err
+		}
+		item, err := sc.typ()
+		if err != nil {
+			return "", err
+		}
+		if err := sc.expect("]"); err != nil {
+			return "", err
+		}
+		name = "Array[" + item + "]"
+	default:
+		return "", sc.errorf("unsupported type: %s", name)
+	}
+	if !sc.eof() && (sc.src[sc.pos] == '?' || sc.src[sc.pos] == '+') {
+		name += string(sc.src[sc.pos])
+		sc.pos++
+	}
+	return name, nil
+}
+
+// command reads a command section, removing the common indentation of its
+// lines.
+func (sc *wdlScanner) command() (string, error) {
+	sc.skip()
+	var body string
+	switch {
+	case strings.HasPrefix(sc.src[sc.pos:], "<<<"):
+		end := strings.Index(sc.src[sc.pos:], ">>>")
+		if end < 0 {
+			return "", sc.errorf("unterminated command")
+		}
+		body = sc.src[sc.pos+3 : sc.pos+end]
+		sc.pos += end + 3
+	case strings.HasPrefix(sc.src[sc.pos:], "{"):
+		end := matchingBrace(sc.src, sc.pos)
+		if end < 0 {
+			return "", sc.errorf("unterminated command")
+		}
+		body = sc.src[sc.pos+1 : end]
+		// ${} placeholders are only recognised in this form, so convert
+		// them to ~{} now.
+		body = strings.ReplaceAll(body, "${", "~{")
+		sc.pos = end + 1
+	default:
+		return "", sc.errorf("expected command")
+	}
+	return dedent(body), nil
+}
+
+// expr parses an expression: terms joined by +.
+func (sc *wdlScanner) expr() (wdlExpr, error) {
+	e, err := sc.term()
+	if err != nil {
+		return nil, err
+	}
+	for sc.peek() == '+' {
+		sc.pos++
+		b, err := sc.term()
+		if err != nil {
+			return nil, err
+		}
+		e = wdlAdd{e, b}
+	}
+	return e, nil
+}
+
+func (sc *wdlScanner) term() (wdlExpr, error) {
+	c := sc.peek()
+	switch {
+	case c == '"' || c == '\'':
+		start := sc.pos
+		sc.pos++
+		for !sc.eof() && sc.src[sc.pos] != c {
+			if sc.src[sc.pos] == '\\' {
+				sc.pos++
+			} else if sc.src[sc.pos] == '{' && (sc.src[sc.pos-1] == '~' || sc.src[sc.pos-1] == '$') {
+				end := matchingBrace(sc.src, sc.pos)
+				if end < 0 {
+					return nil, sc.errorf("unterminated placeholder")
+				}
+				sc.pos = end
+			}
+			sc.pos++
+		}
+		if sc.eof() {
+			return nil, sc.errorf("unterminated string")
+		}
+		sc.pos++
+		raw := sc.src[start+1 : sc.pos-1]
+		raw = strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(raw)
+		return parseInterpolated(raw, true)
+	case c == '(':
+		sc.pos++
+		e, err := sc.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, sc.expect(")")
+	case c == '[':
+		sc.pos++
+		items := []wdlExpr{}
+		for sc.peek() != ']' {
+			e, err := sc.expr()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, e)
+			if sc.peek() == ',' {
+				sc.pos++
+			}
+		}
+		sc.pos++
+		return wdlArray{items}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := sc.pos
+		sc.pos++
+		for !sc.eof() && (sc.src[sc.pos] == '.' || (sc.src[sc.pos] >= '0' && sc.src[sc.pos] <= '9')) {
+			sc.pos++
+		}
+		return wdlLiteral{sc.src[start:sc.pos]}, nil
+	}
+	name, err := sc.ident()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "true":
+		return wdlLiteral{true}, nil
+	case "false":
+		return wdlLiteral{false}, nil
+	}
+	if sc.peek() != '(' {
+		return wdlRef{name}, nil
+	}
+	sc.pos++
+	f := wdlFunc{name: name}
+	for sc.peek() != ')' {
+		if sc.eof() {
+			return nil, sc.errorf("unterminated call of %s", name)
+		}
+		arg, err := sc.expr()
+		if err != nil {
+			return nil, err
+		}
+		f.args = append(f.args, arg)
+		if sc.peek() == ',' {
+			sc.pos++
+		}
+	}
+	sc.pos++
+	return f, nil
+}
+
+type wdlArray struct{ items []wdlExpr }
+
+func (e wdlArray) eval(env wdlEnv) (interface{}, error) {
+	values := []string{}
+	for _, item := range e.items {
+		value, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, fmt.Sprint(value))
+	}
+	return values, nil
+}
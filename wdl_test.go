@@ -0,0 +1,117 @@
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testWDL = `version 1.0
+
+workflow main {
+  input {
+    File reads
+    String sample = "s1"
+  }
+  call count { input: sorted = sort.sorted }
+  call sort as sort { input: reads = reads, prefix = sample }
+}
+
+task sort {
+  input {
+    File reads
+    String prefix
+    Int threads = 2
+  }
+  command <<<
+    sort --parallel ~{threads} ~{reads} > ~{prefix}.sorted.txt
+  >>>
+  output {
+    File sorted = "~{prefix}.sorted.txt"
+  }
+  runtime {
+    cpu: threads
+    memory: "3500 MiB"
+    docker: "debian:10"
+  }
+}
+
+task count {
+  input {
+    File sorted
+  }
+  command {
+    wc -l ${sorted} > ${basename(sorted, ".txt")}.count
+  }
+  output {
+    File count = basename(sorted, ".txt") + ".count"
+    Int lines = 0
+  }
+}
+`
+
+func Test_ImportWDL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-wdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wdl := filepath.Join(dir, "main.wdl")
+	inputs := filepath.Join(dir, "inputs.json")
+	if err := ioutil.WriteFile(wdl, []byte(testWDL), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(inputs, []byte(`{"main.reads": "/data/reads.txt", "main.sort.threads": 4}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ImportWDL(wdl, inputs, "/out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(s.Tasks))
+	}
+	sort, count := s.Tasks[0], s.Tasks[1]
+	if want := "mkdir -p /out/sort && cd /out/sort\nsort --parallel 4 /data/reads.txt > s1.sorted.txt"; sort.Command != want {
+		t.Errorf("sort command = %q, want %q", sort.Command, want)
+	}
+	if sort.Resources.CPUs != 4 || sort.Resources.Memory != 4 || sort.Resources.Container != "docker://debian:10" {
+		t.Errorf("sort resources = %+v", sort.Resources)
+	}
+	if want := "/out/sort/s1.sorted.txt"; sort.Outputs["sorted"] != want || count.Inputs["sorted"] != want {
+		t.Errorf("sort outputs = %v, count inputs = %v", sort.Outputs, count.Inputs)
+	}
+	if want := "mkdir -p /out/count && cd /out/count\nwc -l /out/sort/s1.sorted.txt > s1.sorted.count"; count.Command != want {
+		t.Errorf("count command = %q, want %q", count.Command, want)
+	}
+	if want := "/out/count/s1.sorted.count"; count.Outputs["count"] != want {
+		t.Errorf("count outputs = %v", count.Outputs)
+	}
+}
+
+func Test_wdlMemory(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{"4 GB", 4, false},
+		{"4G", 4, false},
+		{"3500 MiB", 4, false},
+		{"1 TB", 1024, false},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := wdlMemory(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("wdlMemory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("wdlMemory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+//go:build yaegi
+// +build yaegi
+
+package flow
+
+import (
+	"fmt"
+	"go/build"
+	"log"
+
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+func init() {
+	loadInterpreted = loadYaegi
+}
+
+// loadYaegi evaluates the workflow with the yaegi interpreter. Unlike a
+// plugin this needs no Go toolchain and works on every platform, but the
+// workflow may only import the standard library and flow itself.
+func loadYaegi(fn string) (func(*Queue), error) {
+	log.Printf("Interpreting workflow\n")
+	src, err := workflowSource(fn)
+	if err != nil {
+		return nilWorkflowFunc, err
+	}
+	i := interp.New(interp.Options{GoPath: build.Default.GOPATH})
+	if err := i.Use(stdlib.Symbols); err != nil {
+		return nilWorkflowFunc, fmt.Errorf("failed to load standard library: %v", err)
+	}
+	if err := i.Use(Symbols); err != nil {
+		return nilWorkflowFunc, fmt.Errorf("failed to load flow symbols: %v", err)
+	}
+	if _, err := i.EvalPath(src); err != nil {
+		return nilWorkflowFunc, fmt.Errorf("failed to evaluate workflow: %v", err)
+	}
+	w, err := i.Eval("main.Workflow")
+	if err != nil {
+		return nilWorkflowFunc, fmt.Errorf("failed to find Workflow function: %v", err)
+	}
+	workflowFunc, ok := w.Interface().(func(*Queue))
+	if !ok {
+		return nilWorkflowFunc, fmt.Errorf("workflow func found, but it's type is %s", w.Type())
+	}
+	return workflowFunc, nil
+}
+
+// Symbols exposes the flow API to interpreted workflows. It is filled in by
+// yaegi_symbols.go, which is generated with yaegi extract: run
+// go generate -tags yaegi after changing the API.
+var Symbols = interp.Exports{}
+
+//go:generate sh -c "go run github.com/traefik/yaegi/cmd/yaegi extract -name flow github.com/jje42/flow && { printf '//go:build yaegi\\n// +build yaegi\\n\\n'; sed -e '/\"github.com\\/jje42\\/flow\"/d' -e 's/\\([^A-Za-z_\"]\\)flow\\./\\1/g' github_com-jje42-flow.go; } >yaegi_symbols.go && rm github_com-jje42-flow.go"
@@ -0,0 +1,189 @@
+//go:build yaegi
+// +build yaegi
+
+// Code generated by 'yaegi extract github.com/jje42/flow'. DO NOT EDIT.
+
+package flow
+
+import (
+	"go/constant"
+	"go/token"
+	"reflect"
+)
+
+func init() {
+	Symbols["github.com/jje42/flow/flow"] = map[string]reflect.Value{
+		// function, constant and variable definitions
+		"Cmd":                 reflect.ValueOf(Cmd),
+		"DecodeSubmission":    reflect.ValueOf(DecodeSubmission),
+		"ExportWorkflow":      reflect.ValueOf(ExportWorkflow),
+		"Gather":              reflect.ValueOf(Gather),
+		"ImportCWL":           reflect.ValueOf(ImportCWL),
+		"ImportWDL":           reflect.ValueOf(ImportWDL),
+		"InitConfig":          reflect.ValueOf(InitConfig),
+		"LoadParams":          reflect.ValueOf(LoadParams),
+		"Main":                reflect.ValueOf(Main),
+		"Map":                 reflect.ValueOf(Map),
+		"NewConfig":           reflect.ValueOf(NewConfig),
+		"NewJobreport":        reflect.ValueOf(NewJobreport),
+		"NewLocalRunner":      reflect.ValueOf(NewLocalRunner),
+		"NewPBSRunner":        reflect.ValueOf(NewPBSRunner),
+		"NewQueue":            reflect.ValueOf(NewQueue),
+		"NewSlurmRunner":      reflect.ValueOf(NewSlurmRunner),
+		"Outputs":             reflect.ValueOf(Outputs),
+		"Params":              reflect.ValueOf(Params),
+		"ReadFOFN":            reflect.ValueOf(ReadFOFN),
+		"ReadSampleSheet":     reflect.ValueOf(ReadSampleSheet),
+		"RegisterStorage":     reflect.ValueOf(RegisterStorage),
+		"Render":              reflect.ValueOf(Render),
+		"RenderTemplate":      reflect.ValueOf(RenderTemplate),
+		"ResourcesFor":        reflect.ValueOf(ResourcesFor),
+		"RunDir":              reflect.ValueOf(RunDir),
+		"RunWorkflow":         reflect.ValueOf(RunWorkflow),
+		"SafeWriteConfigAs":   reflect.ValueOf(SafeWriteConfigAs),
+		"Serve":               reflect.ValueOf(Serve),
+		"ShowConfig":          reflect.ValueOf(ShowConfig),
+		"SplitBed":            reflect.ValueOf(SplitBed),
+		"SplitFastq":          reflect.ValueOf(SplitFastq),
+		"SplitIntervals":      reflect.ValueOf(SplitIntervals),
+		"SubmissionVersion":   reflect.ValueOf(constant.MakeFromLiteral("1", token.INT, 0)),
+		"WriteConfigSchema":   reflect.ValueOf(WriteConfigSchema),
+		"WriteConfigTemplate": reflect.ValueOf(WriteConfigTemplate),
+
+		// type definitions
+		"AdoptResult":       reflect.ValueOf((*AdoptResult)(nil)),
+		"AnalysisStats":     reflect.ValueOf((*AnalysisStats)(nil)),
+		"Attempter":         reflect.ValueOf((*Attempter)(nil)),
+		"AzureStorage":      reflect.ValueOf((*AzureStorage)(nil)),
+		"Commander":         reflect.ValueOf((*Commander)(nil)),
+		"Config":            reflect.ValueOf((*Config)(nil)),
+		"DummyRunner":       reflect.ValueOf((*DummyRunner)(nil)),
+		"File":              reflect.ValueOf((*File)(nil)),
+		"GCSStorage":        reflect.ValueOf((*GCSStorage)(nil)),
+		"HTTPStorage":       reflect.ValueOf((*HTTPStorage)(nil)),
+		"IRODSStorage":      reflect.ValueOf((*IRODSStorage)(nil)),
+		"ImportStateResult": reflect.ValueOf((*ImportStateResult)(nil)),
+		"LintProblem":       reflect.ValueOf((*LintProblem)(nil)),
+		"LocalRunner":       reflect.ValueOf((*LocalRunner)(nil)),
+		"MockJob":           reflect.ValueOf((*MockJob)(nil)),
+		"MockRun":           reflect.ValueOf((*MockRun)(nil)),
+		"OrphanJob":         reflect.ValueOf((*OrphanJob)(nil)),
+		"PBSRunner":         reflect.ValueOf((*PBSRunner)(nil)),
+		"ParamMap":          reflect.ValueOf((*ParamMap)(nil)),
+		"Pipeline":          reflect.ValueOf((*Pipeline)(nil)),
+		"Queue":             reflect.ValueOf((*Queue)(nil)),
+		"Raw":               reflect.ValueOf((*Raw)(nil)),
+		"ResourceSpec":      reflect.ValueOf((*ResourceSpec)(nil)),
+		"Resources":         reflect.ValueOf((*Resources)(nil)),
+		"RunFlags":          reflect.ValueOf((*RunFlags)(nil)),
+		"Runner":            reflect.ValueOf((*Runner)(nil)),
+		"S3Storage":         reflect.ValueOf((*S3Storage)(nil)),
+		"Sample":            reflect.ValueOf((*Sample)(nil)),
+		"SampleSheet":       reflect.ValueOf((*SampleSheet)(nil)),
+		"ScriptTask":        reflect.ValueOf((*ScriptTask)(nil)),
+		"ShellTask":         reflect.ValueOf((*ShellTask)(nil)),
+		"SlurmRunner":       reflect.ValueOf((*SlurmRunner)(nil)),
+		"SplitTask":         reflect.ValueOf((*SplitTask)(nil)),
+		"Stdiner":           reflect.ValueOf((*Stdiner)(nil)),
+		"Storage":           reflect.ValueOf((*Storage)(nil)),
+		"Submission":        reflect.ValueOf((*Submission)(nil)),
+		"Task":              reflect.ValueOf((*Task)(nil)),
+		"TaskSpec":          reflect.ValueOf((*TaskSpec)(nil)),
+		"Tasks":             reflect.ValueOf((*Tasks)(nil)),
+		"TemplateTask":      reflect.ValueOf((*TemplateTask)(nil)),
+		"WorkflowRunArgs":   reflect.ValueOf((*WorkflowRunArgs)(nil)),
+
+		// interface wrapper definitions
+		"_Attempter": reflect.ValueOf((*_github_com_jje42_flow_Attempter)(nil)),
+		"_Commander": reflect.ValueOf((*_github_com_jje42_flow_Commander)(nil)),
+		"_Runner":    reflect.ValueOf((*_github_com_jje42_flow_Runner)(nil)),
+		"_Stdiner":   reflect.ValueOf((*_github_com_jje42_flow_Stdiner)(nil)),
+		"_Storage":   reflect.ValueOf((*_github_com_jje42_flow_Storage)(nil)),
+	}
+}
+
+// _github_com_jje42_flow_Attempter is an interface wrapper for Attempter type
+type _github_com_jje42_flow_Attempter struct {
+	IValue      interface{}
+	WSetAttempt func(n int)
+}
+
+func (W _github_com_jje42_flow_Attempter) SetAttempt(n int) {
+	W.WSetAttempt(n)
+}
+
+// _github_com_jje42_flow_Commander is an interface wrapper for Commander type
+type _github_com_jje42_flow_Commander struct {
+	IValue        interface{}
+	WAnalysisName func() string
+	WCommand      func() string
+	WResources    func() Resources
+}
+
+func (W _github_com_jje42_flow_Commander) AnalysisName() string {
+	return W.WAnalysisName()
+}
+func (W _github_com_jje42_flow_Commander) Command() string {
+	return W.WCommand()
+}
+func (W _github_com_jje42_flow_Commander) Resources() Resources {
+	return W.WResources()
+}
+
+// _github_com_jje42_flow_Runner is an interface wrapper for Runner type
+type _github_com_jje42_flow_Runner struct {
+	IValue                 interface{}
+	WCompleted             func(a0 *job) (bool, error)
+	WCompletedSuccessfully func(a0 *job) (bool, error)
+	WKill                  func(a0 *job) error
+	WResourcesUsed         func(a0 *job) (resourcesUsed, error)
+	WRun                   func(a0 executionContext) error
+}
+
+func (W _github_com_jje42_flow_Runner) Completed(a0 *job) (bool, error) {
+	return W.WCompleted(a0)
+}
+func (W _github_com_jje42_flow_Runner) CompletedSuccessfully(a0 *job) (bool, error) {
+	return W.WCompletedSuccessfully(a0)
+}
+func (W _github_com_jje42_flow_Runner) Kill(a0 *job) error {
+	return W.WKill(a0)
+}
+func (W _github_com_jje42_flow_Runner) ResourcesUsed(a0 *job) (resourcesUsed, error) {
+	return W.WResourcesUsed(a0)
+}
+func (W _github_com_jje42_flow_Runner) Run(a0 executionContext) error {
+	return W.WRun(a0)
+}
+
+// _github_com_jje42_flow_Stdiner is an interface wrapper for Stdiner type
+type _github_com_jje42_flow_Stdiner struct {
+	IValue interface{}
+	WStdin func() string
+}
+
+func (W _github_com_jje42_flow_Stdiner) Stdin() string {
+	return W.WStdin()
+}
+
+// _github_com_jje42_flow_Storage is an interface wrapper for Storage type
+type _github_com_jje42_flow_Storage struct {
+	IValue    interface{}
+	WChecksum func(uri string) (string, error)
+	WFetch    func(uri string, dst string) error
+	WStat     func(uri string) (bool, error)
+	WStore    func(src string, uri string) error
+}
+
+func (W _github_com_jje42_flow_Storage) Checksum(uri string) (string, error) {
+	return W.WChecksum(uri)
+}
+func (W _github_com_jje42_flow_Storage) Fetch(uri string, dst string) error {
+	return W.WFetch(uri, dst)
+}
+func (W _github_com_jje42_flow_Storage) Stat(uri string) (bool, error) {
+	return W.WStat(uri)
+}
+func (W _github_com_jje42_flow_Storage) Store(src string, uri string) error {
+	return W.WStore(src, uri)
+}
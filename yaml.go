@@ -0,0 +1,113 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TemplateTask is a task whose command is a text/template rendered with its
+// named inputs and outputs and any parameters, e.g.
+// "sort {{.Inputs.in}} >{{.Outputs.out}}". Tasks of a Submission are
+// TemplateTasks.
+type TemplateTask struct {
+	Task
+	Template string
+	Params   map[string]string
+	// InputNames and OutputNames are the names used in the template, each
+	// given to one or more (for a pattern) of the Inputs or Outputs.
+	InputNames  []string
+	OutputNames []string
+	Inputs      []File `type:"input"`
+	Outputs     []File `type:"output"`
+	// Needs are inputs that are not used by the command, but must exist
+	// before it runs.
+	Needs []string `type:"input"`
+}
+
+func (t *TemplateTask) Command() string {
+	return RenderTemplate(t.Template, map[string]interface{}{
+		"Inputs":  namedPaths(t.InputNames, t.Inputs),
+		"Outputs": namedPaths(t.OutputNames, t.Outputs),
+		"Params":  t.Params,
+	})
+}
+
+// namedPaths returns the paths of files by name. The paths matched by a
+// pattern are separated by spaces, and a pattern that matched nothing is
+// empty.
+func namedPaths(names []string, files []File) map[string]string {
+	paths := make(map[string][]string)
+	for _, f := range files {
+		paths[f.Name] = append(paths[f.Name], f.Path)
+	}
+	m := make(map[string]string)
+	for _, name := range names {
+		m[name] = strings.Join(paths[name], " ")
+	}
+	return m
+}
+
+// isSubmission reports whether fn is a workflow described by a Submission,
+// in YAML or JSON, rather than written in Go.
+func isSubmission(fn string) bool {
+	switch filepath.Ext(fn) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// loadSubmission reads a Submission from fn. YAML workflows may omit the
+// version.
+func loadSubmission(conf *Config, fn string) (func(*Queue), error) {
+	log.Printf("Reading workflow\n")
+	var s *Submission
+	if filepath.Ext(fn) == ".json" {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nilWorkflowFunc, fmt.Errorf("failed to read workflow: %v", err)
+		}
+		defer f.Close()
+		if s, err = DecodeSubmission(f); err != nil {
+			return nilWorkflowFunc, err
+		}
+	} else {
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nilWorkflowFunc, fmt.Errorf("failed to read workflow: %v", err)
+		}
+		s = &Submission{}
+		if err := yaml.UnmarshalStrict(data, s); err != nil {
+			return nilWorkflowFunc, fmt.Errorf("failed to parse workflow: %v", err)
+		}
+		if s.Version != 0 && s.Version != SubmissionVersion {
+			return nilWorkflowFunc, fmt.Errorf("unsupported workflow version: %d (expected %d)", s.Version, SubmissionVersion)
+		}
+	}
+	// Values in the parameter file override those in the workflow.
+	params, err := conf.Params()
+	if err != nil {
+		return nilWorkflowFunc, err
+	}
+	for key := range params {
+		if value, err := params.String(key); err == nil {
+			if s.Params == nil {
+				s.Params = map[string]string{}
+			}
+			s.Params[key] = value
+		}
+	}
+	tasks, err := s.Commanders()
+	if err != nil {
+		return nilWorkflowFunc, err
+	}
+	return func(q *Queue) {
+		q.Add(tasks...)
+	}, nil
+}
@@ -0,0 +1,78 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_loadSubmission(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			"yaml",
+			"workflow.yaml",
+			`params:
+  word: hello
+tasks:
+  - name: sort
+    command: echo {{.Params.word}} | sort {{.Inputs.in}} >{{.Outputs.out}}
+    inputs: {in: a.txt}
+    outputs: {out: b.txt}
+`,
+			"echo hello | sort a.txt >b.txt",
+			false,
+		},
+		{"yaml_version", "workflow.yml", "version: 1\ntasks: [{name: x, command: true, outputs: {out: b.txt}}]\n", "true", false},
+		{"yaml_unsupported_version", "workflow.yaml", "version: 2\ntasks: [{name: x, command: true, outputs: {out: b.txt}}]\n", "", true},
+		{"yaml_unknown_field", "workflow.yaml", "tasks: [{name: x, comand: true, outputs: {out: b.txt}}]\n", "", true},
+		{"yaml_no_outputs", "workflow.yaml", "tasks: [{name: x, command: true}]\n", "", true},
+		{"json", "workflow.json", `{"version": 1, "tasks": [{"name": "x", "command": "true", "outputs": {"out": "b.txt"}}]}`, "true", false},
+		{"json_no_version", "workflow.json", `{"tasks": [{"name": "x", "command": "true", "outputs": {"out": "b.txt"}}]}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), tt.fn)
+			if err := ioutil.WriteFile(fn, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if !isSubmission(fn) {
+				t.Fatalf("isSubmission(%s) = false", tt.fn)
+			}
+			workflow, err := loadSubmission(defaultConfig, fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSubmission() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			q := &Queue{}
+			workflow(q)
+			if len(q.Tasks()) != 1 || q.Tasks()[0].Command() != tt.want {
+				t.Errorf("loadSubmission() gave %d tasks, want one with command %q", len(q.Tasks()), tt.want)
+			}
+		})
+	}
+	if isSubmission("workflow.go") {
+		t.Errorf("isSubmission(workflow.go) = true")
+	}
+}
+
+func Test_namedPaths(t *testing.T) {
+	files := []File{{Path: "/a/1.fq", Name: "reads"}, {Path: "/a/ref.fa", Name: "ref"}, {Path: "/a/2.fq", Name: "reads"}}
+	got := namedPaths([]string{"reads", "ref", "unpaired"}, files)
+	want := map[string]string{"reads": "/a/1.fq /a/2.fq", "ref": "/a/ref.fa", "unpaired": ""}
+	if len(got) != len(want) {
+		t.Errorf("namedPaths() = %v, want %v", got, want)
+	}
+	for name, p := range want {
+		if got[name] != p {
+			t.Errorf("namedPaths()[%s] = %q, want %q", name, got[name], p)
+		}
+	}
+}